@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
 )
 
 func nonil(err ...error) error {
@@ -98,3 +101,56 @@ func assertDeepEquals(t *testing.T, expected interface{}, actual interface{}) {
 		t.Errorf("%v is not equal to %v", expected, actual)
 	}
 }
+
+// logRecorder is an ably.Logger which records formatted log lines.
+type logRecorder struct {
+	mtx   sync.Mutex
+	lines []string
+}
+
+func (r *logRecorder) Print(level ably.LogLevel, v ...interface{}) {
+	r.mtx.Lock()
+	r.lines = append(r.lines, fmt.Sprint(v...))
+	r.mtx.Unlock()
+}
+
+func (r *logRecorder) Printf(level ably.LogLevel, format string, v ...interface{}) {
+	r.mtx.Lock()
+	r.lines = append(r.lines, fmt.Sprintf(format, v...))
+	r.mtx.Unlock()
+}
+
+func (r *logRecorder) Lines() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string(nil), r.lines...)
+}
+
+// newPipeRealtimeClient gives a RealtimeClient which is not connected to
+// Ably, but exchanges protocol messages through in and out channels instead.
+func newPipeRealtimeClient(t testing.TB, in chan *proto.ProtocolMessage, out chan *proto.ProtocolMessage, opts ...func(*ably.ClientOptions)) *ably.RealtimeClient {
+	t.Helper()
+	o := &ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+		Dial:        ablytest.MessagePipe(in, out),
+		NoConnect:   true,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	client, err := ably.NewRealtimeClient(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// connectedMessage gives a CONNECTED protocol message for the given
+// connection ID.
+func connectedMessage(id string) *proto.ProtocolMessage {
+	return &proto.ProtocolMessage{
+		Action:            proto.ActionConnected,
+		ConnectionID:      id,
+		ConnectionDetails: &proto.ConnectionDetails{ConnectionKey: id + "-key"},
+	}
+}
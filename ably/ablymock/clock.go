@@ -0,0 +1,63 @@
+package ablymock
+
+import (
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/internal/ablyutil"
+)
+
+// Clock is a clock which only moves when told to. It implements ably.Clock:
+// set as ably.ClientOptions.Clock, it drives the client's timers, e.g. for
+// reconnecting, suspending the connection or reattaching channels, as well
+// as token expiry and message timestamps, so that tests don't have to wait
+// for them.
+type Clock struct {
+	fake *ablyutil.FakeClock
+}
+
+// NewClock gives a new Clock set to the given time.
+func NewClock(now time.Time) *Clock {
+	return &Clock{fake: ablyutil.NewFakeClock(now)}
+}
+
+// Now gives the clock's current time.
+func (c *Clock) Now() time.Time {
+	return c.fake.Now()
+}
+
+// AfterFunc gives a timer calling f once the clock is advanced by d, from
+// the goroutine advancing it.
+func (c *Clock) AfterFunc(d time.Duration, f func()) ably.Timer {
+	return c.fake.AfterFunc(d, f)
+}
+
+// NewTimer gives a timer sending the time on its channel once the clock is
+// advanced by d.
+func (c *Clock) NewTimer(d time.Duration) ably.Timer {
+	return c.fake.NewTimer(d)
+}
+
+// Advance moves the clock forward by d, firing the timers due by then in
+// order.
+func (c *Clock) Advance(d time.Duration) {
+	c.fake.Advance(d)
+}
+
+// Set sets the clock to the given time, firing the timers due by then if
+// it's later than the current time.
+func (c *Clock) Set(now time.Time) {
+	c.fake.Set(now)
+}
+
+// Timers gives the number of timers waiting to fire.
+func (c *Clock) Timers() int {
+	return c.fake.Timers()
+}
+
+// BlockUntil blocks until n timers are waiting to fire, e.g. until a
+// disconnected client scheduled its reconnection, before advancing the
+// clock.
+func (c *Clock) BlockUntil(n int) {
+	c.fake.BlockUntil(n)
+}
@@ -0,0 +1,424 @@
+// Package ablymock provides an in-memory Ably realtime server and a
+// controllable clock for unit testing code which uses the ably package,
+// without sandbox credentials or network access.
+//
+// A Server is plugged into a client with its Dial method:
+//
+//	server := ablymock.NewServer(nil)
+//	client, err := ably.NewRealtimeClient(&ably.ClientOptions{
+//		AuthOptions: ably.AuthOptions{Key: "app.key:secret"},
+//		Dial:        server.Dial,
+//	})
+//
+// The server accepts every connection, attaches and detaches channels,
+// acknowledges published messages and presence updates, and fans them out
+// to the connections attached to the channel. Tests can inject arbitrary
+// protocol messages, or replace the default handling with Options.Handler.
+//
+// A Clock is plugged into a client, and a server, to drive time:
+//
+//	clock := ablymock.NewClock(time.Now())
+//	server := ablymock.NewServer(&ablymock.Options{Clock: clock})
+//	client, err := ably.NewRealtimeClient(&ably.ClientOptions{
+//		AuthOptions: ably.AuthOptions{Key: "app.key:secret"},
+//		Dial:        server.Dial,
+//		Clock:       clock,
+//	})
+package ablymock
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// ErrConnClosed is returned by operations on a closed connection.
+var ErrConnClosed = errors.New("ablymock: connection closed")
+
+// Options configures a Server.
+type Options struct {
+	// Clock, if non-nil, is used to timestamp messages; the wall clock is used
+	// by default.
+	Clock *Clock
+
+	// Handler, if non-nil, is called for every protocol message sent by
+	// a client, before the server's default handling. If it returns true,
+	// the message is considered handled and the default handling is skipped.
+	Handler func(c *Conn, msg *proto.ProtocolMessage) bool
+
+	// DialError, if non-nil, is called on every dial; if it returns an error
+	// the dial fails with it.
+	DialError func(u *url.URL) error
+}
+
+// Server is an in-memory Ably realtime server.
+type Server struct {
+	mtx   sync.Mutex
+	opts  Options
+	conns []*Conn
+	seq   int
+
+	// serials counts the messages and presence messages broadcast on each
+	// channel, giving their channel serials.
+	serials map[string]int
+}
+
+// NewServer gives a new Server. The opts may be nil.
+func NewServer(opts *Options) *Server {
+	s := &Server{serials: make(map[string]int)}
+	if opts != nil {
+		s.opts = *opts
+	}
+	return s
+}
+
+// Dial connects to the server; it is meant to be used as
+// ably.ClientOptions.Dial.
+func (s *Server) Dial(protocol string, u *url.URL) (proto.Conn, error) {
+	if s.opts.DialError != nil {
+		if err := s.opts.DialError(u); err != nil {
+			return nil, err
+		}
+	}
+	query := u.Query()
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	var id string
+	if key := query.Get("resume"); key != "" {
+		for _, c := range s.conns {
+			if c.key == key {
+				id = c.id
+				break
+			}
+		}
+	}
+	if id == "" {
+		s.seq++
+		id = fmt.Sprintf("conn-%d", s.seq)
+	}
+	c := &Conn{
+		server:   s,
+		id:       id,
+		key:      id + "-key",
+		clientID: query.Get("clientId"),
+		noEcho:   query.Get("echo") == "false",
+		url:      u,
+		attached: make(map[string]bool),
+		notify:   make(chan struct{}, 1),
+	}
+	s.conns = append(s.conns, c)
+	c.push(&proto.ProtocolMessage{
+		Action:       proto.ActionConnected,
+		ConnectionID: c.id,
+		ConnectionDetails: &proto.ConnectionDetails{
+			ClientID:      c.clientID,
+			ConnectionKey: c.key,
+		},
+	})
+	return c, nil
+}
+
+// Conns gives all connections dialed so far, including closed ones.
+func (s *Server) Conns() []*Conn {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]*Conn(nil), s.conns...)
+}
+
+// Publish sends the given messages to every connection attached to the
+// channel, as if they were published by another client.
+func (s *Server) Publish(channel string, messages ...*proto.Message) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.broadcast(nil, &proto.ProtocolMessage{
+		Action:   proto.ActionMessage,
+		Channel:  channel,
+		Messages: messages,
+	})
+}
+
+// Inject sends msg to every open connection.
+func (s *Server) Inject(msg *proto.ProtocolMessage) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, c := range s.conns {
+		c.push(msg)
+	}
+}
+
+func (s *Server) now() time.Time {
+	if s.opts.Clock != nil {
+		return s.opts.Clock.Now()
+	}
+	return time.Now()
+}
+
+// channelSerial gives the serial of the last message broadcast on channel.
+// It must be called with s.mtx held.
+func (s *Server) channelSerial(channel string) string {
+	return fmt.Sprintf("%s:%d", channel, s.serials[channel])
+}
+
+// broadcast sends msg to all connections attached to its channel, except
+// from itself if it asked for no echo. It must be called with s.mtx held.
+func (s *Server) broadcast(from *Conn, msg *proto.ProtocolMessage) {
+	s.serials[msg.Channel]++
+	msg.ChannelSerial = s.channelSerial(msg.Channel)
+	for _, c := range s.conns {
+		if c == from && c.noEcho {
+			continue
+		}
+		if c.isAttached(msg.Channel) {
+			c.push(msg)
+		}
+	}
+}
+
+// handle implements the server's default handling of msg sent by c. It must
+// be called with s.mtx held.
+func (s *Server) handle(c *Conn, msg *proto.ProtocolMessage) {
+	switch msg.Action {
+	case proto.ActionAttach:
+		c.setAttached(msg.Channel, true)
+		c.push(&proto.ProtocolMessage{
+			Action:        proto.ActionAttached,
+			Channel:       msg.Channel,
+			ChannelSerial: s.channelSerial(msg.Channel),
+		})
+	case proto.ActionDetach:
+		c.setAttached(msg.Channel, false)
+		c.push(&proto.ProtocolMessage{Action: proto.ActionDetached, Channel: msg.Channel})
+	case proto.ActionMessage:
+		c.push(&proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1})
+		ts := millis(s.now())
+		out := &proto.ProtocolMessage{
+			Action:       proto.ActionMessage,
+			Channel:      msg.Channel,
+			ConnectionID: c.id,
+			Timestamp:    ts,
+		}
+		for i, m := range msg.Messages {
+			m := *m
+			if m.ID == "" {
+				m.ID = fmt.Sprintf("%s:%d:%d", c.id, msg.MsgSerial, i)
+			}
+			if m.ClientID == "" {
+				m.ClientID = c.clientID
+			}
+			m.ConnectionID = c.id
+			m.Timestamp = ts
+			out.Messages = append(out.Messages, &m)
+		}
+		s.broadcast(c, out)
+	case proto.ActionPresence:
+		c.push(&proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1})
+		ts := millis(s.now())
+		out := &proto.ProtocolMessage{
+			Action:       proto.ActionPresence,
+			Channel:      msg.Channel,
+			ConnectionID: c.id,
+			Timestamp:    ts,
+		}
+		for i, m := range msg.Presence {
+			m := *m
+			if m.ID == "" {
+				m.ID = fmt.Sprintf("%s:%d:%d", c.id, msg.MsgSerial, i)
+			}
+			if m.ClientID == "" {
+				m.ClientID = c.clientID
+			}
+			m.ConnectionID = c.id
+			m.Timestamp = ts
+			out.Presence = append(out.Presence, &m)
+		}
+		// Presence is delivered to all members, including the one entering.
+		s.serials[msg.Channel]++
+		out.ChannelSerial = s.channelSerial(msg.Channel)
+		for _, to := range s.conns {
+			if to.isAttached(msg.Channel) {
+				to.push(out)
+			}
+		}
+	case proto.ActionClose:
+		c.push(&proto.ProtocolMessage{Action: proto.ActionClosed})
+	}
+}
+
+// Conn is a client's connection to a Server. It implements proto.Conn.
+type Conn struct {
+	server   *Server
+	id       string
+	key      string
+	clientID string
+	noEcho   bool
+	url      *url.URL
+
+	mtx      sync.Mutex
+	in       []*proto.ProtocolMessage
+	sent     []*proto.ProtocolMessage
+	attached map[string]bool
+	notify   chan struct{}
+	err      error
+}
+
+// ID gives the connection ID sent to the client.
+func (c *Conn) ID() string {
+	return c.id
+}
+
+// URL gives the URL the client dialed, with its query parameters.
+func (c *Conn) URL() *url.URL {
+	return c.url
+}
+
+// Sent gives all protocol messages sent by the client so far.
+func (c *Conn) Sent() []*proto.ProtocolMessage {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return append([]*proto.ProtocolMessage(nil), c.sent...)
+}
+
+// Attached tells whether the client attached the given channel.
+func (c *Conn) Attached(channel string) bool {
+	return c.isAttached(channel)
+}
+
+// Inject sends msg to the client.
+func (c *Conn) Inject(msg *proto.ProtocolMessage) {
+	c.push(msg)
+}
+
+// Drop breaks the connection as if the network failed: the client's pending
+// and future receives fail with err.
+func (c *Conn) Drop(err error) {
+	c.mtx.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mtx.Unlock()
+	c.wake()
+}
+
+// Send implements proto.Conn.
+func (c *Conn) Send(msg *proto.ProtocolMessage) error {
+	c.mtx.Lock()
+	if c.err != nil {
+		err := c.err
+		c.mtx.Unlock()
+		return err
+	}
+	c.sent = append(c.sent, msg)
+	c.mtx.Unlock()
+
+	s := c.server
+	if s.opts.Handler != nil && s.opts.Handler(c, msg) {
+		return nil
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.handle(c, msg)
+	return nil
+}
+
+// Receive implements proto.Conn.
+func (c *Conn) Receive(deadline time.Time) (*proto.ProtocolMessage, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		c.mtx.Lock()
+		if len(c.in) != 0 {
+			msg := c.in[0]
+			c.in = c.in[1:]
+			c.mtx.Unlock()
+			return msg, nil
+		}
+		err := c.err
+		c.mtx.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case <-c.notify:
+		case <-timeout:
+			return nil, errTimeout{}
+		}
+	}
+}
+
+// Close implements proto.Conn.
+func (c *Conn) Close() error {
+	c.Drop(ErrConnClosed)
+	return nil
+}
+
+// push queues a copy of msg to be received by the client: like messages
+// decoded from a real transport, it's owned by the client, which modifies
+// it, e.g. when decoding message data.
+func (c *Conn) push(msg *proto.ProtocolMessage) {
+	msg = cloneMessage(msg)
+	c.mtx.Lock()
+	closed := c.err != nil
+	if !closed {
+		c.in = append(c.in, msg)
+	}
+	c.mtx.Unlock()
+	if !closed {
+		c.wake()
+	}
+}
+
+func cloneMessage(msg *proto.ProtocolMessage) *proto.ProtocolMessage {
+	clone := *msg
+	clone.Messages = nil
+	for _, m := range msg.Messages {
+		m := *m
+		clone.Messages = append(clone.Messages, &m)
+	}
+	clone.Presence = nil
+	for _, m := range msg.Presence {
+		m := *m
+		clone.Presence = append(clone.Presence, &m)
+	}
+	return &clone
+}
+
+func (c *Conn) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Conn) isAttached(channel string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.attached[channel]
+}
+
+func (c *Conn) setAttached(channel string, attached bool) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if attached {
+		c.attached[channel] = true
+	} else {
+		delete(c.attached, channel)
+	}
+}
+
+type errTimeout struct{}
+
+func (errTimeout) Error() string   { return "ablymock: receive timeout" }
+func (errTimeout) Temporary() bool { return true }
+func (errTimeout) Timeout() bool   { return true }
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
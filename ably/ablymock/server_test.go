@@ -0,0 +1,155 @@
+package ablymock_test
+
+import (
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablymock"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func newClient(t *testing.T, server *ablymock.Server, clock *ablymock.Clock) *ably.RealtimeClient {
+	t.Helper()
+	client, err := ably.NewRealtimeClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+		Dial:        server.Dial,
+		Clock:       clock,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	clock := ablymock.NewClock(time.Unix(1500000000, 0))
+	server := ablymock.NewServer(&ablymock.Options{Clock: clock})
+	publisher := newClient(t, server, clock)
+	subscriber := newClient(t, server, clock)
+	defer safeClose(publisher, subscriber)
+
+	sub, err := subscriber.Channels.Get("test").Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	if err := ablytest.Wait(subscriber.Channels.Get("test").Attach()); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(time.Minute)
+	if err := ablytest.Wait(publisher.Channels.Get("test").Publish("event", "data")); err != nil {
+		t.Fatal(err)
+	}
+	msg := recv(t, sub)
+	if msg.Name != "event" || msg.Data != "data" {
+		t.Errorf("want event=data; got %s=%v", msg.Name, msg.Data)
+	}
+	if id := publisher.Connection.ID(); msg.ConnectionID != id {
+		t.Errorf("want ConnectionID=%q; got %q", id, msg.ConnectionID)
+	}
+	if ts := ably.Time(clock.Now()); msg.Timestamp != ts {
+		t.Errorf("want Timestamp=%d; got %d", ts, msg.Timestamp)
+	}
+
+	server.Publish("test", &proto.Message{Name: "injected", Data: "x"})
+	if msg := recv(t, sub); msg.Name != "injected" {
+		t.Errorf("want injected message; got %q", msg.Name)
+	}
+
+	conns := server.Conns()
+	if len(conns) != 2 {
+		t.Fatalf("want 2 connections; got %d", len(conns))
+	}
+	if !conns[1].Attached("test") {
+		t.Error("want subscriber's connection to be attached")
+	}
+}
+
+func TestServer_Handler(t *testing.T) {
+	t.Parallel()
+
+	clock := ablymock.NewClock(time.Now())
+	server := ablymock.NewServer(&ablymock.Options{
+		Handler: func(c *ablymock.Conn, msg *proto.ProtocolMessage) bool {
+			if msg.Action != proto.ActionMessage {
+				return false
+			}
+			c.Inject(&proto.ProtocolMessage{
+				Action:    proto.ActionNack,
+				MsgSerial: msg.MsgSerial,
+				Count:     1,
+				Error:     &proto.ErrorInfo{Code: 40160, StatusCode: 401, Message: "denied"},
+			})
+			return true
+		},
+	})
+	client := newClient(t, server, clock)
+	defer safeClose(client)
+
+	err := ablytest.Wait(client.Channels.Get("test").Publish("event", "data"))
+	if e, ok := err.(*ably.Error); !ok || e.Code != 40160 {
+		t.Fatalf("want error code 40160; got %v", err)
+	}
+}
+
+func TestClock_Reconnect(t *testing.T) {
+	t.Parallel()
+
+	clock := ablymock.NewClock(time.Unix(1500000000, 0))
+	var unreachable int32
+	server := ablymock.NewServer(&ablymock.Options{
+		Clock: clock,
+		DialError: func(u *url.URL) error {
+			if atomic.LoadInt32(&unreachable) != 0 {
+				return errors.New("unreachable")
+			}
+			return nil
+		},
+	})
+	client := newClient(t, server, clock)
+	defer safeClose(client)
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection fails, and so does reconnecting right away; the next
+	// attempt is scheduled on the clock.
+	atomic.StoreInt32(&unreachable, 1)
+	server.Conns()[0].Drop(errors.New("network down"))
+	clock.BlockUntil(1)
+	if state := client.Connection.State(); state != ably.StateConnDisconnected {
+		t.Fatalf("want %v; got %v", ably.StateConnDisconnected, state)
+	}
+
+	atomic.StoreInt32(&unreachable, 0)
+	clock.Advance(client.Connection.RetryIn())
+	ablytest.Soon.WaitForState(t, client.Connection, ably.StateConnConnected)
+	if n := len(server.Conns()); n != 2 {
+		t.Fatalf("want 2 connections; got %d", n)
+	}
+}
+
+func recv(t *testing.T, sub *ably.Subscription) *proto.Message {
+	t.Helper()
+	select {
+	case msg := <-sub.MessageChannel():
+		return msg
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for message")
+		return nil
+	}
+}
+
+func safeClose(clients ...*ably.RealtimeClient) {
+	for _, c := range clients {
+		c.Close()
+	}
+}
@@ -0,0 +1,290 @@
+// Package ablysandbox creates temporary apps in the Ably sandbox environment,
+// for integration tests of code which uses the ably package.
+//
+// An app is created with a set of keys, each with its own capability, and is
+// deleted once the test is done with it:
+//
+//	app, err := ablysandbox.NewApp(ctx, &ablysandbox.Options{
+//		Config: &ablysandbox.Config{
+//			Keys: []ablysandbox.Key{
+//				{},
+//				{RawCapability: `{"public:*":["subscribe"]}`},
+//			},
+//		},
+//	})
+//	if err != nil {
+//		t.Fatal(err)
+//	}
+//	defer app.Close()
+//	client, err := app.NewRealtimeClient(&ably.ClientOptions{
+//		AuthOptions: ably.AuthOptions{Key: app.KeyAt(1)},
+//	})
+package ablysandbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path"
+
+	"github.com/ably/ably-go/ably"
+)
+
+// DefaultEnvironment is the environment apps are created in when
+// Options.Environment is empty.
+const DefaultEnvironment = "sandbox"
+
+// Key is an API key of a sandbox app. An empty RawCapability grants all
+// operations on all channels.
+type Key struct {
+	ID            string `json:"id,omitempty"`
+	ScopeID       string `json:"scopeId,omitempty"`
+	Status        int    `json:"status,omitempty"`
+	Type          int    `json:"type,omitempty"`
+	Value         string `json:"value,omitempty"`
+	Created       int    `json:"created,omitempty"`
+	Modified      int    `json:"modified,omitempty"`
+	RawCapability string `json:"capability,omitempty"`
+	Expires       int    `json:"expired,omitempty"`
+	Privileged    bool   `json:"privileged,omitempty"`
+}
+
+// Capability gives the decoded capability of the key.
+func (k *Key) Capability() ably.Capability {
+	c, _ := ably.ParseCapability(k.RawCapability)
+	return c
+}
+
+// Namespace configures the channels whose names are prefixed with
+// the namespace ID.
+type Namespace struct {
+	ID        string `json:"id"`
+	Created   int    `json:"created,omitempty"`
+	Modified  int    `json:"modified,omitempty"`
+	Persisted bool   `json:"persisted,omitempty"`
+}
+
+// Presence is a presence member fixture.
+type Presence struct {
+	ClientID string `json:"clientId"`
+	Data     string `json:"data"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Channel is a channel fixture, with its initial presence members.
+type Channel struct {
+	Name     string     `json:"name"`
+	Presence []Presence `json:"presence,omitempty"`
+}
+
+// Connection is a connection fixture.
+type Connection struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// Config describes a sandbox app. When the app is created, the IDs and
+// values of its keys are filled in.
+type Config struct {
+	ID          string       `json:"id,omitempty"`
+	AppID       string       `json:"appId,omitempty"`
+	AccountID   string       `json:"accountId,omitempty"`
+	Status      int          `json:"status,omitempty"`
+	Created     int          `json:"created,omitempty"`
+	Modified    int          `json:"modified,omitempty"`
+	TLSOnly     bool         `json:"tlsOnly,omitempty"`
+	Labels      string       `json:"labels,omitempty"`
+	Keys        []Key        `json:"keys"`
+	Namespaces  []Namespace  `json:"namespaces"`
+	Channels    []Channel    `json:"channels"`
+	Connections []Connection `json:"connections,omitempty"`
+}
+
+// DefaultConfig gives the configuration used by the library's own tests:
+// a single key with all capabilities, a "persisted" namespace and
+// a channel with presence fixtures.
+func DefaultConfig() *Config {
+	return &Config{
+		Keys: []Key{
+			{},
+		},
+		Namespaces: []Namespace{
+			{ID: "persisted", Persisted: true},
+		},
+		Channels: []Channel{
+			{
+				Name: "persisted:presence_fixtures",
+				Presence: []Presence{
+					{ClientID: "client_bool", Data: "true"},
+					{ClientID: "client_int", Data: "true"},
+					{ClientID: "client_string", Data: "true"},
+					{ClientID: "client_json", Data: `{"test": "This is a JSONObject clientData payload"}`},
+				},
+			},
+		},
+	}
+}
+
+// Options configures the creation of an app.
+type Options struct {
+	// Config describes the app to create; DefaultConfig is used if nil.
+	Config *Config
+
+	// Environment is the Ably environment to create the app in;
+	// DefaultEnvironment is used if empty.
+	Environment string
+
+	// HTTPClient is used for the requests which create and delete the app;
+	// http.DefaultClient is used if nil.
+	HTTPClient *http.Client
+}
+
+// App is a temporary app in the Ably sandbox.
+type App struct {
+	// Config is the configuration of the app, with the app ID and the keys
+	// filled in by the sandbox.
+	Config *Config
+
+	// Environment is the Ably environment the app lives in.
+	Environment string
+
+	client *http.Client
+}
+
+// NewApp creates a new app in the sandbox. The app must be deleted with Close
+// when no longer needed. The opts may be nil.
+func NewApp(ctx context.Context, opts *Options) (*App, error) {
+	app := &App{
+		Environment: DefaultEnvironment,
+		client:      http.DefaultClient,
+	}
+	if opts != nil {
+		app.Config = opts.Config
+		if opts.Environment != "" {
+			app.Environment = opts.Environment
+		}
+		if opts.HTTPClient != nil {
+			app.client = opts.HTTPClient
+		}
+	}
+	if app.Config == nil {
+		app.Config = DefaultConfig()
+	}
+	p, err := json.Marshal(app.Config)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", app.URL("apps"), bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	resp, err := app.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := checkResponse(resp); err != nil {
+		return nil, err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(app.Config); err != nil {
+		return nil, err
+	}
+	if len(app.Config.Keys) == 0 {
+		return nil, errors.New("ablysandbox: app created with no keys")
+	}
+	return app, nil
+}
+
+// Close deletes the app from the sandbox.
+func (app *App) Close() error {
+	return app.Delete(context.Background())
+}
+
+// Delete deletes the app from the sandbox.
+func (app *App) Delete(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", app.URL("apps", app.Config.AppID), nil)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(app.KeyParts())
+	resp, err := app.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return checkResponse(resp)
+}
+
+// KeyParts gives the name and secret of the app's first key.
+func (app *App) KeyParts() (name, secret string) {
+	return app.KeyPartsAt(0)
+}
+
+// KeyPartsAt gives the name and secret of the app's i-th key, in the order
+// of Config.Keys.
+func (app *App) KeyPartsAt(i int) (name, secret string) {
+	key := app.Config.Keys[i]
+	return app.Config.AppID + "." + key.ID, key.Value
+}
+
+// Key gives the app's first key, in the form expected by
+// ably.AuthOptions.Key.
+func (app *App) Key() string {
+	return app.KeyAt(0)
+}
+
+// KeyAt gives the app's i-th key, in the form expected by
+// ably.AuthOptions.Key.
+func (app *App) KeyAt(i int) string {
+	name, secret := app.KeyPartsAt(i)
+	return name + ":" + secret
+}
+
+// Options gives a copy of opts set up to connect to the app's environment,
+// authenticating with the app's first key unless opts already sets a key or
+// another means of authentication. The opts may be nil.
+func (app *App) Options(opts *ably.ClientOptions) *ably.ClientOptions {
+	var o ably.ClientOptions
+	if opts != nil {
+		o = *opts
+	}
+	if o.Environment == "" {
+		o.Environment = app.Environment
+	}
+	if o.Key == "" && o.Token == "" && o.TokenDetails == nil && o.AuthURL == "" && o.AuthCallback == nil {
+		o.Key = app.Key()
+	}
+	return &o
+}
+
+// NewRestClient gives a REST client for the app; see Options.
+func (app *App) NewRestClient(opts *ably.ClientOptions) (*ably.RestClient, error) {
+	return ably.NewRestClient(app.Options(opts))
+}
+
+// NewRealtimeClient gives a realtime client for the app; see Options.
+func (app *App) NewRealtimeClient(opts *ably.ClientOptions) (*ably.RealtimeClient, error) {
+	return ably.NewRealtimeClient(app.Options(opts))
+}
+
+// URL gives the URL of the given path in the app's environment REST API.
+func (app *App) URL(paths ...string) string {
+	return "https://" + app.Environment + "-rest.ably.io/" + path.Join(paths...)
+}
+
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode < 300 {
+		return nil
+	}
+	err := errors.New(http.StatusText(resp.StatusCode))
+	if p, e := ioutil.ReadAll(resp.Body); e == nil && len(p) != 0 {
+		err = fmt.Errorf("request error: %s (%q)", err, p)
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package ablysandbox_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablysandbox"
+)
+
+// handlerTransport serves requests with a handler instead of the network.
+type handlerTransport struct {
+	http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rec := httptest.NewRecorder()
+	t.ServeHTTP(rec, req)
+	return rec.Result(), nil
+}
+
+func TestApp(t *testing.T) {
+	t.Parallel()
+
+	var requests []string
+	var deleteUser, deletePass string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.String())
+		switch r.Method {
+		case "POST":
+			var config ablysandbox.Config
+			if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+				t.Error(err)
+			}
+			config.AppID = "app"
+			for i := range config.Keys {
+				config.Keys[i].ID = string(rune('a' + i))
+				config.Keys[i].Value = "secret"
+			}
+			w.WriteHeader(http.StatusCreated)
+			json.NewEncoder(w).Encode(config)
+		case "DELETE":
+			deleteUser, deletePass, _ = r.BasicAuth()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	app, err := ablysandbox.NewApp(context.Background(), &ablysandbox.Options{
+		Config: &ablysandbox.Config{
+			Keys: []ablysandbox.Key{
+				{},
+				{RawCapability: `{"public:*":["subscribe"]}`},
+			},
+		},
+		Environment: "test",
+		HTTPClient:  &http.Client{Transport: handlerTransport{handler}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key := app.KeyAt(1); key != "app.b:secret" {
+		t.Errorf("want key app.b:secret; got %q", key)
+	}
+	if c := app.Config.Keys[1].Capability(); len(c["public:*"]) != 1 {
+		t.Errorf("want subscribe capability on public:*; got %v", c)
+	}
+
+	opts := app.Options(&ably.ClientOptions{ClientID: "client"})
+	if opts.Environment != "test" || opts.Key != "app.a:secret" || opts.ClientID != "client" {
+		t.Errorf("unexpected options %+v", opts)
+	}
+	opts = app.Options(&ably.ClientOptions{AuthOptions: ably.AuthOptions{Token: "token"}})
+	if opts.Key != "" {
+		t.Errorf("want no key with token auth; got %q", opts.Key)
+	}
+
+	if err := app.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if deleteUser != "app.a" || deletePass != "secret" {
+		t.Errorf("want DELETE authenticated with app.a:secret; got %s:%s", deleteUser, deletePass)
+	}
+	want := []string{
+		"POST https://test-rest.ably.io/apps",
+		"DELETE https://test-rest.ably.io/apps/app",
+	}
+	if len(requests) != len(want) || requests[0] != want[0] || requests[1] != want[1] {
+		t.Errorf("want requests %q; got %q", want, requests)
+	}
+}
+
+func TestApp_Error(t *testing.T) {
+	t.Parallel()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad config", http.StatusBadRequest)
+	})
+	_, err := ablysandbox.NewApp(context.Background(), &ablysandbox.Options{
+		HTTPClient: &http.Client{Transport: handlerTransport{handler}},
+	})
+	if err == nil {
+		t.Fatal("want error creating app")
+	}
+}
@@ -40,6 +40,9 @@ func init() {
 	if s := os.Getenv("ABLY_ENV"); s != "" {
 		Environment = s
 	}
+	if s := os.Getenv("ABLY_COMMON"); s != "" {
+		CommonDir = s
+	}
 }
 
 func MergeOptions(opts ...*ably.ClientOptions) *ably.ClientOptions {
@@ -2,10 +2,7 @@ package ablytest
 
 import (
 	"encoding/base64"
-	"encoding/json"
 	"errors"
-	"os"
-	"path/filepath"
 )
 
 type CryptoData struct {
@@ -22,14 +19,8 @@ type CryptoData struct {
 
 func LoadCryptoData(rel string) (*CryptoData, []byte, []byte, error) {
 	data := &CryptoData{}
-	f, err := os.Open(filepath.Join("..", "..", "common", filepath.FromSlash(rel)))
-	if err != nil {
-		return nil, nil, nil, errors.New("missing common subrepo - ensure git submodules are initialized")
-	}
-	err = json.NewDecoder(f).Decode(data)
-	f.Close()
-	if err != nil {
-		return nil, nil, nil, errors.New("unable to unmarshal test cases: " + err.Error())
+	if err := LoadFixture(rel, data); err != nil {
+		return nil, nil, nil, err
 	}
 	key, err := base64.StdEncoding.DecodeString(data.Key)
 	if err != nil {
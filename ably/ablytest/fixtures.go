@@ -0,0 +1,183 @@
+package ablytest
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// CommonDir is the checkout of ably-common holding the test vectors shared
+// by Ably's SDKs. It defaults to the common submodule of this repository,
+// or to $ABLY_COMMON if set.
+var CommonDir = defaultCommonDir()
+
+func defaultCommonDir() string {
+	_, file, _, ok := runtime.Caller(0)
+	if !ok {
+		return "common"
+	}
+	return filepath.Join(filepath.Dir(file), "..", "..", "common")
+}
+
+// ErrNoCommon is returned by LoadFixture when CommonDir lacks the fixture.
+var ErrNoCommon = errors.New("missing common subrepo - ensure git submodules are initialized")
+
+// LoadFixture decodes the JSON fixture at rel, a slash-separated path
+// relative to CommonDir, into v.
+func LoadFixture(rel string, v interface{}) error {
+	f, err := os.Open(filepath.Join(CommonDir, filepath.FromSlash(rel)))
+	if err != nil {
+		return ErrNoCommon
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return errors.New("unable to unmarshal test cases: " + err.Error())
+	}
+	return nil
+}
+
+// MessageEncodingFixture is a test vector of messages-encoding.json: a
+// message's data as sent over JSON with its encoding, and the value it
+// decodes to.
+type MessageEncodingFixture struct {
+	Data             interface{} `json:"data"`
+	Encoding         string      `json:"encoding"`
+	ExpectedType     string      `json:"expectedType"`
+	ExpectedValue    interface{} `json:"expectedValue"`
+	ExpectedHexValue string      `json:"expectedHexValue"`
+}
+
+// LoadMessageEncodingFixtures gives the test vectors of
+// messages-encoding.json.
+func LoadMessageEncodingFixtures() ([]MessageEncodingFixture, error) {
+	var fixtures struct {
+		Messages []MessageEncodingFixture `json:"messages"`
+	}
+	if err := LoadFixture("test-resources/messages-encoding.json", &fixtures); err != nil {
+		return nil, err
+	}
+	return fixtures.Messages, nil
+}
+
+// RunMessageEncodingFixtures checks that each test vector of
+// messages-encoding.json decodes to its expected value, and that the value
+// is encoded back to the same data and encoding, as every Ably SDK does. It
+// skips the test if CommonDir lacks the fixtures.
+//
+// Packages registering codecs with proto.RegisterCodec can run it to check
+// they don't break compatibility.
+func RunMessageEncodingFixtures(t *testing.T) {
+	t.Helper()
+	fixtures, err := LoadMessageEncodingFixtures()
+	if err == ErrNoCommon {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range fixtures {
+		b, err := json.Marshal(map[string]interface{}{"data": f.Data, "encoding": f.Encoding})
+		if err != nil {
+			t.Fatalf("fixture %d: %v", i, err)
+		}
+		var decoded proto.Message
+		if err := json.Unmarshal(b, &decoded); err != nil {
+			t.Errorf("fixture %d: decoding %s: %v", i, b, err)
+			continue
+		}
+		var expected interface{}
+		switch f.ExpectedType {
+		case "string":
+			expected = f.ExpectedValue
+		case "binary":
+			if expected, err = hex.DecodeString(f.ExpectedHexValue); err != nil {
+				t.Fatalf("fixture %d: %v", i, err)
+			}
+		default:
+			// JSON objects and arrays are decoded as by encoding/json.
+			expected = f.ExpectedValue
+		}
+		if !reflect.DeepEqual(decoded.Data, expected) {
+			t.Errorf("fixture %d: want %s data %#v; got %#v", i, f.ExpectedType, expected, decoded.Data)
+			continue
+		}
+
+		b, err = json.Marshal(proto.Message{Data: decoded.Data})
+		if err != nil {
+			t.Errorf("fixture %d: encoding %#v: %v", i, decoded.Data, err)
+			continue
+		}
+		var encoded MessageEncodingFixture
+		if err := json.Unmarshal(b, &encoded); err != nil {
+			t.Fatalf("fixture %d: %v", i, err)
+		}
+		if encoded.Encoding != f.Encoding {
+			t.Errorf("fixture %d: want encoding %q; got %q", i, f.Encoding, encoded.Encoding)
+		}
+		if f.Encoding == proto.JSON {
+			// Only the JSON value matters, not its formatting.
+			var want, got interface{}
+			if err := nonil(
+				json.Unmarshal([]byte(f.Data.(string)), &want),
+				json.Unmarshal([]byte(encoded.Data.(string)), &got),
+			); err != nil {
+				t.Errorf("fixture %d: %v", i, err)
+			} else if !reflect.DeepEqual(got, want) {
+				t.Errorf("fixture %d: want data %s; got %s", i, f.Data, encoded.Data)
+			}
+		} else if !reflect.DeepEqual(encoded.Data, f.Data) {
+			t.Errorf("fixture %d: want data %#v; got %#v", i, f.Data, encoded.Data)
+		}
+	}
+}
+
+// RunCryptoFixtures checks that each test vector of crypto-data-<keyLength>.json
+// decrypts to its expected value, and that the value is encrypted in a way
+// that decrypts back to it. It skips the test if CommonDir lacks the
+// fixtures.
+func RunCryptoFixtures(t *testing.T, keyLength int) {
+	t.Helper()
+	data, key, iv, err := LoadCryptoData("test-resources/crypto-data-" + strconv.Itoa(keyLength) + ".json")
+	if err == ErrNoCommon {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &proto.ChannelOptions{
+		Cipher: proto.CipherParams{Algorithm: proto.AES, KeyLength: keyLength, Key: key, IV: iv},
+	}
+	for i, item := range data.Items {
+		expected := &proto.Message{ChannelOptions: opts}
+		encrypted := &proto.Message{ChannelOptions: opts}
+		if err := nonil(expected.FromMap(item.Encoded), encrypted.FromMap(item.Encrypted)); err != nil {
+			t.Errorf("item %d: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(encrypted.Data, expected.Data) {
+			t.Errorf("item %d: want decrypted data %#v; got %#v", i, expected.Data, encrypted.Data)
+			continue
+		}
+		b, err := json.Marshal(proto.Message{Data: expected.Data, ChannelOptions: opts})
+		if err != nil {
+			t.Errorf("item %d: encrypting %#v: %v", i, expected.Data, err)
+			continue
+		}
+		roundTrip := proto.Message{ChannelOptions: opts}
+		if err := json.Unmarshal(b, &roundTrip); err != nil {
+			t.Errorf("item %d: decrypting %s: %v", i, b, err)
+			continue
+		}
+		if !reflect.DeepEqual(roundTrip.Data, expected.Data) {
+			t.Errorf("item %d: want data %#v after encrypting it; got %#v", i, expected.Data, roundTrip.Data)
+		}
+	}
+}
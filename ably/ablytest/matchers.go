@@ -0,0 +1,131 @@
+package ablytest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// MessageMatcher checks a received message, returning why it doesn't match,
+// or nil if it does.
+type MessageMatcher func(*proto.Message) error
+
+// MatchName matches messages with the given name.
+func MatchName(name string) MessageMatcher {
+	return func(m *proto.Message) error {
+		if m.Name != name {
+			return fmt.Errorf("want name %q; got %q", name, m.Name)
+		}
+		return nil
+	}
+}
+
+// MatchClientID matches messages published by the given client ID.
+func MatchClientID(clientID string) MessageMatcher {
+	return func(m *proto.Message) error {
+		if m.ClientID != clientID {
+			return fmt.Errorf("want client ID %q; got %q", clientID, m.ClientID)
+		}
+		return nil
+	}
+}
+
+// MatchData matches messages whose data deeply equals data.
+func MatchData(data interface{}) MessageMatcher {
+	return func(m *proto.Message) error {
+		if !reflect.DeepEqual(m.Data, data) {
+			return fmt.Errorf("want data %#v; got %#v", data, m.Data)
+		}
+		return nil
+	}
+}
+
+// MatchDataJSON matches messages whose data is the same JSON value as js,
+// regardless of formatting and of whether the data was decoded already.
+func MatchDataJSON(js string) MessageMatcher {
+	return func(m *proto.Message) error {
+		var want interface{}
+		if err := json.Unmarshal([]byte(js), &want); err != nil {
+			return fmt.Errorf("invalid expected JSON %q: %v", js, err)
+		}
+		var b []byte
+		switch data := m.Data.(type) {
+		case string:
+			b = []byte(data)
+		case []byte:
+			b = data
+		default:
+			var err error
+			if b, err = json.Marshal(data); err != nil {
+				return fmt.Errorf("data %#v isn't JSON: %v", m.Data, err)
+			}
+		}
+		var got interface{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			return fmt.Errorf("data %#v isn't JSON: %v", m.Data, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("want JSON data %s; got %s", js, b)
+		}
+		return nil
+	}
+}
+
+// RecvMessage asserts that a message is received from before the timeout,
+// and that it matches all matchers; if not, the test fails right away. It
+// returns the received message.
+func (wt WithTimeout) RecvMessage(t *testing.T, from <-chan *proto.Message, matchers ...MessageMatcher) *proto.Message {
+	t.Helper()
+	var m *proto.Message
+	select {
+	case m = <-from:
+	case <-time.After(wt.before):
+		t.Fatalf("timed out after %v waiting for a message", wt.before)
+	}
+	if m == nil {
+		t.Fatal("message channel closed")
+	}
+	for _, match := range matchers {
+		if err := match(m); err != nil {
+			t.Fatalf("unexpected message %+v: %v", m, err)
+		}
+	}
+	return m
+}
+
+// NoRecvMessage asserts that no message is received from before the
+// timeout.
+func (wt WithTimeout) NoRecvMessage(t *testing.T, from <-chan *proto.Message) {
+	t.Helper()
+	select {
+	case m := <-from:
+		t.Fatalf("unexpected message %+v", m)
+	case <-time.After(wt.before):
+	}
+}
+
+// StateWaiter is implemented by ably.Conn and ably.RealtimeChannel.
+type StateWaiter interface {
+	State() ably.StateEnum
+	WaitForState(ctx context.Context, states ...ably.StateEnum) (ably.State, error)
+}
+
+// WaitForState asserts that the connection or channel is in, or gets to,
+// one of the given states before the timeout; if not, the test fails right
+// away. It returns the state change, or the current state.
+func (wt WithTimeout) WaitForState(t *testing.T, of StateWaiter, states ...ably.StateEnum) ably.State {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), wt.before)
+	defer cancel()
+	st, err := of.WaitForState(ctx, states...)
+	if err != nil {
+		t.Fatalf("timed out after %v waiting for any of states %v; state is %v", wt.before, states, of.State())
+	}
+	return st
+}
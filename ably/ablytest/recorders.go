@@ -289,29 +289,38 @@ func (rec *StateRecorder) timeout() time.Duration {
 
 func MessagePipe(in <-chan *proto.ProtocolMessage, out chan<- *proto.ProtocolMessage) func(string, *url.URL) (proto.Conn, error) {
 	return func(proto string, u *url.URL) (proto.Conn, error) {
-		return pipeConn{
-			in:  in,
-			out: out,
+		return &pipeConn{
+			in:     in,
+			out:    out,
+			closed: make(chan struct{}),
 		}, nil
 	}
 }
 
 type pipeConn struct {
-	in  <-chan *proto.ProtocolMessage
-	out chan<- *proto.ProtocolMessage
+	in     <-chan *proto.ProtocolMessage
+	out    chan<- *proto.ProtocolMessage
+	closed chan struct{}
+	once   sync.Once
 }
 
-func (pc pipeConn) Send(msg *proto.ProtocolMessage) error {
+func (pc *pipeConn) Send(msg *proto.ProtocolMessage) error {
 	pc.out <- msg
 	return nil
 }
 
-func (pc pipeConn) Receive(deadline time.Time) (*proto.ProtocolMessage, error) {
+func (pc *pipeConn) Receive(deadline time.Time) (*proto.ProtocolMessage, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timeout = time.After(time.Until(deadline))
+	}
 	select {
 	case m := <-pc.in:
 		return m, nil
-	case <-time.After(time.Until(deadline)):
+	case <-timeout:
 		return nil, errTimeout{}
+	case <-pc.closed:
+		return nil, errClosed{}
 	}
 }
 
@@ -323,7 +332,12 @@ func (errTimeout) Timeout() bool   { return true }
 
 var _ net.Error = errTimeout{}
 
-func (pc pipeConn) Close() error {
+type errClosed struct{}
+
+func (errClosed) Error() string { return "use of closed pipe" }
+
+func (pc *pipeConn) Close() error {
+	pc.once.Do(func() { close(pc.closed) })
 	return nil
 }
 
@@ -0,0 +1,185 @@
+package ablytest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// ErrReplayExhausted is returned by ProtocolReplayer.Dial when all recorded
+// transports were already replayed.
+var ErrReplayExhausted = errors.New("ablytest: no more recorded transports to replay")
+
+// ProtocolReplayer replays a recording written to
+// ably.ClientOptions.ProtocolRecorder, feeding the recorded protocol messages
+// back to a client. It is plugged into the client with its Dial method:
+//
+//	replayer, err := ablytest.NewProtocolReplayer(recording)
+//	client, err := ably.NewRealtimeClient(&ably.ClientOptions{
+//		AuthOptions: ably.AuthOptions{Key: "app.key:secret"},
+//		Dial:        replayer.Dial,
+//	})
+//
+// Each dial replays the next recorded transport. A recorded message is
+// received by the client only after it sent as many messages over the
+// transport as it did before receiving it in the recording, so replies are
+// received in the same order relative to the client's requests.
+type ProtocolReplayer struct {
+	mtx        sync.Mutex
+	transports [][]ably.ProtocolRecord
+	dialed     int
+	sent       []*proto.ProtocolMessage
+}
+
+// NewProtocolReplayer reads a recording from r.
+func NewProtocolReplayer(r io.Reader) (*ProtocolReplayer, error) {
+	rep := &ProtocolReplayer{}
+	dec := json.NewDecoder(r)
+	for {
+		var rec ably.ProtocolRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rec.Event == ably.ProtocolRecordDial {
+			rep.transports = append(rep.transports, nil)
+			continue
+		}
+		if len(rep.transports) == 0 {
+			return nil, errors.New("ablytest: recording doesn't start with a dial")
+		}
+		i := len(rep.transports) - 1
+		rep.transports[i] = append(rep.transports[i], rec)
+	}
+	return rep, nil
+}
+
+// Dial replays the next recorded transport; it is meant to be used as
+// ably.ClientOptions.Dial.
+func (rep *ProtocolReplayer) Dial(protocol string, u *url.URL) (proto.Conn, error) {
+	rep.mtx.Lock()
+	defer rep.mtx.Unlock()
+	if rep.dialed == len(rep.transports) {
+		return nil, ErrReplayExhausted
+	}
+	records := rep.transports[rep.dialed]
+	rep.dialed++
+	return &replayConn{
+		rep:     rep,
+		records: records,
+		notify:  make(chan struct{}, 1),
+	}, nil
+}
+
+// Sent gives all protocol messages sent by the client during the replay, to
+// be compared with the recorded ones.
+func (rep *ProtocolReplayer) Sent() []*proto.ProtocolMessage {
+	rep.mtx.Lock()
+	defer rep.mtx.Unlock()
+	return append([]*proto.ProtocolMessage(nil), rep.sent...)
+}
+
+type replayConn struct {
+	rep     *ProtocolReplayer
+	notify  chan struct{}
+	mtx     sync.Mutex
+	records []ably.ProtocolRecord
+	next    int // index of the next record to replay
+	expect  int // number of sends the next receive waits for
+	sends   int
+	closed  bool
+}
+
+func (c *replayConn) Send(msg *proto.ProtocolMessage) error {
+	c.mtx.Lock()
+	if c.closed {
+		c.mtx.Unlock()
+		return errReplayClosed
+	}
+	c.sends++
+	c.mtx.Unlock()
+	c.rep.mtx.Lock()
+	c.rep.sent = append(c.rep.sent, msg)
+	c.rep.mtx.Unlock()
+	c.wake()
+	return nil
+}
+
+func (c *replayConn) Receive(deadline time.Time) (*proto.ProtocolMessage, error) {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		if msg, ok, err := c.replay(); ok {
+			return msg, err
+		}
+		select {
+		case <-c.notify:
+		case <-timeout:
+			return nil, errReplayTimeout{}
+		}
+	}
+}
+
+// replay gives the next recorded receive if the client is ready for it.
+func (c *replayConn) replay() (msg *proto.ProtocolMessage, ok bool, err error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.closed {
+		return nil, true, errReplayClosed
+	}
+	for ; c.next < len(c.records); c.next++ {
+		rec := c.records[c.next]
+		switch rec.Event {
+		case ably.ProtocolRecordSend:
+			c.expect++
+		case ably.ProtocolRecordReceive:
+			if c.sends < c.expect {
+				return nil, false, nil
+			}
+			c.next++
+			if rec.Error != "" {
+				return nil, true, errors.New(rec.Error)
+			}
+			return rec.Message, true, nil
+		}
+	}
+	// The recording of this transport is over; block until closed or
+	// the deadline passes, as an idle transport would.
+	return nil, false, nil
+}
+
+func (c *replayConn) Close() error {
+	c.mtx.Lock()
+	c.closed = true
+	c.mtx.Unlock()
+	c.wake()
+	return nil
+}
+
+func (c *replayConn) wake() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+var errReplayClosed = errors.New("ablytest: replayed transport closed")
+
+type errReplayTimeout struct{}
+
+func (errReplayTimeout) Error() string   { return "ablytest: replay receive timeout" }
+func (errReplayTimeout) Temporary() bool { return true }
+func (errReplayTimeout) Timeout() bool   { return true }
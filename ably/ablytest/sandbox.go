@@ -1,104 +1,36 @@
 package ablytest
 
 import (
-	"bytes"
+	"context"
 	"crypto/tls"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
-	"path"
 	"time"
 
 	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablysandbox"
 )
 
-type Key struct {
-	ID            string `json:"id,omitempty"`
-	ScopeID       string `json:"scopeId,omitempty"`
-	Status        int    `json:"status,omitempty"`
-	Type          int    `json:"type,omitempty"`
-	Value         string `json:"value,omitempty"`
-	Created       int    `json:"created,omitempty"`
-	Modified      int    `json:"modified,omitempty"`
-	RawCapability string `json:"capability,omitempty"`
-	Expires       int    `json:"expired,omitempty"`
-	Privileged    bool   `json:"privileged,omitempty"`
-}
-
-func (k *Key) Capability() ably.Capability {
-	c, _ := ably.ParseCapability(k.RawCapability)
-	return c
-}
-
-type Namespace struct {
-	ID        string `json:"id"`
-	Created   int    `json:"created,omitempty"`
-	Modified  int    `json:"modified,omitempty"`
-	Persisted bool   `json:"persisted,omitempty"`
-}
-
-type Presence struct {
-	ClientID string `json:"clientId"`
-	Data     string `json:"data"`
-	Encoding string `json:"encoding,omitempty"`
-}
-
-type Channel struct {
-	Name     string     `json:"name"`
-	Presence []Presence `json:"presence,omitempty"`
-}
-
-type Connection struct {
-	Name string `json:"name"`
-	Key  string `json:"key"`
-}
-
-type Config struct {
-	ID          string       `json:"id,omitempty"`
-	AppID       string       `json:"appId,omitempty"`
-	AccountID   string       `json:"accountId,omitempty"`
-	Status      int          `json:"status,omitempty"`
-	Created     int          `json:"created,omitempty"`
-	Modified    int          `json:"modified,omitempty"`
-	TLSOnly     bool         `json:"tlsOnly,omitempty"`
-	Labels      string       `json:"labels,omitempty"`
-	Keys        []Key        `json:"keys"`
-	Namespaces  []Namespace  `json:"namespaces"`
-	Channels    []Channel    `json:"channels"`
-	Connections []Connection `json:"connections,omitempty"`
-}
+// The sandbox app types are defined in the public ablysandbox package.
+type (
+	Key        = ablysandbox.Key
+	Namespace  = ablysandbox.Namespace
+	Presence   = ablysandbox.Presence
+	Channel    = ablysandbox.Channel
+	Connection = ablysandbox.Connection
+	Config     = ablysandbox.Config
+)
 
 func DefaultConfig() *Config {
-	return &Config{
-		Keys: []Key{
-			{},
-		},
-		Namespaces: []Namespace{
-			{ID: "persisted", Persisted: true},
-		},
-		Channels: []Channel{
-			{
-				Name: "persisted:presence_fixtures",
-				Presence: []Presence{
-					{ClientID: "client_bool", Data: "true"},
-					{ClientID: "client_int", Data: "true"},
-					{ClientID: "client_string", Data: "true"},
-					{ClientID: "client_json", Data: `{"test": "This is a JSONObject clientData payload"}`},
-				},
-			},
-		},
-	}
+	return ablysandbox.DefaultConfig()
 }
 
 type Sandbox struct {
 	Config      *Config
 	Environment string
 
-	client *http.Client
+	app *ablysandbox.App
 }
 
 func NewRealtimeClient(opts *ably.ClientOptions) (*Sandbox, *ably.RealtimeClient) {
@@ -132,57 +64,23 @@ func NewSandbox(config *Config) (*Sandbox, error) {
 }
 
 func NewSandboxWIthEnv(config *Config, env string) (*Sandbox, error) {
-	app := &Sandbox{
+	app, err := ablysandbox.NewApp(context.Background(), &ablysandbox.Options{
 		Config:      config,
 		Environment: env,
-		client:      NewHTTPClient(),
-	}
-	if app.Config == nil {
-		app.Config = DefaultConfig()
-	}
-	p, err := json.Marshal(app.Config)
-	if err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequest("POST", app.URL("apps"), bytes.NewReader(p))
+		HTTPClient:  NewHTTPClient(),
+	})
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Accept", "application/json")
-	resp, err := app.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode > 299 {
-		err := errors.New(http.StatusText(resp.StatusCode))
-		if p, e := ioutil.ReadAll(resp.Body); e == nil && len(p) != 0 {
-			err = fmt.Errorf("request error: %s (%q)", err, p)
-		}
-		return nil, err
-	}
-	if err := json.NewDecoder(resp.Body).Decode(app.Config); err != nil {
-		return nil, err
-	}
-	return app, nil
+	return &Sandbox{
+		Config:      app.Config,
+		Environment: app.Environment,
+		app:         app,
+	}, nil
 }
 
 func (app *Sandbox) Close() error {
-	req, err := http.NewRequest("DELETE", app.URL("apps", app.Config.AppID), nil)
-	if err != nil {
-		return err
-	}
-	req.SetBasicAuth(app.KeyParts())
-	resp, err := app.client.Do(req)
-	if err != nil {
-		return err
-	}
-	resp.Body.Close()
-	if resp.StatusCode > 299 {
-		return errors.New(http.StatusText(resp.StatusCode))
-	}
-	return nil
+	return app.app.Close()
 }
 
 func (app *Sandbox) NewRealtimeClient(opts ...*ably.ClientOptions) *ably.RealtimeClient {
@@ -194,12 +92,11 @@ func (app *Sandbox) NewRealtimeClient(opts ...*ably.ClientOptions) *ably.Realtim
 }
 
 func (app *Sandbox) KeyParts() (name, secret string) {
-	return app.Config.AppID + "." + app.Config.Keys[0].ID, app.Config.Keys[0].Value
+	return app.app.KeyParts()
 }
 
 func (app *Sandbox) Key() string {
-	name, secret := app.KeyParts()
-	return name + ":" + secret
+	return app.app.Key()
 }
 
 func (app *Sandbox) Options(opts ...*ably.ClientOptions) *ably.ClientOptions {
@@ -229,7 +126,7 @@ func (app *Sandbox) Options(opts ...*ably.ClientOptions) *ably.ClientOptions {
 }
 
 func (app *Sandbox) URL(paths ...string) string {
-	return "https://" + app.Environment + "-rest.ably.io/" + path.Join(paths...)
+	return app.app.URL(paths...)
 }
 
 func NewHTTPClient() *http.Client {
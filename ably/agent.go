@@ -0,0 +1,49 @@
+package ably
+
+import (
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// AblyAgentHeader is the HTTP header carrying the agent identifier of the
+// library, see ClientOptions.Agents.
+//
+// Spec RSC7d
+const AblyAgentHeader = "Ably-Agent"
+
+// agentParam is the realtime transport param carrying the agent identifier.
+//
+// Spec RTN2g
+const agentParam = "agent"
+
+func goRuntimeAgent() string {
+	return "go/" + strings.TrimPrefix(runtime.Version(), "go")
+}
+
+// agent gives the agent identifier sent with every REST request and realtime
+// connection, in the form:
+//
+//	ably-go/1.1.5 go/1.15.2 os/linux [product/version...]
+//
+// Spec RSC7d1, RSC7d2
+func (opts *ClientOptions) agent() string {
+	agents := []string{
+		LibraryName + "/" + LibraryVersion,
+		goRuntimeAgent(),
+		"os/" + runtime.GOOS,
+	}
+	products := make([]string, 0, len(opts.Agents))
+	for product := range opts.Agents {
+		products = append(products, product)
+	}
+	sort.Strings(products)
+	for _, product := range products {
+		if version := opts.Agents[product]; version != "" {
+			agents = append(agents, product+"/"+version)
+		} else {
+			agents = append(agents, product)
+		}
+	}
+	return strings.Join(agents, " ")
+}
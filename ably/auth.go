@@ -2,7 +2,10 @@ package ably
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"mime"
@@ -24,9 +27,15 @@ var (
 	errUnsupportedType     = errors.New("unsupported Content-Type header in response from AuthURL")
 	errMissingType         = errors.New("missing Content-Type header in response from AuthURL")
 	errInvalidCallbackType = errors.New("invalid value type returned from AuthCallback")
+	errUnsignedTokenReq    = errors.New("unsigned token request returned from AuthCallback")
+	errNoTokenSource       = errors.New("no means to obtain a token: set Key, AuthURL or AuthCallback")
+	errAuthURLShape        = errors.New("response from AuthURL is neither a token request nor token details")
+	errAuthURLEmptyToken   = errors.New("empty token in response from AuthURL")
+	errAuthURLDowngrade    = errors.New("AuthURL redirected from https to http")
 	errInsecureBasicAuth   = errors.New("basic auth is not supported on insecure non-TLS connections")
 	errWildcardClientID    = errors.New("provided ClientID must not be a wildcard")
 	errClientIDMismatch    = errors.New("the received ClientID does not match the requested one")
+	errWildcardMessage     = errors.New("messages must not be published with the wildcard ClientID")
 )
 
 const wildcardClientID = "*"
@@ -66,7 +75,12 @@ type Auth struct {
 	host     string       // a host part of AuthURL
 	clientID string       // clientID of the authenticated user or wildcard "*"
 
+	// serverTimeOffset is the difference between the server clock and the
+	// local one, cached once queried for UseQueryTime (RSA10k) at
+	// serverTimeSynced, by the local clock.
 	serverTimeOffset time.Duration
+	serverTimeKnown  bool
+	serverTimeSynced time.Time
 
 	// ServerTimeHandler when provided this will be used to query server time.
 	serverTimeHandler func() (time.Time, error)
@@ -101,12 +115,19 @@ func newAuth(client *RestClient) (*Auth, error) {
 		}
 		// References RSC17, RSA7b1
 		a.clientID = a.opts().ClientID
+	} else if tok := a.opts().TokenDetails; tok != nil {
+		a.clientID = tok.ClientID // Spec RSA7b2
 	}
 
 	return a, nil
 }
 
-// ClientID
+// ClientID gives the identity of the client: the ClientID set in
+// ClientOptions, or else the one of the token the client authenticates
+// with, or else the one the connection was given by Ably, if any (RSA7, RSA12).
+//
+// An empty string is given if the client is anonymous or is allowed to act
+// on behalf of any client, i.e. it has the wildcard client ID "*".
 func (a *Auth) ClientID() string {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
@@ -119,22 +140,52 @@ func (a *Auth) ClientID() string {
 func (a *Auth) clientIDForCheck() string {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
-	if a.method == authBasic {
-		return wildcardClientID // for Basic Auth no ClientID check is performed
+	if a.method == authBasic && a.clientID == "" {
+		return wildcardClientID // a key can act on behalf of any client
 	}
 	return a.clientID
 }
 
-func (a *Auth) updateClientID(clientID string) {
+// checkClientID checks that a message with the given ClientID can be
+// published by the client (RSL1g, RTL6g).
+//
+// A client authenticated with a key or a wildcard token can publish on
+// behalf of any client. If the client's identity isn't known yet, e.g. it
+// was given an opaque token, the message is sent as is and it is up to
+// Ably to reject it.
+func (a *Auth) checkClientID(clientID string) error {
+	switch id := a.clientIDForCheck(); {
+	case clientID == wildcardClientID:
+		return newError(ErrInvalidClientID, errWildcardMessage)
+	case !canPublishAs(id, clientID):
+		return newErrorf(ErrInvalidClientID, "unable to publish message with ClientID %q incompatible with the client's ClientID %q", clientID, id)
+	}
+	return nil
+}
+
+// updateClientID adopts the ClientID Ably identified the connection with if
+// the client doesn't have one yet, or else checks that they match.
+func (a *Auth) updateClientID(clientID string) error {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
-	if a.clientID == "" {
+	switch {
+	case clientID == "" || clientID == a.clientID:
+	case a.clientID == "":
 		//Spec RSA7b3, RSA7b4, RSA12a,RSA12b, RSA7b2,
 		a.clientID = clientID
+	case a.clientID != wildcardClientID && clientID != wildcardClientID:
+		return newError(ErrInvalidClientID, errClientIDMismatch)
 	}
+	return nil
 }
 
-// CreateTokenRequest
+// CreateTokenRequest gives a token request signed locally with the key of
+// opts, or of the client's options if opts is nil, completing params with
+// the client's ClientID and DefaultTokenParams (RSA9).
+//
+// No network call is made, unless UseQueryTime is set and the offset to the
+// server time isn't known yet. See NewTokenRequest for signing token requests
+// without a client.
 func (a *Auth) CreateTokenRequest(params *TokenParams, opts *AuthOptions) (*TokenRequest, error) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
@@ -147,7 +198,7 @@ func (a *Auth) createTokenRequest(params *TokenParams, opts *AuthOptions) (*Toke
 	}
 	keySecret := opts.KeySecret()
 	req := &TokenRequest{KeyName: opts.KeyName()}
-	if params != nil {
+	if params := a.tokenParams(params); params != nil {
 		req.TokenParams = *params
 	}
 	if err := a.setDefaults(opts, req); err != nil {
@@ -164,15 +215,39 @@ func (a *Auth) createTokenRequest(params *TokenParams, opts *AuthOptions) (*Toke
 	return req, nil
 }
 
-// RequestToken
+// RequestToken is like RequestTokenContext, with context.Background().
 func (a *Auth) RequestToken(params *TokenParams, opts *AuthOptions) (*TokenDetails, error) {
+	return a.RequestTokenContext(context.Background(), params, opts)
+}
+
+// RequestTokenContext obtains a new token, without changing the token the
+// client authenticates with, e.g. for an auth server to issue tokens to its
+// clients. The token is obtained from the first of these sources in opts,
+// completed with the client's AuthOptions (RSA8):
+//
+//   - Token or TokenDetails, which are given as is
+//   - AuthCallback, whose result is given as is unless it's a TokenRequest,
+//     failing with ErrErrorFromClientTokenCallback
+//   - AuthURL, whose response is given as is unless it's a TokenRequest,
+//     failing with ErrErrorFromClientTokenCallback if the request fails
+//   - Key, which signs a token request locally, failing with
+//     ErrIncompatibleCredentials if it's invalid
+//
+// Token requests are then exchanged for a token with Ably. If there's no
+// source at all, it fails with ErrUnableToObtainCredentialsFromGivenParameters.
+//
+// ctx applies to requests sent to AuthURL and Ably.
+func (a *Auth) RequestTokenContext(ctx context.Context, params *TokenParams, opts *AuthOptions) (*TokenDetails, error) {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
-	tok, _, err := a.requestToken(params, opts)
+	tok, _, err := a.requestToken(ctx, params, opts)
 	return tok, err
 }
 
-func (a *Auth) requestToken(params *TokenParams, opts *AuthOptions) (tok *TokenDetails, tokReqClientID string, err error) {
+func (a *Auth) requestToken(ctx context.Context, params *TokenParams, opts *AuthOptions) (tok *TokenDetails, tokReqClientID string, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	log := a.logger().Sugar()
 	switch {
 	case opts != nil && opts.Token != "":
@@ -183,6 +258,7 @@ func (a *Auth) requestToken(params *TokenParams, opts *AuthOptions) (tok *TokenD
 		return opts.TokenDetails, "", nil
 	}
 	opts = a.mergeOpts(opts)
+	params = a.tokenParams(params)
 	var tokReq *TokenRequest
 	switch {
 	case opts.AuthCallback != nil:
@@ -194,9 +270,15 @@ func (a *Auth) requestToken(params *TokenParams, opts *AuthOptions) (tok *TokenD
 		}
 		switch v := v.(type) {
 		case *TokenRequest:
+			if v == nil || v.KeyName == "" || v.Mac == "" {
+				return nil, "", newError(ErrErrorFromClientTokenCallback, errUnsignedTokenReq)
+			}
 			tokReq = v
 			tokReqClientID = tokReq.ClientID
 		case *TokenDetails:
+			if v == nil {
+				return nil, "", newError(ErrErrorFromClientTokenCallback, errInvalidCallbackType)
+			}
 			return v, "", nil
 		case string:
 			return newTokenDetails(v), "", nil
@@ -205,7 +287,7 @@ func (a *Auth) requestToken(params *TokenParams, opts *AuthOptions) (tok *TokenD
 		}
 	case opts.AuthURL != "":
 		log.Verbose("Auth: found AuthURL in AuthOptions")
-		res, err := a.requestAuthURL(params, opts)
+		res, err := a.requestAuthURL(ctx, params, opts)
 		if err != nil {
 			log.Error("Auth: failed calling requesting token with AuthURL ", err)
 			return nil, "", err
@@ -217,6 +299,8 @@ func (a *Auth) requestToken(params *TokenParams, opts *AuthOptions) (tok *TokenD
 			tokReq = res
 			tokReqClientID = tokReq.ClientID
 		}
+	case opts.Key == "":
+		return nil, "", newError(ErrUnableToObtainCredentialsFromGivenParameters, errNoTokenSource)
 	default:
 		log.Verbose("Auth: using default token request")
 
@@ -233,6 +317,7 @@ func (a *Auth) requestToken(params *TokenParams, opts *AuthOptions) (tok *TokenD
 		In:     tokReq,
 		Out:    tok,
 		NoAuth: true,
+		ctx:    ctx,
 	}
 	if _, err := a.client.do(r); err != nil {
 		return nil, "", err
@@ -268,16 +353,13 @@ func (a *Auth) Authorize(params *TokenParams, opts *AuthOptions) (*TokenDetails,
 
 func (a *Auth) authorize(params *TokenParams, opts *AuthOptions, force bool) (*TokenDetails, error) {
 	log := a.logger().Sugar()
-	switch tok := a.token(); {
-	case tok != nil && !force && (tok.Expires == 0 || !tok.Expired()):
+	if tok := a.token(); tok != nil && !force && !a.tokenExpired(tok) {
 		return tok, nil
-	case params != nil && params.ClientID == "":
-		params.ClientID = a.clientID
-	case params == nil && a.clientID != "":
-		params = &TokenParams{ClientID: a.clientID}
 	}
 	log.Info("Auth: sending  token request")
-	tok, tokReqClientID, err := a.requestToken(params, opts)
+	ctx, span := a.opts().startSpan(nil, "ably.auth.token_request")
+	tok, tokReqClientID, err := a.requestToken(ctx, params, opts)
+	span.End(err)
 	if err != nil {
 		log.Error("Auth: failed to get token", err)
 		return nil, err
@@ -308,6 +390,34 @@ func (a *Auth) reauthorize() (*TokenDetails, error) {
 	return a.authorize(a.params, nil, true)
 }
 
+// tokenParams gives a copy of params completed with the client's ClientID
+// and then with DefaultTokenParams (RSA10j, TO3j11); it gives nil if there
+// are no params at all.
+func (a *Auth) tokenParams(params *TokenParams) *TokenParams {
+	var p TokenParams
+	if params != nil {
+		p = *params
+	}
+	if p.ClientID == "" {
+		p.ClientID = a.clientID
+	}
+	if d := a.opts().DefaultTokenParams; d != nil {
+		if p.TTL == 0 {
+			p.TTL = d.TTL
+		}
+		if p.RawCapability == "" {
+			p.RawCapability = d.RawCapability
+		}
+		if p.ClientID == "" {
+			p.ClientID = d.ClientID
+		}
+	}
+	if p == (TokenParams{}) {
+		return nil
+	}
+	return &p
+}
+
 func (a *Auth) mergeOpts(opts *AuthOptions) *AuthOptions {
 	if opts == nil {
 		opts = &a.opts().AuthOptions
@@ -318,15 +428,7 @@ func (a *Auth) mergeOpts(opts *AuthOptions) *AuthOptions {
 }
 
 func (a *Auth) setDefaults(opts *AuthOptions, req *TokenRequest) error {
-	if req.Nonce == "" {
-		req.Nonce = randomString(32)
-	}
-	if req.RawCapability == "" {
-		req.RawCapability = (Capability{"*": {"*"}}).Encode()
-	}
-	if req.TTL == 0 {
-		req.TTL = 60 * 60 * 1000
-	}
+	req.setDefaults()
 	if req.ClientID == "" {
 		req.ClientID = a.opts().ClientID
 	}
@@ -340,44 +442,93 @@ func (a *Auth) setDefaults(opts *AuthOptions, req *TokenRequest) error {
 	return nil
 }
 
-//Timestamp returns the timestamp to be used in authorization request.
-func (a *Auth) timestamp(query bool) (time.Time, error) {
-	var now time.Time
+// currentTime gives the current time according to the client's clock.
+func (a *Auth) currentTime() time.Time {
 	if a.now != nil {
-		now = a.now()
-	} else {
-		now = time.Now()
+		return a.now()
 	}
+	return a.opts().now()
+}
+
+//Timestamp returns the timestamp to be used in authorization request.
+func (a *Auth) timestamp(query bool) (time.Time, error) {
+	now := a.currentTime()
 	if !query {
 		return now, nil
 	}
-	if a.serverTimeOffset != 0 {
+	if a.serverTimeKnown && !a.serverTimeStale(now) {
 		// refers to rsa10k
 		//
 		// No need to do api call for time from the server. We are calculating it
 		// using the cached offset(duration) value.
 		return now.Add(a.serverTimeOffset), nil
 	}
-	var serverTime time.Time
+	serverTime, err := a.queryServerTime(context.Background())
+	if err != nil {
+		return time.Time{}, newError(ErrUnauthorized, err)
+	}
+	a.setServerTime(serverTime, now)
+	return serverTime, nil
+}
+
+// serverTimeStale tells whether the cached server time offset is due to be
+// queried again, as set by ServerTimeSyncInterval.
+func (a *Auth) serverTimeStale(now time.Time) bool {
+	if a.client == nil {
+		return false
+	}
+	interval := a.opts().ServerTimeSyncInterval
+	return interval > 0 && now.Sub(a.serverTimeSynced) >= interval
+}
+
+func (a *Auth) queryServerTime(ctx context.Context) (time.Time, error) {
 	if a.serverTimeHandler != nil {
-		t, err := a.serverTimeHandler()
-		if err != nil {
-			return time.Time{}, newError(ErrUnauthorized, err)
-		}
-		serverTime = t
-	} else {
-		t, err := a.client.Time()
-		if err != nil {
-			return time.Time{}, newError(ErrUnauthorized, err)
-		}
-		serverTime = t
+		return a.serverTimeHandler()
 	}
+	return a.client.TimeContext(ctx)
+}
+
+// setServerTime caches the offset between serverTime and the local time now.
+// It must be called with a.mtx held.
+func (a *Auth) setServerTime(serverTime, now time.Time) {
 	a.serverTimeOffset = serverTime.Sub(now)
-	return serverTime, nil
+	a.serverTimeKnown = true
+	a.serverTimeSynced = now
+}
+
+// ServerTimeOffset gives the difference between the server clock and the
+// local one, which is added to the local time to sign token requests and to
+// tell whether tokens have expired when UseQueryTime is set. The offset is
+// queried when first needed, or with SyncServerTime; ok is false if it
+// hasn't been yet.
+func (a *Auth) ServerTimeOffset() (offset time.Duration, ok bool) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	return a.serverTimeOffset, a.serverTimeKnown
 }
 
-func (a *Auth) requestAuthURL(params *TokenParams, opts *AuthOptions) (interface{}, error) {
-	req, err := http.NewRequest(opts.authMethod(), opts.AuthURL, nil)
+// SyncServerTime queries the server time, and caches its offset to the local
+// time, as given by ServerTimeOffset, whether UseQueryTime is set or not.
+func (a *Auth) SyncServerTime(ctx context.Context) (time.Duration, error) {
+	serverTime, err := a.queryServerTime(ctx)
+	if err != nil {
+		return 0, err
+	}
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+	a.setServerTime(serverTime, a.currentTime())
+	return a.serverTimeOffset, nil
+}
+
+// maxAuthURLResponse is the maximum size of an AuthURL response body.
+const maxAuthURLResponse = 64 << 10
+
+// maxAuthURLRedirects is the maximum number of redirects followed when
+// requesting AuthURL.
+const maxAuthURLRedirects = 10
+
+func (a *Auth) requestAuthURL(ctx context.Context, params *TokenParams, opts *AuthOptions) (interface{}, error) {
+	req, err := http.NewRequestWithContext(ctx, opts.authMethod(), opts.AuthURL, nil)
 	if err != nil {
 		return nil, a.newError(40000, err)
 	}
@@ -385,7 +536,8 @@ func (a *Auth) requestAuthURL(params *TokenParams, opts *AuthOptions) (interface
 	req.Header = addHeaders(req.Header, opts.AuthHeaders)
 	switch opts.authMethod() {
 	case "GET":
-		req.URL.RawQuery = query
+		// Keep the query of AuthURL itself, if any.
+		req.URL.RawQuery = addParams(addParams(params.Query(), opts.AuthParams), req.URL.Query()).Encode()
 	case "POST":
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		req.Header.Set("Content-Length", strconv.Itoa(len(query)))
@@ -393,7 +545,8 @@ func (a *Auth) requestAuthURL(params *TokenParams, opts *AuthOptions) (interface
 	default:
 		return nil, a.newError(40500, nil)
 	}
-	resp, err := a.opts().httpclient().Do(req)
+	req.Header.Set("Accept", "application/json, application/x-msgpack, text/plain, application/jwt")
+	resp, err := a.authURLClient(opts.AuthHeaders).Do(req)
 	if err != nil {
 		return nil, a.newError(ErrErrorFromClientTokenCallback, err)
 	}
@@ -402,35 +555,106 @@ func (a *Auth) requestAuthURL(params *TokenParams, opts *AuthOptions) (interface
 	}
 	defer resp.Body.Close()
 	typ, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
-	if err != nil {
+	if err != nil && resp.Header.Get("Content-Type") != "" {
 		return nil, a.newError(40004, err)
 	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxAuthURLResponse+1))
+	if err != nil {
+		return nil, a.newError(ErrErrorFromClientTokenCallback, err)
+	}
+	if len(body) > maxAuthURLResponse {
+		return nil, a.newError(ErrErrorFromClientTokenCallback,
+			fmt.Errorf("response from AuthURL exceeds %d bytes", maxAuthURLResponse))
+	}
 	switch typ {
-	case "text/plain":
-		token, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
+	case "text/plain", "application/jwt":
+		token := strings.TrimSpace(string(body))
+		if token == "" {
+			return nil, a.newError(ErrErrorFromClientTokenCallback, errAuthURLEmptyToken)
+		}
+		return newTokenDetails(token), nil
+	case protocolJSON:
+		// Tell token requests and token details apart by their fields.
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
 			return nil, a.newError(40000, err)
 		}
-		return newTokenDetails(string(token)), nil
-	case protocolJSON, protocolMsgPack:
+		switch {
+		case fields["mac"] != nil:
+			req, err := TokenRequestFromJSON(body)
+			if err != nil {
+				return nil, a.newError(40000, err)
+			}
+			return req, nil
+		case fields["token"] != nil:
+			tok, err := TokenDetailsFromJSON(body)
+			if err != nil {
+				return nil, a.newError(40000, err)
+			}
+			return tok, nil
+		}
+		return nil, a.newError(ErrErrorFromClientTokenCallback, errAuthURLShape)
+	case protocolMsgPack:
 		var req TokenRequest
-		var buf bytes.Buffer
-		err := decode(typ, io.TeeReader(resp.Body, &buf), &req)
-		if err == nil && req.Mac != "" && req.Nonce != "" {
+		if err := decode(typ, bytes.NewReader(body), &req); err == nil && req.Mac != "" && req.Nonce != "" {
 			return &req, nil
 		}
 		var token TokenDetails
-		if err := decode(typ, io.MultiReader(&buf, resp.Body), &token); err != nil {
+		if err := decode(typ, bytes.NewReader(body), &token); err != nil {
 			return nil, a.newError(40000, err)
 		}
+		if token.Token == "" {
+			return nil, a.newError(ErrErrorFromClientTokenCallback, errAuthURLShape)
+		}
 		return &token, nil
 	case "":
 		return nil, a.newError(40000, errMissingType)
 	default:
-		return nil, a.newError(40000, errUnsupportedType)
+		return nil, a.newError(40000, fmt.Errorf("%v: %q", errUnsupportedType, typ))
 	}
 }
 
+// authURLClient gives the HTTP client of the client options, made to
+// follow at most maxAuthURLRedirects redirects, never from https to http,
+// and dropping the given AuthHeaders when redirected to another host.
+func (a *Auth) authURLClient(authHeaders http.Header) *http.Client {
+	c := *a.opts().httpclient()
+	next := c.CheckRedirect
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxAuthURLRedirects {
+			return fmt.Errorf("stopped after %d redirects from AuthURL", maxAuthURLRedirects)
+		}
+		if via[0].URL.Scheme == "https" && req.URL.Scheme != "https" {
+			return errAuthURLDowngrade
+		}
+		if req.URL.Host != via[0].URL.Host {
+			for key := range authHeaders {
+				req.Header.Del(key)
+			}
+		}
+		if next != nil {
+			return next(req, via)
+		}
+		return nil
+	}
+	return &c
+}
+
+// tokenExpired tells whether tok has expired, according to the local clock
+// corrected with the server time offset if UseQueryTime is set, allowing for
+// ClockSkewTolerance. It must be called with a.mtx held.
+func (a *Auth) tokenExpired(tok *TokenDetails) bool {
+	if tok.Expires == 0 {
+		return false
+	}
+	now := a.currentTime()
+	if a.opts().UseQueryTime {
+		now = now.Add(a.serverTimeOffset)
+	}
+	now = now.Add(-a.opts().ClockSkewTolerance)
+	return tok.Expires <= now.UnixNano()/int64(time.Millisecond)
+}
+
 func (a *Auth) isTokenRenewable() bool {
 	return a.opts().Key != "" || a.opts().AuthURL != "" || a.opts().AuthCallback != nil
 }
@@ -479,7 +703,7 @@ func (a *Auth) token() *TokenDetails {
 }
 
 func (a *Auth) logger() *LoggerOptions {
-	return a.client.logger()
+	return a.client.logger().forSubsystem(SubsystemAuth)
 }
 
 func detectAuthMethod(opts *ClientOptions) (int, error) {
@@ -510,3 +734,9 @@ func areClientIDsSet(clientIDs ...string) bool {
 func isClientIDAllowed(clientID, msgClientID string) bool {
 	return clientID == wildcardClientID || msgClientID == "" || clientID == msgClientID
 }
+
+// canPublishAs is like isClientIDAllowed, but lets a client with an unknown
+// identity publish messages with an explicit ClientID (RSL1g1b).
+func canPublishAs(clientID, msgClientID string) bool {
+	return clientID == "" || isClientIDAllowed(clientID, msgClientID)
+}
@@ -0,0 +1,153 @@
+package ably
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// Token revocation target types.
+const (
+	RevocationTargetClientID      = "clientId"
+	RevocationTargetRevocationKey = "revocationKey"
+	RevocationTargetChannel       = "channel"
+)
+
+// TokenRevocationTarget matches the tokens revoked by Auth.RevokeTokens:
+// tokens issued for a client ID, with a revocation key, or for a channel.
+type TokenRevocationTarget struct {
+	Type  string // one of RevocationTargetClientID, RevocationTargetRevocationKey or RevocationTargetChannel
+	Value string
+}
+
+// String gives the target as sent to Ably, e.g. "clientId:bob".
+func (t TokenRevocationTarget) String() string {
+	return t.Type + ":" + t.Value
+}
+
+// TokenRevocationOptions are the optional parameters of Auth.RevokeTokens.
+type TokenRevocationOptions struct {
+	// IssuedBefore revokes only the tokens issued before it; the default is
+	// Ably's current time.
+	IssuedBefore time.Time
+
+	// AllowReauthMargin delays the revocation by 30 seconds, giving connected
+	// clients the chance to obtain a new token before they're disconnected.
+	AllowReauthMargin bool
+}
+
+// TokenRevocationResult is the outcome of the revocation of a single target.
+type TokenRevocationResult struct {
+	Target       string    // target as given by TokenRevocationTarget.String
+	IssuedBefore time.Time // tokens issued before this time are revoked
+	AppliesAt    time.Time // time from which the revocation is enforced
+	Err          *Error    // set if the target couldn't be revoked
+}
+
+// TokenRevocationResults are the results of Auth.RevokeTokens, in the same
+// order as the requested targets.
+type TokenRevocationResults struct {
+	SuccessCount int
+	FailureCount int
+	Results      []TokenRevocationResult
+}
+
+// RevokeTokens revokes the tokens matching the given targets, so that they
+// can no longer be used to access Ably, e.g. to immediately disconnect
+// banned users (RSA17). Tokens must have been issued with a key with
+// revocable tokens enabled.
+//
+// The request is authenticated with the client's API key, whatever its
+// authentication method, so a key is required. Failures to revoke single
+// targets are reported in the results rather than as an error.
+func (a *Auth) RevokeTokens(ctx context.Context, targets []TokenRevocationTarget, opts *TokenRevocationOptions) (*TokenRevocationResults, error) {
+	o := a.opts()
+	if o.Key == "" {
+		return nil, newErrorf(ErrInvalidCredentials, "revoking tokens requires an API key")
+	}
+	if o.NoTLS {
+		return nil, newError(ErrInvalidUseOfBasicAuthOverNonTLSTransport, errInsecureBasicAuth)
+	}
+	if len(targets) == 0 {
+		return nil, newErrorf(ErrInvalidParameterValue, "no token revocation targets given")
+	}
+	specifiers := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if t.Type == "" || t.Value == "" || strings.Contains(t.Type, ":") {
+			return nil, newErrorf(ErrInvalidParameterValue, "invalid token revocation target %q", t.String())
+		}
+		specifiers = append(specifiers, t.String())
+	}
+	body := map[string]interface{}{"targets": specifiers}
+	if opts != nil {
+		if !opts.IssuedBefore.IsZero() {
+			body["issuedBefore"] = unixMilli(opts.IssuedBefore)
+		}
+		if opts.AllowReauthMargin {
+			body["allowReauthMargin"] = true
+		}
+	}
+	credentials := base64.StdEncoding.EncodeToString([]byte(o.KeyName() + ":" + o.KeySecret()))
+	var out tokenRevocationResponse
+	_, err := a.client.do(&Request{
+		Method: "POST",
+		Path:   "/keys/" + url.PathEscape(o.KeyName()) + "/revokeTokens",
+		In:     body,
+		Out:    &out,
+		NoAuth: true,
+		header: http.Header{"Authorization": {"Basic " + credentials}},
+		ctx:    ctx,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.results(), nil
+}
+
+type tokenRevocationResponse struct {
+	SuccessCount int                       `json:"successCount" codec:"successCount"`
+	FailureCount int                       `json:"failureCount" codec:"failureCount"`
+	Results      []tokenRevocationWireItem `json:"results" codec:"results"`
+}
+
+type tokenRevocationWireItem struct {
+	Target       string           `json:"target" codec:"target"`
+	IssuedBefore int64            `json:"issuedBefore,omitempty" codec:"issuedBefore,omitempty"`
+	AppliesAt    int64            `json:"appliesAt,omitempty" codec:"appliesAt,omitempty"`
+	Error        *proto.ErrorInfo `json:"error,omitempty" codec:"error,omitempty"`
+}
+
+func (r *tokenRevocationResponse) results() *TokenRevocationResults {
+	res := &TokenRevocationResults{
+		SuccessCount: r.SuccessCount,
+		FailureCount: r.FailureCount,
+		Results:      make([]TokenRevocationResult, 0, len(r.Results)),
+	}
+	for _, item := range r.Results {
+		result := TokenRevocationResult{Target: item.Target}
+		if item.Error != nil {
+			result.Err = newErrorProto(item.Error)
+		} else {
+			result.IssuedBefore = fromUnixMilli(item.IssuedBefore)
+			result.AppliesAt = fromUnixMilli(item.AppliesAt)
+		}
+		res.Results = append(res.Results, result)
+	}
+	return res
+}
+
+func unixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMilli(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,96 @@
+package ably_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+)
+
+func TestAuth_RevokeTokens(t *testing.T) {
+	var (
+		gotPath string
+		gotAuth string
+		gotBody map[string]interface{}
+	)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		gotBody = nil
+		json.NewDecoder(r.Body).Decode(&gotBody)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"successCount":1,"failureCount":1,"results":[
+			{"target":"clientId:bob","issuedBefore":1700000000000,"appliesAt":1700000030000},
+			{"target":"channel:chat","error":{"code":40000,"statusCode":400,"message":"bad target"}}
+		]}`))
+	}))
+	defer server.Close()
+
+	client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+		o.Key = "app.key:secret"
+		o.UseTokenAuth = true
+		o.NoTLS = false
+		o.TLSPort = o.Port
+		o.HTTPClient = server.Client()
+	})
+	ctx := context.Background()
+
+	issuedBefore := time.Unix(1700000000, 0)
+	results, err := client.Auth.RevokeTokens(ctx, []ably.TokenRevocationTarget{
+		{Type: ably.RevocationTargetClientID, Value: "bob"},
+		{Type: ably.RevocationTargetChannel, Value: "chat"},
+	}, &ably.TokenRevocationOptions{IssuedBefore: issuedBefore, AllowReauthMargin: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "/keys/app.key/revokeTokens"; gotPath != expected {
+		t.Errorf("expected path %q; got %q", expected, gotPath)
+	}
+	if expected := "Basic YXBwLmtleTpzZWNyZXQ="; gotAuth != expected {
+		t.Errorf("expected Authorization %q; got %q", expected, gotAuth)
+	}
+	assertDeepEquals(t, map[string]interface{}{
+		"targets":           []interface{}{"clientId:bob", "channel:chat"},
+		"issuedBefore":      float64(1700000000000),
+		"allowReauthMargin": true,
+	}, gotBody)
+
+	if results.SuccessCount != 1 || results.FailureCount != 1 || len(results.Results) != 2 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	ok := results.Results[0]
+	if ok.Target != "clientId:bob" || ok.Err != nil {
+		t.Errorf("unexpected result: %+v", ok)
+	}
+	if !ok.IssuedBefore.Equal(issuedBefore) || !ok.AppliesAt.Equal(issuedBefore.Add(30*time.Second)) {
+		t.Errorf("unexpected times: issuedBefore %v, appliesAt %v", ok.IssuedBefore, ok.AppliesAt)
+	}
+	failed := results.Results[1]
+	if failed.Target != "channel:chat" || failed.Err == nil || failed.Err.Code != ably.ErrBadRequest {
+		t.Errorf("unexpected result: %+v", failed)
+	}
+
+	for _, c := range []struct {
+		name    string
+		targets []ably.TokenRevocationTarget
+	}{
+		{"no targets", nil},
+		{"missing type", []ably.TokenRevocationTarget{{Value: "bob"}}},
+		{"missing value", []ably.TokenRevocationTarget{{Type: ably.RevocationTargetClientID}}},
+	} {
+		_, err := client.Auth.RevokeTokens(ctx, c.targets, nil)
+		if err := checkError(ably.ErrInvalidParameterValue, err); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+
+	tokenOnly := newTestRestClient(t, server)
+	_, err = tokenOnly.Auth.RevokeTokens(ctx, []ably.TokenRevocationTarget{{Type: ably.RevocationTargetClientID, Value: "bob"}}, nil)
+	if err := checkError(ably.ErrInvalidCredentials, err); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,13 +2,18 @@ package ably_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -271,6 +276,74 @@ func TestAuth_TimestampRSA10k(t *testing.T) {
 			ts.Errorf("expected %s got %s", serverTime, stamp)
 		}
 	})
+	t.Run("must cache a zero server time offset", func(ts *testing.T) {
+		a := &ably.Auth{}
+		a.SetNowFunc(func() time.Time {
+			return now
+		})
+		a.SetServerTimeFunc(func() (time.Time, error) {
+			return now, nil
+		})
+		if _, err := a.Timestamp(true); err != nil {
+			ts.Fatal(err)
+		}
+		a.SetServerTimeFunc(func() (time.Time, error) {
+			return time.Time{}, errors.New("must not be called")
+		})
+		stamp, err := a.Timestamp(true)
+		if err != nil {
+			ts.Fatal(err)
+		}
+		if !stamp.Equal(now) {
+			ts.Errorf("expected %s got %s", now, stamp)
+		}
+	})
+}
+
+func TestAuth_DefaultTokenParams(t *testing.T) {
+	t.Parallel()
+	defaults := &ably.TokenParams{
+		TTL:           time.Minute.Milliseconds(),
+		RawCapability: `{"chat":["subscribe"]}`,
+		ClientID:      "default",
+	}
+	var got []*ably.TokenParams
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			Key:                "xxxxxxx.yyyyyyy:zzzzzzz",
+			DefaultTokenParams: defaults,
+			AuthCallback: func(params *ably.TokenParams) (interface{}, error) {
+				got = append(got, params)
+				return "token", nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Auth.Authorize(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	params := &ably.TokenParams{TTL: time.Hour.Milliseconds()}
+	if _, err := client.Auth.Authorize(params, &ably.AuthOptions{Force: true}); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, []*ably.TokenParams{
+		defaults,
+		{TTL: time.Hour.Milliseconds(), RawCapability: defaults.RawCapability, ClientID: "default"},
+	}, got)
+	if params.ClientID != "" || params.RawCapability != "" {
+		t.Errorf("expected given params to be left unmodified; got %+v", params)
+	}
+
+	req, err := client.Auth.CreateTokenRequest(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.TTL != defaults.TTL || req.RawCapability != defaults.RawCapability || req.ClientID != defaults.ClientID {
+		t.Errorf("want token request with default params %+v; got %+v", defaults, req.TokenParams)
+	}
 }
 
 func TestAuth_TokenAuth_Renew(t *testing.T) {
@@ -731,6 +804,70 @@ func TestAuth_ClientID(t *testing.T) {
 	}
 }
 
+func TestAuth_ServerTimeOffset(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			Key:                    "xxxxxxx.yyyyyyy:zzzzzzz",
+			UseQueryTime:           true,
+			ServerTimeSyncInterval: time.Hour,
+		},
+		Now: func() time.Time { return now },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queries := 0
+	skew := 5 * time.Second
+	client.Auth.SetServerTimeFunc(func() (time.Time, error) {
+		queries++
+		return now.Add(skew), nil
+	})
+	expect := func(expectedQueries int, expectedOffset time.Duration) {
+		t.Helper()
+		if queries != expectedQueries {
+			t.Errorf("expected %d server time queries; got %d", expectedQueries, queries)
+		}
+		if offset, ok := client.Auth.ServerTimeOffset(); !ok || offset != expectedOffset {
+			t.Errorf("expected offset %v; got %v (known: %t)", expectedOffset, offset, ok)
+		}
+	}
+
+	if _, ok := client.Auth.ServerTimeOffset(); ok {
+		t.Fatal("expected server time offset to be unknown")
+	}
+	for i := 0; i < 2; i++ {
+		req, err := client.Auth.CreateTokenRequest(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := ably.Time(now.Add(skew)); req.Timestamp != expected {
+			t.Errorf("expected timestamp %d; got %d", expected, req.Timestamp)
+		}
+	}
+	expect(1, skew)
+
+	// The offset is queried again once ServerTimeSyncInterval has elapsed.
+	now = now.Add(time.Hour)
+	skew = 3 * time.Second
+	if _, err := client.Auth.CreateTokenRequest(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	expect(2, skew)
+
+	skew = -time.Second
+	offset, err := client.Auth.SyncServerTime(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offset != skew {
+		t.Errorf("expected offset %v; got %v", skew, offset)
+	}
+	expect(3, skew)
+}
+
 func TestAuth_CreateTokenRequest(t *testing.T) {
 	t.Parallel()
 	app, client := ablytest.NewRestClient(nil)
@@ -857,3 +994,678 @@ func TestAuth_RSA7c(t *testing.T) {
 		t.Error("expected an error")
 	}
 }
+
+func TestVerifyAuth(t *testing.T) {
+	t.Parallel()
+	var requested int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.URL.Path == "/keys/xxxxxxx.yyyyyyy/requestToken":
+			atomic.AddInt32(&requested, 1)
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"token":"t","keyName":"xxxxxxx.yyyyyyy","capability":"{\"chat:*\":[\"publish\"]}"}`))
+		case r.URL.Path == "/keys/xxxxxxx.invalid/requestToken":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":40101,"statusCode":401,"message":"invalid credentials"}}`))
+		case r.URL.Path == "/stats" && r.Header.Get("Authorization") != "":
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":40160,"statusCode":401,"message":"no stats capability"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	newClient := func(auth ably.AuthOptions) *ably.RestClient {
+		client, err := ably.NewRestClient(&ably.ClientOptions{
+			AuthOptions:      auth,
+			RestHost:         u.Hostname(),
+			TLSPort:          port,
+			NoBinaryProtocol: true,
+			HTTPClient:       server.Client(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	report, err := newClient(ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"}).VerifyAuth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, &ably.AuthReport{
+		Method:     "basic",
+		KeyName:    "xxxxxxx.yyyyyyy",
+		Capability: ably.Capability{"chat:*": {"publish"}},
+	}, report)
+
+	_, err = newClient(ably.AuthOptions{Key: "xxxxxxx.invalid:zzzzzzz"}).VerifyAuth(context.Background())
+	if err := checkError(ably.ErrInvalidCredentials, err); err != nil {
+		t.Fatal(err)
+	}
+
+	expires := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	report, err = newClient(ably.AuthOptions{TokenDetails: &ably.TokenDetails{
+		Token:   "token",
+		Expires: expires.UnixNano() / int64(time.Millisecond),
+	}}).VerifyAuth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Method != "token" || !report.Expires.Equal(expires) || report.Capability != nil {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	_, err = newClient(ably.AuthOptions{TokenDetails: &ably.TokenDetails{
+		Token:   "token",
+		Expires: time.Now().Add(-time.Hour).UnixNano() / int64(time.Millisecond),
+	}}).VerifyAuth(context.Background())
+	if err := checkError(ably.ErrTokenExpired, err); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verifying doesn't change the client's token, nor requests a new one
+	// while it's valid.
+	tokenRequests := atomic.LoadInt32(&requested)
+	client := newClient(ably.AuthOptions{
+		Key:          "xxxxxxx.yyyyyyy:zzzzzzz",
+		UseTokenAuth: true,
+		TokenDetails: &ably.TokenDetails{Token: "token", Expires: expires.UnixNano() / int64(time.Millisecond)},
+	})
+	if _, err := client.VerifyAuth(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requested); n != tokenRequests {
+		t.Fatalf("want no token requested; got %d", n-tokenRequests)
+	}
+	if tok, err := client.Auth.Authorize(nil, nil); err != nil || tok.Token != "token" {
+		t.Fatalf("want the client's token unchanged; got %+v (err=%v)", tok, err)
+	}
+
+	// Nor does it keep the token it requests when the client has none yet.
+	client = newClient(ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz", UseTokenAuth: true})
+	if report, err := client.VerifyAuth(context.Background()); err != nil || report.KeyName != "xxxxxxx.yyyyyyy" {
+		t.Fatalf("unexpected report: %+v (err=%v)", report, err)
+	}
+	if _, err := client.Auth.Authorize(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&requested); n != tokenRequests+2 {
+		t.Fatalf("want a token requested by both VerifyAuth and Authorize; got %d requests", n-tokenRequests)
+	}
+
+	// A cancelled context aborts verifying.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := newClient(ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz", UseTokenAuth: true}).VerifyAuth(ctx); err == nil {
+		t.Fatal("want an error verifying with a cancelled context")
+	}
+
+	// A realtime client's report has its tags.
+	realtime, err := ably.NewRealtimeClient(&ably.ClientOptions{
+		AuthOptions:      ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+		RestHost:         u.Hostname(),
+		TLSPort:          port,
+		NoBinaryProtocol: true,
+		HTTPClient:       server.Client(),
+		NoConnect:        true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer realtime.Close()
+	realtime.SetTag("tenant", "acme")
+	report, err = realtime.VerifyAuth(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, map[string]string{"tenant": "acme"}, report.Tags)
+}
+
+func TestAuth_ClockSkew(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	ahead := func() time.Time { return now.Add(5 * time.Minute) }
+	expires := now.Add(time.Minute).UnixNano() / int64(time.Millisecond)
+
+	t.Run("tolerance", func(t *testing.T) {
+		for _, c := range []struct {
+			tolerance time.Duration
+			want      int
+		}{
+			{0, 2},
+			{10 * time.Minute, 1},
+		} {
+			calls := 0
+			client, err := ably.NewRestClient(&ably.ClientOptions{AuthOptions: ably.AuthOptions{
+				AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+					calls++
+					return &ably.TokenDetails{Token: "token", Expires: expires}, nil
+				},
+				ClockSkewTolerance: c.tolerance,
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			client.Auth.SetNowFunc(ahead)
+			for i := 0; i < 2; i++ {
+				if _, err := client.Auth.Authorize(nil, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if calls != c.want {
+				t.Errorf("tolerance=%v: want %d token requests; got %d", c.tolerance, c.want, calls)
+			}
+		}
+	})
+
+	t.Run("server time offset", func(t *testing.T) {
+		requests := 0
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"token":"token","expires":%d}`, expires)
+		}))
+		defer server.Close()
+		client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+			o.AuthOptions = ably.AuthOptions{
+				Key:          "xxxxxxx.yyyyyyy:zzzzzzz",
+				UseTokenAuth: true,
+				UseQueryTime: true,
+			}
+		})
+		client.Auth.SetNowFunc(ahead)
+		client.Auth.SetServerTimeFunc(func() (time.Time, error) { return now, nil })
+		for i := 0; i < 2; i++ {
+			if _, err := client.Auth.Authorize(nil, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if requests != 1 {
+			t.Errorf("want 1 token request; got %d", requests)
+		}
+	})
+}
+
+func TestNewTokenRequest(t *testing.T) {
+	t.Parallel()
+	const key = "xxxxxxx.yyyyyyy:zzzzzzz"
+	req, err := ably.NewTokenRequest(key, &ably.TokenParams{ClientID: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.KeyName != "xxxxxxx.yyyyyyy" || req.ClientID != "client" {
+		t.Errorf("unexpected token request: %+v", req)
+	}
+	if len(req.Nonce) < 16 || req.Timestamp == 0 || req.TTL == 0 || req.RawCapability == "" || req.Mac == "" {
+		t.Errorf("want token request with defaults set; got %+v", req)
+	}
+	if err := req.Verify(key); err != nil {
+		t.Fatal(err)
+	}
+
+	// Token requests created by clients verify the same way.
+	client, err := ably.NewRestClient(&ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: key}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientReq, err := client.Auth.CreateTokenRequest(&req.TokenParams, &ably.AuthOptions{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := clientReq.Verify(key); err != nil {
+		t.Fatal(err)
+	}
+	clientReq.Nonce += "x"
+	if err := clientReq.Verify(key); err == nil {
+		t.Error("want changed nonce to invalidate the mac")
+	}
+
+	if err := req.Verify("xxxxxxx.other:zzzzzzz"); ably.ErrorCode(err) != ably.ErrIncompatibleCredentials {
+		t.Errorf("want error code %d for another key; got %v", ably.ErrIncompatibleCredentials, err)
+	}
+	if err := req.Verify("xxxxxxx.yyyyyyy:other"); ably.ErrorCode(err) != ably.ErrInvalidCredentials {
+		t.Errorf("want error code %d for another secret; got %v", ably.ErrInvalidCredentials, err)
+	}
+	if _, err := ably.NewTokenRequest("xxxxxxx", nil); ably.ErrorCode(err) != ably.ErrInvalidCredential {
+		t.Errorf("want error code %d for invalid key; got %v", ably.ErrInvalidCredential, err)
+	}
+
+	for name, valid := range map[string]bool{
+		"xxxxxxx.yyyyyyy":  true,
+		"xxxxxxx":          false,
+		".yyyyyyy":         false,
+		"xxxxxxx.":         false,
+		"xxxxxxx.yyy:zzzz": false,
+	} {
+		if err := ably.ValidateKeyName(name); (err == nil) != valid {
+			t.Errorf("ValidateKeyName(%q) = %v; want valid=%t", name, err, valid)
+		}
+	}
+}
+
+func TestAuth_RequestTokenContext(t *testing.T) {
+	t.Parallel()
+	const key = "xxxxxxx.yyyyyyy:zzzzzzz"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/keys/xxxxxxx.yyyyyyy/requestToken":
+			var req ably.TokenRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Error(err)
+			}
+			if err := req.Verify(key); err != nil {
+				t.Error(err)
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&ably.TokenDetails{Token: "issued", ClientID: req.ClientID})
+		case "/auth-error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	client := newTestRestClient(t, server)
+	ctx := context.Background()
+
+	tok, err := client.Auth.RequestTokenContext(ctx, &ably.TokenParams{ClientID: "client"}, &ably.AuthOptions{Key: key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Token != "issued" || tok.ClientID != "client" {
+		t.Errorf("unexpected token: %+v", tok)
+	}
+
+	for _, c := range []struct {
+		name string
+		opts *ably.AuthOptions
+		code int
+	}{{
+		name: "callback error",
+		opts: &ably.AuthOptions{AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+			return nil, errors.New("failed")
+		}},
+		code: ably.ErrErrorFromClientTokenCallback,
+	}, {
+		name: "unsigned token request from callback",
+		opts: &ably.AuthOptions{AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+			return &ably.TokenRequest{KeyName: "xxxxxxx.yyyyyyy"}, nil
+		}},
+		code: ably.ErrErrorFromClientTokenCallback,
+	}, {
+		name: "AuthURL error",
+		opts: &ably.AuthOptions{AuthURL: server.URL + "/auth-error"},
+		code: ably.ErrErrorFromClientTokenCallback,
+	}, {
+		name: "invalid key",
+		opts: &ably.AuthOptions{Key: "xxxxxxx"},
+		code: ably.ErrIncompatibleCredentials,
+	}, {
+		name: "no source",
+		opts: nil,
+		code: ably.ErrUnableToObtainCredentialsFromGivenParameters,
+	}} {
+		_, err := client.Auth.RequestTokenContext(ctx, nil, c.opts)
+		if err := checkError(c.code, err); err != nil {
+			t.Errorf("%s: %v", c.name, err)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = client.Auth.RequestTokenContext(canceled, nil, &ably.AuthOptions{AuthURL: server.URL + "/token"})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("want %v; got %v", context.Canceled, err)
+	}
+}
+
+func TestTokenJSON(t *testing.T) {
+	t.Parallel()
+	tok := &ably.TokenDetails{
+		Token:         "token",
+		KeyName:       "xxxxxxx.yyyyyyy",
+		Expires:       1600000060000,
+		ClientID:      "client",
+		Issued:        1600000000000,
+		RawCapability: `{"chat":["subscribe"]}`,
+	}
+	p, err := tok.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"token":"token","keyName":"xxxxxxx.yyyyyyy","expires":1600000060000,"clientId":"client","issued":1600000000000,"capability":"{\"chat\":[\"subscribe\"]}"}`
+	if string(p) != want {
+		t.Errorf("want %s; got %s", want, p)
+	}
+	decoded, err := ably.TokenDetailsFromJSON(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, tok, decoded)
+
+	// Capability as an object.
+	decoded, err = ably.TokenDetailsFromJSON([]byte(`{"token":"token","capability":{"chat": ["subscribe"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Token != "token" || decoded.RawCapability != `{"chat":["subscribe"]}` {
+		t.Errorf("unexpected token details: %+v", decoded)
+	}
+	if _, err := ably.TokenDetailsFromJSON([]byte(`{"expires":1}`)); ably.ErrorCode(err) != ably.ErrInvalidTokenFormat {
+		t.Errorf("want error code %d; got %v", ably.ErrInvalidTokenFormat, err)
+	}
+
+	req, err := ably.NewTokenRequest("xxxxxxx.yyyyyyy:zzzzzzz", &ably.TokenParams{ClientID: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err = req.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"keyName", "ttl", "capability", "clientId", "timestamp", "nonce", "mac"} {
+		if _, ok := fields[name]; !ok {
+			t.Errorf("want field %q in %s", name, p)
+		}
+	}
+	decodedReq, err := ably.TokenRequestFromJSON(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decodedReq.Verify("xxxxxxx.yyyyyyy:zzzzzzz"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ably.TokenRequestFromJSON([]byte(`{"keyName":"xxxxxxx.yyyyyyy"}`)); ably.ErrorCode(err) != ably.ErrInvalidRequestBody {
+		t.Errorf("want error code %d; got %v", ably.ErrInvalidRequestBody, err)
+	}
+	if _, err := ably.TokenRequestFromJSON([]byte(`{`)); ably.ErrorCode(err) != ably.ErrBadRequest {
+		t.Errorf("want error code %d; got %v", ably.ErrBadRequest, err)
+	}
+}
+
+func TestAuth_AuthURLResponses(t *testing.T) {
+	t.Parallel()
+	const key = "xxxxxxx.yyyyyyy:zzzzzzz"
+	var lastHeader http.Header
+	mux := http.NewServeMux()
+	mux.HandleFunc("/keys/xxxxxxx.yyyyyyy/requestToken", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token":"from-request"}`))
+	})
+	mux.HandleFunc("/request", func(w http.ResponseWriter, r *http.Request) {
+		req, _ := ably.NewTokenRequest(key, nil)
+		p, _ := req.ToJSON()
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.Write(p)
+	})
+	mux.HandleFunc("/details", func(w http.ResponseWriter, r *http.Request) {
+		lastHeader = r.Header
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"token":"details","capability":{"*":["*"]}}`))
+	})
+	mux.HandleFunc("/jwt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/jwt")
+		w.Write([]byte("jwt-token\n"))
+	})
+	mux.HandleFunc("/unknown", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"expires":1}`))
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write(bytes.Repeat([]byte("x"), 1<<20))
+	})
+	mux.HandleFunc("/html", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Query().Get("to"), http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client := newTestRestClient(t, server)
+	ctx := context.Background()
+
+	for path, token := range map[string]string{
+		"/request": "from-request",
+		"/details": "details",
+		"/jwt":     "jwt-token",
+	} {
+		tok, err := client.Auth.RequestTokenContext(ctx, nil, &ably.AuthOptions{AuthURL: server.URL + path})
+		if err != nil {
+			t.Errorf("%s: %v", path, err)
+			continue
+		}
+		if tok.Token != token {
+			t.Errorf("%s: want token %q; got %q", path, token, tok.Token)
+		}
+	}
+
+	for path, code := range map[string]int{
+		"/unknown": ably.ErrErrorFromClientTokenCallback,
+		"/large":   ably.ErrErrorFromClientTokenCallback,
+		"/html":    ably.ErrBadRequest,
+	} {
+		_, err := client.Auth.RequestTokenContext(ctx, nil, &ably.AuthOptions{AuthURL: server.URL + path})
+		if err := checkError(code, err); err != nil {
+			t.Errorf("%s: %v", path, err)
+		}
+	}
+
+	// Redirects keep AuthHeaders on the same host only.
+	auth := func(to string) *ably.AuthOptions {
+		return &ably.AuthOptions{
+			AuthURL:     server.URL + "/redirect?to=" + url.QueryEscape(to),
+			AuthHeaders: http.Header{"X-Secret": {"secret"}},
+		}
+	}
+	if _, err := client.Auth.RequestTokenContext(ctx, nil, auth("/details")); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastHeader.Get("X-Secret"); got != "secret" {
+		t.Errorf("want header kept on same host redirect; got %q", got)
+	}
+	otherHost := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	if _, err := client.Auth.RequestTokenContext(ctx, nil, auth(otherHost+"/details")); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastHeader.Get("X-Secret"); got != "" {
+		t.Errorf("want header dropped on cross-host redirect; got %q", got)
+	}
+
+	// No redirects from https to http.
+	tlsServer := httptest.NewTLSServer(mux)
+	defer tlsServer.Close()
+	tlsClient := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+		o.HTTPClient = tlsServer.Client()
+	})
+	_, err := tlsClient.Auth.RequestTokenContext(ctx, nil, &ably.AuthOptions{
+		AuthURL: tlsServer.URL + "/redirect?to=" + url.QueryEscape(server.URL+"/details"),
+	})
+	if e := checkError(ably.ErrErrorFromClientTokenCallback, err); e != nil {
+		t.Error(e)
+	} else if !strings.Contains(err.Error(), "https to http") {
+		t.Errorf("want redirect downgrade error; got %v", err)
+	}
+}
+
+func TestAuth_ClientIDEnforcement(t *testing.T) {
+	t.Parallel()
+
+	t.Run("REST", func(t *testing.T) {
+		t.Parallel()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+		client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+			o.ClientID = "alice"
+		})
+		channel := client.Channels.Get("test")
+		for clientID, code := range map[string]int{
+			"":      0,
+			"alice": 0,
+			"bob":   ably.ErrInvalidClientID,
+			"*":     ably.ErrInvalidClientID,
+		} {
+			err := channel.PublishAll([]*proto.Message{{Name: "event", ClientID: clientID}})
+			if got := ably.ErrorCode(err); got != code {
+				t.Errorf("ClientID %q: want error code %d; got %v", clientID, code, err)
+			}
+		}
+	})
+
+	t.Run("basic auth with ClientID", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		_, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.ClientID = "alice"
+		})
+		_, err := channel.PublishAll([]*proto.Message{{Name: "event", ClientID: "bob"}})
+		if err := checkError(ably.ErrInvalidClientID, err); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("adopted from CONNECTED", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		client := newPipeRealtimeClient(t, in, out)
+		connected := connectedMessage("conn")
+		connected.ConnectionDetails.ClientID = "carol"
+		in <- connected
+		if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+			t.Fatal(err)
+		}
+		if id := client.Auth.ClientID(); id != "carol" {
+			t.Fatalf("want ClientID %q; got %q", "carol", id)
+		}
+		_, err := client.Channels.Get("test").PublishAll([]*proto.Message{{Name: "event", ClientID: "bob"}})
+		if err := checkError(ably.ErrInvalidClientID, err); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("mismatch on CONNECTED", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.ClientID = "alice"
+		})
+		connected := connectedMessage("conn")
+		connected.ConnectionDetails.ClientID = "mallory"
+		in <- connected
+		err := ablytest.Wait(client.Connection.Connect())
+		if err := checkError(ably.ErrInvalidClientID, err); err != nil {
+			t.Fatal(err)
+		}
+		if state := client.Connection.State(); state != ably.StateConnFailed {
+			t.Fatalf("want state %v; got %v", ably.StateConnFailed, state)
+		}
+	})
+
+	t.Run("CONNECTED without details", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		client := newPipeRealtimeClient(t, in, out)
+		in <- &proto.ProtocolMessage{Action: proto.ActionConnected, ConnectionID: "conn"}
+		if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestPublishOnBehalfOfClients(t *testing.T) {
+	t.Parallel()
+
+	publishREST := func(t *testing.T, opts func(*ably.ClientOptions), clientIDs ...string) ([]string, error) {
+		var sent []string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var msgs []*proto.Message
+			if err := json.NewDecoder(r.Body).Decode(&msgs); err != nil {
+				t.Error(err)
+			}
+			for _, m := range msgs {
+				sent = append(sent, m.ClientID)
+			}
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+		client := newTestRestClient(t, server, opts)
+		var msgs []*proto.Message
+		for _, id := range clientIDs {
+			msgs = append(msgs, &proto.Message{Name: "event", ClientID: id})
+		}
+		err := client.Channels.Get("test").PublishAll(msgs)
+		return sent, err
+	}
+
+	for _, c := range []struct {
+		name string
+		opts func(*ably.ClientOptions)
+	}{
+		{"opaque token", func(*ably.ClientOptions) {}},
+		{"wildcard token", func(o *ably.ClientOptions) {
+			o.Token = ""
+			o.TokenDetails = &ably.TokenDetails{Token: "token", ClientID: "*"}
+		}},
+	} {
+		c := c
+		t.Run("REST with "+c.name, func(t *testing.T) {
+			t.Parallel()
+			sent, err := publishREST(t, c.opts, "alice", "bob")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertDeepEquals(t, sent, []string{"alice", "bob"})
+		})
+	}
+
+	t.Run("REST with identified token", func(t *testing.T) {
+		t.Parallel()
+		_, err := publishREST(t, func(o *ably.ClientOptions) {
+			o.Token = ""
+			o.TokenDetails = &ably.TokenDetails{Token: "token", ClientID: "alice"}
+		}, "alice", "bob")
+		if err := checkError(ably.ErrInvalidClientID, err); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("Realtime with basic auth", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		_, channel := newAttachedPipeClient(t, in, out)
+		if _, err := channel.PublishAll([]*proto.Message{
+			{Name: "event", ClientID: "alice"},
+			{Name: "event", ClientID: "bob"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		for msg := range out {
+			if msg.Action != proto.ActionMessage {
+				continue
+			}
+			var sent []string
+			for _, m := range msg.Messages {
+				sent = append(sent, m.ClientID)
+			}
+			assertDeepEquals(t, sent, []string{"alice", "bob"})
+			break
+		}
+	})
+}
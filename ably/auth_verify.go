@@ -0,0 +1,140 @@
+package ably
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"time"
+)
+
+var errTokenExpired = errors.New("token has expired and there are no means to renew it")
+
+// AuthReport describes the credentials a client is configured with, as
+// verified with Ably by VerifyAuth.
+type AuthReport struct {
+	Method     string     // either "basic" or "token"
+	KeyName    string     // name of the API key; empty for token auth without a key
+	ClientID   string     // client ID of the authenticated client, if any
+	Expires    time.Time  // expiry of the token; zero for basic auth
+	Capability Capability // capabilities of the key or token; nil when unknown
+
+	// Tags are the tags set on the client with RealtimeClient.SetTag when
+	// verifying, for reports logged at startup to be correlated with e.g.
+	// the tenant of the client; nil for a RestClient or if there are none.
+	Tags map[string]string
+}
+
+// VerifyAuth performs a cheap authenticated request to check that the
+// client's credentials are accepted by Ably, and reports what they resolve
+// to. Services may call it at startup to fail fast with an actionable error
+// instead of failing on the first publish.
+//
+// With basic auth, VerifyAuth requests a token with the API key, which gives
+// the key's capabilities. With token auth, it checks the client's token
+// against Ably, or a new one obtained the same way any other request would
+// if the client has none or it has expired; the client keeps authenticating
+// with its own token either way. The capabilities of a token given as a
+// plain string are unknown.
+//
+// The returned error is *Error, with the code reported by Ably, e.g.
+// ErrInvalidCredentials for unknown keys, or ErrTokenExpired for an expired
+// token which can't be renewed.
+func (c *RestClient) VerifyAuth(ctx context.Context) (*AuthReport, error) {
+	a := c.Auth
+	a.mtx.Lock()
+	method := a.method
+	a.mtx.Unlock()
+	if method == authBasic {
+		return c.verifyBasicAuth(ctx)
+	}
+	return c.verifyTokenAuth(ctx)
+}
+
+func (c *RestClient) verifyBasicAuth(ctx context.Context) (*AuthReport, error) {
+	a := c.Auth
+	a.mtx.Lock()
+	req, err := a.createTokenRequest(nil, nil)
+	a.mtx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	tok := &TokenDetails{}
+	r := &Request{
+		Method: "POST",
+		Path:   "/keys/" + req.KeyName + "/requestToken",
+		In:     req,
+		Out:    tok,
+		NoAuth: true,
+		ctx:    ctx,
+	}
+	if _, err := c.do(r); err != nil {
+		return nil, err
+	}
+	return &AuthReport{
+		Method:     "basic",
+		KeyName:    c.opts.KeyName(),
+		ClientID:   a.ClientID(),
+		Capability: tok.Capability(),
+	}, nil
+}
+
+// verifyTokenAuth checks the client's token, or a new one if it has none
+// or it has expired, without storing it: verifying doesn't change the
+// credentials the client authenticates with.
+func (c *RestClient) verifyTokenAuth(ctx context.Context) (*AuthReport, error) {
+	a := c.Auth
+	a.mtx.Lock()
+	tok := a.token()
+	expired := tok != nil && a.tokenExpired(tok)
+	if expired && !a.isTokenRenewable() {
+		a.mtx.Unlock()
+		return nil, newError(ErrTokenExpired, errTokenExpired)
+	}
+	var err error
+	if tok == nil || expired {
+		tok, _, err = a.requestToken(ctx, a.params, nil)
+	}
+	clientID := a.clientID
+	a.mtx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	// Any authenticated request does; a token lacking the stats capability
+	// is still a valid one.
+	r := &Request{
+		Method:  "GET",
+		Path:    "/stats?limit=1",
+		NoAuth:  true,
+		NoRenew: true,
+		header: http.Header{
+			"Authorization": {"Bearer " + base64.StdEncoding.EncodeToString([]byte(tok.Token))},
+		},
+		ctx: ctx,
+	}
+	resp, err := c.do(r)
+	if err != nil && code(err) != ErrOperationNotPermittedWithProvidedCapability {
+		return nil, err
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	if tok.ClientID != "" {
+		clientID = tok.ClientID
+	}
+	if clientID == wildcardClientID {
+		clientID = ""
+	}
+	report := &AuthReport{
+		Method:   "token",
+		KeyName:  tok.KeyName,
+		ClientID: clientID,
+	}
+	if tok.Expires != 0 {
+		report.Expires = time.Unix(0, tok.Expires*int64(time.Millisecond))
+	}
+	if tok.RawCapability != "" {
+		report.Capability = tok.Capability()
+	}
+	return report, nil
+}
@@ -0,0 +1,226 @@
+package ably_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestRestChannels_Collection(t *testing.T) {
+	t.Parallel()
+
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	got := make([]*ably.RestChannel, 8)
+	for i := range got {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			got[i] = client.Channels.Get("b")
+		}(i)
+	}
+	wg.Wait()
+	for _, ch := range got[1:] {
+		if ch != got[0] {
+			t.Fatal("want a single channel created by concurrent calls to Get")
+		}
+	}
+	client.Channels.Get("a", &proto.ChannelOptions{})
+
+	var names []string
+	for _, ch := range client.Channels.Iterate() {
+		names = append(names, ch.Name)
+	}
+	assertDeepEquals(t, []string{"a", "b"}, names)
+
+	client.Channels.Release("b")
+	if client.Channels.Exists("b") {
+		t.Error("want channel b released")
+	}
+	if client.Channels.Get("b") == got[0] {
+		t.Error("want a new channel created after Release")
+	}
+}
+
+func TestChannels_Release(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client := newPipeRealtimeClient(t, in, out)
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	recv := func(action proto.Action) *proto.ProtocolMessage {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != action {
+				t.Fatalf("want %v; got %v", action, msg.Action)
+			}
+			return msg
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v", action)
+			return nil
+		}
+	}
+
+	channel := client.Channels.Get("test")
+	if _, err := channel.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	recv(proto.ActionAttach)
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+
+	opts := &proto.ChannelOptions{Encodings: []string{"test-encoding"}}
+	if ch := client.Channels.Get("test", opts); ch != channel {
+		t.Fatal("want Get to reuse the existing channel")
+	}
+	if !client.Channels.Exists("test") || client.Channels.Exists("other") {
+		t.Fatal("want only channel test to exist")
+	}
+	if _, err := channel.Publish("event", "data"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := recv(proto.ActionMessage); msg.Messages[0].ChannelOptions != opts {
+		t.Errorf("want message published with the options given to Get; got %+v", msg.Messages[0].ChannelOptions)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Channels.Release("test")
+	}()
+	recv(proto.ActionDetach)
+	in <- &proto.ProtocolMessage{Action: proto.ActionDetached, Channel: "test"}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for Release")
+	}
+	if client.Channels.Exists("test") {
+		t.Fatal("want channel released")
+	}
+
+	// Messages for a released channel don't recreate it; the heartbeats make
+	// sure the message was processed.
+	in <- &proto.ProtocolMessage{
+		Action:   proto.ActionMessage,
+		Channel:  "test",
+		Messages: []*proto.Message{{Name: "late"}},
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionHeartbeat}
+	in <- &proto.ProtocolMessage{Action: proto.ActionHeartbeat}
+	if n := len(client.Channels.Iterate()); n != 0 {
+		t.Errorf("want no channels; got %d", n)
+	}
+}
+
+func TestRealtimeChannel_SetOptions(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client := newPipeRealtimeClient(t, in, out)
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	recvAttach := func() *proto.ProtocolMessage {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != proto.ActionAttach {
+				t.Fatalf("want ATTACH; got %v", msg.Action)
+			}
+			return msg
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for ATTACH")
+			return nil
+		}
+	}
+
+	channel := client.Channels.Get("test", &proto.ChannelOptions{
+		Params: map[string]string{"rewind": "1"},
+		Modes:  []proto.ChannelMode{proto.ModeSubscribe},
+	})
+	res, err := channel.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := recvAttach()
+	assertDeepEquals(t, map[string]string{"rewind": "1"}, msg.Params)
+	if msg.Flags != proto.FlagModeSubscribe {
+		t.Errorf("want subscribe mode flag; got %x", msg.Flags)
+	}
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionAttached,
+		Channel: "test",
+		Flags:   proto.FlagBacklog | proto.FlagModeSubscribe,
+	}
+	if err := res.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, []proto.ChannelMode{proto.ModeSubscribe}, channel.Modes())
+
+	// Options not changing params nor modes don't reattach.
+	err = channel.SetOptions(context.Background(), &proto.ChannelOptions{
+		Params: map[string]string{"rewind": "1"},
+		Modes:  []proto.ChannelMode{proto.ModeSubscribe},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-out:
+		t.Fatalf("want no message sent; got %v", msg)
+	default:
+	}
+
+	update := make(chan ably.State, 1)
+	channel.On(update, ably.StateChanUpdate)
+	done := make(chan error, 1)
+	go func() {
+		done <- channel.SetOptions(context.Background(), &proto.ChannelOptions{
+			Params: map[string]string{"delta": "vcdiff"},
+		})
+	}()
+	msg = recvAttach()
+	assertDeepEquals(t, map[string]string{"delta": "vcdiff"}, msg.Params)
+	if msg.Flags != 0 {
+		t.Errorf("want no mode flags; got %x", msg.Flags)
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for SetOptions")
+	}
+	select {
+	case state := <-update:
+		if state.Err != nil {
+			t.Errorf("want no error; got %v", state.Err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for UPDATE")
+	}
+	if state := channel.State(); state != ably.StateChanAttached {
+		t.Errorf("want channel attached; got %v", state)
+	}
+}
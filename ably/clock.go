@@ -0,0 +1,22 @@
+package ably
+
+import "github.com/ably/ably-go/ably/internal/ablyutil"
+
+// Clock gives the current time and timers to a client, as set by
+// ClientOptions.Clock:
+//
+//	type Clock interface {
+//		Now() time.Time
+//		AfterFunc(d time.Duration, f func()) Timer // like time.AfterFunc
+//		NewTimer(d time.Duration) Timer            // like time.NewTimer
+//	}
+type Clock = ablyutil.Clock
+
+// Timer is a timer given by a Clock, like *time.Timer:
+//
+//	type Timer interface {
+//		C() <-chan time.Time // nil for timers created with AfterFunc
+//		Stop() bool
+//		Reset(d time.Duration) bool
+//	}
+type Timer = ablyutil.Timer
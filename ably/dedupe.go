@@ -0,0 +1,117 @@
+package ably
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// DedupeOptions bound the window within which realtime channels drop
+// messages they already delivered, see ClientOptions.Dedupe. A zero
+// DedupeOptions disables deduplication.
+type DedupeOptions struct {
+	// MaxMessages is the number of most recently delivered message IDs
+	// remembered per channel; zero means no limit.
+	MaxMessages int
+
+	// MaxAge is how long a delivered message ID is remembered; zero means
+	// no limit.
+	MaxAge time.Duration
+}
+
+func (o DedupeOptions) enabled() bool {
+	return o.MaxMessages > 0 || o.MaxAge > 0
+}
+
+// dedupeCache remembers the IDs of the messages delivered on a channel
+// within the window set by its options, oldest first.
+type dedupeCache struct {
+	mtx   sync.Mutex
+	opts  DedupeOptions
+	now   func() time.Time
+	seen  map[string]struct{}
+	order []dedupeEntry
+}
+
+type dedupeEntry struct {
+	id   string
+	seen time.Time
+}
+
+func newDedupeCache(opts DedupeOptions, now func() time.Time) *dedupeCache {
+	return &dedupeCache{
+		opts: opts,
+		now:  now,
+		seen: make(map[string]struct{}),
+	}
+}
+
+// filter gives the messages whose IDs weren't seen within the window, and
+// remembers them. Messages without an ID are never dropped. The given slice
+// is returned as is when none is dropped.
+func (d *dedupeCache) filter(messages []*proto.Message) []*proto.Message {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	now := d.now()
+	d.expire(now)
+	var kept []*proto.Message
+	for i, m := range messages {
+		if m.ID == "" {
+			if kept != nil {
+				kept = append(kept, m)
+			}
+			continue
+		}
+		if _, ok := d.seen[m.ID]; ok {
+			if kept == nil {
+				kept = append(make([]*proto.Message, 0, len(messages)-1), messages[:i]...)
+			}
+			continue
+		}
+		d.seen[m.ID] = struct{}{}
+		d.order = append(d.order, dedupeEntry{id: m.ID, seen: now})
+		d.evict()
+		if kept != nil {
+			kept = append(kept, m)
+		}
+	}
+	if kept == nil {
+		return messages
+	}
+	return kept
+}
+
+// expire forgets the IDs seen before the window's MaxAge.
+func (d *dedupeCache) expire(now time.Time) {
+	if d.opts.MaxAge <= 0 {
+		return
+	}
+	n := 0
+	for n < len(d.order) && now.Sub(d.order[n].seen) > d.opts.MaxAge {
+		delete(d.seen, d.order[n].id)
+		n++
+	}
+	d.drop(n)
+}
+
+// evict forgets the oldest IDs beyond the window's MaxMessages.
+func (d *dedupeCache) evict() {
+	if d.opts.MaxMessages <= 0 || len(d.order) <= d.opts.MaxMessages {
+		return
+	}
+	n := len(d.order) - d.opts.MaxMessages
+	for _, e := range d.order[:n] {
+		delete(d.seen, e.id)
+	}
+	d.drop(n)
+}
+
+func (d *dedupeCache) drop(n int) {
+	if n == 0 {
+		return
+	}
+	// Copy rather than reslice, so that the backing array doesn't grow
+	// without bound.
+	d.order = append(d.order[:0], d.order[n:]...)
+}
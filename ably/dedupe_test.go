@@ -0,0 +1,113 @@
+package ably_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestRealtimeChannel_Dedupe(t *testing.T) {
+	t.Parallel()
+
+	var mtx sync.Mutex
+	now := time.Now()
+	clock := func() time.Time {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return now
+	}
+	advance := func(d time.Duration) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		now = now.Add(d)
+	}
+
+	for _, c := range []struct {
+		name   string
+		dedupe ably.DedupeOptions
+		send   []string // message IDs, or "+" to advance the clock by a minute
+		// expected are the IDs of the delivered messages, with "-" for those
+		// without one.
+		expected []string
+	}{{
+		name:     "disabled",
+		send:     []string{"a", "a", "b"},
+		expected: []string{"a", "a", "b"},
+	}, {
+		name:     "MaxMessages",
+		dedupe:   ably.DedupeOptions{MaxMessages: 2},
+		send:     []string{"a", "a", "b", "", "", "c", "b", "a"},
+		expected: []string{"a", "b", "-", "-", "c", "a"},
+	}, {
+		name:     "MaxAge",
+		dedupe:   ably.DedupeOptions{MaxAge: 90 * time.Second},
+		send:     []string{"a", "+", "b", "a", "+", "a", "b"},
+		expected: []string{"a", "b", "a"},
+	}} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			in := make(chan *proto.ProtocolMessage, 16)
+			out := make(chan *proto.ProtocolMessage, 16)
+			client, channel := newAttachedPipeClient(t, in, out, func(opts *ably.ClientOptions) {
+				opts.Dedupe = c.dedupe
+				opts.Now = clock
+			})
+			defer func() {
+				in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+				client.Close()
+			}()
+			sub, err := channel.Subscribe()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sub.Close()
+
+			var got []string
+			// receive collects the delivered messages up to the one with
+			// the given data, which isn't collected.
+			receive := func(until string) {
+				t.Helper()
+				for {
+					select {
+					case msg := <-sub.MessageChannel():
+						if msg.Data == until {
+							return
+						}
+						got = append(got, msg.Data.(string))
+					case <-time.After(ablytest.Timeout):
+						t.Fatalf("timed out waiting for %q; got %q", until, got)
+					}
+				}
+			}
+			send := func(id, data string) {
+				in <- &proto.ProtocolMessage{
+					Action:   proto.ActionMessage,
+					Channel:  "test",
+					Messages: []*proto.Message{{ID: id, Data: data}},
+				}
+			}
+			for _, id := range c.send {
+				if id == "+" {
+					// Have the messages sent so far handled before moving
+					// the clock.
+					send("", "sync")
+					receive("sync")
+					advance(time.Minute)
+					continue
+				}
+				data := id
+				if data == "" {
+					data = "-"
+				}
+				send(id, data)
+			}
+			send("", "end")
+			receive("end")
+			assertDeepEquals(t, got, c.expected)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package ably
+
+import (
+	"encoding/json"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// Envelope is the JSON envelope in which Ably wraps the messages it relays
+// to Reactor integrations, e.g. Ably Queues consumed over AMQP or a
+// Firehose rule streaming to Kinesis.
+type Envelope struct {
+	Source  string `json:"source"` // e.g. "channel.message" or "channel.presence"
+	AppID   string `json:"appId"`
+	Channel string `json:"channel"`
+	Site    string `json:"site"`
+	RuleID  string `json:"ruleId"`
+
+	// Messages are the relayed messages, for the "channel.message" source,
+	// with their data decoded.
+	Messages []*proto.Message `json:"messages"`
+	// Presence are the relayed presence messages, for the "channel.presence"
+	// source, with their data decoded as for Messages.
+	Presence []*proto.PresenceMessage `json:"presence"`
+}
+
+// DecodeEnvelope decodes an enveloped message relayed by a Reactor
+// integration. It fails for encrypted messages, whose data can't be decoded
+// without the channel's cipher.
+func DecodeEnvelope(data []byte) (*Envelope, error) {
+	var e Envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, newError(ErrInvalidRequestBody, err)
+	}
+	return &e, nil
+}
@@ -0,0 +1,64 @@
+package ably_test
+
+import (
+	"testing"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestDecodeEnvelope(t *testing.T) {
+	t.Parallel()
+
+	envelope, err := ably.DecodeEnvelope([]byte(`{
+		"source": "channel.message",
+		"appId": "aBCdEf",
+		"channel": "news",
+		"site": "eu-central-1-A",
+		"ruleId": "1-a2Bc",
+		"messages": [
+			{"id": "conn:1:0", "connectionId": "conn", "timestamp": 1500000000000, "name": "greeting", "data": "hello"},
+			{"id": "conn:1:1", "name": "bytes", "data": "aGVsbG8=", "encoding": "base64"},
+			{"id": "conn:1:2", "name": "object", "data": "{\"n\":1}", "encoding": "json"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, &ably.Envelope{
+		Source:  "channel.message",
+		AppID:   "aBCdEf",
+		Channel: "news",
+		Site:    "eu-central-1-A",
+		RuleID:  "1-a2Bc",
+		Messages: []*proto.Message{
+			{ID: "conn:1:0", ConnectionID: "conn", Timestamp: 1500000000000, Name: "greeting", Data: "hello"},
+			{ID: "conn:1:1", Name: "bytes", Data: []byte("hello"), Encoding: "base64"},
+			{ID: "conn:1:2", Name: "object", Data: map[string]interface{}{"n": float64(1)}, Encoding: "json"},
+		},
+	}, envelope)
+
+	envelope, err = ably.DecodeEnvelope([]byte(`{
+		"source": "channel.presence",
+		"channel": "news",
+		"presence": [{"clientId": "alice", "connectionId": "conn", "action": 2, "data": "here"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, []*proto.PresenceMessage{{
+		State:   proto.PresenceEnter,
+		Message: proto.Message{ClientID: "alice", ConnectionID: "conn", Data: "here"},
+	}}, envelope.Presence)
+
+	for _, data := range []string{
+		`not json`,
+		`{"messages": [{"data": "not base64!", "encoding": "base64"}]}`,
+		`{"messages": [{"data": "aGVsbG8=", "encoding": "cipher+aes-128-cbc/base64"}]}`,
+	} {
+		_, err := ably.DecodeEnvelope([]byte(data))
+		if err := checkError(ably.ErrInvalidRequestBody, err); err != nil {
+			t.Errorf("%s: %v", data, err)
+		}
+	}
+}
@@ -28,16 +28,33 @@ type Error struct {
 	StatusCode int    // HTTP status code
 	Err        error  // underlying error responsible for the failure; may be nil
 	Server     string // non-empty ID of the Ably server which the error was received from
+	RequestID  string // ID of the failed REST request, set when ClientOptions.AddRequestIDs is true
+
+	// Headers are the headers of the HTTP response the error was received
+	// with, e.g. for its X-Ably-ErrorCode and X-Ably-ErrorMessage; nil for
+	// errors not received from the REST API.
+	Headers http.Header
 }
 
 // Error implements builtin error interface.
 func (err *Error) Error() string {
 	if err.Err != nil {
+		if err.RequestID != "" {
+			return fmt.Sprintf("%s (status=%d, internal=%d, requestId=%s)", err.Err, err.StatusCode, err.Code, err.RequestID)
+		}
 		return fmt.Sprintf("%s (status=%d, internal=%d)", err.Err, err.StatusCode, err.Code)
 	}
+	if err.RequestID != "" {
+		return fmt.Sprintf("%s (requestId=%s)", errCodeText[err.Code], err.RequestID)
+	}
 	return errCodeText[err.Code]
 }
 
+// Unwrap gives the underlying error, for use with errors.Is and errors.As.
+func (err *Error) Unwrap() error {
+	return err.Err
+}
+
 func newError(code int, err error) *Error {
 	switch err := err.(type) {
 	case *Error:
@@ -81,6 +98,72 @@ func newErrorProto(err *proto.ErrorInfo) *Error {
 
 type genericError error
 
+// IsConnectionLimitError reports whether err, e.g. the Err of a connection
+// State or the error given by Conn.Reason, is Ably refusing a connection
+// because the account's connection limits are exceeded. Reconnecting won't
+// succeed until connections are closed or the limits are raised.
+func IsConnectionLimitError(err error) bool {
+	return hasErrorCode(err, ErrAccountRestrictedConnectionLimitsExceeded)
+}
+
+// IsResumeError reports whether err, e.g. the Err of a connection State or
+// the error given by Conn.Reason, is Ably refusing to resume a connection,
+// e.g. because it expired. Messages published on attached channels while
+// the connection was broken are then lost. See
+// ClientOptions.RetryOnResumeFailure.
+//
+// A connection disconnected for longer than Ably keeps its state isn't
+// resumed; it connects anew, changing state to StateConnConnected with a
+// resume error too.
+func IsResumeError(err error) bool {
+	return hasErrorCode(err, resumeErrorCodes...)
+}
+
+var resumeErrorCodes = []int{
+	ErrInvalidConnectionIDRemoteNotFound,
+	ErrUnableToRecoverConnectionMessagesExpired,
+	ErrUnableToRecoverConnectionMessageLimitExceeded,
+	ErrUnableToRecoverConnectionConnectionExpired,
+	ErrUnableToRecoverConnectionIncompatibleAuthParams,
+	ErrUnableToRecoverConnectionInvalidOrUnspecifiedConnectionSerial,
+	ErrInvalidConnectionIDInvalidFormat,
+}
+
+func isResumeErrorCode(code int) bool {
+	for _, c := range resumeErrorCodes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// hasErrorCode reports whether err, or an error it wraps, is an *Error or a
+// *proto.ErrorInfo with one of the given codes.
+func hasErrorCode(err error, codes ...int) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		var code int
+		switch err := err.(type) {
+		case *Error:
+			if err == nil {
+				return false
+			}
+			code = err.Code
+		case *proto.ErrorInfo:
+			if err == nil {
+				return false
+			}
+			code = err.Code
+		}
+		for _, c := range codes {
+			if code == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func code(err error) int {
 	if e, ok := err.(*Error); ok {
 		return e.Code
@@ -88,7 +171,7 @@ func code(err error) int {
 	return 0
 }
 
-func errFromUnprocessableBody(resp *http.Response) error {
+func errFromUnprocessableBody(resp *http.Response) *Error {
 	errMsg, err := ioutil.ReadAll(resp.Body)
 	if err == nil {
 		err = errors.New(string(errMsg))
@@ -97,13 +180,19 @@ func errFromUnprocessableBody(resp *http.Response) error {
 }
 
 func checkValidHTTPResponse(resp *http.Response) error {
-	type errorBody struct {
-		Error proto.ErrorInfo `json:"error,omitempty" codec:"error,omitempty"`
-	}
 	if resp.StatusCode < 300 {
 		return nil
 	}
 	defer resp.Body.Close()
+	err := errFromResponse(resp)
+	err.Headers = resp.Header
+	return err
+}
+
+func errFromResponse(resp *http.Response) *Error {
+	type errorBody struct {
+		Error proto.ErrorInfo `json:"error,omitempty" codec:"error,omitempty"`
+	}
 	typ, _, mimeErr := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 	if mimeErr != nil {
 		return &Error{
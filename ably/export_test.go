@@ -101,6 +101,48 @@ func (c *RestClient) GetCachedFallbackHost() string {
 	return c.successFallbackHost.get()
 }
 
+func (c *RestClient) GetHTTPClient() *http.Client {
+	return c.opts.httpclient()
+}
+
+// StatsLast is LastMinutes and the like, at the given time.
+func StatsLast(now, since time.Time, unit string) *PaginateParams {
+	return statsLast(now, since, unit)
+}
+
+func (opts *ClientOptions) GetNow() time.Time {
+	return opts.now()
+}
+
 func (opts *ClientOptions) GetFallbackRetryTimeout() time.Duration {
 	return opts.fallbackRetryTimeout()
 }
+
+func (l LoggerOptions) ForSubsystem(subsystem Subsystem) *LoggerOptions {
+	return l.forSubsystem(subsystem)
+}
+
+func MatchNamePattern(pattern, name string) bool {
+	return namePattern(pattern).match(name)
+}
+
+func ClientOptionsFromEnv(env map[string]string) (*ClientOptions, error) {
+	return clientOptionsFromEnv(func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	})
+}
+
+// Subscribed gives the number of subscriptions to messages on the channel.
+func (c *RealtimeChannel) Subscribed() int {
+	subs := c.messageSubs()
+	subs.mtx.Lock()
+	defer subs.mtx.Unlock()
+	seen := make(map[*Subscription]struct{})
+	for _, all := range subs.all {
+		for sub := range all {
+			seen[sub] = struct{}{}
+		}
+	}
+	return len(seen)
+}
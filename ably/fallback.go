@@ -0,0 +1,126 @@
+package ably
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync/atomic"
+)
+
+// FallbackStrategy orders the fallback hosts REST requests are retried
+// against when the primary host fails, as set by
+// ClientOptions.FallbackStrategy. Once a fallback host succeeds, it's used
+// first for FallbackRetryTimeout regardless of the strategy (RSC15f).
+type FallbackStrategy interface {
+	// Order gives the hosts in the order they're tried for a request. It
+	// must not modify hosts, and may be called concurrently.
+	Order(hosts []string) []string
+}
+
+// ShuffleFallbacks gives the default FallbackStrategy, trying the hosts in
+// random order (RSC15a).
+func ShuffleFallbacks() FallbackStrategy {
+	return shuffleFallbacks{}
+}
+
+type shuffleFallbacks struct{}
+
+func (shuffleFallbacks) Order(hosts []string) []string {
+	ordered := make([]string, len(hosts))
+	for i, j := range rand.Perm(len(hosts)) {
+		ordered[i] = hosts[j]
+	}
+	return ordered
+}
+
+// RoundRobinFallbacks gives a FallbackStrategy trying the hosts in their
+// order, starting from the next host on every request, spreading retries
+// evenly across them.
+func RoundRobinFallbacks() FallbackStrategy {
+	return &roundRobinFallbacks{}
+}
+
+type roundRobinFallbacks struct {
+	next uint32
+}
+
+func (s *roundRobinFallbacks) Order(hosts []string) []string {
+	if len(hosts) == 0 {
+		return nil
+	}
+	start := int((atomic.AddUint32(&s.next, 1) - 1) % uint32(len(hosts)))
+	ordered := make([]string, 0, len(hosts))
+	ordered = append(ordered, hosts[start:]...)
+	return append(ordered, hosts[:start]...)
+}
+
+// PinnedFallbacks gives a FallbackStrategy trying the given hosts first, in
+// that order, e.g. the clusters known to be closest to the client, and then
+// the others in random order. Pinned hosts which aren't fallback hosts are
+// ignored.
+func PinnedFallbacks(pinned ...string) FallbackStrategy {
+	return pinnedFallbacks(pinned)
+}
+
+type pinnedFallbacks []string
+
+func (s pinnedFallbacks) Order(hosts []string) []string {
+	var ordered, others []string
+	for _, h := range s {
+		if containsString(hosts, h) && !containsString(ordered, h) {
+			ordered = append(ordered, h)
+		}
+	}
+	for _, h := range hosts {
+		if !containsString(ordered, h) {
+			others = append(others, h)
+		}
+	}
+	return append(ordered, shuffleFallbacks{}.Order(others)...)
+}
+
+// WeightedFallbacks gives a FallbackStrategy trying the hosts in random
+// order, with each host as likely to be tried before another as its
+// weight is greater. Hosts not in weights have a weight of 1; hosts with a
+// weight of zero or less are tried last, in their order.
+func WeightedFallbacks(weights map[string]float64) FallbackStrategy {
+	w := make(map[string]float64, len(weights))
+	for h, v := range weights {
+		w[h] = v
+	}
+	return weightedFallbacks(w)
+}
+
+type weightedFallbacks map[string]float64
+
+func (s weightedFallbacks) Order(hosts []string) []string {
+	// Weighted sampling without replacement: each host gets a random key
+	// with an exponential distribution scaled by its weight, and hosts are
+	// tried by increasing key.
+	keys := make(map[string]float64, len(hosts))
+	for _, h := range hosts {
+		w, ok := s[h]
+		if !ok {
+			w = 1
+		}
+		if w <= 0 {
+			keys[h] = math.Inf(1)
+			continue
+		}
+		keys[h] = rand.ExpFloat64() / w
+	}
+	ordered := append([]string(nil), hosts...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return keys[ordered[i]] < keys[ordered[j]]
+	})
+	return ordered
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package ably
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// HistoryExportOptions configures ExportHistory.
+type HistoryExportOptions struct {
+	// Params selects the exported messages. By default the whole history is
+	// exported oldest first, requesting 1000 messages per page.
+	Params *PaginateParams
+
+	// PageInterval, when non-zero, is the minimum time between requesting two
+	// consecutive pages, which limits the rate of history requests made by
+	// a long running export.
+	PageInterval time.Duration
+}
+
+func (opts *HistoryExportOptions) params() *PaginateParams {
+	if opts != nil && opts.Params != nil {
+		return opts.Params
+	}
+	return &PaginateParams{Limit: 1000, Direction: "forwards"}
+}
+
+func (opts *HistoryExportOptions) pageInterval() time.Duration {
+	if opts != nil {
+		return opts.PageInterval
+	}
+	return 0
+}
+
+// ExportHistory writes the channel's message history to w as newline
+// delimited JSON, one message per line, following pages until the history is
+// exhausted or ctx is done. It gives the number of messages written.
+//
+// Messages are decoded, and decrypted when the channel was created with
+// a cipher, before being written; binary payloads are written base64 encoded
+// as in Ably's JSON wire format.
+func (c *RestChannel) ExportHistory(ctx context.Context, w io.Writer, opts *HistoryExportOptions) (int, error) {
+	enc := json.NewEncoder(w)
+	interval := opts.pageInterval()
+	n := 0
+	requested := time.Now()
+	page, err := c.History(opts.params())
+	for {
+		if err != nil {
+			return n, err
+		}
+		for _, m := range page.Messages() {
+			// The data is already decoded and decrypted, have MarshalJSON encode
+			// it afresh without encrypting it again.
+			msg := *m
+			msg.Encoding = ""
+			msg.ChannelOptions = nil
+			if err := enc.Encode(msg); err != nil {
+				return n, newError(ErrInternalError, err)
+			}
+			n++
+		}
+		if _, ok := page.paginationHeaders()["next"]; !ok || len(page.Messages()) == 0 {
+			c.logger().Sugar().Verbosef("RestChannel: exported %d messages of channel %q", n, c.Name)
+			return n, nil
+		}
+		if wait := interval - time.Since(requested); wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return n, ctx.Err()
+			case <-t.C:
+			}
+		} else if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		requested = time.Now()
+		page, err = page.Next()
+	}
+}
+
+// ExportHistory writes the channel's message history to w as newline
+// delimited JSON; see RestChannel.ExportHistory.
+func (c *RealtimeChannel) ExportHistory(ctx context.Context, w io.Writer, opts *HistoryExportOptions) (int, error) {
+	return c.client.rest.Channels.Get(c.Name, nil).ExportHistory(ctx, w, opts)
+}
@@ -0,0 +1,130 @@
+package ably_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestRestChannel_ExportHistory(t *testing.T) {
+	t.Parallel()
+
+	var requests []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("page") {
+		case "":
+			if got := r.URL.Query().Get("direction"); got != "forwards" {
+				t.Errorf("want direction=forwards; got %q", got)
+			}
+			w.Header().Set("Link", `<./history?page=2>; rel="next"`)
+			w.Write([]byte(`[{"id":"1","name":"a","data":"one"},{"id":"2","name":"a","data":"dHdv","encoding":"base64"}]`))
+		case "2":
+			w.Write([]byte(`[{"id":"3","name":"b","data":"{\"n\":3}","encoding":"json"}]`))
+		default:
+			t.Errorf("unexpected request %s", r.URL)
+		}
+	}))
+	defer server.Close()
+
+	client := newTestRestClient(t, server)
+	var buf bytes.Buffer
+	n, err := client.Channels.Get("test", nil).ExportHistory(context.Background(), &buf, &ably.HistoryExportOptions{
+		PageInterval: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("want n=3; got %d", n)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("want 2 requests; got %d", len(requests))
+	}
+	if d := requests[1].Sub(requests[0]); d < 40*time.Millisecond {
+		t.Errorf("want pages requested about 50ms apart; got %v", d)
+	}
+
+	var ids []string
+	var data []interface{}
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var m proto.Message
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, m.ID)
+		data = append(data, m.Data)
+	}
+	assertDeepEquals(t, []string{"1", "2", "3"}, ids)
+	assertDeepEquals(t, []interface{}{"one", []byte("two"), map[string]interface{}{"n": float64(3)}}, data)
+}
+
+func TestRestChannel_ExportHistoryCanceled(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Link", `<./history?page=next>; rel="next"`)
+		w.Write([]byte(`[{"id":"1","name":"a","data":"one"}]`))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	client := newTestRestClient(t, server)
+	var buf bytes.Buffer
+	n, err := client.Channels.Get("test", nil).ExportHistory(ctx, &buf, &ably.HistoryExportOptions{
+		PageInterval: time.Hour,
+	})
+	if err != context.Canceled {
+		t.Fatalf("want err=%v; got %v", context.Canceled, err)
+	}
+	if n != 1 {
+		t.Fatalf("want n=1; got %d", n)
+	}
+}
+
+func TestRestChannel_HistoryIterator(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", `<./history?page=2>; rel="next"`)
+			w.Write([]byte(`[{"id":"1","data":"one"},{"id":"2","data":"dHdv","encoding":"base64"}]`))
+		case "2":
+			w.Header().Set("Link", `<./history?page=3>; rel="next"`)
+			w.Write([]byte(`[{"id":"3","data":"{\"n\":3}","encoding":"json"}]`))
+		case "3":
+			w.Write([]byte(`[]`))
+		}
+	}))
+	defer server.Close()
+
+	client := newTestRestClient(t, server)
+	it := client.Channels.Get("test", nil).HistoryIterator(context.Background(), nil)
+	var ids, encodings []string
+	var data []interface{}
+	for it.Next() {
+		ids = append(ids, it.Message().ID)
+		encodings = append(encodings, it.Message().Encoding)
+		data = append(data, it.Message().Data)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, []string{"1", "2", "3"}, ids)
+	assertDeepEquals(t, []string{"", "", ""}, encodings)
+	assertDeepEquals(t, []interface{}{"one", []byte("two"), map[string]interface{}{"n": float64(3)}}, data)
+}
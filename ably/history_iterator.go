@@ -0,0 +1,101 @@
+package ably
+
+import (
+	"context"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// MessageIterator iterates over the messages of all pages of a channel's
+// history, requesting next pages as needed:
+//
+//	it := channel.HistoryIterator(ctx, nil)
+//	for it.Next() {
+//		msg := it.Message()
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// Messages are fully decoded: base64, JSON and, for channels created with
+// a cipher, encrypted payloads are resolved and their Encoding is empty.
+type MessageIterator struct {
+	ctx   context.Context
+	first func() (*PaginatedResult, error)
+	page  *PaginatedResult
+	items []*proto.Message
+	msg   *proto.Message
+	err   error
+}
+
+// HistoryIterator gives an iterator over the channel's message history
+// according to the given parameters; ctx is used for every page request.
+func (c *RestChannel) HistoryIterator(ctx context.Context, params *PaginateParams) *MessageIterator {
+	return newMessageIterator(ctx, func() (*PaginatedResult, error) {
+		return c.history(ctx, params, nil)
+	})
+}
+
+// HistoryIterator gives an iterator over the channel's message history
+// according to opts; see HistoryWithOptions.
+func (c *RealtimeChannel) HistoryIterator(ctx context.Context, opts HistoryOptions) *MessageIterator {
+	return newMessageIterator(ctx, func() (*PaginatedResult, error) {
+		return c.HistoryWithOptions(ctx, opts)
+	})
+}
+
+func newMessageIterator(ctx context.Context, first func() (*PaginatedResult, error)) *MessageIterator {
+	return &MessageIterator{ctx: ctx, first: first}
+}
+
+// Next advances the iterator to the next message, which is then available
+// with Message. It returns false once all messages were iterated over or an
+// error occurred, which is then available with Err.
+func (it *MessageIterator) Next() bool {
+	it.msg = nil
+	for len(it.items) == 0 {
+		if it.err != nil {
+			return false
+		}
+		if err := it.ctx.Err(); err != nil {
+			it.err = err
+			return false
+		}
+		switch {
+		case it.page == nil:
+			it.page, it.err = it.first()
+		case it.hasNext():
+			it.page, it.err = it.page.Next()
+		default:
+			return false
+		}
+		if it.err != nil {
+			return false
+		}
+		it.items = it.page.Messages()
+		if len(it.items) == 0 {
+			return false
+		}
+	}
+	msg := *it.items[0]
+	msg.Encoding = ""
+	it.msg = &msg
+	it.items = it.items[1:]
+	return true
+}
+
+func (it *MessageIterator) hasNext() bool {
+	_, ok := it.page.paginationHeaders()["next"]
+	return ok
+}
+
+// Message gives the current message.
+func (it *MessageIterator) Message() *proto.Message {
+	return it.msg
+}
+
+// Err gives the error which stopped the iteration, if any.
+func (it *MessageIterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,49 @@
+//go:build go1.18
+// +build go1.18
+
+package ably
+
+import (
+	"encoding/json"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// DecodeInto gives the decoded data of m as a T. Data of type T is returned
+// as is; strings and byte slices convert to each other. Other data, including
+// JSON payloads and strings or bytes holding JSON, is unmarshaled into T:
+//
+//	for it.Next() {
+//		order, err := ably.DecodeInto[Order](it.Message())
+//		...
+//	}
+func DecodeInto[T any](m *proto.Message) (T, error) {
+	var v T
+	if d, ok := m.Data.(T); ok {
+		return d, nil
+	}
+	var p []byte
+	switch d := m.Data.(type) {
+	case nil:
+		return v, nil
+	case string:
+		if _, ok := interface{}(v).([]byte); ok {
+			return interface{}([]byte(d)).(T), nil
+		}
+		p = []byte(d)
+	case []byte:
+		if _, ok := interface{}(v).(string); ok {
+			return interface{}(string(d)).(T), nil
+		}
+		p = d
+	default:
+		var err error
+		if p, err = json.Marshal(d); err != nil {
+			return v, newError(ErrInternalError, err)
+		}
+	}
+	if err := json.Unmarshal(p, &v); err != nil {
+		return v, newErrorf(ErrBadRequest, "decoding data of message %q into %T: %v", m.ID, v, err)
+	}
+	return v, nil
+}
@@ -0,0 +1,47 @@
+//go:build go1.18
+// +build go1.18
+
+package ably_test
+
+import (
+	"testing"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestDecodeInto(t *testing.T) {
+	t.Parallel()
+
+	type point struct {
+		X, Y int
+	}
+	p, err := ably.DecodeInto[point](&proto.Message{Data: map[string]interface{}{"X": float64(1), "Y": float64(2)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, point{X: 1, Y: 2}, p)
+
+	p, err = ably.DecodeInto[point](&proto.Message{Data: `{"X":3}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, point{X: 3}, p)
+
+	s, err := ably.DecodeInto[string](&proto.Message{Data: []byte("bytes")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, "bytes", s)
+
+	b, err := ably.DecodeInto[[]byte](&proto.Message{Data: "text"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, []byte("text"), b)
+
+	_, err = ably.DecodeInto[point](&proto.Message{Data: "not json"})
+	if err := checkError(ably.ErrBadRequest, err); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,7 @@
 package ably
 
 import (
+	"errors"
 	"net/http"
 	"reflect"
 
@@ -8,6 +9,10 @@ import (
 )
 
 // HTTPPaginatedResponse represent a response from an http request.
+//
+// Unsuccessful responses are returned rather than an error, so their status,
+// error and items, e.g. the per-message results of a partially failed batch
+// publish, can be inspected; see Err.
 type HTTPPaginatedResponse struct {
 	*PaginatedResult
 	StatusCode   int         //spec HP4
@@ -21,6 +26,11 @@ func decodeHTTPPaginatedResult(opts *proto.ChannelOptions, typ reflect.Type, res
 	var o interface{}
 	err := decodeResp(resp, &o)
 	if err != nil {
+		if resp.StatusCode >= 300 {
+			// The error is still reported by the response, which just
+			// has no items.
+			return []interface{}{}, nil
+		}
 		return nil, err
 	}
 	return o, nil
@@ -44,9 +54,24 @@ func newHTTPPaginatedResultFromPaginatedResult(p *PaginatedResult) *HTTPPaginate
 	h.Success = p.success
 	h.ErrorCode = p.errorCode
 	h.ErrorMessage = p.errorMessage
+	h.Headers = p.respHeaders
 	return h
 }
 
+// Err gives the error reported by an unsuccessful response as an *Error, or
+// nil if it was successful.
+func (h *HTTPPaginatedResponse) Err() error {
+	if h.Success {
+		return nil
+	}
+	return &Error{
+		Code:       h.ErrorCode,
+		StatusCode: h.StatusCode,
+		Err:        errors.New(h.ErrorMessage),
+		Headers:    h.Headers,
+	}
+}
+
 // Next overrides PaginatedResult.Next
 // spec HP2
 func (h *HTTPPaginatedResponse) Next() (*HTTPPaginatedResponse, error) {
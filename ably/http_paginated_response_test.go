@@ -2,6 +2,7 @@ package ably_test
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"sort"
 	"testing"
@@ -153,3 +154,90 @@ func TestHTTPPaginatedResponse(t *testing.T) {
 		}
 	})
 }
+
+func TestHTTPPaginatedResponse_Errors(t *testing.T) {
+	t.Parallel()
+	var handler http.HandlerFunc
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+	}))
+	defer server.Close()
+	client := newTestRestClient(t, server)
+
+	t.Run("partial success", func(t *testing.T) {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Ably-Errorcode", "40020")
+			w.Header().Set("X-Ably-Errormessage", "Batched response includes errors")
+			w.Header().Set("X-Ably-Serial", "42")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`[{"channel":"a","messageId":"x"},{"channel":"b","error":{"code":40160,"statusCode":401}}]`))
+		}
+		res, err := client.Request("POST", "/messages", nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Success || res.StatusCode != http.StatusBadRequest || res.ErrorCode != 40020 {
+			t.Errorf("unexpected response: success %v, status %d, code %d", res.Success, res.StatusCode, res.ErrorCode)
+		}
+		if expected := "42"; res.Headers.Get("X-Ably-Serial") != expected {
+			t.Errorf("expected X-Ably-Serial header %q; got %q", expected, res.Headers.Get("X-Ably-Serial"))
+		}
+		if n := len(res.Items()); n != 2 {
+			t.Errorf("expected 2 per-channel results; got %d", n)
+		}
+		if err := checkError(40020, res.Err()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("error in body only", func(t *testing.T) {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"code":40400,"statusCode":404,"message":"Not found"}}`))
+		}
+		res, err := client.Request("GET", "/missing", nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.ErrorCode != ably.ErrNotFound || res.ErrorMessage != "Not found" {
+			t.Errorf("unexpected error: code %d, message %q", res.ErrorCode, res.ErrorMessage)
+		}
+	})
+
+	t.Run("non-Ably error", func(t *testing.T) {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.WriteHeader(http.StatusBadGateway)
+			w.Write([]byte("upstream unavailable"))
+		}
+		res, err := client.Request("GET", "/time", nil, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Success || res.StatusCode != http.StatusBadGateway || res.ErrorMessage != "upstream unavailable" {
+			t.Errorf("unexpected response: success %v, status %d, message %q", res.Success, res.StatusCode, res.ErrorMessage)
+		}
+		if n := len(res.Items()); n != 0 {
+			t.Errorf("expected no items; got %d", n)
+		}
+	})
+
+	t.Run("headers on errors", func(t *testing.T) {
+		handler = func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Ably-Errorcode", "40160")
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":40160,"statusCode":401,"message":"Denied"}}`))
+		}
+		_, err := client.Time()
+		e, ok := err.(*ably.Error)
+		if !ok {
+			t.Fatalf("expected *ably.Error; got %T: %v", err, err)
+		}
+		if expected := "40160"; e.Headers.Get("X-Ably-Errorcode") != expected {
+			t.Errorf("expected X-Ably-ErrorCode header %q; got %q", expected, e.Headers.Get("X-Ably-Errorcode"))
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package ably
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/ably/ably-go/ably/internal/ablyutil"
+)
+
+// IDGenerator generates the IDs the client assigns: the base of idempotent
+// message IDs (Spec RSL1k1) and REST request IDs (Spec RSC7c).
+//
+// The default generator gives random base64 strings. A custom one can give
+// deterministic IDs in tests, or sortable IDs like ULIDGenerator for easier
+// log correlation. IDs must be unique, as Ably discards a published message
+// whose ID it has already seen.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+func (opts *ClientOptions) baseID() (string, error) {
+	if opts.IDGenerator != nil {
+		return opts.IDGenerator.NewID()
+	}
+	return ablyutil.BaseID()
+}
+
+func (opts *ClientOptions) requestID() (string, error) {
+	if opts.IDGenerator != nil {
+		return opts.IDGenerator.NewID()
+	}
+	return ablyutil.RequestID()
+}
+
+// ULIDGenerator is an IDGenerator giving ULIDs: 26 character strings made
+// of a millisecond timestamp followed by 80 random bits, which sort in order
+// of creation.
+type ULIDGenerator struct{}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// NewID implements the IDGenerator interface.
+func (ULIDGenerator) NewID() (string, error) {
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().UnixNano()/int64(time.Millisecond))<<16)
+	if _, err := rand.Read(b[6:]); err != nil {
+		return "", err
+	}
+	// Encode the 128 bits as 26 base32 digits, the first one holding the
+	// top 3 bits.
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var id [26]byte
+	for i := 25; i >= 0; i-- {
+		id[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id[:]), nil
+}
@@ -0,0 +1,90 @@
+package ably_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// seqIDGenerator is an ably.IDGenerator giving sequential IDs.
+type seqIDGenerator struct {
+	mtx sync.Mutex
+	n   int
+}
+
+func (g *seqIDGenerator) NewID() (string, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+	g.n++
+	return fmt.Sprintf("id-%d", g.n), nil
+}
+
+func TestClientOptions_IDGenerator(t *testing.T) {
+	t.Parallel()
+
+	var ids []string
+	var requestID string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.URL.Query().Get("request_id")
+		var messages []*proto.Message
+		if err := json.NewDecoder(r.Body).Decode(&messages); err != nil {
+			t.Error(err)
+		}
+		for _, m := range messages {
+			ids = append(ids, m.ID)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+		o.IdempotentRestPublishing = true
+		o.AddRequestIDs = true
+		o.IDGenerator = &seqIDGenerator{}
+	})
+	err := client.Channels.Get("test", nil).PublishAll([]*proto.Message{
+		{Name: "a"},
+		{Name: "b"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, []string{"id-1:0", "id-1:1"}, ids)
+	if requestID != "id-2" {
+		t.Errorf("want request_id=id-2; got %q", requestID)
+	}
+}
+
+func TestULIDGenerator(t *testing.T) {
+	t.Parallel()
+
+	var gen ably.ULIDGenerator
+	prev := ""
+	for i := 0; i < 3; i++ {
+		id, err := gen.NewID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(id) != 26 {
+			t.Fatalf("want 26 characters; got %q", id)
+		}
+		if i := strings.IndexFunc(id, func(r rune) bool {
+			return !strings.ContainsRune("0123456789ABCDEFGHJKMNPQRSTVWXYZ", r)
+		}); i != -1 {
+			t.Fatalf("invalid character at %d in %q", i, id)
+		}
+		if id <= prev {
+			t.Fatalf("want %q > %q", id, prev)
+		}
+		prev = id
+		time.Sleep(2 * time.Millisecond)
+	}
+}
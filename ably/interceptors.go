@@ -0,0 +1,42 @@
+package ably
+
+import "github.com/ably/ably-go/ably/proto"
+
+// interceptPublish calls the PublishInterceptors with every message to be
+// published.
+func (opts *ClientOptions) interceptPublish(messages []*proto.Message) error {
+	if len(opts.PublishInterceptors) == 0 {
+		return nil
+	}
+	for _, m := range messages {
+		for _, intercept := range opts.PublishInterceptors {
+			if err := intercept(m); err != nil {
+				return newError(ErrBadRequest, err)
+			}
+		}
+	}
+	return nil
+}
+
+// interceptReceived calls the ReceiveInterceptors with the messages of msg,
+// dropping the ones for which one of them fails, and reports whether msg is
+// still to be delivered, that is unless all of its messages were dropped.
+func (c *RealtimeChannel) interceptReceived(msg *proto.ProtocolMessage) bool {
+	interceptors := c.opts().ReceiveInterceptors
+	if len(interceptors) == 0 {
+		return true
+	}
+	messages := make([]*proto.Message, 0, len(msg.Messages))
+next:
+	for _, m := range msg.Messages {
+		for _, intercept := range interceptors {
+			if err := intercept(m); err != nil {
+				c.logger().Printf(LogWarning, "Realtime channel %q: dropping message %q rejected by an interceptor: %v", c.Name, m.ID, err)
+				continue next
+			}
+		}
+		messages = append(messages, m)
+	}
+	msg.Messages = messages
+	return len(messages) > 0
+}
@@ -0,0 +1,116 @@
+package ably_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+var errForbiddenName = errors.New("forbidden name")
+
+// shout upper-cases the data of messages, and rejects the ones named
+// "forbidden".
+func shout(m *proto.Message) error {
+	if m.Name == "forbidden" {
+		return errForbiddenName
+	}
+	if s, ok := m.Data.(string); ok {
+		m.Data = strings.ToUpper(s)
+	}
+	return nil
+}
+
+func TestRealtimeChannel_Interceptors(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	var audited []string
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.PublishInterceptors = []func(*proto.Message) error{shout}
+		o.ReceiveInterceptors = []func(*proto.Message) error{
+			shout,
+			func(m *proto.Message) error {
+				audited = append(audited, m.Name)
+				return nil
+			},
+		}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+
+	if _, err := channel.Publish("greeting", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-out:
+		if data := msg.Messages[0].Data; data != "HELLO" {
+			t.Errorf("expected intercepted data %q; got %v", "HELLO", data)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for MESSAGE to be sent")
+	}
+	_, err := channel.Publish("forbidden", "hello")
+	if ably.ErrorCode(err) != ably.ErrBadRequest || !errors.Is(err, errForbiddenName) {
+		t.Errorf("expected code %d wrapping %v; got %v", ably.ErrBadRequest, errForbiddenName, err)
+	}
+
+	sub, err := channel.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionMessage,
+		Channel: "test",
+		Messages: []*proto.Message{
+			{Name: "forbidden", Data: "secret"},
+			{Name: "greeting", Data: "hi"},
+		},
+	}
+	ablytest.Soon.RecvMessage(t, sub.MessageChannel(), ablytest.MatchName("greeting"), ablytest.MatchData("HI"))
+	// The interceptors following a failing one aren't called.
+	assertDeepEquals(t, []string{"greeting"}, audited)
+}
+
+func TestRestChannel_PublishInterceptors(t *testing.T) {
+	t.Parallel()
+
+	var published []*proto.Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&published); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+		o.PublishInterceptors = []func(*proto.Message) error{shout}
+	})
+	channel := client.Channels.Get("test", nil)
+
+	if err := channel.Publish("greeting", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if len(published) != 1 || published[0].Data != "HELLO" {
+		t.Errorf("expected intercepted data %q to be published; got %v", "HELLO", published)
+	}
+	published = nil
+	err := channel.Publish("forbidden", "hello")
+	if ably.ErrorCode(err) != ably.ErrBadRequest || !errors.Is(err, errForbiddenName) {
+		t.Errorf("expected code %d wrapping %v; got %v", ably.ErrBadRequest, errForbiddenName, err)
+	}
+	if published != nil {
+		t.Errorf("expected nothing to be published; got %v", published)
+	}
+}
@@ -0,0 +1,155 @@
+package ably
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// The interfaces below are implemented by the library's client, channel,
+// presence and connection types. Code depending on them rather than on the
+// concrete types can substitute mocks in tests, e.g. generated with gomock
+// or testify/mock.
+
+// RestClientAPI is the interface implemented by *RestClient.
+type RestClientAPI interface {
+	Channel(name string, opts *proto.ChannelOptions) RestChannelAPI
+	Time() (time.Time, error)
+	TimeContext(ctx context.Context) (time.Time, error)
+	Stats(params *PaginateParams) (*PaginatedResult, error)
+	Request(method string, path string, params *PaginateParams, body interface{}, headers http.Header) (*HTTPPaginatedResponse, error)
+	VerifyAuth(ctx context.Context) (*AuthReport, error)
+	Close() error
+}
+
+// RestChannelAPI is the interface implemented by *RestChannel.
+type RestChannelAPI interface {
+	Publish(name string, data interface{}) error
+	PublishContext(ctx context.Context, name string, data interface{}) error
+	PublishAll(messages []*proto.Message) error
+	PublishMultiple(ctx context.Context, messages []*proto.Message) error
+	History(params *PaginateParams) (*PaginatedResult, error)
+	HistoryIterator(ctx context.Context, params *PaginateParams) *MessageIterator
+	ExportHistory(ctx context.Context, w io.Writer, opts *HistoryExportOptions) (int, error)
+}
+
+// RestPresenceAPI is the interface implemented by *RestPresence.
+type RestPresenceAPI interface {
+	Get(params *PaginateParams) (*PaginatedResult, error)
+	GetWithParams(params *RestPresenceParams) (*PaginatedResult, error)
+	History(params *PaginateParams) (*PaginatedResult, error)
+}
+
+// RealtimeClientAPI is the interface implemented by *RealtimeClient.
+type RealtimeClientAPI interface {
+	Channel(name string) RealtimeChannelAPI
+	Conn() ConnectionAPI
+	Close() error
+	CloseContext(ctx context.Context) error
+	Shutdown(ctx context.Context) error
+	Time() (time.Time, error)
+	TimeContext(ctx context.Context) (time.Time, error)
+	Stats(params *PaginateParams) (*PaginatedResult, error)
+	VerifyAuth(ctx context.Context) (*AuthReport, error)
+}
+
+// ConnectionAPI is the interface implemented by *Conn.
+type ConnectionAPI interface {
+	Connect() (Result, error)
+	Close() error
+	ID() string
+	Key() string
+	Serial() int64
+	State() StateEnum
+	Reason() error
+	Ping() (ping, pong time.Duration, err error)
+	LastHeartbeat() (sent, received time.Time)
+	RetryIn() time.Duration
+	On(ch chan<- State, states ...StateEnum)
+	Off(ch chan<- State, states ...StateEnum)
+	OnIDChange(ch chan<- ConnectionIDChange)
+	OffIDChange(ch chan<- ConnectionIDChange)
+	WaitForState(ctx context.Context, states ...StateEnum) (State, error)
+	StateChanges(ctx context.Context) <-chan State
+}
+
+// RealtimeChannelAPI is the interface implemented by *RealtimeChannel.
+type RealtimeChannelAPI interface {
+	Attach() (Result, error)
+	Detach() (Result, error)
+	Close() error
+	SetOptions(ctx context.Context, opts *proto.ChannelOptions) error
+	Subscribe(names ...string) (*Subscription, error)
+	SubscribeWithOptions(opts *SubscriptionOptions, names ...string) (*Subscription, error)
+	SubscribePooled(pool MessagePool, handler func(*proto.Message), names ...string) (*Subscription, error)
+	OnOccupancy(handler func(Occupancy)) (*Subscription, error)
+	Unsubscribe(sub *Subscription, names ...string)
+	Publish(name string, data interface{}) (Result, error)
+	PublishContext(ctx context.Context, name string, data interface{}) error
+	PublishAll(messages []*proto.Message) (Result, error)
+	PublishMultiple(ctx context.Context, messages []*proto.Message) error
+	PublishWithOptions(ctx context.Context, messages []*proto.Message, opts PublishOptions) error
+	PublishBatch(ctx context.Context, messages []*proto.Message) error
+	History(params *PaginateParams) (*PaginatedResult, error)
+	HistoryWithOptions(ctx context.Context, opts HistoryOptions) (*PaginatedResult, error)
+	HistoryIterator(ctx context.Context, opts HistoryOptions) *MessageIterator
+	ExportHistory(ctx context.Context, w io.Writer, opts *HistoryExportOptions) (int, error)
+	PresenceAPI() RealtimePresenceAPI
+	Modes() []proto.ChannelMode
+	AttachSerial() string
+	State() StateEnum
+	Reason() error
+	On(ch chan<- State, states ...StateEnum)
+	Off(ch chan<- State, states ...StateEnum)
+	WaitForState(ctx context.Context, states ...StateEnum) (State, error)
+	StateChanges(ctx context.Context) <-chan State
+}
+
+// RealtimePresenceAPI is the interface implemented by *RealtimePresence.
+type RealtimePresenceAPI interface {
+	Get(ctx context.Context, params PresenceParams) ([]*proto.PresenceMessage, error)
+	SyncComplete() bool
+	Subscribe(states ...proto.PresenceState) (*Subscription, error)
+	Unsubscribe(sub *Subscription, states ...proto.PresenceState)
+	Enter(data string) (Result, error)
+	Update(data string) (Result, error)
+	Leave(data string) (Result, error)
+	EnterClient(clientID string, data interface{}) (Result, error)
+	UpdateClient(clientID string, data interface{}) (Result, error)
+	LeaveClient(clientID string, data interface{}) (Result, error)
+}
+
+var (
+	_ RestClientAPI       = (*RestClient)(nil)
+	_ RestChannelAPI      = (*RestChannel)(nil)
+	_ RestPresenceAPI     = (*RestPresence)(nil)
+	_ RealtimeClientAPI   = (*RealtimeClient)(nil)
+	_ ConnectionAPI       = (*Conn)(nil)
+	_ RealtimeChannelAPI  = (*RealtimeChannel)(nil)
+	_ RealtimePresenceAPI = (*RealtimePresence)(nil)
+)
+
+// Channel gives the channel with the given name; it is equivalent to
+// c.Channels.Get(name, opts).
+func (c *RestClient) Channel(name string, opts *proto.ChannelOptions) RestChannelAPI {
+	return c.Channels.Get(name, opts)
+}
+
+// Channel gives the channel with the given name; it is equivalent to
+// c.Channels.Get(name).
+func (c *RealtimeClient) Channel(name string) RealtimeChannelAPI {
+	return c.Channels.Get(name)
+}
+
+// Conn gives the client's connection; it is equivalent to c.Connection.
+func (c *RealtimeClient) Conn() ConnectionAPI {
+	return c.Connection
+}
+
+// PresenceAPI gives the channel's presence; it is equivalent to c.Presence.
+func (c *RealtimeChannel) PresenceAPI() RealtimePresenceAPI {
+	return c.Presence
+}
@@ -0,0 +1,210 @@
+package ablyutil
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock gives the current time and timers, so that code depending on time
+// passing can be driven by a FakeClock in tests.
+type Clock interface {
+	Now() time.Time
+
+	// AfterFunc is like time.AfterFunc.
+	AfterFunc(d time.Duration, f func()) Timer
+
+	// NewTimer is like time.NewTimer.
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer is like *time.Timer, with its channel given by C.
+type Timer interface {
+	// C gives the channel the time is sent on when the timer fires; it's nil
+	// for timers created with AfterFunc.
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock is the Clock of the time package.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{Timer: time.AfterFunc(d, f)}
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{Timer: time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// FakeClock is a Clock which only moves when told to, firing the timers due
+// by then.
+type FakeClock struct {
+	mtx     sync.Mutex
+	changed *sync.Cond // signaled when timers change
+	now     time.Time
+	timers  []*fakeTimer // pending timers
+}
+
+// NewFakeClock gives a FakeClock set to the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{now: now}
+	c.changed = sync.NewCond(&c.mtx)
+	return c
+}
+
+// Now gives the clock's current time.
+func (c *FakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// AfterFunc gives a timer calling f once the clock is advanced by d, from
+// the goroutine advancing it.
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.newTimer(d, f, nil)
+}
+
+// NewTimer gives a timer sending the time on its channel once the clock is
+// advanced by d.
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	return c.newTimer(d, nil, make(chan time.Time, 1))
+}
+
+func (c *FakeClock) newTimer(d time.Duration, f func(), ch chan time.Time) *fakeTimer {
+	t := &fakeTimer{clock: c, f: f, ch: ch}
+	c.mtx.Lock()
+	c.schedule(t, d)
+	c.mtx.Unlock()
+	return t
+}
+
+// Timers gives the number of timers waiting to fire.
+func (c *FakeClock) Timers() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil blocks until n timers are waiting to fire, e.g. for code
+// running in other goroutines to schedule them before advancing the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for len(c.timers) != n {
+		c.changed.Wait()
+	}
+}
+
+// Advance moves the clock forward by d, firing the timers due by then in
+// order. Timers scheduled by the fired ones fire too, if they're due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	end := c.now.Add(d)
+	c.mtx.Unlock()
+	for {
+		c.mtx.Lock()
+		if len(c.timers) == 0 || c.timers[0].at.After(end) {
+			c.now = end
+			c.mtx.Unlock()
+			return
+		}
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.changed.Broadcast()
+		if t.at.After(c.now) {
+			c.now = t.at
+		}
+		now := c.now
+		c.mtx.Unlock()
+		if t.f != nil {
+			t.f()
+		} else {
+			select {
+			case t.ch <- now:
+			default:
+			}
+		}
+	}
+}
+
+// Set sets the clock to the given time. If it's later than the current
+// one, the clock is advanced to it, firing the timers due by then.
+func (c *FakeClock) Set(now time.Time) {
+	c.mtx.Lock()
+	d := now.Sub(c.now)
+	if d < 0 {
+		c.now = now
+	}
+	c.mtx.Unlock()
+	if d >= 0 {
+		c.Advance(d)
+	}
+}
+
+// schedule adds t to the pending timers, to fire after d. It must be called
+// with c.mtx held.
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.at = c.now.Add(d)
+	i := sort.Search(len(c.timers), func(i int) bool {
+		return c.timers[i].at.After(t.at)
+	})
+	c.timers = append(c.timers, nil)
+	copy(c.timers[i+1:], c.timers[i:])
+	c.timers[i] = t
+	c.changed.Broadcast()
+}
+
+// unschedule removes t from the pending timers, telling whether it was
+// pending. It must be called with c.mtx held.
+func (c *FakeClock) unschedule(t *fakeTimer) bool {
+	for i, pending := range c.timers {
+		if pending == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			c.changed.Broadcast()
+			return true
+		}
+	}
+	return false
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	f     func()
+	ch    chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	return t.clock.unschedule(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.mtx.Lock()
+	defer t.clock.mtx.Unlock()
+	pending := t.clock.unschedule(t)
+	t.clock.schedule(t, d)
+	return pending
+}
@@ -0,0 +1,53 @@
+package ablyutil
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	clock := NewFakeClock(start)
+	var fired []time.Duration
+	record := func() {
+		fired = append(fired, clock.Now().Sub(start))
+	}
+	clock.AfterFunc(2*time.Second, func() {
+		record()
+		// Timers scheduled by fired ones fire too, if they're due.
+		clock.AfterFunc(time.Second, record)
+	})
+	clock.AfterFunc(time.Second, record)
+	stopped := clock.AfterFunc(time.Second, record)
+	if !stopped.Stop() {
+		t.Fatal("expected the timer to be pending")
+	}
+	timer := clock.NewTimer(time.Second)
+	timer.Reset(10 * time.Second)
+
+	clock.Advance(5 * time.Second)
+	if expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}; !reflect.DeepEqual(fired, expected) {
+		t.Fatalf("expected timers to fire at %v; got %v", expected, fired)
+	}
+	if now := clock.Now(); !now.Equal(start.Add(5 * time.Second)) {
+		t.Fatalf("expected the clock to be at %v; got %v", start.Add(5*time.Second), now)
+	}
+	select {
+	case <-timer.C():
+		t.Fatal("expected the reset timer not to have fired")
+	default:
+	}
+	clock.Advance(5 * time.Second)
+	select {
+	case now := <-timer.C():
+		if !now.Equal(start.Add(10 * time.Second)) {
+			t.Fatalf("expected the timer to fire at %v; got %v", start.Add(10*time.Second), now)
+		}
+	default:
+		t.Fatal("expected the reset timer to have fired")
+	}
+	if n := clock.Timers(); n != 0 {
+		t.Fatalf("expected no pending timers; got %d", n)
+	}
+}
@@ -1,8 +1,8 @@
 package ablyutil
 
 import (
-	"bytes"
 	"io"
+	"sync"
 
 	"github.com/ugorji/go/codec"
 )
@@ -11,14 +11,47 @@ var handle codec.MsgpackHandle
 
 func init() {
 	handle.Raw = true
+	// Strings are written and read as msgpack str and byte slices as bin,
+	// so that message data decodes to the type it was published with.
 	handle.WriteExt = true
-	handle.RawToString = true
+}
+
+// maxPooledBuffer is the capacity above which encoding buffers aren't kept
+// for reuse, so that a single large message doesn't pin its buffer forever.
+const maxPooledBuffer = 64 << 10
+
+// msgpackEncoder is an encoder writing to its own buffer. Encoders are pooled,
+// as creating one and growing its buffer dominate the cost of encoding small
+// messages.
+type msgpackEncoder struct {
+	buf []byte
+	enc *codec.Encoder
+}
+
+var encoderPool = sync.Pool{
+	New: func() interface{} {
+		e := &msgpackEncoder{buf: make([]byte, 0, 1024)}
+		e.enc = codec.NewEncoderBytes(&e.buf, &handle)
+		return e
+	},
+}
+
+var decoderPool = sync.Pool{
+	New: func() interface{} {
+		return codec.NewDecoderBytes([]byte{}, &handle)
+	},
 }
 
 // Unmarshal decodes the MessagePack-encoded data and stores the result in the
 // value pointed to by v.
 func Unmarshal(data []byte, v interface{}) error {
-	return decodeMsg(bytes.NewReader(data), v)
+	dec := decoderPool.Get().(*codec.Decoder)
+	dec.ResetBytes(data)
+	err := dec.Decode(v)
+	// Don't keep data referenced from the pool.
+	dec.ResetBytes([]byte{})
+	decoderPool.Put(dec)
+	return err
 }
 
 // decodeMsg decodes msgpack message read from r into v.
@@ -29,12 +62,19 @@ func decodeMsg(r io.Reader, v interface{}) error {
 
 // Marshal returns msgpack encoding of v
 func Marshal(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	err := encodeMsg(&buf, v)
-	if err != nil {
-		return nil, err
+	e := encoderPool.Get().(*msgpackEncoder)
+	e.enc.ResetBytes(&e.buf)
+	err := e.enc.Encode(v)
+	var p []byte
+	if err == nil {
+		p = make([]byte, len(e.buf))
+		copy(p, e.buf)
+	}
+	if cap(e.buf) <= maxPooledBuffer {
+		e.buf = e.buf[:0]
+		encoderPool.Put(e)
 	}
-	return buf.Bytes(), nil
+	return p, err
 }
 
 // encodeMsg encodes v into msgpack format and writes the output to w.
@@ -2,7 +2,11 @@ package ablyutil
 
 import (
 	"bytes"
+	"fmt"
+	"reflect"
 	"testing"
+
+	"github.com/ably/ably-go/ably/proto"
 )
 
 func TestMsgpack(t *testing.T) {
@@ -27,4 +31,69 @@ func TestMsgpack(t *testing.T) {
 			ts.Errorf("expected 12 got %v", b.Key)
 		}
 	})
+	t.Run("must encode messages as maps of their set fields", func(ts *testing.T) {
+		msg := &proto.Message{ID: "id", Name: "name", Data: "data", Timestamp: 1}
+		p, err := Marshal(msg)
+		if err != nil {
+			ts.Fatal(err)
+		}
+		var fields map[string]interface{}
+		if err := Unmarshal(p, &fields); err != nil {
+			ts.Fatal(err)
+		}
+		if len(fields) != 4 || fields["id"] != "id" || fields["name"] != "name" {
+			ts.Errorf("unexpected fields %v", fields)
+		}
+		var got proto.Message
+		if err := Unmarshal(p, &got); err != nil {
+			ts.Fatal(err)
+		}
+		if !reflect.DeepEqual(&got, msg) {
+			ts.Errorf("want %+v; got %+v", msg, &got)
+		}
+	})
+}
+
+func benchmarkProtocolMessage() *proto.ProtocolMessage {
+	msg := &proto.ProtocolMessage{
+		Action:    proto.ActionMessage,
+		Channel:   "benchmark",
+		MsgSerial: 42,
+	}
+	for i := 0; i < 10; i++ {
+		msg.Messages = append(msg.Messages, &proto.Message{
+			ID:   fmt.Sprintf("id:%d", i),
+			Name: "event",
+			Data: "payload of a typical size for a published message",
+		})
+	}
+	return msg
+}
+
+// BenchmarkMarshal measures encoding on the publish path.
+func BenchmarkMarshal(b *testing.B) {
+	msg := benchmarkProtocolMessage()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkUnmarshal measures decoding on the receive path.
+func BenchmarkUnmarshal(b *testing.B) {
+	p, err := Marshal(benchmarkProtocolMessage())
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var msg proto.ProtocolMessage
+		if err := Unmarshal(p, &msg); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
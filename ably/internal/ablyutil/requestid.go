@@ -0,0 +1,18 @@
+package ablyutil
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// RequestID returns a url-safe base64 encoded 12 random bytes to be used
+// as the request_id query parameter of REST requests.
+//
+// Spec RSC7c
+func RequestID() (string, error) {
+	r := make([]byte, 12)
+	if _, err := rand.Read(r); err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(r), nil
+}
@@ -1,7 +1,9 @@
 package ablyutil
 
 import (
+	"crypto/tls"
 	"errors"
+	"net"
 	"net/url"
 	"time"
 
@@ -11,11 +13,24 @@ import (
 )
 
 type WebsocketConn struct {
-	conn  *websocket.Conn
-	codec websocket.Codec
+	conn         *websocket.Conn
+	codec        websocket.Codec
+	writeTimeout time.Duration
+}
+
+// WebsocketTimeouts are the timeouts of a websocket connection; zero values
+// mean no timeout.
+type WebsocketTimeouts struct {
+	Handshake time.Duration // for the TCP, TLS and websocket handshakes altogether
+	Write     time.Duration // for writing each message
 }
 
 func (ws *WebsocketConn) Send(msg *proto.ProtocolMessage) error {
+	if ws.writeTimeout > 0 {
+		if err := ws.conn.SetWriteDeadline(time.Now().Add(ws.writeTimeout)); err != nil {
+			return err
+		}
+	}
 	return ws.codec.Send(ws.conn, msg)
 }
 
@@ -39,7 +54,11 @@ func (ws *WebsocketConn) Close() error {
 }
 
 func DialWebsocket(proto string, u *url.URL) (*WebsocketConn, error) {
-	ws := &WebsocketConn{}
+	return DialWebsocketTimeouts(proto, u, WebsocketTimeouts{})
+}
+
+func DialWebsocketTimeouts(proto string, u *url.URL, timeouts WebsocketTimeouts) (*WebsocketConn, error) {
+	ws := &WebsocketConn{writeTimeout: timeouts.Write}
 	switch proto {
 	case "application/json":
 		ws.codec = websocket.JSON
@@ -48,14 +67,61 @@ func DialWebsocket(proto string, u *url.URL) (*WebsocketConn, error) {
 	default:
 		return nil, errors.New(`invalid protocol "` + proto + `"`)
 	}
-	conn, err := websocket.Dial(u.String(), "", "https://"+u.Host)
+	config, err := websocket.NewConfig(u.String(), "https://"+u.Host)
 	if err != nil {
 		return nil, err
 	}
+	conn, err := dialWebsocket(config, timeouts.Handshake)
+	if err != nil {
+		return nil, &websocket.DialError{Config: config, Err: err}
+	}
 	ws.conn = conn
 	return ws, nil
 }
 
+// dialWebsocket is websocket.DialConfig with a timeout covering the whole
+// handshake, not only establishing the TCP connection.
+func dialWebsocket(config *websocket.Config, timeout time.Duration) (*websocket.Conn, error) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	addr := config.Location.Host
+	if config.Location.Port() == "" {
+		port := "80"
+		if config.Location.Scheme == "wss" {
+			port = "443"
+		}
+		addr = net.JoinHostPort(config.Location.Hostname(), port)
+	}
+	conn, err := (&net.Dialer{Deadline: deadline}).Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if config.Location.Scheme == "wss" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: config.Location.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
 var msgpackCodec = websocket.Codec{
 	Marshal: func(v interface{}) ([]byte, byte, error) {
 		p, err := Marshal(v)
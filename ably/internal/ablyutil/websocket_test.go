@@ -0,0 +1,78 @@
+package ablyutil
+
+import (
+	"net"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+
+	"golang.org/x/net/websocket"
+)
+
+func TestDialWebsocketTimeouts(t *testing.T) {
+	t.Run("must exchange messages", func(ts *testing.T) {
+		server := httptest.NewServer(websocket.Handler(func(ws *websocket.Conn) {
+			var msg proto.ProtocolMessage
+			if err := websocket.JSON.Receive(ws, &msg); err != nil {
+				return
+			}
+			websocket.JSON.Send(ws, msg)
+		}))
+		defer server.Close()
+		u, _ := url.Parse("ws" + server.URL[len("http"):])
+
+		conn, err := DialWebsocketTimeouts("application/json", u, WebsocketTimeouts{
+			Handshake: time.Second,
+			Write:     time.Second,
+		})
+		if err != nil {
+			ts.Fatal(err)
+		}
+		defer conn.Close()
+		if err := conn.Send(&proto.ProtocolMessage{Action: proto.ActionHeartbeat, Channel: "echo"}); err != nil {
+			ts.Fatal(err)
+		}
+		msg, err := conn.Receive(time.Now().Add(time.Second))
+		if err != nil {
+			ts.Fatal(err)
+		}
+		if msg.Action != proto.ActionHeartbeat || msg.Channel != "echo" {
+			ts.Errorf("expected the sent message to be echoed; got %+v", msg)
+		}
+	})
+	t.Run("must time out a stalled handshake", func(ts *testing.T) {
+		// The listener accepts connections, but never answers the handshake.
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			ts.Fatal(err)
+		}
+		defer ln.Close()
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+		u := &url.URL{Scheme: "ws", Host: ln.Addr().String()}
+
+		start := time.Now()
+		_, err = DialWebsocketTimeouts("application/json", u, WebsocketTimeouts{Handshake: 50 * time.Millisecond})
+		if err == nil {
+			ts.Fatal("expected handshake to fail")
+		}
+		if dialErr, ok := err.(*websocket.DialError); !ok {
+			ts.Errorf("expected *websocket.DialError; got %T: %v", err, err)
+		} else if netErr, ok := dialErr.Err.(net.Error); !ok || !netErr.Timeout() {
+			ts.Errorf("expected a timeout; got %v", dialErr.Err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			ts.Errorf("expected handshake to time out after 50ms; took %v", elapsed)
+		}
+	})
+}
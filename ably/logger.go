@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"sort"
+	"strings"
 )
 
 type LogLevel uint
@@ -30,26 +32,131 @@ var defaultLog = LoggerOptions{
 	Level:  LogNone,
 }
 
+// Subsystem identifies a part of the library which writes log entries.
+type Subsystem string
+
+const (
+	SubsystemTransport Subsystem = "transport" // realtime connection and protocol messages
+	SubsystemAuth      Subsystem = "auth"      // authentication and token renewal
+	SubsystemChannel   Subsystem = "channel"   // realtime channels
+	SubsystemPresence  Subsystem = "presence"  // realtime and REST presence
+	SubsystemHTTP      Subsystem = "http"      // REST requests
+)
+
 // LoggerOptions defines options for ably logging.
 type LoggerOptions struct {
 	Logger Logger
 	Level  LogLevel
+
+	// Levels overrides Level for the given subsystems, e.g. to debug
+	// presence without verbose transport logs:
+	//
+	//	ably.LoggerOptions{
+	//		Level:  ably.LogWarning,
+	//		Levels: map[ably.Subsystem]ably.LogLevel{ably.SubsystemPresence: ably.LogDebug},
+	//	}
+	Levels map[Subsystem]LogLevel
+
+	// LogPayloads when true includes message payloads and HTTP bodies in
+	// logs written below LogDebug. By default they're redacted, as are API
+	// keys, tokens and connection keys; at LogDebug nothing is redacted.
+	LogPayloads bool
+
+	// Structured, when non-nil, receives all log entries instead of Logger,
+	// together with contextual fields like connectionID, channel and
+	// requestID. Entries are still filtered by Level.
+	Structured StructuredLogger
+
+	fields    []Field
+	tags      *tags
+	subsystem Subsystem
 }
 
 func (l LoggerOptions) Is(level LogLevel) bool {
-	return l.Level != LogNone && l.Level >= level
+	max := l.Level
+	if v, ok := l.Levels[l.subsystem]; ok && l.subsystem != "" {
+		max = v
+	}
+	return max != LogNone && max >= level
 }
 
 func (l LoggerOptions) Print(level LogLevel, v ...interface{}) {
-	if l.Is(level) {
-		l.GetLogger().Print(level, v...)
+	if !l.Is(level) {
+		return
+	}
+	if l.Structured != nil {
+		l.Structured.Log(level, fmt.Sprint(v...), l.allFields()...)
+		return
+	}
+	if s := l.suffix(); s != "" {
+		l.GetLogger().Print(level, fmt.Sprint(v...)+s)
+		return
 	}
+	l.GetLogger().Print(level, v...)
 }
 
 func (l LoggerOptions) Printf(level LogLevel, format string, v ...interface{}) {
-	if l.Is(level) {
-		l.GetLogger().Printf(level, format, v...)
+	if !l.Is(level) {
+		return
 	}
+	if l.Structured != nil {
+		l.Structured.Log(level, fmt.Sprintf(format, v...), l.allFields()...)
+		return
+	}
+	if s := l.suffix(); s != "" {
+		l.GetLogger().Printf(level, format+"%s", append(v[:len(v):len(v)], s)...)
+		return
+	}
+	l.GetLogger().Printf(level, format, v...)
+}
+
+// With gives a copy of the options which adds the given contextual fields
+// to every log entry.
+func (l LoggerOptions) With(fields ...Field) LoggerOptions {
+	all := make([]Field, 0, len(l.fields)+len(fields))
+	l.fields = append(append(all, l.fields...), fields...)
+	return l
+}
+
+// forSubsystem gives a copy of the options which filters entries using the
+// level configured for the given subsystem.
+func (l LoggerOptions) forSubsystem(subsystem Subsystem) *LoggerOptions {
+	l.subsystem = subsystem
+	return &l
+}
+
+// allFields gives the contextual fields followed by the client tags.
+func (l LoggerOptions) allFields() []Field {
+	fields := l.fields
+	if l.tags != nil {
+		tags := l.tags.all()
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields = make([]Field, 0, len(l.fields)+len(keys))
+		fields = append(fields, l.fields...)
+		for _, k := range keys {
+			fields = append(fields, Field{Key: k, Value: tags[k]})
+		}
+	}
+	return fields
+}
+
+// suffix formats the contextual fields and client tags for unstructured
+// loggers.
+func (l LoggerOptions) suffix() string {
+	var b strings.Builder
+	for _, f := range l.fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	if l.tags != nil {
+		if s := l.tags.String(); s != "" {
+			b.WriteString(" [" + s + "]")
+		}
+	}
+	return b.String()
 }
 
 // GetLogger returns the custom logger if any. This will return the default
@@ -71,6 +178,20 @@ type Logger interface {
 	Printf(level LogLevel, format string, v ...interface{})
 }
 
+// Field is a single piece of context attached to a structured log entry.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// StructuredLogger is an interface for loggers which keep the context of
+// log entries as separate fields, e.g. to integrate with existing logging
+// pipelines. See NewZapLogger, NewLogrusLogger and NewSlogLogger for
+// adapters of popular logging libraries.
+type StructuredLogger interface {
+	Log(level LogLevel, msg string, fields ...Field)
+}
+
 // StdLogger wraps log.Logger to satisfy the Logger interface.
 type StdLogger struct {
 	*log.Logger
@@ -0,0 +1,84 @@
+package ably
+
+// ZapSugaredLogger is the subset of *zap.SugaredLogger used by the adapter
+// returned from NewZapLogger.
+type ZapSugaredLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// NewZapLogger gives a StructuredLogger which writes to the given zap
+// logger, e.g.:
+//
+//	opts.Logger.Structured = ably.NewZapLogger(zapLogger.Sugar())
+//
+// LogVerbose and LogDebug entries are written at zap's debug level.
+func NewZapLogger(l ZapSugaredLogger) StructuredLogger {
+	return zapLogger{l: l}
+}
+
+type zapLogger struct {
+	l ZapSugaredLogger
+}
+
+func (z zapLogger) Log(level LogLevel, msg string, fields ...Field) {
+	kv := make([]interface{}, 0, 2*len(fields))
+	for _, f := range fields {
+		kv = append(kv, f.Key, f.Value)
+	}
+	switch level {
+	case LogError:
+		z.l.Errorw(msg, kv...)
+	case LogWarning:
+		z.l.Warnw(msg, kv...)
+	case LogInfo:
+		z.l.Infow(msg, kv...)
+	default:
+		z.l.Debugw(msg, kv...)
+	}
+}
+
+// LogrusEntry is the subset of *logrus.Entry used by the adapter returned
+// from NewLogrusLogger.
+type LogrusEntry interface {
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warn(args ...interface{})
+	Error(args ...interface{})
+}
+
+// NewLogrusLogger gives a StructuredLogger which writes to the entries
+// returned by withFields, which is expected to wrap logrus' WithFields, e.g.:
+//
+//	opts.Logger.Structured = ably.NewLogrusLogger(func(fields map[string]interface{}) ably.LogrusEntry {
+//		return logrusLogger.WithFields(fields)
+//	})
+//
+// LogVerbose and LogDebug entries are written at logrus' debug level.
+func NewLogrusLogger(withFields func(fields map[string]interface{}) LogrusEntry) StructuredLogger {
+	return logrusLogger{withFields: withFields}
+}
+
+type logrusLogger struct {
+	withFields func(map[string]interface{}) LogrusEntry
+}
+
+func (l logrusLogger) Log(level LogLevel, msg string, fields ...Field) {
+	m := make(map[string]interface{}, len(fields))
+	for _, f := range fields {
+		m[f.Key] = f.Value
+	}
+	entry := l.withFields(m)
+	switch level {
+	case LogError:
+		entry.Error(msg)
+	case LogWarning:
+		entry.Warn(msg)
+	case LogInfo:
+		entry.Info(msg)
+	default:
+		entry.Debug(msg)
+	}
+}
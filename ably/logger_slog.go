@@ -0,0 +1,40 @@
+//go:build go1.21
+// +build go1.21
+
+package ably
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger gives a StructuredLogger which writes to the given slog
+// logger. LogVerbose and LogDebug entries are written at slog.LevelDebug.
+func NewSlogLogger(l *slog.Logger) StructuredLogger {
+	return slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s slogLogger) Log(level LogLevel, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+	s.l.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LogError:
+		return slog.LevelError
+	case LogWarning:
+		return slog.LevelWarn
+	case LogInfo:
+		return slog.LevelInfo
+	default:
+		return slog.LevelDebug
+	}
+}
@@ -1,9 +1,17 @@
 package ably_test
 
 import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
 )
 
 type dummyLogger struct {
@@ -76,3 +84,230 @@ func TestLoggerOptions(t *testing.T) {
 		}
 	})
 }
+
+type structuredRecorder struct {
+	mtx     sync.Mutex
+	entries []string
+}
+
+func (r *structuredRecorder) Log(level ably.LogLevel, msg string, fields ...ably.Field) {
+	entry := msg
+	for _, f := range fields {
+		entry += fmt.Sprintf(" %s=%v", f.Key, f.Value)
+	}
+	r.mtx.Lock()
+	r.entries = append(r.entries, entry)
+	r.mtx.Unlock()
+}
+
+func (r *structuredRecorder) Entries() []string {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return append([]string(nil), r.entries...)
+}
+
+type zapRecorder struct {
+	structuredRecorder
+}
+
+func (z *zapRecorder) logw(level, msg string, kv []interface{}) {
+	fields := make([]ably.Field, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fields = append(fields, ably.Field{Key: kv[i].(string), Value: kv[i+1]})
+	}
+	z.Log(0, level+" "+msg, fields...)
+}
+
+func (z *zapRecorder) Debugw(msg string, kv ...interface{}) { z.logw("debug", msg, kv) }
+func (z *zapRecorder) Infow(msg string, kv ...interface{})  { z.logw("info", msg, kv) }
+func (z *zapRecorder) Warnw(msg string, kv ...interface{})  { z.logw("warn", msg, kv) }
+func (z *zapRecorder) Errorw(msg string, kv ...interface{}) { z.logw("error", msg, kv) }
+
+func TestLoggerOptions_Structured(t *testing.T) {
+	t.Parallel()
+	t.Run("fields", func(t *testing.T) {
+		rec := &structuredRecorder{}
+		lg := ably.LoggerOptions{Level: ably.LogInfo, Structured: rec}
+		lg = lg.With(ably.Field{Key: "channel", Value: "test"})
+		lg.Printf(ably.LogInfo, "attached %d", 1)
+		lg.Print(ably.LogVerbose, "not logged")
+		assertDeepEquals(t, []string{"attached 1 channel=test"}, rec.Entries())
+	})
+	t.Run("unstructured suffix", func(t *testing.T) {
+		rec := &logRecorder{}
+		lg := ably.LoggerOptions{Level: ably.LogInfo, Logger: rec}
+		lg.With(ably.Field{Key: "requestID", Value: "abc"}).Printf(ably.LogInfo, "failed %s", "request")
+		assertDeepEquals(t, []string{"failed request requestID=abc"}, rec.Lines())
+	})
+	t.Run("zap adapter", func(t *testing.T) {
+		rec := &zapRecorder{}
+		lg := ably.LoggerOptions{Level: ably.LogDebug, Structured: ably.NewZapLogger(rec)}
+		lg = lg.With(ably.Field{Key: "channel", Value: "test"})
+		lg.Print(ably.LogWarning, "slow receiver")
+		lg.Print(ably.LogVerbose, "sending")
+		assertDeepEquals(t, []string{
+			"warn slow receiver channel=test",
+			"debug sending channel=test",
+		}, rec.Entries())
+	})
+	t.Run("connection ID and tags", func(t *testing.T) {
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		rec := &structuredRecorder{}
+		client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.Logger = ably.LoggerOptions{Level: ably.LogVerbose, Structured: rec}
+		})
+		client.SetTag("tenant", "acme")
+		in <- connectedMessage("connection-id")
+		if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Channels.Get("test").Attach(); err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, e := range rec.Entries() {
+			if strings.HasSuffix(e, "connectionID=connection-id tenant=acme") {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("want entry with connection ID and tags; got %q", rec.Entries())
+		}
+	})
+	t.Run("acknowledgements", func(t *testing.T) {
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		rec := &structuredRecorder{}
+		client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.Logger = ably.LoggerOptions{Level: ably.LogVerbose, Structured: rec}
+		})
+		defer func() {
+			in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+			client.Close()
+		}()
+		res, err := channel.Publish("event", "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-out // MESSAGE
+		in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 1, Count: 1}
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		var found bool
+		for _, e := range rec.Entries() {
+			if strings.HasPrefix(e, "received ACK") && strings.Contains(e, "connectionID=conn") {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("want ACK entry with connection ID; got %q", rec.Entries())
+		}
+	})
+}
+
+func TestLoggerOptions_Levels(t *testing.T) {
+	t.Parallel()
+	lg := ably.LoggerOptions{
+		Level: ably.LogWarning,
+		Levels: map[ably.Subsystem]ably.LogLevel{
+			ably.SubsystemPresence:  ably.LogDebug,
+			ably.SubsystemTransport: ably.LogNone,
+		},
+	}
+	for _, c := range []struct {
+		subsystem ably.Subsystem
+		level     ably.LogLevel
+		want      bool
+	}{
+		{"", ably.LogWarning, true},
+		{"", ably.LogInfo, false},
+		{ably.SubsystemPresence, ably.LogDebug, true},
+		{ably.SubsystemTransport, ably.LogError, false},
+		{ably.SubsystemAuth, ably.LogWarning, true},
+		{ably.SubsystemAuth, ably.LogVerbose, false},
+	} {
+		if got := lg.ForSubsystem(c.subsystem).Is(c.level); got != c.want {
+			t.Errorf("%q: Is(%d)=%t; want %t", c.subsystem, c.level, got, c.want)
+		}
+	}
+
+	// Transport frame dumps are silenced, while the connection still works.
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	rec := &logRecorder{}
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Logger = ably.LoggerOptions{
+			Level:  ably.LogVerbose,
+			Levels: map[ably.Subsystem]ably.LogLevel{ably.SubsystemTransport: ably.LogNone},
+			Logger: rec,
+		}
+	})
+	in <- connectedMessage("connection-id")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range rec.Lines() {
+		if strings.Contains(line, "Realtime Connection:") {
+			t.Errorf("want no transport logs; got %q", line)
+		}
+	}
+}
+
+func TestLoggerOptions_Redaction(t *testing.T) {
+	t.Parallel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"secret":"secret-payload"}`))
+	}))
+	defer server.Close()
+	token := base64.StdEncoding.EncodeToString([]byte("token"))
+
+	for _, c := range []struct {
+		level    ably.LogLevel
+		payloads bool
+		want     []string
+		dontWant []string
+	}{
+		{ably.LogVerbose, false, []string{"Authorization: [REDACTED]"}, []string{token, "secret-payload"}},
+		{ably.LogVerbose, true, []string{"Authorization: [REDACTED]", "secret-payload"}, []string{token}},
+		{ably.LogDebug, false, []string{token, "secret-payload"}, nil},
+	} {
+		rec := &logRecorder{}
+		client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+			o.Logger = ably.LoggerOptions{Level: c.level, LogPayloads: c.payloads, Logger: rec}
+		})
+		if err := client.Channels.Get("test", nil).Publish("event", "secret-payload"); err != nil {
+			t.Fatal(err)
+		}
+		logs := strings.Join(rec.Lines(), "\n")
+		for _, s := range c.want {
+			if !strings.Contains(logs, s) {
+				t.Errorf("level=%d payloads=%t: want logs to contain %q; got:\n%s", c.level, c.payloads, s, logs)
+			}
+		}
+		for _, s := range c.dontWant {
+			if strings.Contains(logs, s) {
+				t.Errorf("level=%d payloads=%t: want logs not to contain %q; got:\n%s", c.level, c.payloads, s, logs)
+			}
+		}
+	}
+
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	rec := &logRecorder{}
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Logger = ably.LoggerOptions{Level: ably.LogVerbose, Logger: rec}
+	})
+	in <- connectedMessage("connection-id")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range rec.Lines() {
+		if strings.Contains(line, "connection-id-key") {
+			t.Errorf("want connection key to be redacted; got %q", line)
+		}
+	}
+}
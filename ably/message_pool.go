@@ -0,0 +1,61 @@
+package ably
+
+import (
+	"sync"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// MessagePool supplies the messages handed to the handlers of subscriptions
+// created with RealtimeChannel.SubscribePooled, and takes them back once
+// the handlers return.
+type MessagePool interface {
+	Get() *proto.Message
+	Put(*proto.Message)
+}
+
+// NewMessagePool gives a MessagePool backed by a sync.Pool.
+func NewMessagePool() MessagePool {
+	return &syncMessagePool{pool: sync.Pool{
+		New: func() interface{} { return &proto.Message{} },
+	}}
+}
+
+type syncMessagePool struct {
+	pool sync.Pool
+}
+
+func (p *syncMessagePool) Get() *proto.Message {
+	return p.pool.Get().(*proto.Message)
+}
+
+func (p *syncMessagePool) Put(m *proto.Message) {
+	p.pool.Put(m)
+}
+
+// defaultMessagePool is used by SubscribePooled when no pool is given.
+var defaultMessagePool = NewMessagePool()
+
+// SubscribePooled subscribes to the channel like Subscribe, but calls
+// handler for every received message instead of delivering messages on a Go
+// channel. The handler is called in order from a single goroutine, unless
+// ClientOptions.HandlerConcurrency allows concurrent calls.
+//
+// The message given to handler is taken from pool, or from a pool shared by
+// the library if pool is nil, and is put back once handler returns: handler
+// must not keep a reference to it. Its payload isn't copied; it's shared with
+// the other subscriptions receiving the message and must not be modified.
+// Unlike Subscribe, delivering a message allocates nothing, which matters
+// for high throughput consumers.
+//
+// The returned Subscription is closed and unsubscribed as any other; its
+// MessageChannel method panics.
+func (c *RealtimeChannel) SubscribePooled(pool MessagePool, handler func(*proto.Message), names ...string) (*Subscription, error) {
+	if _, err := c.attach(false); err != nil {
+		return nil, err
+	}
+	if pool == nil {
+		pool = defaultMessagePool
+	}
+	return c.messageSubs().subscribePooled(pool, handler, c.opts().HandlerConcurrency, namesToKeys(names)...)
+}
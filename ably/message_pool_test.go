@@ -0,0 +1,201 @@
+package ably_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// newAttachedPipeChannel gives the attached channel "test" of a client
+// connected through in and out.
+func newAttachedPipeChannel(tb testing.TB, in, out chan *proto.ProtocolMessage, opts ...func(*ably.ClientOptions)) *ably.RealtimeChannel {
+	tb.Helper()
+	_, channel := newAttachedPipeClient(tb, in, out, opts...)
+	return channel
+}
+
+// newAttachedPipeClient gives a client connected through in and out, and its
+// attached channel "test".
+func newAttachedPipeClient(tb testing.TB, in, out chan *proto.ProtocolMessage, opts ...func(*ably.ClientOptions)) (*ably.RealtimeClient, *ably.RealtimeChannel) {
+	tb.Helper()
+	client := newPipeRealtimeClient(tb, in, out, opts...)
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		tb.Fatal(err)
+	}
+	channel := client.Channels.Get("test")
+	res, err := channel.Attach()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	<-out // ATTACH
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	if err := res.Wait(); err != nil {
+		tb.Fatal(err)
+	}
+	return client, channel
+}
+
+// countingPool is an ably.MessagePool counting the messages put back.
+type countingPool struct {
+	ably.MessagePool
+	puts int32
+}
+
+func (p *countingPool) Put(m *proto.Message) {
+	atomic.AddInt32(&p.puts, 1)
+	p.MessagePool.Put(m)
+}
+
+func TestRealtimeChannel_SubscribePooled(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	channel := newAttachedPipeChannel(t, in, out)
+
+	pool := &countingPool{MessagePool: ably.NewMessagePool()}
+	received := make(chan string, 2)
+	pooled, err := channel.SubscribePooled(pool, func(m *proto.Message) {
+		received <- string(m.Data.([]byte))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pooled.Close()
+	sub, err := channel.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	for _, data := range []string{"first", "second"} {
+		in <- &proto.ProtocolMessage{
+			Action:   proto.ActionMessage,
+			Channel:  "test",
+			Messages: []*proto.Message{{Name: "event", Data: []byte(data)}},
+		}
+	}
+	var got, gotPooled []string
+	for len(got) < 2 || len(gotPooled) < 2 {
+		select {
+		case data := <-received:
+			gotPooled = append(gotPooled, data)
+		case msg := <-sub.MessageChannel():
+			got = append(got, string(msg.Data.([]byte)))
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for messages")
+		}
+	}
+	// Reusing pooled messages must not alter the messages given to other
+	// subscriptions.
+	assertDeepEquals(t, []string{"first", "second"}, got)
+	assertDeepEquals(t, []string{"first", "second"}, gotPooled)
+	if n := atomic.LoadInt32(&pool.puts); n != 2 {
+		t.Errorf("want 2 messages put back; got %d", n)
+	}
+}
+
+func benchmarkSubscribe(b *testing.B, subscribe func(*ably.RealtimeChannel, chan<- struct{}) *ably.Subscription) {
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	channel := newAttachedPipeChannel(b, in, out)
+	done := make(chan struct{}, 1)
+	sub := subscribe(channel, done)
+	defer sub.Close()
+	msg := &proto.ProtocolMessage{
+		Action:   proto.ActionMessage,
+		Channel:  "test",
+		Messages: []*proto.Message{{Name: "event", Data: make([]byte, 256)}},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		in <- msg
+		<-done
+	}
+}
+
+func BenchmarkRealtimeChannel_Subscribe(b *testing.B) {
+	benchmarkSubscribe(b, func(channel *ably.RealtimeChannel, done chan<- struct{}) *ably.Subscription {
+		sub, err := channel.Subscribe()
+		if err != nil {
+			b.Fatal(err)
+		}
+		go func() {
+			for range sub.MessageChannel() {
+				done <- struct{}{}
+			}
+		}()
+		return sub
+	})
+}
+
+func BenchmarkRealtimeChannel_SubscribePooled(b *testing.B) {
+	benchmarkSubscribe(b, func(channel *ably.RealtimeChannel, done chan<- struct{}) *ably.Subscription {
+		sub, err := channel.SubscribePooled(nil, func(*proto.Message) {
+			done <- struct{}{}
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		return sub
+	})
+}
+
+func TestRealtimeChannel_SubscribePooledConcurrency(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	const concurrency = 4
+	channel := newAttachedPipeChannel(t, in, out, func(o *ably.ClientOptions) {
+		o.HandlerConcurrency = concurrency
+	})
+
+	// Every handler call waits for all of them to be running at once.
+	var running int32
+	release := make(chan struct{})
+	handled := make(chan struct{}, concurrency)
+	sub, err := channel.SubscribePooled(nil, func(*proto.Message) {
+		if atomic.AddInt32(&running, 1) == concurrency {
+			close(release)
+		}
+		select {
+		case <-release:
+		case <-time.After(ablytest.Timeout):
+		}
+		handled <- struct{}{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	msg := &proto.ProtocolMessage{Action: proto.ActionMessage, Channel: "test"}
+	for i := 0; i < concurrency; i++ {
+		msg.Messages = append(msg.Messages, &proto.Message{Name: "event"})
+	}
+	in <- msg
+	for i := 0; i < concurrency; i++ {
+		<-handled
+	}
+	select {
+	case <-release:
+	default:
+		t.Fatalf("want %d concurrent handler calls", concurrency)
+	}
+
+	_, err = ably.NewRealtimeClient(&ably.ClientOptions{
+		AuthOptions:        ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+		NoConnect:          true,
+		HandlerConcurrency: -1,
+	})
+	if code := ably.ErrorCode(err); code != ably.ErrInvalidParameterValue {
+		t.Errorf("want error %d for negative HandlerConcurrency; got %v", ably.ErrInvalidParameterValue, err)
+	}
+}
@@ -0,0 +1,113 @@
+package ably
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// Names of the metachannels on which Ably publishes lifecycle events of
+// the app's channels and connections. Subscribing to them requires the
+// channel-metadata capability.
+const (
+	ChannelLifecycleMetachannel    = "[meta]channel.lifecycle"
+	ConnectionLifecycleMetachannel = "[meta]connection.lifecycle"
+)
+
+// ChannelStatus is the status of a channel carried by a
+// ChannelLifecycleEvent or a channel lifecycle webhook.
+type ChannelStatus struct {
+	IsActive  bool `json:"isActive"`
+	Occupancy struct {
+		Metrics Occupancy `json:"metrics"`
+	} `json:"occupancy"`
+}
+
+// ChannelLifecycleEvent is an event published on the
+// ChannelLifecycleMetachannel, e.g. when a channel becomes active in a
+// region or its occupancy changes.
+type ChannelLifecycleEvent struct {
+	Name      string        `json:"-"` // e.g. "channel.opened", "channel.closed", "channel.region.active"
+	Timestamp time.Time     `json:"-"` // when Ably published the event
+	ChannelID string        `json:"channelId"`
+	Region    string        `json:"region,omitempty"` // for the channel.region.* events
+	Status    ChannelStatus `json:"status"`
+}
+
+// ConnectionLifecycleEvent is an event published on the
+// ConnectionLifecycleMetachannel when a connection is opened or closed.
+type ConnectionLifecycleEvent struct {
+	Name         string    `json:"-"` // "connection.opened" or "connection.closed"
+	Timestamp    time.Time `json:"-"` // when Ably published the event
+	ConnectionID string    `json:"connectionId"`
+	ClientID     string    `json:"clientId,omitempty"`
+	Transport    string    `json:"transport,omitempty"`
+}
+
+// SubscribeChannelLifecycle calls handler with every event published on the
+// ChannelLifecycleMetachannel, which it attaches. handler is called in order
+// from a single goroutine, until the returned Subscription is closed.
+func (ch *Channels) SubscribeChannelLifecycle(handler func(*ChannelLifecycleEvent)) (*Subscription, error) {
+	return ch.subscribeMeta(ChannelLifecycleMetachannel, func(m *proto.Message) error {
+		event := ChannelLifecycleEvent{Name: m.Name, Timestamp: messageTime(m)}
+		if err := decodeJSONData(m.Data, &event); err != nil {
+			return err
+		}
+		handler(&event)
+		return nil
+	})
+}
+
+// SubscribeConnectionLifecycle calls handler with every event published on
+// the ConnectionLifecycleMetachannel, which it attaches. handler is called in
+// order from a single goroutine, until the returned Subscription is closed.
+func (ch *Channels) SubscribeConnectionLifecycle(handler func(*ConnectionLifecycleEvent)) (*Subscription, error) {
+	return ch.subscribeMeta(ConnectionLifecycleMetachannel, func(m *proto.Message) error {
+		event := ConnectionLifecycleEvent{Name: m.Name, Timestamp: messageTime(m)}
+		if err := decodeJSONData(m.Data, &event); err != nil {
+			return err
+		}
+		handler(&event)
+		return nil
+	})
+}
+
+// subscribeMeta subscribes to all the messages of the named metachannel,
+// handing them to handle; the messages it fails to decode are dropped.
+func (ch *Channels) subscribeMeta(name string, handle func(*proto.Message) error) (*Subscription, error) {
+	channel := ch.Get(name)
+	if _, err := channel.attach(false); err != nil {
+		return nil, err
+	}
+	return channel.messageSubs().subscribePooled(defaultMessagePool, func(m *proto.Message) {
+		if err := handle(m); err != nil {
+			channel.logger().Printf(LogWarning, "Realtime channel %q: dropping malformed %q event: %v", name, m.Name, err)
+		}
+	}, 1)
+}
+
+func messageTime(m *proto.Message) time.Time {
+	if m.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.Timestamp*int64(time.Millisecond))
+}
+
+// decodeJSONData decodes into v the data of a message published by Ably as
+// JSON, which is either already decoded or still encoded.
+func decodeJSONData(data interface{}, v interface{}) error {
+	var b []byte
+	switch data := data.(type) {
+	case string:
+		b = []byte(data)
+	case []byte:
+		b = data
+	default:
+		var err error
+		if b, err = json.Marshal(data); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(b, v)
+}
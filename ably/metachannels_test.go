@@ -0,0 +1,117 @@
+package ably_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestChannels_SubscribeLifecycle(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client := newPipeRealtimeClient(t, in, out)
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	attached := func(name string) {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != proto.ActionAttach || msg.Channel != name {
+				t.Fatalf("expected ATTACH for %q; got %v for %q", name, msg.Action, msg.Channel)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for ATTACH for %q", name)
+		}
+		in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: name}
+	}
+
+	channelEvents := make(chan *ably.ChannelLifecycleEvent, 4)
+	sub, err := client.Channels.SubscribeChannelLifecycle(func(e *ably.ChannelLifecycleEvent) {
+		channelEvents <- e
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	attached(ably.ChannelLifecycleMetachannel)
+
+	connEvents := make(chan *ably.ConnectionLifecycleEvent, 4)
+	sub, err = client.Channels.SubscribeConnectionLifecycle(func(e *ably.ConnectionLifecycleEvent) {
+		connEvents <- e
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	attached(ably.ConnectionLifecycleMetachannel)
+
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionMessage,
+		Channel: ably.ChannelLifecycleMetachannel,
+		Messages: []*proto.Message{
+			{Name: "channel.opened", Timestamp: 1500000000000, Data: map[string]interface{}{
+				"channelId": "news",
+				"status": map[string]interface{}{
+					"isActive": true,
+					"occupancy": map[string]interface{}{
+						"metrics": map[string]interface{}{"connections": float64(2), "subscribers": float64(1)},
+					},
+				},
+			}},
+			{Name: "channel.region.inactive", Data: "malformed"},
+			{Name: "channel.region.inactive", Data: `{"channelId":"news","region":"us-east-1-A","status":{"isActive":false}}`},
+		},
+	}
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionMessage,
+		Channel: ably.ConnectionLifecycleMetachannel,
+		Messages: []*proto.Message{
+			{Name: "connection.opened", Data: `{"connectionId":"other","clientId":"alice","transport":"web_socket"}`},
+		},
+	}
+
+	opened := &ably.ChannelLifecycleEvent{
+		Name:      "channel.opened",
+		Timestamp: time.Unix(1500000000, 0),
+		ChannelID: "news",
+	}
+	opened.Status.IsActive = true
+	opened.Status.Occupancy.Metrics = ably.Occupancy{Connections: 2, Subscribers: 1}
+	for _, expected := range []*ably.ChannelLifecycleEvent{
+		opened,
+		{Name: "channel.region.inactive", ChannelID: "news", Region: "us-east-1-A"},
+	} {
+		select {
+		case got := <-channelEvents:
+			if !got.Timestamp.Equal(expected.Timestamp) {
+				t.Errorf("expected timestamp %v; got %v", expected.Timestamp, got.Timestamp)
+			}
+			got.Timestamp = expected.Timestamp
+			assertDeepEquals(t, expected, got)
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for a channel lifecycle event")
+		}
+	}
+	select {
+	case got := <-connEvents:
+		assertDeepEquals(t, &ably.ConnectionLifecycleEvent{
+			Name:         "connection.opened",
+			ConnectionID: "other",
+			ClientID:     "alice",
+			Transport:    "web_socket",
+		}, got)
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for a connection lifecycle event")
+	}
+}
@@ -0,0 +1,97 @@
+package ably
+
+import (
+	"encoding/json"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// Metric identifies a value reported to a MetricsSink.
+type Metric string
+
+const (
+	// MetricMessagesSent counts messages published over REST and realtime.
+	MetricMessagesSent Metric = "messages_sent"
+	// MetricMessagesReceived counts messages received on realtime channels.
+	MetricMessagesReceived Metric = "messages_received"
+	// MetricMessagesDeduplicated counts messages dropped by realtime
+	// channels as duplicates, see ClientOptions.Dedupe.
+	MetricMessagesDeduplicated Metric = "messages_deduplicated"
+	// MetricBytesSent counts bytes of REST request bodies and of the payloads
+	// of messages published over realtime.
+	MetricBytesSent Metric = "bytes_sent"
+	// MetricBytesReceived counts bytes of REST response bodies and of the
+	// payloads of messages received over realtime.
+	MetricBytesReceived Metric = "bytes_received"
+	// MetricReconnects counts attempts to resume a realtime connection.
+	MetricReconnects Metric = "reconnects"
+	// MetricFallbacks counts REST requests retried against a fallback host.
+	MetricFallbacks Metric = "fallbacks"
+	// MetricQueueDepth is a gauge of the protocol messages either queued
+	// while the connection or channel isn't ready, or waiting for an ACK.
+	MetricQueueDepth Metric = "queue_depth"
+	// MetricACKLatency observes the seconds between sending a protocol
+	// message and receiving its ACK.
+	MetricACKLatency Metric = "ack_latency_seconds"
+)
+
+// MetricsSink is implemented by metrics libraries to receive measurements of
+// the client's internals. Its methods may be called concurrently and must not
+// block.
+//
+// The github.com/ably/ably-go/ablyprom module provides an implementation
+// for Prometheus.
+type MetricsSink interface {
+	// Add adds delta to the counter m.
+	Add(m Metric, delta float64)
+
+	// Set sets the gauge m to value.
+	Set(m Metric, value float64)
+
+	// Observe records value in the distribution m.
+	Observe(m Metric, value float64)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Add(Metric, float64)     {}
+func (nopMetrics) Set(Metric, float64)     {}
+func (nopMetrics) Observe(Metric, float64) {}
+
+func (opts *ClientOptions) metrics() MetricsSink {
+	if opts.MetricsSink == nil {
+		return nopMetrics{}
+	}
+	return opts.MetricsSink
+}
+
+// countMessages reports the number of messages and their payload bytes to
+// the given counters.
+func countMessages(sink MetricsSink, messages []*proto.Message, count, bytes Metric) {
+	if _, ok := sink.(nopMetrics); ok || len(messages) == 0 {
+		return
+	}
+	size := 0
+	for _, m := range messages {
+		size += payloadSize(m.Data)
+	}
+	sink.Add(count, float64(len(messages)))
+	sink.Add(bytes, float64(size))
+}
+
+func payloadSize(data interface{}) int {
+	switch data := data.(type) {
+	case nil:
+		return 0
+	case string:
+		return len(data)
+	case []byte:
+		return len(data)
+	default:
+		p, err := json.Marshal(data)
+		if err != nil {
+			return 0
+		}
+		return len(p)
+	}
+}
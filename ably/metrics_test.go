@@ -0,0 +1,157 @@
+package ably_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// metricsRecorder is an ably.MetricsSink which records reported values.
+type metricsRecorder struct {
+	mtx          sync.Mutex
+	counters     map[ably.Metric]float64
+	gauges       map[ably.Metric]float64
+	observations map[ably.Metric][]float64
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{
+		counters:     make(map[ably.Metric]float64),
+		gauges:       make(map[ably.Metric]float64),
+		observations: make(map[ably.Metric][]float64),
+	}
+}
+
+func (r *metricsRecorder) Add(m ably.Metric, delta float64) {
+	r.mtx.Lock()
+	r.counters[m] += delta
+	r.mtx.Unlock()
+}
+
+func (r *metricsRecorder) Set(m ably.Metric, value float64) {
+	r.mtx.Lock()
+	r.gauges[m] = value
+	r.mtx.Unlock()
+}
+
+func (r *metricsRecorder) Observe(m ably.Metric, value float64) {
+	r.mtx.Lock()
+	r.observations[m] = append(r.observations[m], value)
+	r.mtx.Unlock()
+}
+
+func (r *metricsRecorder) counter(m ably.Metric) float64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.counters[m]
+}
+
+func (r *metricsRecorder) gauge(m ably.Metric) float64 {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return r.gauges[m]
+}
+
+func (r *metricsRecorder) observed(m ably.Metric) int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return len(r.observations[m])
+}
+
+func TestMetricsSink(t *testing.T) {
+	t.Parallel()
+
+	t.Run("rest", func(t *testing.T) {
+		t.Parallel()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+		}))
+		defer server.Close()
+		metrics := newMetricsRecorder()
+		client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+			o.MetricsSink = metrics
+		})
+		if err := client.Channels.Get("test", nil).Publish("event", "data"); err != nil {
+			t.Fatal(err)
+		}
+		if n := metrics.counter(ably.MetricMessagesSent); n != 1 {
+			t.Errorf("want %s=1; got %v", ably.MetricMessagesSent, n)
+		}
+		if n := metrics.counter(ably.MetricBytesSent); n == 0 {
+			t.Errorf("want %s>0", ably.MetricBytesSent)
+		}
+	})
+
+	t.Run("realtime", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 16)
+		out := make(chan *proto.ProtocolMessage, 16)
+		metrics := newMetricsRecorder()
+		client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.MetricsSink = metrics
+		})
+		in <- connectedMessage("id")
+		if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+			t.Fatal(err)
+		}
+		channel := client.Channels.Get("test")
+		sub, err := channel.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+		in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+
+		res, err := channel.Publish("event", "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for msg := range out {
+			if msg.Action == proto.ActionMessage {
+				if n := metrics.gauge(ably.MetricQueueDepth); n != 1 {
+					t.Errorf("want %s=1; got %v", ably.MetricQueueDepth, n)
+				}
+				in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+				break
+			}
+		}
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		if n := metrics.gauge(ably.MetricQueueDepth); n != 0 {
+			t.Errorf("want %s=0; got %v", ably.MetricQueueDepth, n)
+		}
+		if n := metrics.observed(ably.MetricACKLatency); n != 1 {
+			t.Errorf("want 1 %s observation; got %d", ably.MetricACKLatency, n)
+		}
+		if n := metrics.counter(ably.MetricMessagesSent); n != 1 {
+			t.Errorf("want %s=1; got %v", ably.MetricMessagesSent, n)
+		}
+		if n := metrics.counter(ably.MetricBytesSent); n != 4 {
+			t.Errorf("want %s=4; got %v", ably.MetricBytesSent, n)
+		}
+
+		in <- &proto.ProtocolMessage{
+			Action:   proto.ActionMessage,
+			Channel:  "test",
+			Messages: []*proto.Message{{Name: "event", Data: "hello"}},
+		}
+		select {
+		case <-sub.MessageChannel():
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for message")
+		}
+		if n := metrics.counter(ably.MetricMessagesReceived); n != 1 {
+			t.Errorf("want %s=1; got %v", ably.MetricMessagesReceived, n)
+		}
+		if n := metrics.counter(ably.MetricBytesReceived); n != 5 {
+			t.Errorf("want %s=5; got %v", ably.MetricBytesReceived, n)
+		}
+	})
+}
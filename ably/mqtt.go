@@ -0,0 +1,92 @@
+package ably
+
+import (
+	"sync"
+	"time"
+)
+
+// Endpoint of Ably's MQTT protocol adapter.
+const (
+	MQTTHost    = "mqtt.ably.io"
+	MQTTPort    = 1883
+	MQTTTLSPort = 8883
+)
+
+// MQTTOptions are the settings to connect an MQTT client to Ably's MQTT
+// protocol adapter, as given by RestClient.MQTTOptions.
+type MQTTOptions struct {
+	Host string
+	Port int
+	TLS  bool
+
+	// Username and Password authenticate the connection: they're the key's
+	// name and secret with basic auth, or the token and an empty password
+	// with token auth.
+	Username string
+	Password string
+	// Expires is when the token in Username expires; the adapter then closes
+	// the connection. It's zero with basic auth.
+	Expires time.Time
+
+	mtx    sync.Mutex
+	client *RestClient
+}
+
+// MQTTOptions gives the settings to connect to Ably's MQTT protocol adapter
+// with the client's credentials, in the client's environment. If the client
+// uses token auth, a token is obtained first. Without TLS, which the client
+// allows only with token auth, the adapter is connected to on MQTTPort.
+func (c *RestClient) MQTTOptions() (*MQTTOptions, error) {
+	o := &MQTTOptions{
+		Host:   MQTTHost,
+		Port:   MQTTTLSPort,
+		TLS:    !c.opts.NoTLS,
+		client: c,
+	}
+	if !c.opts.isProductionEnvironment() {
+		o.Host = c.opts.Environment + "-" + MQTTHost
+	}
+	if c.opts.NoTLS {
+		o.Port = MQTTPort
+	}
+	if err := o.authorize(); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Credentials gives the username and password for a new connection,
+// renewing the token if it expired. It suits MQTT clients asking for the
+// credentials on every connection attempt, e.g. with paho.mqtt.golang's
+// ClientOptions.SetCredentialsProvider, which can't fail: if the token can't
+// be renewed, the expired credentials are given and the adapter refuses
+// them.
+func (o *MQTTOptions) Credentials() (username, password string) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	if !o.Expires.IsZero() && !o.client.Auth.currentTime().Before(o.Expires) {
+		if err := o.authorize(); err != nil {
+			o.client.logger().Printf(LogWarning, "MQTT: failed renewing token: %v", err)
+		}
+	}
+	return o.Username, o.Password
+}
+
+// authorize sets the credentials, getting a token if the client uses token
+// auth. It must be called with o.mtx held, if o is shared.
+func (o *MQTTOptions) authorize() error {
+	auth := o.client.Auth
+	if auth.method == authBasic {
+		o.Username, o.Password = o.client.opts.KeyName(), o.client.opts.KeySecret()
+		return nil
+	}
+	tok, err := auth.Authorize(nil, nil)
+	if err != nil {
+		return err
+	}
+	o.Username, o.Password = tok.Token, ""
+	if tok.Expires != 0 {
+		o.Expires = time.Unix(0, tok.Expires*int64(time.Millisecond))
+	}
+	return nil
+}
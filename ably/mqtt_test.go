@@ -0,0 +1,86 @@
+package ably_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+)
+
+func TestRestClient_MQTTOptions(t *testing.T) {
+	t.Parallel()
+
+	t.Run("key", func(t *testing.T) {
+		t.Parallel()
+
+		client, err := ably.NewRestClient(&ably.ClientOptions{
+			AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+			Environment: "sandbox",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := client.MQTTOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Host != "sandbox-mqtt.ably.io" || o.Port != ably.MQTTTLSPort || !o.TLS {
+			t.Errorf("unexpected endpoint %s:%d, TLS %t", o.Host, o.Port, o.TLS)
+		}
+		if user, pass := o.Credentials(); user != "xxxxxxx.yyyyyyy" || pass != "zzzzzzz" || !o.Expires.IsZero() {
+			t.Errorf("unexpected credentials %q, %q expiring at %v", user, pass, o.Expires)
+		}
+	})
+
+	t.Run("token", func(t *testing.T) {
+		t.Parallel()
+
+		var mtx sync.Mutex
+		now := time.Now()
+		tokens := 0
+		client, err := ably.NewRestClient(&ably.ClientOptions{
+			AuthOptions: ably.AuthOptions{
+				AuthCallback: func(*ably.TokenParams) (interface{}, error) {
+					mtx.Lock()
+					defer mtx.Unlock()
+					tokens++
+					return &ably.TokenDetails{
+						Token:   "token" + strconv.Itoa(tokens),
+						Expires: now.Add(time.Hour).UnixNano() / int64(time.Millisecond),
+					}, nil
+				},
+			},
+			NoTLS: true,
+			Now: func() time.Time {
+				mtx.Lock()
+				defer mtx.Unlock()
+				return now
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		o, err := client.MQTTOptions()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if o.Host != ably.MQTTHost || o.Port != ably.MQTTPort || o.TLS {
+			t.Errorf("unexpected endpoint %s:%d, TLS %t", o.Host, o.Port, o.TLS)
+		}
+		if user, pass := o.Credentials(); user != "token1" || pass != "" {
+			t.Errorf("unexpected credentials %q, %q", user, pass)
+		}
+
+		mtx.Lock()
+		now = now.Add(2 * time.Hour)
+		mtx.Unlock()
+		if user, _ := o.Credentials(); user != "token2" {
+			t.Errorf("expected the token to be renewed; got %q", user)
+		}
+		if !o.Expires.After(now) {
+			t.Errorf("expected the renewed token to expire after %v; got %v", now, o.Expires)
+		}
+	})
+}
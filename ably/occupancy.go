@@ -0,0 +1,50 @@
+package ably
+
+import "github.com/ably/ably-go/ably/proto"
+
+// OccupancyEventName is the name of the messages carrying a channel's
+// occupancy metrics, published whenever they change to channels attached
+// with the {"occupancy": "metrics"} params; see proto.ChannelOptions.Params.
+const OccupancyEventName = "[meta]occupancy"
+
+// Occupancy gives how many connections and clients use a channel, and how.
+type Occupancy struct {
+	Connections         int `json:"connections"`
+	Publishers          int `json:"publishers"`
+	Subscribers         int `json:"subscribers"`
+	PresenceConnections int `json:"presenceConnections"`
+	PresenceMembers     int `json:"presenceMembers"`
+	PresenceSubscribers int `json:"presenceSubscribers"`
+}
+
+// OnOccupancy calls handler with the channel's occupancy metrics every time
+// Ably publishes them, which it does once the channel is attached with the
+// {"occupancy": "metrics"} params. If the channel is not attached,
+// OnOccupancy implicitly attaches it.
+//
+// handler is called in order from a single goroutine, until the returned
+// Subscription is closed.
+func (c *RealtimeChannel) OnOccupancy(handler func(Occupancy)) (*Subscription, error) {
+	if _, err := c.attach(false); err != nil {
+		return nil, err
+	}
+	return c.messageSubs().subscribePooled(defaultMessagePool, func(m *proto.Message) {
+		occupancy, err := decodeOccupancy(m.Data)
+		if err != nil {
+			c.logger().Printf(LogWarning, "Realtime channel %q: dropping malformed occupancy event: %v", c.Name, err)
+			return
+		}
+		handler(occupancy)
+	}, 1, namesToKeys([]string{OccupancyEventName})...)
+}
+
+// decodeOccupancy decodes the metrics in the data of an occupancy event.
+func decodeOccupancy(data interface{}) (Occupancy, error) {
+	var event struct {
+		Metrics Occupancy `json:"metrics"`
+	}
+	if err := decodeJSONData(data, &event); err != nil {
+		return Occupancy{}, err
+	}
+	return event.Metrics, nil
+}
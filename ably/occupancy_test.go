@@ -0,0 +1,67 @@
+package ably_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestRealtimeChannel_OnOccupancy(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client, channel := newAttachedPipeClient(t, in, out)
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+
+	occupancies := make(chan ably.Occupancy, 4)
+	sub, err := channel.OnOccupancy(func(o ably.Occupancy) {
+		occupancies <- o
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionMessage,
+		Channel: "test",
+		Messages: []*proto.Message{
+			{Name: "greeting", Data: "hello"},
+			{Name: ably.OccupancyEventName, Data: map[string]interface{}{
+				"metrics": map[string]interface{}{
+					"connections":         float64(3),
+					"publishers":          float64(2),
+					"subscribers":         float64(3),
+					"presenceConnections": float64(1),
+					"presenceMembers":     float64(1),
+					"presenceSubscribers": float64(3),
+				},
+			}},
+			{Name: ably.OccupancyEventName, Data: "malformed"},
+			{Name: ably.OccupancyEventName, Data: `{"metrics":{"connections":1,"subscribers":1}}`},
+		},
+	}
+	for _, expected := range []ably.Occupancy{
+		{Connections: 3, Publishers: 2, Subscribers: 3, PresenceConnections: 1, PresenceMembers: 1, PresenceSubscribers: 3},
+		{Connections: 1, Subscribers: 1},
+	} {
+		select {
+		case got := <-occupancies:
+			assertDeepEquals(t, expected, got)
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for occupancy")
+		}
+	}
+	select {
+	case got := <-occupancies:
+		t.Fatalf("unexpected occupancy %+v", got)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
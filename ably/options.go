@@ -1,12 +1,15 @@
 package ably
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -31,10 +34,14 @@ var defaultOptions = &ClientOptions{
 	FallbackHosts:            defaultFallbackHosts(),
 	HTTPMaxRetryCount:        3,
 	HTTPRequestTimeout:       10 * time.Second,
+	HTTPMaxIdleConnsPerHost:  64,
+	HTTPIdleConnTimeout:      90 * time.Second,
 	RealtimeHost:             RealtimeHost,
 	TimeoutDisconnect:        30 * time.Second,
 	RealtimeRequestTimeout:   10 * time.Second, // DF1b
 	DisconnectedRetryTimeout: 15 * time.Second, // TO3l1
+	SuspendedRetryTimeout:    30 * time.Second, // TO3l2
+	ChannelRetryTimeout:      15 * time.Second, // TO3l7
 	TimeoutSuspended:         2 * time.Minute,
 	FallbackRetryTimeout:     10 * time.Minute,
 	IdempotentRestPublishing: false,
@@ -138,7 +145,26 @@ type AuthOptions struct {
 	// be used to sign the TokenRequest instead of using local time.
 	UseQueryTime bool
 
-	// Spec: TO3j11
+	// ClockSkewTolerance is how long past its expiry time, as told by the local
+	// clock, a token is still considered valid before it gets renewed. It
+	// prevents renewal loops on hosts whose clock runs ahead of Ably's.
+	//
+	// When UseQueryTime is set, the offset to the server time cached while
+	// signing token requests is used to correct the local clock as well.
+	ClockSkewTolerance time.Duration
+
+	// ServerTimeSyncInterval, when positive, is how long the offset to the
+	// server time cached for UseQueryTime is used before the server time is
+	// queried again, accounting for a local clock drifting away from it.
+	// Otherwise it's queried once. See Auth.ServerTimeOffset.
+	ServerTimeSyncInterval time.Duration
+
+	// DefaultTokenParams are used for the TTL, capability and client ID of
+	// token requests whose TokenParams leave them unset, including the ones
+	// made to renew tokens. The ClientID of ClientOptions takes precedence
+	// over the one of DefaultTokenParams.
+	//
+	// Spec: TO3j11, RSA10j
 	DefaultTokenParams *TokenParams
 
 	// UseTokenAuth makes the Rest and Realtime clients always use token
@@ -184,6 +210,21 @@ func (opts *AuthOptions) KeySecret() string {
 	return ""
 }
 
+// InFlightPolicy tells what happens to publishes while
+// ClientOptions.MaxInFlightMessages messages are awaiting an ACK.
+type InFlightPolicy int
+
+const (
+	// InFlightQueue queues the published messages, to be sent once ACKs or
+	// NACKs make room for them.
+	InFlightQueue InFlightPolicy = iota
+
+	// InFlightBlock makes publishing block until ACKs or NACKs make room for
+	// the published messages, the connection isn't connected anymore or the
+	// publish's context, e.g. PublishContext's, is done.
+	InFlightBlock
+)
+
 type ClientOptions struct {
 	AuthOptions
 
@@ -192,9 +233,19 @@ type ClientOptions struct {
 	// Deprecated: The library will automatically use default fallback hosts when a custom REST host or custom fallback hosts aren't provided.
 	FallbackHostsUseDefault bool
 
-	FallbackHosts   []string
-	RealtimeHost    string        // optional; overwrite endpoint hostname for Realtime client
-	Environment     string        // optional; prefixes both hostname with the environment string
+	FallbackHosts []string
+	RealtimeHost  string // optional; overwrite endpoint hostname for Realtime client
+
+	// Environment selects a non-production or dedicated cluster by name, e.g.
+	// "sandbox" or "acme". It prefixes the default hostnames, giving e.g.
+	// "acme-rest.ably.io" and "acme-realtime.ably.io", and the fallback
+	// hosts are generated from it, e.g. "acme-a-fallback.ably-realtime.com".
+	//
+	// A cluster reached through custom hostnames is set with both RestHost
+	// and RealtimeHost along with Environment, which then only selects the
+	// fallback hosts, unless FallbackHosts is set too.
+	Environment string
+
 	Port            int           // optional: port to use for non-TLS connections and requests
 	TLSPort         int           // optional: port to use for TLS connections and requests
 	ClientID        string        // optional; required for managing realtime presence of the current client
@@ -209,28 +260,98 @@ type ClientOptions struct {
 	// Will only be used if no custom HTTPClient is set.
 	HTTPRequestTimeout time.Duration
 
+	// HTTPMaxIdleConnsPerHost is the maximum number of idle connections kept
+	// open to each REST host for reuse by later requests; the default is 64.
+	// Clients publishing concurrently at high rates should keep it above
+	// their number of concurrent requests, so that connections aren't closed
+	// and reopened, exhausting ephemeral ports.
+	//
+	// Will only be used if no custom HTTPClient is set.
+	HTTPMaxIdleConnsPerHost int
+
+	// HTTPIdleConnTimeout is how long idle connections are kept open for
+	// reuse; the default is 90 seconds.
+	//
+	// Will only be used if no custom HTTPClient is set.
+	HTTPIdleConnTimeout time.Duration
+
+	// HTTPDisableHTTP2 when true stops REST requests from using HTTP/2,
+	// which is otherwise negotiated with hosts supporting it, multiplexing
+	// concurrent requests over a single connection.
+	//
+	// Will only be used if no custom HTTPClient is set.
+	HTTPDisableHTTP2 bool
+
 	// The period in milliseconds before HTTP requests are retried against the
 	// default endpoint
 	//
 	// spec TO3l10
 	FallbackRetryTimeout time.Duration
 
+	// FallbackStrategy orders the fallback hosts failed REST requests are
+	// retried against, for networks where some fallback clusters are
+	// consistently faster. It defaults to ShuffleFallbacks.
+	FallbackStrategy FallbackStrategy
+
 	NoTLS            bool // when true REST and realtime client won't use TLS
 	NoConnect        bool // when true realtime client will not attempt to connect automatically
 	NoEcho           bool // when true published messages will not be echoed back
 	NoQueueing       bool // when true drops messages published during regaining connection
 	NoBinaryProtocol bool // when true uses JSON for network serialization protocol instead of MsgPack
 
+	// NoAutoReconnect when true leaves the realtime connection disconnected
+	// once broken, with no automatic reconnection attempt, for applications
+	// which reconnect with Connection.Connect as their own policy dictates.
+	NoAutoReconnect bool
+
+	// MaxInFlightMessages, when non-zero, bounds the number of protocol
+	// messages sent over the realtime connection and awaiting an ACK, e.g.
+	// publishes and presence updates. Further ones are queued, to be sent in
+	// order as ACKs or NACKs come, or make publishing block until then, as
+	// set by InFlightPolicy. This bounds the memory used for tracking them
+	// and keeps a fast publisher from overwhelming the connection.
+	MaxInFlightMessages int
+
+	// InFlightPolicy is what happens to publishes while MaxInFlightMessages
+	// messages are awaiting an ACK.
+	InFlightPolicy InFlightPolicy
+
+	// PublishRateLimit, if set, limits how many messages the client publishes
+	// on all channels together, over REST or realtime. Channels can be
+	// further limited with their SetPublishRateLimit.
+	PublishRateLimit *RateLimit
+
 	// When true idempotent rest publishing will be enabled.
 	// Spec TO3n
 	IdempotentRestPublishing bool
 
+	// IDGenerator, if set, generates the base of idempotent message IDs and
+	// REST request IDs instead of the default random ones.
+	IDGenerator IDGenerator
+
+	// Now, if set, is used instead of time.Now for the current time, e.g. for
+	// checking whether tokens have expired. It's ignored if Clock is set.
+	Now func() time.Time
+
+	// Clock, if set, is used instead of the system clock both for the timers
+	// retrying connections and channels, sending heartbeats and limiting
+	// publish rates, and for the current time, overriding Now, so that they
+	// all move together. The ablymock package provides a controllable Clock,
+	// for testing e.g. reconnection without waiting.
+	Clock Clock
+
+	// ReadOnly makes the client refuse to publish messages and to enter,
+	// update or leave presence, failing locally with an error wrapping
+	// ErrReadOnly instead. It is meant for consumer-only deployments using
+	// subscribe-only credentials.
+	ReadOnly bool
+
 	// TimeoutConnect is the time period after which connect request is failed.
 	//
 	// Deprecated: use RealtimeRequestTimeout instead.
 	TimeoutConnect    time.Duration
 	TimeoutDisconnect time.Duration // time period after which disconnect request is failed
-	TimeoutSuspended  time.Duration // time period after which no more reconnection attempts are performed
+	TimeoutSuspended  time.Duration // time period of being disconnected after which the connection is suspended
 
 	// RealtimeRequestTimeout is the timeout for realtime connection establishment
 	// and each subsequent operation.
@@ -240,24 +361,186 @@ type ClientOptions struct {
 	// attempting an automatic reconnection, if still disconnected.
 	DisconnectedRetryTimeout time.Duration
 
+	// SuspendedRetryTimeout is the time to wait between automatic
+	// reconnection attempts once the connection is suspended.
+	SuspendedRetryTimeout time.Duration
+
+	// ChannelRetryTimeout is the time to wait before attempting to reattach
+	// a channel which went into the suspended state after the server detached
+	// it and the immediate reattach failed.
+	ChannelRetryTimeout time.Duration
+
+	// PublishBatchWindow, when positive, makes realtime channels coalesce
+	// the messages of Publish and PublishAll calls made within the window
+	// into a single protocol message, reducing framing and acknowledgement
+	// overhead for high rate publishers at the cost of added latency. The
+	// result of every coalesced call is resolved when the protocol message
+	// is acknowledged, or with the error it's rejected with.
+	PublishBatchWindow time.Duration
+
+	// Dedupe, when set, makes realtime channels drop the messages whose ID
+	// they already delivered within the given window, such as those
+	// redelivered around a connection resume or a rewind, for subscribers
+	// that must process every message once.
+	Dedupe DedupeOptions
+
 	// Dial specifies the dial function for creating message connections used
 	// by RealtimeClient.
 	//
 	// If Dial is nil, the default websocket connection is used.
 	Dial func(protocol string, u *url.URL) (proto.Conn, error)
 
+	// WebsocketHandshakeTimeout is the timeout for opening the websocket
+	// connection, including the TCP, TLS and websocket handshakes; the
+	// default is RealtimeRequestTimeout.
+	//
+	// Will only be used if no custom Dial is set.
+	WebsocketHandshakeTimeout time.Duration
+
+	// WebsocketReadTimeout is the time after which a connection from which
+	// nothing was received is considered broken, and dropped. By default,
+	// it's derived from the maxIdleInterval advertised by Ably once
+	// connected, plus RealtimeRequestTimeout (RTN23a).
+	WebsocketReadTimeout time.Duration
+
+	// RetryOnResumeFailure when true makes a connection which Ably refuses to
+	// resume after it was broken, e.g. because it expired, connect anew
+	// instead of failing. As when Ably accepts the connection but not its
+	// resume, the connection's state changes to StateConnConnected with the
+	// resume error, see IsResumeError, and attached channels are reattached.
+	RetryOnResumeFailure bool
+
+	// HeartbeatInterval, when non-zero, makes the client send a heartbeat to
+	// Ably whenever nothing was sent or received over the connection for
+	// that long, keeping idle connections alive through proxies and load
+	// balancers rather than relying on Ably's heartbeats alone. See
+	// Conn.LastHeartbeat.
+	HeartbeatInterval time.Duration
+
+	// WebsocketWriteTimeout is the timeout for writing each message to the
+	// websocket connection; the default is RealtimeRequestTimeout.
+	//
+	// Will only be used if no custom Dial is set.
+	WebsocketWriteTimeout time.Duration
+
 	// Listener if set, will be automatically registered with On method for every
 	// realtime connection and realtime channel created by realtime client.
 	// The listener will receive events for all state transitions.
 	Listener chan<- State
 
+	// OnConnectionStateChange, OnChannelStateChange and OnTransportEvent, if
+	// set, are called synchronously for every connection state transition,
+	// channel state transition and transport event respectively, before any
+	// listener is notified. Unlike listeners they can't miss events, e.g. ones
+	// happening before a listener is registered.
+	//
+	// The hooks are called with internal locks held; they must return quickly
+	// and must not call methods of the client, its connection or channels.
+	OnConnectionStateChange func(State)
+	OnChannelStateChange    func(State)
+	OnTransportEvent        func(TransportEvent)
+
+	// HandlerConcurrency is the number of goroutines calling the handler of
+	// each subscription created with RealtimeChannel.SubscribePooled.
+	//
+	// By default, or if it's 1, the handler is called from a single goroutine
+	// per subscription, in the order messages were received. If it's greater,
+	// up to HandlerConcurrency messages are handled at once and their order
+	// isn't preserved, which suits CPU-heavy handlers.
+	//
+	// In both cases a slow handler only delays its own subscription: messages
+	// are queued for each subscription, and delivered to the Go channels of
+	// the ones created with Subscribe, by a goroutine per subscription, in
+	// order. State listeners registered with On aren't waited for; state
+	// changes are dropped for listeners which aren't ready to receive them.
+	HandlerConcurrency int
+
+	// ProtocolRecorder, when non-nil, receives a recording of every realtime
+	// transport dialed and every protocol message sent and received over it,
+	// as ProtocolRecord values encoded as JSON, one per line. The recording
+	// can be replayed with ablytest.ProtocolReplayer to reproduce connection
+	// issues.
+	//
+	// Credentials in dialed URLs are redacted, but message payloads and
+	// connection keys are recorded as is.
+	ProtocolRecorder io.Writer
+
 	// HTTPClient specifies the client used for HTTP communication by RestClient.
 	//
 	// If HTTPClient is nil, a client configured with default settings is used.
+	//
+	// Its transport's TLS config shouldn't set a ServerName, so that requests
+	// retried against fallback hosts verify their certificates against the
+	// fallback host names.
 	HTTPClient *http.Client
 
+	// HTTPMiddleware wraps, in order, the transport of the HTTP client used
+	// for REST and AuthURL requests: the first one is the outermost, seeing
+	// requests first. Requests reach them with their auth headers set, and
+	// once per attempt, so that retries against fallback hosts go through
+	// them too; they suit signing, logging or fault injection. Neither
+	// HTTPClient nor its transport are modified.
+	HTTPMiddleware []func(http.RoundTripper) http.RoundTripper
+
 	//When provided this will be used on every request.
 	Trace *httptrace.ClientTrace
+
+	// Agents are additional product identifiers appended to the agent string
+	// sent to Ably, keyed by product name with an optional version value.
+	// Libraries and frameworks built on top of ably-go should add their own
+	// entry, e.g. {"my-framework": "1.2.3"}.
+	//
+	// Spec RSC7d6
+	Agents map[string]string
+
+	// AddRequestIDs when true adds a unique request_id query parameter to
+	// every REST request, which is kept the same when the request is retried
+	// against fallback hosts. The ID is logged and set on returned errors so
+	// failed requests can be matched with Ably's server-side logs.
+	//
+	// Spec RSC7c
+	AddRequestIDs bool
+
+	// DisableCompression when true stops REST requests from asking for
+	// gzip or deflate compressed responses, e.g. to read responses on the
+	// wire when debugging. By default, compressed responses are accepted and
+	// transparently decompressed, which cuts transfer sizes of large history
+	// and stats pages.
+	DisableCompression bool
+
+	// TracerProvider, when non-nil, receives tracing spans for REST and
+	// token requests, connection attempts, channel attach and detach, and
+	// publishes until their ACK.
+	TracerProvider TracerProvider
+
+	// MessageEncodings lists the names of codecs registered with
+	// proto.RegisterCodec applied, in order, to the data of every message
+	// published by the client, e.g. to compress payloads. Encodings set in
+	// a REST channel's ChannelOptions take precedence. Received messages are
+	// decoded with the registered codecs whether or not they're listed.
+	MessageEncodings []string
+
+	// PublishInterceptors are called in order with every message published
+	// by the client, over REST or realtime, before its data is encoded; they
+	// may modify it, e.g. to validate it against a schema or to log it. If
+	// one returns an error, publishing fails with it, wrapped in an *Error
+	// with code ErrBadRequest, and the following ones aren't called.
+	PublishInterceptors []func(*proto.Message) error
+
+	// ReceiveInterceptors are called in order with every message received on
+	// a realtime channel, once its data is decoded and before it's delivered
+	// to subscribers; they may modify it. If one returns an error, the
+	// message is dropped, and the error logged.
+	ReceiveInterceptors []func(*proto.Message) error
+
+	// MetricsSink, when non-nil, receives counters, gauges and observations
+	// measuring messages, bytes, reconnects, queue depth, ACK latency and
+	// fallback host usage.
+	MetricsSink MetricsSink
+
+	// Push configures the registration of the device the client runs on
+	// for push notifications, with Push.Activate.
+	Push PushOptions
 }
 
 func NewClientOptions(key string) *ClientOptions {
@@ -268,14 +551,163 @@ func NewClientOptions(key string) *ClientOptions {
 	}
 }
 
+// OptionsError is the underlying error of the *Error returned when creating
+// a client with invalid ClientOptions. It lists every problem found, each
+// with its own error code; the code of the returned *Error is the one of
+// the first problem.
+type OptionsError struct {
+	Errors []*Error
+}
+
+func (e *OptionsError) Error() string {
+	msgs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs[i] = err.Err.Error()
+	}
+	return "invalid client options: " + strings.Join(msgs, "; ")
+}
+
+// validate checks the options for invalid values and conflicting
+// combinations, so that they're reported when creating a client rather than
+// when a request is made.
 func (opts *ClientOptions) validate() error {
-	_, err := opts.getFallbackHosts()
-	if err != nil {
+	var errs []*Error
+	invalid := func(format string, v ...interface{}) {
+		errs = append(errs, newErrorf(ErrInvalidParameterValue, format, v...))
+	}
+	if _, err := opts.getFallbackHosts(); err != nil {
 		log := opts.Logger.Sugar()
 		log.Errorf("Error getting fallbackHosts : %v", err.Error())
-		return err
+		invalid("%v", err)
 	}
-	return nil
+
+	// Authentication; see detectAuthMethod.
+	switch {
+	case opts.Key != "" && (opts.KeyName() == "" || opts.KeySecret() == ""):
+		errs = append(errs, newErrorf(ErrInvalidCredential, "%v: want <name>:<secret>", errInvalidKey))
+	case opts.UseTokenAuth || opts.externalTokenAuthSupported():
+	case opts.Key == "":
+		errs = append(errs, newErrorf(ErrInvalidCredential, "%v: set Key, Token, TokenDetails, AuthURL or AuthCallback", errMissingKey))
+	case opts.NoTLS:
+		errs = append(errs, newError(ErrInvalidUseOfBasicAuthOverNonTLSTransport, errInsecureBasicAuth))
+	}
+	if opts.ClientID == wildcardClientID {
+		errs = append(errs, newError(ErrIncompatibleCredentials, errWildcardClientID)) // RSA7c
+	}
+	if opts.AuthURL != "" {
+		if u, err := url.Parse(opts.AuthURL); err != nil {
+			invalid("invalid AuthURL: %v", err)
+		} else if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			invalid("AuthURL must be an absolute http or https URL; got %q", opts.AuthURL)
+		}
+	}
+	if m := opts.AuthMethod; m != "" && m != "GET" && m != "POST" {
+		invalid("AuthMethod must be GET or POST; got %q", m)
+	}
+
+	// Endpoints.
+	if env := opts.Environment; env != "" && !envPattern.MatchString(env) {
+		invalid("Environment must be a cluster name made of letters, digits and hyphens, such as \"sandbox\"; got %q; set custom hostnames with RestHost and RealtimeHost", env)
+	}
+	if !opts.isProductionEnvironment() && (opts.RestHost == "") != (opts.RealtimeHost == "") {
+		invalid("RestHost and RealtimeHost must be set together along with Environment %q", opts.Environment)
+	}
+	for _, h := range []struct {
+		name, host string
+	}{
+		{"RestHost", opts.RestHost},
+		{"RealtimeHost", opts.RealtimeHost},
+	} {
+		if h.host != "" && !isBareHost(h.host) {
+			invalid("%s must be a hostname, without scheme, port or path; got %q", h.name, h.host)
+		}
+	}
+	for _, p := range []struct {
+		name string
+		port int
+	}{
+		{"Port", opts.Port},
+		{"TLSPort", opts.TLSPort},
+	} {
+		if p.port < 0 || p.port > 65535 {
+			invalid("%s must be within [0, 65535]; got %d", p.name, p.port)
+		}
+	}
+
+	// Timeouts and limits, for which zero means the default.
+	for _, d := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"HTTPRequestTimeout", opts.HTTPRequestTimeout},
+		{"HTTPIdleConnTimeout", opts.HTTPIdleConnTimeout},
+		{"FallbackRetryTimeout", opts.FallbackRetryTimeout},
+		{"TimeoutConnect", opts.TimeoutConnect},
+		{"WebsocketHandshakeTimeout", opts.WebsocketHandshakeTimeout},
+		{"WebsocketReadTimeout", opts.WebsocketReadTimeout},
+		{"WebsocketWriteTimeout", opts.WebsocketWriteTimeout},
+		{"HeartbeatInterval", opts.HeartbeatInterval},
+		{"TimeoutDisconnect", opts.TimeoutDisconnect},
+		{"TimeoutSuspended", opts.TimeoutSuspended},
+		{"RealtimeRequestTimeout", opts.RealtimeRequestTimeout},
+		{"DisconnectedRetryTimeout", opts.DisconnectedRetryTimeout},
+		{"SuspendedRetryTimeout", opts.SuspendedRetryTimeout},
+		{"ChannelRetryTimeout", opts.ChannelRetryTimeout},
+		{"PublishBatchWindow", opts.PublishBatchWindow},
+		{"ClockSkewTolerance", opts.ClockSkewTolerance},
+		{"ServerTimeSyncInterval", opts.ServerTimeSyncInterval},
+	} {
+		if d.d < 0 {
+			invalid("%s must not be negative; got %v", d.name, d.d)
+		}
+	}
+	if opts.HTTPMaxRetryCount < 0 {
+		invalid("HTTPMaxRetryCount must not be negative; got %d", opts.HTTPMaxRetryCount)
+	}
+	if opts.HTTPMaxIdleConnsPerHost < 0 {
+		invalid("HTTPMaxIdleConnsPerHost must not be negative; got %d", opts.HTTPMaxIdleConnsPerHost)
+	}
+	if opts.Dedupe.MaxMessages < 0 {
+		invalid("Dedupe.MaxMessages must not be negative; got %d", opts.Dedupe.MaxMessages)
+	}
+	if opts.Dedupe.MaxAge < 0 {
+		invalid("Dedupe.MaxAge must not be negative; got %v", opts.Dedupe.MaxAge)
+	}
+	if opts.HandlerConcurrency < 0 {
+		invalid("HandlerConcurrency must not be negative; got %d", opts.HandlerConcurrency)
+	}
+	if opts.MaxInFlightMessages < 0 {
+		invalid("MaxInFlightMessages must not be negative; got %d", opts.MaxInFlightMessages)
+	}
+	if opts.InFlightPolicy != InFlightQueue && opts.InFlightPolicy != InFlightBlock {
+		invalid("InFlightPolicy must be InFlightQueue or InFlightBlock; got %d", opts.InFlightPolicy)
+	}
+	if l := opts.PublishRateLimit; l != nil && (l.Rate <= 0 || l.Burst < 0) {
+		invalid("PublishRateLimit must have a positive Rate and a non-negative Burst; got %v and %d", l.Rate, l.Burst)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return &Error{
+		Code:       errs[0].Code,
+		StatusCode: errs[0].StatusCode,
+		Err:        &OptionsError{Errors: errs},
+	}
+}
+
+// envPattern matches the names of environments, which are prepended to
+// hostnames.
+var envPattern = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9-]*[A-Za-z0-9])?$`)
+
+// isBareHost reports whether host is a hostname or an IP address, without a
+// scheme, port or path.
+func isBareHost(host string) bool {
+	if strings.ContainsAny(host, "/?#@ ") {
+		return false
+	}
+	_, _, err := net.SplitHostPort(host)
+	return err != nil
 }
 
 func (opts *ClientOptions) isProductionEnvironment() bool {
@@ -332,6 +764,13 @@ func (opts *ClientOptions) fallbackRetryTimeout() time.Duration {
 	return defaultOptions.FallbackRetryTimeout
 }
 
+func (opts *ClientOptions) fallbackStrategy() FallbackStrategy {
+	if opts.FallbackStrategy != nil {
+		return opts.FallbackStrategy
+	}
+	return ShuffleFallbacks()
+}
+
 func (opts *ClientOptions) realtimeRequestTimeout() time.Duration {
 	if opts.RealtimeRequestTimeout != 0 {
 		return opts.RealtimeRequestTimeout
@@ -339,6 +778,27 @@ func (opts *ClientOptions) realtimeRequestTimeout() time.Duration {
 	return defaultOptions.RealtimeRequestTimeout
 }
 
+func (opts *ClientOptions) websocketTimeouts() ablyutil.WebsocketTimeouts {
+	timeouts := ablyutil.WebsocketTimeouts{
+		Handshake: opts.WebsocketHandshakeTimeout,
+		Write:     opts.WebsocketWriteTimeout,
+	}
+	if timeouts.Handshake == 0 {
+		timeouts.Handshake = opts.realtimeRequestTimeout()
+	}
+	if timeouts.Write == 0 {
+		timeouts.Write = opts.realtimeRequestTimeout()
+	}
+	return timeouts
+}
+
+func (opts *ClientOptions) channelRetryTimeout() time.Duration {
+	if opts.ChannelRetryTimeout != 0 {
+		return opts.ChannelRetryTimeout
+	}
+	return defaultOptions.ChannelRetryTimeout
+}
+
 func (opts *ClientOptions) disconnectedRetryTimeout() time.Duration {
 	if opts.DisconnectedRetryTimeout != 0 {
 		return opts.DisconnectedRetryTimeout
@@ -346,6 +806,13 @@ func (opts *ClientOptions) disconnectedRetryTimeout() time.Duration {
 	return defaultOptions.DisconnectedRetryTimeout
 }
 
+func (opts *ClientOptions) suspendedRetryTimeout() time.Duration {
+	if opts.SuspendedRetryTimeout != 0 {
+		return opts.SuspendedRetryTimeout
+	}
+	return defaultOptions.SuspendedRetryTimeout
+}
+
 func (opts *ClientOptions) getRestHost() string {
 	if !empty(opts.RestHost) {
 		return opts.RestHost
@@ -411,11 +878,14 @@ func (opts *ClientOptions) getFallbackHosts() ([]string, error) {
 		logger.Warn("Deprecated fallbackHostsUseDefault : using default fallbackhosts")
 		return defaultOptions.FallbackHosts, nil
 	}
-	if opts.FallbackHosts == nil && empty(opts.RestHost) && empty(opts.RealtimeHost) && isDefaultPort {
-		if opts.isProductionEnvironment() {
+	if opts.FallbackHosts == nil && isDefaultPort {
+		if !opts.isProductionEnvironment() {
+			// Also with custom hosts for the environment's cluster.
+			return getEnvFallbackHosts(opts.Environment), nil
+		}
+		if empty(opts.RestHost) && empty(opts.RealtimeHost) {
 			return defaultOptions.FallbackHosts, nil
 		}
-		return getEnvFallbackHosts(opts.Environment), nil
 	}
 	return opts.FallbackHosts, nil
 }
@@ -424,8 +894,66 @@ func (opts *ClientOptions) httpclient() *http.Client {
 	if opts.HTTPClient != nil {
 		return opts.HTTPClient
 	}
+	return opts.newHTTPClient()
+}
+
+// withMiddleware gives a copy of client with its transport wrapped by
+// HTTPMiddleware.
+func (opts *ClientOptions) withMiddleware(client *http.Client) *http.Client {
+	if len(opts.HTTPMiddleware) == 0 {
+		return client
+	}
+	c := *client
+	rt := c.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	for i := len(opts.HTTPMiddleware) - 1; i >= 0; i-- {
+		rt = opts.HTTPMiddleware[i](rt)
+	}
+	c.Transport = rt
+	return &c
+}
+
+// newHTTPClient gives the HTTP client used when no custom HTTPClient is set.
+// Its transport is made to be shared by all the requests of a client, so
+// that connections, and TLS sessions for new ones, are reused.
+func (opts *ClientOptions) newHTTPClient() *http.Client {
+	timeout := opts.HTTPRequestTimeout
+	if timeout == 0 {
+		timeout = defaultOptions.HTTPRequestTimeout
+	}
+	maxIdle := opts.HTTPMaxIdleConnsPerHost
+	if maxIdle == 0 {
+		maxIdle = defaultOptions.HTTPMaxIdleConnsPerHost
+	}
+	idleTimeout := opts.HTTPIdleConnTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultOptions.HTTPIdleConnTimeout
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		MaxIdleConnsPerHost:   maxIdle,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig: &tls.Config{
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		},
+		// Setting TLSClientConfig otherwise disables HTTP/2.
+		ForceAttemptHTTP2: !opts.HTTPDisableHTTP2,
+	}
+	if opts.HTTPDisableHTTP2 {
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
 	return &http.Client{
-		Timeout: defaultOptions.HTTPRequestTimeout,
+		Timeout:   timeout,
+		Transport: transport,
 	}
 }
 
@@ -440,6 +968,34 @@ func (opts *ClientOptions) idempotentRestPublishing() bool {
 	return opts.IdempotentRestPublishing
 }
 
+// ErrReadOnly is the underlying error of the *Error returned by operations
+// refused by a client with ClientOptions.ReadOnly set.
+var ErrReadOnly = errors.New("operation not permitted by a read-only client")
+
+func (opts *ClientOptions) checkWritable() error {
+	if opts.ReadOnly {
+		return newError(ErrOperationNotPermittedWithProvidedCapability, ErrReadOnly)
+	}
+	return nil
+}
+
+func (opts *ClientOptions) now() time.Time {
+	switch {
+	case opts.Clock != nil:
+		return opts.Clock.Now()
+	case opts.Now != nil:
+		return opts.Now()
+	}
+	return time.Now()
+}
+
+func (opts *ClientOptions) getClock() Clock {
+	if opts.Clock != nil {
+		return opts.Clock
+	}
+	return ablyutil.SystemClock
+}
+
 // Time returns the given time as a timestamp in milliseconds since epoch.
 func Time(t time.Time) int64 {
 	return t.UnixNano() / int64(time.Millisecond)
@@ -0,0 +1,95 @@
+package ably
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Environment variables read by NewClientOptionsFromEnv.
+const (
+	EnvKey         = "ABLY_KEY"         // AuthOptions.Key
+	EnvEnvironment = "ABLY_ENVIRONMENT" // ClientOptions.Environment
+	EnvClientID    = "ABLY_CLIENT_ID"   // ClientOptions.ClientID
+	EnvTLS         = "ABLY_TLS"         // negation of ClientOptions.NoTLS
+	EnvProxy       = "ABLY_PROXY"       // proxy URL for REST requests
+	EnvLogLevel    = "ABLY_LOG_LEVEL"   // ClientOptions.Logger.Level
+)
+
+var logLevelNames = map[string]LogLevel{
+	"none":    LogNone,
+	"error":   LogError,
+	"warning": LogWarning,
+	"warn":    LogWarning,
+	"info":    LogInfo,
+	"verbose": LogVerbose,
+	"debug":   LogDebug,
+}
+
+// NewClientOptionsFromEnv gives client options configured from the
+// environment variables below, leaving the defaults for the ones which
+// aren't set:
+//
+//   - ABLY_KEY: the API key
+//   - ABLY_ENVIRONMENT: the environment, e.g. "sandbox"
+//   - ABLY_CLIENT_ID: the client ID
+//   - ABLY_TLS: whether to use TLS, as accepted by strconv.ParseBool
+//   - ABLY_PROXY: the URL of the proxy REST requests are sent through,
+//     instead of the one given by HTTPS_PROXY and HTTP_PROXY
+//   - ABLY_LOG_LEVEL: the log level, either a name ("none", "error",
+//     "warning", "info", "verbose", "debug") or its LogLevel value
+//
+// The options can be further modified before creating a client with them.
+// An error is returned if a variable has an invalid value.
+func NewClientOptionsFromEnv() (*ClientOptions, error) {
+	return clientOptionsFromEnv(os.LookupEnv)
+}
+
+func clientOptionsFromEnv(lookup func(string) (string, bool)) (*ClientOptions, error) {
+	opts := &ClientOptions{}
+	if v, ok := lookup(EnvKey); ok {
+		opts.Key = v
+	}
+	if v, ok := lookup(EnvEnvironment); ok {
+		opts.Environment = v
+	}
+	if v, ok := lookup(EnvClientID); ok {
+		opts.ClientID = v
+	}
+	if v, ok := lookup(EnvTLS); ok && v != "" {
+		tls, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, newErrorf(ErrInvalidParameterValue, "invalid %s value %q: %v", EnvTLS, v, err)
+		}
+		opts.NoTLS = !tls
+	}
+	if v, ok := lookup(EnvProxy); ok && v != "" {
+		u, err := url.Parse(v)
+		if err != nil {
+			return nil, newErrorf(ErrInvalidParameterValue, "invalid %s value %q: %v", EnvProxy, v, err)
+		}
+		client := opts.newHTTPClient()
+		client.Transport.(*http.Transport).Proxy = http.ProxyURL(u)
+		opts.HTTPClient = client
+	}
+	if v, ok := lookup(EnvLogLevel); ok && v != "" {
+		level, err := parseLogLevel(v)
+		if err != nil {
+			return nil, err
+		}
+		opts.Logger.Level = level
+	}
+	return opts, nil
+}
+
+func parseLogLevel(s string) (LogLevel, error) {
+	if level, ok := logLevelNames[strings.ToLower(s)]; ok {
+		return level, nil
+	}
+	if n, err := strconv.ParseUint(s, 10, 0); err == nil && LogLevel(n) <= LogDebug {
+		return LogLevel(n), nil
+	}
+	return 0, newErrorf(ErrInvalidParameterValue, "invalid %s value %q", EnvLogLevel, s)
+}
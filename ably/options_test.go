@@ -1,10 +1,18 @@
 package ably_test
 
 import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablymock"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
 )
 
 func TestDefaultFallbacks_RSC15h(t *testing.T) {
@@ -149,6 +157,21 @@ func TestFallbackHosts_RSC15b(t *testing.T) {
 		assertDeepEquals(ts, ably.DefaultFallbackHosts(), fallbackHosts)
 	})
 
+	t.Run("RSC15g2 with custom environment and custom hosts", func(ts *testing.T) {
+		clientOptions := ably.NewClientOptions("")
+		clientOptions.Environment = "acme"
+		clientOptions.RestHost = "rest.acme.example.com"
+		clientOptions.RealtimeHost = "realtime.acme.example.com"
+		assertEquals(ts, "realtime.acme.example.com", clientOptions.GetRealtimeHost())
+		assertEquals(ts, "rest.acme.example.com", clientOptions.GetRestHost())
+		fallbackHosts, _ := clientOptions.GetFallbackHosts()
+		assertDeepEquals(ts, ably.GetEnvFallbackHosts("acme"), fallbackHosts)
+
+		clientOptions.FallbackHosts = []string{"fallback.acme.example.com"}
+		fallbackHosts, _ = clientOptions.GetFallbackHosts()
+		assertDeepEquals(ts, []string{"fallback.acme.example.com"}, fallbackHosts)
+	})
+
 	t.Run("RSC15g1 with fallbackHosts", func(ts *testing.T) {
 		clientOptions := ably.NewClientOptions("")
 		clientOptions.FallbackHosts = []string{"a.example.com", "b.example.com"}
@@ -333,3 +356,356 @@ func TestPaginateParams(t *testing.T) {
 		}
 	})
 }
+
+func TestClientOptions_Now(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 3, 5, 10, 30, 0, 0, time.UTC)
+	clock := ablymock.NewClock(now.Add(time.Hour))
+	opts := &ably.ClientOptions{Now: func() time.Time { return now }}
+	if got := opts.GetNow(); !got.Equal(now) {
+		t.Errorf("expected Now to give %v; got %v", now, got)
+	}
+	opts.Clock = clock
+	clock.Advance(time.Minute)
+	if got, expected := opts.GetNow(), now.Add(time.Hour+time.Minute); !got.Equal(expected) {
+		t.Errorf("expected Clock to override Now with %v; got %v", expected, got)
+	}
+}
+
+func TestClientOptions_ReadOnly(t *testing.T) {
+	t.Parallel()
+
+	checkReadOnly := func(t *testing.T, err error) {
+		t.Helper()
+		if !errors.Is(err, ably.ErrReadOnly) {
+			t.Errorf("want ErrReadOnly; got %v", err)
+		}
+		if err := checkError(ably.ErrOperationNotPermittedWithProvidedCapability, err); err != nil {
+			t.Error(err)
+		}
+	}
+
+	t.Run("rest", func(t *testing.T) {
+		t.Parallel()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL)
+		}))
+		defer server.Close()
+		client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+			o.ReadOnly = true
+		})
+		checkReadOnly(t, client.Channels.Get("test", nil).Publish("event", "data"))
+	})
+
+	t.Run("realtime", func(t *testing.T) {
+		t.Parallel()
+		server := ablymock.NewServer(nil)
+		client, err := ably.NewRealtimeClient(&ably.ClientOptions{
+			AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+			ClientID:    "client",
+			Dial:        server.Dial,
+			ReadOnly:    true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+		channel := client.Channels.Get("test")
+		if err := ablytest.Wait(channel.Attach()); err != nil {
+			t.Fatal(err)
+		}
+		_, err = channel.Publish("event", "data")
+		checkReadOnly(t, err)
+		_, err = channel.Presence.Enter("data")
+		checkReadOnly(t, err)
+		for _, msg := range server.Conns()[0].Sent() {
+			if msg.Action == proto.ActionMessage || msg.Action == proto.ActionPresence {
+				t.Errorf("unexpected message sent: %v", msg)
+			}
+		}
+	})
+}
+
+// reverseCodec is a proto.Codec reversing string data.
+type reverseCodec struct{}
+
+func (reverseCodec) Encode(encoding string, data interface{}) (interface{}, error) {
+	return reverse(data.(string)), nil
+}
+
+func (reverseCodec) Decode(encoding string, data interface{}) (interface{}, error) {
+	return reverse(data.(string)), nil
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+func TestClientOptions_MessageEncodings(t *testing.T) {
+	t.Parallel()
+
+	proto.RegisterCodec("test-reverse", reverseCodec{})
+	var published []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			var messages []map[string]interface{}
+			if err := json.NewDecoder(r.Body).Decode(&messages); err != nil {
+				t.Error(err)
+			}
+			published, _ = json.Marshal(messages)
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(published)
+	}))
+	defer server.Close()
+
+	client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+		o.MessageEncodings = []string{"test-reverse"}
+	})
+	channel := client.Channels.Get("test", nil)
+	if err := channel.Publish("event", "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if want := `[{"data":"cba","encoding":"test-reverse","name":"event"}]`; string(published) != want {
+		t.Errorf("want published %s; got %s", want, published)
+	}
+	page, err := channel.History(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msgs := page.Messages(); len(msgs) != 1 || msgs[0].Data != "abc" {
+		t.Errorf("want history decoded to abc; got %+v", msgs)
+	}
+}
+
+func TestNewClientOptionsFromEnv(t *testing.T) {
+	t.Parallel()
+
+	opts, err := ably.ClientOptionsFromEnv(map[string]string{
+		ably.EnvKey:         "xxxxxxx.yyyyyyy:zzzzzzz",
+		ably.EnvEnvironment: "sandbox",
+		ably.EnvClientID:    "client",
+		ably.EnvTLS:         "false",
+		ably.EnvProxy:       "http://proxy.example:3128",
+		ably.EnvLogLevel:    "Debug",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Key != "xxxxxxx.yyyyyyy:zzzzzzz" || opts.Environment != "sandbox" || opts.ClientID != "client" {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+	if !opts.NoTLS {
+		t.Error("expected NoTLS to be set")
+	}
+	if opts.Logger.Level != ably.LogDebug {
+		t.Errorf("want log level %d; got %d", ably.LogDebug, opts.Logger.Level)
+	}
+	req, _ := http.NewRequest("GET", "https://rest.ably.io/time", nil)
+	proxy, err := opts.HTTPClient.Transport.(*http.Transport).Proxy(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proxy.String() != "http://proxy.example:3128" {
+		t.Errorf("want proxy http://proxy.example:3128; got %v", proxy)
+	}
+
+	opts, err = ably.ClientOptionsFromEnv(map[string]string{ably.EnvLogLevel: "3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Logger.Level != ably.LogInfo || opts.NoTLS || opts.HTTPClient != nil {
+		t.Errorf("want only the log level set; got %+v", opts)
+	}
+
+	for _, env := range []map[string]string{
+		{ably.EnvTLS: "maybe"},
+		{ably.EnvLogLevel: "loud"},
+		{ably.EnvLogLevel: "9"},
+		{ably.EnvProxy: "http://[::1"},
+	} {
+		if _, err := ably.ClientOptionsFromEnv(env); ably.ErrorCode(err) != ably.ErrInvalidParameterValue {
+			t.Errorf("%v: want error code %d; got %v", env, ably.ErrInvalidParameterValue, err)
+		}
+	}
+}
+
+func TestClientOptions_Validate(t *testing.T) {
+	t.Parallel()
+
+	_, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{
+			Key:        "xxxxxxx.yyyyyyy:zzzzzzz",
+			AuthURL:    "/token",
+			AuthMethod: "PUT",
+		},
+		ClientID:           "*",
+		Environment:        "sandbox",
+		RestHost:           "localhost",
+		TLSPort:            70000,
+		HTTPRequestTimeout: -time.Second,
+		HTTPMaxRetryCount:  -1,
+	})
+	if err := checkError(ably.ErrIncompatibleCredentials, err); err != nil {
+		t.Fatal(err)
+	}
+	var optsErr *ably.OptionsError
+	if !errors.As(err, &optsErr) {
+		t.Fatalf("want *ably.OptionsError; got %#v", err)
+	}
+	var codes []int
+	for _, err := range optsErr.Errors {
+		codes = append(codes, err.Code)
+	}
+	assertDeepEquals(t, []int{
+		ably.ErrIncompatibleCredentials, // ClientID
+		ably.ErrInvalidParameterValue,   // AuthURL
+		ably.ErrInvalidParameterValue,   // AuthMethod
+		ably.ErrInvalidParameterValue,   // Environment with RestHost only
+		ably.ErrInvalidParameterValue,   // TLSPort
+		ably.ErrInvalidParameterValue,   // HTTPRequestTimeout
+		ably.ErrInvalidParameterValue,   // HTTPMaxRetryCount
+	}, codes)
+
+	for _, c := range []struct {
+		name string
+		opts *ably.ClientOptions
+		code int
+	}{{
+		name: "malformed key",
+		opts: &ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy", UseTokenAuth: true}},
+		code: ably.ErrInvalidCredential,
+	}, {
+		name: "no credentials",
+		opts: &ably.ClientOptions{},
+		code: ably.ErrInvalidCredential,
+	}, {
+		name: "basic auth without TLS",
+		opts: &ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"}, NoTLS: true},
+		code: ably.ErrInvalidUseOfBasicAuthOverNonTLSTransport,
+	}, {
+		name: "hostname as environment",
+		opts: &ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"}, Environment: "acme.example.com"},
+		code: ably.ErrInvalidParameterValue,
+	}, {
+		name: "host with port",
+		opts: &ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"}, RestHost: "rest.example.com:8443"},
+		code: ably.ErrInvalidParameterValue,
+	}, {
+		name: "host with scheme",
+		opts: &ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"}, RealtimeHost: "wss://realtime.example.com"},
+		code: ably.ErrInvalidParameterValue,
+	}, {
+		name: "negative realtime timeout",
+		opts: &ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"}, RealtimeRequestTimeout: -1},
+		code: ably.ErrInvalidParameterValue,
+	}, {
+		name: "negative max in-flight messages",
+		opts: &ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"}, MaxInFlightMessages: -1},
+		code: ably.ErrInvalidParameterValue,
+	}, {
+		name: "zero publish rate",
+		opts: &ably.ClientOptions{AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"}, PublishRateLimit: &ably.RateLimit{}},
+		code: ably.ErrInvalidParameterValue,
+	}} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			_, err := ably.NewRealtimeClient(c.opts)
+			if err := checkError(c.code, err); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// A production environment doesn't conflict with custom hosts, and
+	// others are set along with both of them.
+	for _, opts := range []*ably.ClientOptions{{
+		Environment: "production",
+		RestHost:    "localhost",
+	}, {
+		Environment:  "acme",
+		RestHost:     "rest.acme.example.com",
+		RealtimeHost: "realtime.acme.example.com",
+	}} {
+		opts.Key = "xxxxxxx.yyyyyyy:zzzzzzz"
+		if _, err := ably.NewRestClient(opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestClientOptions_HTTPTransport(t *testing.T) {
+	t.Parallel()
+	newTransport := func(t *testing.T, opts *ably.ClientOptions) (*http.Client, *http.Transport) {
+		t.Helper()
+		opts.Key = "xxxxxxx.yyyyyyy:zzzzzzz"
+		client, err := ably.NewRestClient(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpClient := client.GetHTTPClient()
+		if httpClient != client.GetHTTPClient() {
+			t.Fatal("expected requests to share the same HTTP client")
+		}
+		transport, ok := httpClient.Transport.(*http.Transport)
+		if !ok {
+			t.Fatalf("expected *http.Transport; got %T", httpClient.Transport)
+		}
+		return httpClient, transport
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		t.Parallel()
+		client, transport := newTransport(t, &ably.ClientOptions{})
+		if client.Timeout != 10*time.Second {
+			t.Errorf("expected timeout 10s; got %v", client.Timeout)
+		}
+		if transport.MaxIdleConnsPerHost != 64 || transport.IdleConnTimeout != 90*time.Second {
+			t.Errorf("expected 64 idle conns per host kept for 90s; got %d for %v", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+		}
+		if !transport.ForceAttemptHTTP2 {
+			t.Error("expected HTTP/2 to be enabled")
+		}
+		if transport.TLSClientConfig == nil || transport.TLSClientConfig.ClientSessionCache == nil {
+			t.Error("expected a TLS session cache")
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		t.Parallel()
+		client, transport := newTransport(t, &ably.ClientOptions{
+			HTTPRequestTimeout:      time.Second,
+			HTTPMaxIdleConnsPerHost: 8,
+			HTTPIdleConnTimeout:     time.Minute,
+			HTTPDisableHTTP2:        true,
+		})
+		if client.Timeout != time.Second {
+			t.Errorf("expected timeout 1s; got %v", client.Timeout)
+		}
+		if transport.MaxIdleConnsPerHost != 8 || transport.IdleConnTimeout != time.Minute {
+			t.Errorf("expected 8 idle conns per host kept for 1m; got %d for %v", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+		}
+		if transport.ForceAttemptHTTP2 || transport.TLSNextProto == nil {
+			t.Error("expected HTTP/2 to be disabled")
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		t.Parallel()
+		_, err := ably.NewRestClient(&ably.ClientOptions{
+			AuthOptions:             ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+			HTTPMaxIdleConnsPerHost: -1,
+		})
+		if err := checkError(ably.ErrInvalidParameterValue, err); err != nil {
+			t.Error(err)
+		}
+	})
+}
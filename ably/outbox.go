@@ -0,0 +1,234 @@
+package ably
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+var errOutboxEventID = errors.New("outbox event is missing an ID")
+
+// OutboxEvent is a single event written to the application's outbox, usually
+// in the same database transaction as the business change it describes.
+type OutboxEvent struct {
+	// ID uniquely identifies the event. It is used as the published message ID,
+	// which makes Ably discard duplicates when the same event is published
+	// more than once, e.g. after a crash between publishing and MarkSent.
+	ID string
+
+	Channel string      // name of the channel the event is published on
+	Name    string      // event name of the published message
+	Data    interface{} // payload of the published message
+}
+
+// OutboxStore is implemented by the application to give the Outbox access to
+// the events stored in its outbox table.
+type OutboxStore interface {
+	// Pending gives at most limit events which were not yet marked as sent,
+	// in the order they are expected to be published.
+	Pending(limit int) ([]*OutboxEvent, error)
+
+	// MarkSent marks the given events as sent. It is called only after Ably
+	// acknowledged all of them.
+	MarkSent(events []*OutboxEvent) error
+}
+
+// OutboxFailedStore may be implemented by an OutboxStore to be told of the
+// events which can never be published, e.g. because they're missing an ID,
+// for them to be moved out of the pending events, e.g. to a dead letter
+// table. Otherwise, they're skipped every time they're read.
+type OutboxFailedStore interface {
+	// MarkFailed marks the given events as failed with err.
+	MarkFailed(events []*OutboxEvent, err error) error
+}
+
+// OutboxOptions configures an Outbox.
+type OutboxOptions struct {
+	// BatchSize is the maximum number of events read from the store at once;
+	// 100 by default.
+	BatchSize int
+
+	// PollInterval is the time Run waits before querying the store again once
+	// there are no pending events; 1s by default.
+	PollInterval time.Duration
+}
+
+func (opts *OutboxOptions) batchSize() int {
+	if opts != nil && opts.BatchSize > 0 {
+		return opts.BatchSize
+	}
+	return 100
+}
+
+func (opts *OutboxOptions) pollInterval() time.Duration {
+	if opts != nil && opts.PollInterval > 0 {
+		return opts.PollInterval
+	}
+	return time.Second
+}
+
+// Outbox relays events from an OutboxStore to Ably channels, implementing the
+// transactional outbox pattern.
+//
+// Events are published in the order given by the store and marked as sent only
+// once Ably acknowledged them, which gives at-least-once, ordered delivery;
+// the idempotent message IDs taken from OutboxEvent.ID make Ably drop any
+// duplicates caused by redelivery.
+type Outbox struct {
+	store   OutboxStore
+	opts    OutboxOptions
+	publish func(ctx context.Context, channel string, messages []*proto.Message) error
+	logger  *LoggerOptions
+}
+
+// OutboxMarkError is returned by Outbox.Flush when marking published events
+// as sent failed; they're then published again by the next Flush, and
+// deduplicated by Ably.
+type OutboxMarkError struct {
+	Err error // returned by OutboxStore.MarkSent
+
+	// PublishErr, if not nil, is why publishing the events following the
+	// published ones failed.
+	PublishErr error
+}
+
+func (e *OutboxMarkError) Error() string {
+	if e.PublishErr != nil {
+		return fmt.Sprintf("failed marking events as sent: %v (after failing publishing: %v)", e.Err, e.PublishErr)
+	}
+	return fmt.Sprintf("failed marking events as sent: %v", e.Err)
+}
+
+func (e *OutboxMarkError) Unwrap() error {
+	return e.Err
+}
+
+// NewRealtimeOutbox gives new Outbox which publishes events using the given
+// realtime client, waiting for the ACK of every published batch.
+func NewRealtimeOutbox(client RealtimeClientAPI, store OutboxStore, opts *OutboxOptions) *Outbox {
+	publish := func(ctx context.Context, channel string, messages []*proto.Message) error {
+		return client.Channel(channel).PublishMultiple(ctx, messages)
+	}
+	log := &LoggerOptions{}
+	if c, ok := client.(*RealtimeClient); ok {
+		log = c.logger()
+	}
+	return newOutbox(store, opts, publish, log)
+}
+
+// NewRestOutbox gives new Outbox which publishes events using the given
+// REST client.
+func NewRestOutbox(client RestClientAPI, store OutboxStore, opts *OutboxOptions) *Outbox {
+	publish := func(ctx context.Context, channel string, messages []*proto.Message) error {
+		return client.Channel(channel, nil).PublishMultiple(ctx, messages)
+	}
+	log := &LoggerOptions{}
+	if c, ok := client.(*RestClient); ok {
+		log = c.logger()
+	}
+	return newOutbox(store, opts, publish, log)
+}
+
+func newOutbox(store OutboxStore, opts *OutboxOptions, publish func(context.Context, string, []*proto.Message) error, log *LoggerOptions) *Outbox {
+	o := &Outbox{
+		store:   store,
+		publish: publish,
+		logger:  log,
+	}
+	if opts != nil {
+		o.opts = *opts
+	}
+	return o
+}
+
+// Run relays pending events until ctx is done, in which case it returns
+// ctx.Err(). A failure to read, publish or mark events is logged and retried
+// after PollInterval.
+func (o *Outbox) Run(ctx context.Context) error {
+	log := o.logger.Sugar()
+	for {
+		n, err := o.Flush(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Errorf("Outbox: failed relaying events: %v", err)
+		}
+		if n != 0 && err == nil {
+			// There may be more pending events, don't wait.
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(o.opts.pollInterval()):
+		}
+	}
+}
+
+// Flush relays a single batch of pending events and gives the number of
+// events that were published and marked as sent.
+//
+// Consecutive events for the same channel are published together in a single
+// request. If publishing fails, the events published so far are still marked
+// as sent and the remaining ones are left pending. Publishing stops once ctx
+// is done, e.g. while the realtime connection is disconnected.
+//
+// Events missing an ID are skipped, for the following ones to still be
+// published, and reported to the store if it implements OutboxFailedStore;
+// Flush then fails with an error with code ErrInvalidMessageID unless
+// publishing failed.
+func (o *Outbox) Flush(ctx context.Context) (int, error) {
+	pending, err := o.store.Pending(o.opts.batchSize())
+	if err != nil {
+		return 0, err
+	}
+	var events, invalid []*OutboxEvent
+	for _, e := range pending {
+		if e.ID == "" {
+			invalid = append(invalid, e)
+			continue
+		}
+		events = append(events, e)
+	}
+	sent := 0
+	for sent < len(events) {
+		run := events[sent:]
+		for i, e := range run {
+			if e.Channel != run[0].Channel {
+				run = run[:i]
+				break
+			}
+		}
+		messages := make([]*proto.Message, 0, len(run))
+		for _, e := range run {
+			messages = append(messages, &proto.Message{ID: e.ID, Name: e.Name, Data: e.Data})
+		}
+		if err = o.publish(ctx, run[0].Channel, messages); err != nil {
+			break
+		}
+		sent += len(run)
+	}
+	if sent != 0 {
+		if e := o.store.MarkSent(events[:sent]); e != nil {
+			return 0, &OutboxMarkError{Err: e, PublishErr: err}
+		}
+	}
+	if len(invalid) != 0 {
+		invalidErr := newError(ErrInvalidMessageID, errOutboxEventID)
+		if s, ok := o.store.(OutboxFailedStore); ok {
+			if e := s.MarkFailed(invalid, invalidErr); e != nil {
+				o.logger.Sugar().Errorf("Outbox: failed marking %d events as failed: %v", len(invalid), e)
+			}
+		}
+		if err == nil {
+			err = invalidErr
+		}
+	}
+	return sent, err
+}
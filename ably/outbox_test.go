@@ -0,0 +1,287 @@
+package ably_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// newTestRestClient gives a RestClient talking JSON to the given test server
+// using a static token.
+func newTestRestClient(t *testing.T, server *httptest.Server, opts ...func(*ably.ClientOptions)) *ably.RestClient {
+	t.Helper()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	o := &ably.ClientOptions{
+		AuthOptions:      ably.AuthOptions{Token: "token"},
+		RestHost:         u.Hostname(),
+		Port:             port,
+		NoTLS:            true,
+		NoBinaryProtocol: true,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	client, err := ably.NewRestClient(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+type memOutbox struct {
+	mtx    sync.Mutex
+	events []*ably.OutboxEvent
+	sent   []string
+	failed []*ably.OutboxEvent
+}
+
+func (s *memOutbox) Pending(limit int) ([]*ably.OutboxEvent, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if len(s.events) < limit {
+		limit = len(s.events)
+	}
+	return append([]*ably.OutboxEvent(nil), s.events[:limit]...), nil
+}
+
+func (s *memOutbox) MarkSent(events []*ably.OutboxEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, e := range events {
+		s.sent = append(s.sent, e.ID)
+	}
+	s.remove(events)
+	return nil
+}
+
+func (s *memOutbox) MarkFailed(events []*ably.OutboxEvent, err error) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.failed = append(s.failed, events...)
+	s.remove(events)
+	return nil
+}
+
+func (s *memOutbox) remove(events []*ably.OutboxEvent) {
+	removed := make(map[*ably.OutboxEvent]bool, len(events))
+	for _, e := range events {
+		removed[e] = true
+	}
+	var pending []*ably.OutboxEvent
+	for _, e := range s.events {
+		if !removed[e] {
+			pending = append(pending, e)
+		}
+	}
+	s.events = pending
+}
+
+func TestOutbox_Flush(t *testing.T) {
+	t.Parallel()
+
+	var mtx sync.Mutex
+	var published []string
+	fail := "" // channel name for which the server responds with an error
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		if r.URL.Path == "/channels/"+fail+"/messages" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":40000,"statusCode":400,"message":"bad"}}`))
+			return
+		}
+		var messages []*proto.Message
+		if err := json.NewDecoder(r.Body).Decode(&messages); err != nil {
+			t.Error(err)
+		}
+		for _, m := range messages {
+			published = append(published, r.URL.Path+"#"+m.ID)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	store := &memOutbox{events: []*ably.OutboxEvent{
+		{ID: "1", Channel: "a", Name: "e", Data: "1"},
+		{ID: "2", Channel: "a", Name: "e", Data: "2"},
+		{ID: "3", Channel: "b", Name: "e", Data: "3"},
+		{ID: "4", Channel: "c", Name: "e", Data: "4"},
+		{ID: "5", Channel: "a", Name: "e", Data: "5"},
+	}}
+	outbox := ably.NewRestOutbox(newTestRestClient(t, server), store, &ably.OutboxOptions{BatchSize: 10})
+
+	mtx.Lock()
+	fail = "c"
+	mtx.Unlock()
+
+	n, err := outbox.Flush(context.Background())
+	if err == nil {
+		t.Fatal("Flush(): want err != nil")
+	}
+	if n != 3 {
+		t.Fatalf("want n=3; got %d", n)
+	}
+	assertDeepEquals(t, []string{"1", "2", "3"}, store.sent)
+
+	mtx.Lock()
+	fail = ""
+	mtx.Unlock()
+
+	if n, err = outbox.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("want n=2; got %d", n)
+	}
+	assertDeepEquals(t, []string{"1", "2", "3", "4", "5"}, store.sent)
+	assertDeepEquals(t, []string{
+		"/channels/a/messages#1",
+		"/channels/a/messages#2",
+		"/channels/b/messages#3",
+		"/channels/c/messages#4",
+		"/channels/a/messages#5",
+	}, published)
+}
+
+func TestOutbox_MissingID(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	missing := &ably.OutboxEvent{Channel: "a", Name: "e"}
+	store := &memOutbox{events: []*ably.OutboxEvent{missing, {ID: "2", Channel: "a", Name: "e"}}}
+	outbox := ably.NewRestOutbox(newTestRestClient(t, server), store, nil)
+	n, err := outbox.Flush(context.Background())
+	var e *ably.Error
+	if !errors.As(err, &e) || e.Code != ably.ErrInvalidMessageID {
+		t.Fatalf("want error code %d; got %v", ably.ErrInvalidMessageID, err)
+	}
+	if n != 1 {
+		t.Fatalf("want the event following the invalid one published; got n=%d", n)
+	}
+	assertDeepEquals(t, []string{"2"}, store.sent)
+	assertDeepEquals(t, []*ably.OutboxEvent{missing}, store.failed)
+
+	if n, err := outbox.Flush(context.Background()); n != 0 || err != nil {
+		t.Fatalf("want nothing left to relay; got n=%d, err=%v", n, err)
+	}
+}
+
+// fakeRestClient is a mock ably.RestClientAPI recording published messages.
+type fakeRestClient struct {
+	ably.RestClientAPI
+	published map[string][]string
+}
+
+type fakeRestChannel struct {
+	ably.RestChannelAPI
+	client *fakeRestClient
+	name   string
+}
+
+func (c *fakeRestClient) Channel(name string, opts *proto.ChannelOptions) ably.RestChannelAPI {
+	return &fakeRestChannel{client: c, name: name}
+}
+
+func (c *fakeRestChannel) PublishMultiple(ctx context.Context, messages []*proto.Message) error {
+	for _, m := range messages {
+		c.client.published[c.name] = append(c.client.published[c.name], m.ID)
+	}
+	return nil
+}
+
+func TestOutbox_MockClient(t *testing.T) {
+	t.Parallel()
+
+	client := &fakeRestClient{published: make(map[string][]string)}
+	store := &memOutbox{events: []*ably.OutboxEvent{
+		{ID: "1", Channel: "a", Name: "e"},
+		{ID: "2", Channel: "b", Name: "e"},
+	}}
+	if _, err := ably.NewRestOutbox(client, store, nil).Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, map[string][]string{"a": {"1"}, "b": {"2"}}, client.published)
+}
+
+// failingMarkOutbox is a memOutbox failing to mark events as sent.
+type failingMarkOutbox struct {
+	memOutbox
+}
+
+func (s *failingMarkOutbox) MarkSent(events []*ably.OutboxEvent) error {
+	return errors.New("database is down")
+}
+
+func TestOutbox_MarkSentError(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/channels/b/messages" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":40000,"statusCode":400,"message":"bad"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	store := &failingMarkOutbox{memOutbox{events: []*ably.OutboxEvent{
+		{ID: "1", Channel: "a", Name: "e"},
+		{ID: "2", Channel: "b", Name: "e"},
+	}}}
+	n, err := ably.NewRestOutbox(newTestRestClient(t, server), store, nil).Flush(context.Background())
+	if n != 0 {
+		t.Errorf("want n=0; got %d", n)
+	}
+	var markErr *ably.OutboxMarkError
+	if !errors.As(err, &markErr) {
+		t.Fatalf("want *ably.OutboxMarkError; got %v", err)
+	}
+	if markErr.Err == nil || ably.ErrorCode(markErr.PublishErr) != 40000 {
+		t.Fatalf("want both the mark and the publish errors; got %v", err)
+	}
+}
+
+func TestOutbox_FlushContext(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client, _ := newAttachedPipeClient(t, in, out)
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+
+	// The published messages are never acknowledged.
+	store := &memOutbox{events: []*ably.OutboxEvent{{ID: "1", Channel: "test", Name: "e"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	n, err := ably.NewRealtimeOutbox(client, store, nil).Flush(ctx)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("want context.DeadlineExceeded; got %v", err)
+	}
+	if n != 0 || len(store.sent) != 0 {
+		t.Fatalf("want no events sent; got %d, %v", n, store.sent)
+	}
+}
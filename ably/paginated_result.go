@@ -1,9 +1,8 @@
 package ably
 
 import (
-	"errors"
-	"fmt"
-	"io"
+	"bytes"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"path"
@@ -56,6 +55,10 @@ type paginatedRequest struct {
 	logger    *LoggerOptions
 	respCheck func(*http.Response) error
 	decoder   func(*proto.ChannelOptions, reflect.Type, *http.Response) (interface{}, error)
+
+	// extra holds query parameters sent along params with the first page
+	// request; next page links already carry them.
+	extra url.Values
 }
 
 func decodePaginatedResult(opts *proto.ChannelOptions, typ reflect.Type, resp *http.Response) (interface{}, error) {
@@ -114,7 +117,7 @@ func newPaginatedResult(opts *proto.ChannelOptions, req paginatedRequest) (*Pagi
 		opts: opts,
 		req:  req,
 	}
-	builtPath, err := p.buildPaginatedPath(req.path, req.params)
+	builtPath, err := p.buildPaginatedPath(req.path, req.params, req.extra)
 	if err != nil {
 		return nil, err
 	}
@@ -138,13 +141,12 @@ func newPaginatedResult(opts *proto.ChannelOptions, req paginatedRequest) (*Pagi
 			return nil, err
 		}
 		p.errorCode = i
-	} else if !p.success {
-		return nil, malformedPaginatedResponseError(resp)
 	}
-	if h := p.respHeaders.Get(AblyErrormessageHeader); h != "" {
-		p.errorMessage = h
-	} else if !p.success {
-		return nil, malformedPaginatedResponseError(resp)
+	p.errorMessage = p.respHeaders.Get(AblyErrormessageHeader)
+	if !p.success && (p.errorCode == 0 || p.errorMessage == "") {
+		if err := p.setErrorFromBody(resp); err != nil {
+			return nil, err
+		}
 	}
 	p.path = builtPath
 	p.links = resp.Header["Link"]
@@ -156,15 +158,34 @@ func newPaginatedResult(opts *proto.ChannelOptions, req paginatedRequest) (*Pagi
 	return p, nil
 }
 
-func malformedPaginatedResponseError(resp *http.Response) error {
-	body := make([]byte, 200)
-	n, err := io.ReadFull(resp.Body, body)
-	body = body[:n]
-	msg := fmt.Sprintf("invalid PaginatedResult HTTP response; status: %d; body (first %d bytes): %q", resp.StatusCode, len(body), body)
-	if err != nil && !errors.Is(err, io.EOF) {
-		return fmt.Errorf("%s; body read error: %w", msg, err)
+// setErrorFromBody sets the error code and message missing from the
+// headers of an unsuccessful response, e.g. one from a proxy rather than
+// from Ably, with the error found in its body. The body is left for the
+// response's items to be decoded from.
+func (p *PaginatedResult) setErrorFromBody(resp *http.Response) error {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	errResp := *resp
+	errResp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	e := errFromResponse(&errResp)
+	if _, ok := e.Err.(genericError); ok {
+		// The body doesn't describe an Ably error.
+		e.Code = resp.StatusCode * 100
+	}
+	if p.errorCode == 0 {
+		p.errorCode = e.Code
+	}
+	if p.errorMessage == "" {
+		if e.Err != nil {
+			p.errorMessage = e.Err.Error()
+		} else {
+			p.errorMessage = http.StatusText(resp.StatusCode)
+		}
 	}
-	return errors.New(msg)
+	return nil
 }
 
 func copyHeader(dest, src http.Header) {
@@ -187,6 +208,7 @@ func (p *PaginatedResult) Next() (*PaginatedResult, error) {
 	req := p.req
 	req.path = nextPage
 	req.params = nil
+	req.extra = nil
 	return newPaginatedResult(p.opts, req)
 }
 
@@ -236,14 +258,29 @@ func (p *PaginatedResult) Stats() []*proto.Stats {
 	return items
 }
 
-func (c *PaginatedResult) buildPaginatedPath(path string, params *PaginateParams) (string, error) {
-	if params == nil {
+// PushChannelSubscriptions gives a slice of push channel subscriptions for
+// the current page. The method panics if the underlying paginated result is
+// not push channel subscriptions.
+func (p *PaginatedResult) PushChannelSubscriptions() []*PushChannelSubscription {
+	items, ok := p.typItems.([]*PushChannelSubscription)
+	if !ok {
+		panic(errInvalidType{typ: p.req.typ})
+	}
+	return items
+}
+
+func (c *PaginatedResult) buildPaginatedPath(path string, params *PaginateParams, extra url.Values) (string, error) {
+	if params == nil && extra == nil {
 		return path, nil
 	}
 	values := &url.Values{}
-	err := params.EncodeValues(values)
-	if err != nil {
-		return "", newError(50000, err)
+	if params != nil {
+		if err := params.EncodeValues(values); err != nil {
+			return "", newError(50000, err)
+		}
+	}
+	for k, v := range extra {
+		(*values)[k] = v
 	}
 	queryString := values.Encode()
 	if len(queryString) > 0 {
@@ -2,11 +2,9 @@ package ably_test
 
 import (
 	"encoding/json"
-	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
 
 	"github.com/ably/ably-go/ably"
@@ -43,17 +41,18 @@ func TestMalformedPaginatedResult(t *testing.T) {
 	}
 
 	resp, err := client.Request("POST", "/foo", nil, nil, nil)
-	if resp != nil {
-		t.Errorf("expected no HTTPPaginatedResult; got %+v", resp)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if err == nil {
-		t.Fatal("expected an error")
+	if resp.Success || resp.StatusCode != 400 {
+		t.Errorf("expected unsuccessful response with status 400; got success %v, status %d", resp.Success, resp.StatusCode)
 	}
-	errMsg := err.Error()
-	if !strings.Contains(errMsg, "status: 400") {
-		t.Errorf("expected error to contain status code; got: %v", err)
+	if err := checkError(ably.ErrBadRequest, resp.Err()); err != nil {
+		t.Error(err)
 	}
-	if !strings.Contains(errMsg, fmt.Sprintf("%q", bodyBytes)) {
-		t.Errorf("expected error to contain body; got: %v", err)
+	var body []string
+	json.Unmarshal(bodyBytes, &body)
+	if items := resp.Items(); len(items) != 1 || items[0] != body[0] {
+		t.Errorf("expected the body to be surfaced as items; got %q", items)
 	}
 }
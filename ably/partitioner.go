@@ -0,0 +1,96 @@
+package ably
+
+import (
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// Partitioner dispatches received messages to a fixed set of worker
+// goroutines, so that messages with the same ordering key are always handled
+// by the same worker in the order they were received, while messages with
+// different keys are handled concurrently.
+//
+// The ordering key of a message is set by the publisher with
+// proto.Message.SetOrderingKey. Messages without an ordering key are
+// distributed among the workers in a round-robin fashion.
+type Partitioner struct {
+	workers []chan *proto.Message
+	handler func(*proto.Message)
+	wg      sync.WaitGroup
+	next    uint32 // round-robin counter for messages without ordering key
+	mtx     sync.RWMutex
+	closed  bool
+}
+
+// NewPartitioner gives new Partitioner which calls handler on the given
+// number of worker goroutines. The number of workers is at least 1.
+func NewPartitioner(workers int, handler func(*proto.Message)) *Partitioner {
+	if workers < 1 {
+		workers = 1
+	}
+	p := &Partitioner{
+		workers: make([]chan *proto.Message, workers),
+		handler: handler,
+	}
+	for i := range p.workers {
+		ch := make(chan *proto.Message, 16)
+		p.workers[i] = ch
+		p.wg.Add(1)
+		go p.work(ch)
+	}
+	return p
+}
+
+func (p *Partitioner) work(ch <-chan *proto.Message) {
+	defer p.wg.Done()
+	for msg := range ch {
+		p.handler(msg)
+	}
+}
+
+// Dispatch passes msg to the worker responsible for its ordering key. It
+// blocks while the worker is busy and its queue is full.
+//
+// Dispatch panics if called after Close.
+func (p *Partitioner) Dispatch(msg *proto.Message) {
+	var n uint32
+	if key := msg.OrderingKey(); key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		n = h.Sum32()
+	} else {
+		n = atomic.AddUint32(&p.next, 1)
+	}
+	p.mtx.RLock()
+	defer p.mtx.RUnlock()
+	if p.closed {
+		panic("ably: Dispatch called on closed Partitioner")
+	}
+	p.workers[n%uint32(len(p.workers))] <- msg
+}
+
+// Consume dispatches all messages received by sub until it is closed.
+func (p *Partitioner) Consume(sub *Subscription) {
+	for msg := range sub.MessageChannel() {
+		p.Dispatch(msg)
+	}
+}
+
+// Close stops the workers once they handled all dispatched messages and
+// waits for them to finish.
+func (p *Partitioner) Close() {
+	p.mtx.Lock()
+	if p.closed {
+		p.mtx.Unlock()
+		return
+	}
+	p.closed = true
+	p.mtx.Unlock()
+	for _, ch := range p.workers {
+		close(ch)
+	}
+	p.wg.Wait()
+}
@@ -0,0 +1,34 @@
+package ably_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestPartitioner(t *testing.T) {
+	t.Parallel()
+	var mtx sync.Mutex
+	got := make(map[string][]int)
+	p := ably.NewPartitioner(4, func(msg *proto.Message) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		key := msg.OrderingKey()
+		got[key] = append(got[key], msg.Data.(int))
+	})
+	want := make(map[string][]int)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i%7)
+		msg := &proto.Message{Data: i}
+		msg.SetOrderingKey(key)
+		p.Dispatch(msg)
+		want[key] = append(want[key], i)
+	}
+	p.Dispatch(&proto.Message{Data: -1})
+	want[""] = []int{-1}
+	p.Close()
+	assertDeepEquals(t, want, got)
+}
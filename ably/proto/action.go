@@ -1,5 +1,7 @@
 package proto
 
+import "strconv"
+
 type Action int8
 
 const (
@@ -20,6 +22,8 @@ const (
 	ActionPresence
 	ActionMessage
 	ActionSync
+	ActionAuth
+	ActionActivate
 )
 
 var actions = map[Action]string{
@@ -40,8 +44,13 @@ var actions = map[Action]string{
 	ActionPresence:     "presence",
 	ActionMessage:      "message",
 	ActionSync:         "sync",
+	ActionAuth:         "auth",
+	ActionActivate:     "activate",
 }
 
 func (a Action) String() string {
-	return actions[a]
+	if s, ok := actions[a]; ok {
+		return s
+	}
+	return "action(" + strconv.Itoa(int(a)) + ")"
 }
@@ -0,0 +1,75 @@
+package proto
+
+import (
+	"strings"
+	"sync"
+)
+
+// Vcdiff is the encoding of delta compressed messages; it's reserved, as
+// the library doesn't support deltas.
+const Vcdiff = "vcdiff"
+
+// Codec implements a custom message data encoding, e.g. a compression or
+// a serialization format, which extends the built-in utf-8, json, base64
+// and cipher encodings.
+//
+// A registered codec encodes the data of published messages when its
+// encoding name is listed in ChannelOptions.Encodings, and decodes the data
+// of every received message whose encoding includes its name, whether
+// received over realtime or in REST history.
+type Codec interface {
+	// Encode encodes the data of a message being published, given as is or
+	// as output by the previous encoding. The encoding name is given in full,
+	// with its eventual parameter.
+	Encode(encoding string, data interface{}) (interface{}, error)
+
+	// Decode reverses Encode; data is a string or []byte.
+	Decode(encoding string, data interface{}) (interface{}, error)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	m map[string]Codec
+}{m: make(map[string]Codec)}
+
+// RegisterCodec registers c for the given encoding name, replacing
+// the codec previously registered for it. Codecs are typically registered
+// by init functions.
+//
+// An encoding can carry a parameter after a "+", as the cipher encoding
+// does, e.g. "protobuf+orders.Order" is decoded with the codec registered
+// as "protobuf". As encodings are chained with "/", name can't contain
+// "/" nor "+". It can't be a built-in encoding either.
+func RegisterCodec(name string, c Codec) {
+	switch {
+	case c == nil:
+		panic("proto: RegisterCodec with nil codec")
+	case name == "" || strings.ContainsAny(name, "/+"):
+		panic("proto: RegisterCodec with invalid encoding name " + name)
+	case isBuiltinEncoding(name):
+		panic("proto: RegisterCodec with built-in encoding " + name)
+	}
+	codecs.Lock()
+	codecs.m[name] = c
+	codecs.Unlock()
+}
+
+// LookupCodec gives the codec registered for the given encoding, which may
+// carry a parameter after a "+".
+func LookupCodec(encoding string) (Codec, bool) {
+	if i := strings.IndexByte(encoding, '+'); i != -1 {
+		encoding = encoding[:i]
+	}
+	codecs.RLock()
+	c, ok := codecs.m[encoding]
+	codecs.RUnlock()
+	return c, ok
+}
+
+func isBuiltinEncoding(name string) bool {
+	switch name {
+	case UTF8, JSON, Base64, Cipher, Vcdiff:
+		return true
+	}
+	return false
+}
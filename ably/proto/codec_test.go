@@ -0,0 +1,81 @@
+package proto_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// gzipCodec compresses string and []byte data.
+type gzipCodec struct{}
+
+func (gzipCodec) Encode(encoding string, data interface{}) (interface{}, error) {
+	var p []byte
+	switch d := data.(type) {
+	case string:
+		p = []byte(d)
+	case []byte:
+		p = d
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(p)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decode(encoding string, data interface{}) (interface{}, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data.([]byte)))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func TestRegisterCodec(t *testing.T) {
+	proto.RegisterCodec("test-gzip", gzipCodec{})
+	if _, ok := proto.LookupCodec("test-gzip+level9"); !ok {
+		t.Fatal("want codec found for encoding with parameter")
+	}
+
+	msg := proto.Message{
+		Data:           "hello hello hello",
+		ChannelOptions: &proto.ChannelOptions{Encodings: []string{"test-gzip"}},
+	}
+	p, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire map[string]interface{}
+	if err := json.Unmarshal(p, &wire); err != nil {
+		t.Fatal(err)
+	}
+	if enc := wire["encoding"]; enc != "test-gzip/base64" {
+		t.Errorf("want encoding test-gzip/base64; got %v", enc)
+	}
+
+	var decoded proto.Message
+	if err := json.Unmarshal(p, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := decoded.Data.([]byte); !ok || string(d) != "hello hello hello" {
+		t.Errorf("want decoded data; got %#v", decoded.Data)
+	}
+
+	for _, name := range []string{"json", "cipher", "a/b", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("want RegisterCodec(%q) to panic", name)
+				}
+			}()
+			proto.RegisterCodec(name, gzipCodec{})
+		}()
+	}
+}
@@ -71,15 +71,88 @@ type CipherParams struct {
 	Mode CipherMode
 }
 
+// ChannelMode is an operation a realtime channel can be attached for.
+type ChannelMode Flag
+
+const (
+	ModePresence          = ChannelMode(FlagModePresence)
+	ModePublish           = ChannelMode(FlagModePublish)
+	ModeSubscribe         = ChannelMode(FlagModeSubscribe)
+	ModePresenceSubscribe = ChannelMode(FlagModePresenceSubscribe)
+)
+
+var channelModeNames = []struct {
+	mode ChannelMode
+	name string
+}{
+	{ModePresence, "presence"},
+	{ModePublish, "publish"},
+	{ModeSubscribe, "subscribe"},
+	{ModePresenceSubscribe, "presence_subscribe"},
+}
+
+func (m ChannelMode) String() string {
+	for _, n := range channelModeNames {
+		if n.mode == m {
+			return n.name
+		}
+	}
+	return fmt.Sprintf("ChannelMode(%d)", int64(m))
+}
+
+// ChannelModes gives the modes set in the flags of an ATTACH or ATTACHED
+// message.
+func ChannelModes(flags Flag) []ChannelMode {
+	var modes []ChannelMode
+	for _, n := range channelModeNames {
+		if flags.Has(Flag(n.mode)) {
+			modes = append(modes, n.mode)
+		}
+	}
+	return modes
+}
+
 // ChannelOptions defines options provided for creating a new channel.
 type ChannelOptions struct {
 	Cipher CipherParams
+
+	// Encodings lists the names of registered codecs applied, in order, to
+	// the data of messages published on the channel; see RegisterCodec.
+	// The cipher, if any, applies after them.
+	Encodings []string
+
+	// Params are sent to the server when attaching a realtime channel, e.g.
+	// {"rewind": "1"} to receive the last message published before attaching,
+	// or {"occupancy": "metrics"} to receive the channel's occupancy metrics.
+	Params map[string]string
+
+	// Modes restricts the operations a realtime channel is attached for; if
+	// empty, the channel is attached with all the modes its capabilities
+	// allow. The modes granted by the server are given by
+	// RealtimeChannel.Modes once the channel is attached.
+	Modes []ChannelMode
+
 	cipher ChannelCipher
 }
 
+// ModeFlags gives the flags of the ATTACH message requesting the modes of c.
+func (c *ChannelOptions) ModeFlags() Flag {
+	if c == nil {
+		return 0
+	}
+	var flags Flag
+	for _, mode := range c.Modes {
+		flags |= Flag(mode)
+	}
+	return flags
+}
+
 // GetCipher returns a ChannelCipher based on the algorithms set in the
-// ChannelOptions.CipherParams.
+// ChannelOptions.CipherParams. It fails if c is nil.
 func (c *ChannelOptions) GetCipher() (ChannelCipher, error) {
+	if c == nil {
+		return nil, errors.New("no cipher configured")
+	}
 	if c.cipher != nil {
 		return c.cipher, nil
 	}
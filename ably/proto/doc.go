@@ -0,0 +1,10 @@
+// Package proto is Ably's realtime wire protocol: the protocol messages
+// exchanged with Ably, the messages they carry, and their encodings.
+//
+// The ably package is built on it, and it's usable on its own by tooling
+// speaking the protocol directly, e.g. load generators or proxies: a
+// ProtocolMessage is encoded with Marshal and decoded with Unmarshal in
+// either of the formats the realtime client uses, and a Conn transports
+// protocol messages. Its exported API is kept backwards compatible, as the
+// ably package's is.
+package proto
@@ -2,12 +2,14 @@ package proto
 
 import (
 	"crypto/aes"
+	"encoding"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/ugorji/go/codec"
 )
@@ -20,51 +22,104 @@ const (
 	Cipher = "cipher"
 )
 
+// Message is a message published on or received from a channel.
+//
+// When publishing, ClientID can be left empty to use the client's own
+// ClientID, or set to publish on behalf of another client, which is only
+// allowed when authenticated with a key or a token with the wildcard client
+// ID "*".
+//
+// Data is sent as UTF-8 text if it's a string and as binary if it's a
+// []byte, base64-encoded with the JSON protocol; any other data, e.g. a
+// struct, a map or a number, is sent encoded as JSON. Received messages have
+// their data decoded back to a string, a []byte, or for JSON the value given
+// by json.Unmarshal into an interface{}, whichever the protocol.
 type Message struct {
 	ID              string                 `json:"id,omitempty" codec:"id,omitempty"`
 	ClientID        string                 `json:"clientId,omitempty" codec:"clientId,omitempty"`
-	ConnectionID    string                 `json:"connectionId,omitempty" codec:"connectionID,omitempty"`
+	ConnectionID    string                 `json:"connectionId,omitempty" codec:"connectionId,omitempty"`
+	ConnectionKey   string                 `json:"connectionKey,omitempty" codec:"connectionKey,omitempty"`
 	Name            string                 `json:"name,omitempty" codec:"name,omitempty"`
 	Data            interface{}            `json:"data,omitempty" codec:"data,omitempty"`
 	Encoding        string                 `json:"encoding,omitempty" codec:"encoding,omitempty"`
 	Timestamp       int64                  `json:"timestamp" codec:"timestamp"`
 	Extras          map[string]interface{} `json:"extras" codec:"extras"`
 	*ChannelOptions `json:"-" codec:"-"`
+
+	// ChannelSerial is the serial of the protocol message which delivered
+	// the message on a realtime channel, which an application may store to
+	// resume from it; it's set by the client, and never sent.
+	ChannelSerial string `json:"-" codec:"-"`
 }
 
+// maybeJSONEncode normalizes the data of the message to be sent: strings and
+// byte slices, including those of named types, are sent as they are, while
+// any other data is encoded as JSON (RSL4).
+//
+// Some types are encoded as fits them better: a time.Time is sent as an
+// RFC 3339 string, a json.RawMessage as the JSON it holds, and values
+// implementing encoding.TextMarshaler or encoding.BinaryMarshaler, but not
+// json.Marshaler, as the string or bytes they marshal to.
 func (m *Message) maybeJSONEncode() error {
 	if m.Data == nil {
 		return nil
 	}
-	switch m.Data.(type) {
+	switch data := m.Data.(type) {
+	case string, []byte:
+		return nil
+	case time.Time:
+		m.Data = data.Format(time.RFC3339Nano)
+		return nil
+	case *time.Time:
+		if data != nil {
+			m.Data = data.Format(time.RFC3339Nano)
+			return nil
+		}
+	case json.RawMessage:
+		if !json.Valid(data) {
+			return errors.New("json.RawMessage data is not valid JSON")
+		}
+		m.Data = string(data)
+		m.Encoding = mergeEncoding(m.Encoding, JSON)
+		return nil
 	case json.Marshaler:
-		bs, err := m.Data.(json.Marshaler).MarshalJSON()
+		bs, err := data.MarshalJSON()
 		if err != nil {
 			return err
 		}
 		m.Data = string(bs)
 		m.Encoding = mergeEncoding(m.Encoding, JSON)
 		return nil
-	case map[string]interface{}:
-		bs, err := json.Marshal(m.Data)
+	case encoding.TextMarshaler:
+		text, err := data.MarshalText()
 		if err != nil {
 			return err
 		}
-		m.Data = string(bs)
-		m.Encoding = mergeEncoding(m.Encoding, JSON)
+		m.Data = string(text)
 		return nil
-	}
-	dataType := reflect.TypeOf(m.Data)
-
-	// marshal any sort of slice except for []byte (i.e. []uint8)
-	if dataType.Kind() == reflect.Slice && dataType.Elem().Kind() != reflect.Uint8 {
-		bs, err := json.Marshal(m.Data)
+	case encoding.BinaryMarshaler:
+		bs, err := data.MarshalBinary()
 		if err != nil {
 			return err
 		}
-		m.Data = string(bs)
-		m.Encoding = mergeEncoding(m.Encoding, JSON)
+		m.Data = bs
+		return nil
 	}
+	v := reflect.ValueOf(m.Data)
+	switch {
+	case v.Kind() == reflect.String:
+		m.Data = v.String()
+		return nil
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		m.Data = v.Bytes()
+		return nil
+	}
+	bs, err := json.Marshal(m.Data)
+	if err != nil {
+		return err
+	}
+	m.Data = string(bs)
+	m.Encoding = mergeEncoding(m.Encoding, JSON)
 	return nil
 }
 
@@ -80,6 +135,20 @@ func (m Message) encode() (Message, error) {
 	if m.Data == nil {
 		return m, nil
 	}
+	if m.ChannelOptions != nil {
+		for _, encoding := range m.ChannelOptions.Encodings {
+			codec, ok := LookupCodec(encoding)
+			if !ok {
+				return Message{}, fmt.Errorf("unknown encoding %s", encoding)
+			}
+			data, err := codec.Encode(encoding, m.Data)
+			if err != nil {
+				return Message{}, fmt.Errorf("error encoding payload with %s: %s", encoding, err)
+			}
+			m.Data = data
+			m.Encoding = mergeEncoding(m.Encoding, encoding)
+		}
+	}
 	err := m.maybeJSONEncode()
 	if err != nil {
 		return m, err
@@ -164,6 +233,9 @@ func (m Message) ToMap() map[string]interface{} {
 	if m.ConnectionID != "" {
 		ctx["connectionId"] = m.ConnectionID
 	}
+	if m.ConnectionKey != "" {
+		ctx["connectionKey"] = m.ConnectionKey
+	}
 	if m.Name != "" {
 		ctx["name"] = m.Name
 	}
@@ -199,19 +271,72 @@ func (m *Message) UnmarshalJSON(data []byte) error {
 	return m.FromMap(ctx)
 }
 
+// messageWire is the msgpack representation of Message. Encoding and
+// decoding it, rather than the map given by ToMap, saves allocating a map
+// and boxing each of its values for every message.
+type messageWire struct {
+	ID            string                 `codec:"id,omitempty"`
+	ClientID      string                 `codec:"clientId,omitempty"`
+	ConnectionID  string                 `codec:"connectionId,omitempty"`
+	ConnectionKey string                 `codec:"connectionKey,omitempty"`
+	Name          string                 `codec:"name,omitempty"`
+	Data          interface{}            `codec:"data,omitempty"`
+	Encoding      string                 `codec:"encoding,omitempty"`
+	Timestamp     int64                  `codec:"timestamp,omitempty"`
+	Extras        map[string]interface{} `codec:"extras,omitempty"`
+}
+
+func (m *Message) toWire() messageWire {
+	return messageWire{
+		ID:            m.ID,
+		ClientID:      m.ClientID,
+		ConnectionID:  m.ConnectionID,
+		ConnectionKey: m.ConnectionKey,
+		Name:          m.Name,
+		Data:          m.Data,
+		Encoding:      m.Encoding,
+		Timestamp:     m.Timestamp,
+		Extras:        m.Extras,
+	}
+}
+
+// fromWire sets the fields of m from w and decodes its data.
+func (m *Message) fromWire(w *messageWire) error {
+	m.ID = w.ID
+	m.ClientID = w.ClientID
+	m.ConnectionID = w.ConnectionID
+	m.ConnectionKey = w.ConnectionKey
+	m.Name = w.Name
+	m.Data = w.Data
+	m.Encoding = w.Encoding
+	m.Timestamp = w.Timestamp
+	m.Extras = w.Extras
+	if m.Data == nil {
+		return nil
+	}
+	dec, err := m.decode()
+	if err != nil {
+		return err
+	}
+	*m = dec
+	return nil
+}
+
 func (m Message) CodecEncodeSelf(encoder *codec.Encoder) {
 	e, err := m.encode()
 	if err != nil {
 		panic(err)
 	}
-	encoder.MustEncode(e.ToMap())
+	encoder.MustEncode(e.toWire())
 }
 
 // CodecDecodeSelf implements codec.Selfer interface for msgpack decoding.
 func (m *Message) CodecDecodeSelf(decoder *codec.Decoder) {
-	ctx := make(map[string]interface{})
-	decoder.MustDecode(&ctx)
-	m.FromMap(ctx)
+	var w messageWire
+	decoder.MustDecode(&w)
+	if err := m.fromWire(&w); err != nil {
+		panic(err)
+	}
 }
 
 func (m *Message) FromMap(ctx map[string]interface{}) error {
@@ -236,6 +361,13 @@ func (m *Message) FromMap(ctx map[string]interface{}) error {
 		}
 		m.ConnectionID = string(x)
 	}
+	if v, ok := ctx["connectionKey"]; ok {
+		x, err := coerceString(v)
+		if err != nil {
+			return err
+		}
+		m.ConnectionKey = string(x)
+	}
 	if v, ok := ctx["name"]; ok {
 		x, err := coerceString(v)
 		if err != nil {
@@ -274,11 +406,61 @@ func (m *Message) FromMap(ctx map[string]interface{}) error {
 	return nil
 }
 
+// LatencyFrom gives the time elapsed from the message's Timestamp, when it
+// was received by Ably, to now, e.g. the time the message is processed. It's
+// zero if the message has no timestamp. The result includes the skew
+// between the local clock and Ably's.
+func (m *Message) LatencyFrom(now time.Time) time.Duration {
+	if m.Timestamp == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, m.Timestamp*int64(time.Millisecond)))
+}
+
 // MemberKey returns string that allows to uniquely identify connected clients.
 func (m *Message) MemberKey() string {
 	return m.ConnectionID + ":" + m.ClientID
 }
 
+// OrderingKeyHeader is the name of the extras header which carries the
+// ordering key of a message.
+const OrderingKeyHeader = "orderingKey"
+
+// SetOrderingKey sets the ordering key of the message, stored in the
+// headers of its extras. Consumers may use it to process messages with the
+// same key sequentially, while processing different keys concurrently.
+func (m *Message) SetOrderingKey(key string) {
+	if m.Extras == nil {
+		m.Extras = make(map[string]interface{})
+	}
+	headers, ok := m.Extras["headers"].(map[string]interface{})
+	if !ok {
+		headers = make(map[string]interface{})
+		m.Extras["headers"] = headers
+	}
+	headers[OrderingKeyHeader] = key
+}
+
+// OrderingKey gives the ordering key set with SetOrderingKey, or an empty
+// string if the message has none.
+func (m *Message) OrderingKey() string {
+	var key interface{}
+	switch headers := m.Extras["headers"].(type) {
+	case map[string]interface{}:
+		key = headers[OrderingKeyHeader]
+	case map[interface{}]interface{}:
+		key = headers[OrderingKeyHeader]
+	}
+	switch key := key.(type) {
+	case string:
+		return key
+	case []byte:
+		return string(key)
+	default:
+		return ""
+	}
+}
+
 func (m Message) Decrypt() (interface{}, error) {
 	cipher, err := m.GetCipher()
 	if err != nil {
@@ -339,6 +521,14 @@ func (m Message) decode() (Message, error) {
 				}
 				m.Data = d
 			default:
+				if codec, ok := LookupCodec(encodings[i]); ok {
+					d, err := codec.Decode(encodings[i], m.Data)
+					if err != nil {
+						return m, fmt.Errorf("error decoding payload with %s: %s", encodings[i], err)
+					}
+					m.Data = d
+					break
+				}
 				return m, fmt.Errorf("unknown encoding %s", encodings[i])
 			}
 
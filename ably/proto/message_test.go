@@ -3,10 +3,13 @@ package proto_test
 import (
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/internal/ablyutil"
 	"github.com/ably/ably-go/ably/proto"
 )
 
@@ -66,6 +69,32 @@ func TestMessage(t *testing.T) {
 			encodedJSON: `{"data":"\"custom\"","encoding":"json"}`,
 			decoded:     "custom",
 		},
+		{
+			desc: "with a json encoding RSL4d3 struct data",
+			data: struct {
+				Text string `json:"text"`
+			}{"hello"},
+			decoded: map[string]interface{}{
+				"text": "hello",
+			},
+			encodedJSON: `{"data":"{\"text\":\"hello\"}","encoding":"json"}`,
+		},
+		{
+			desc: "with a json encoding RSL4d3 struct pointer data",
+			data: &struct {
+				N int `json:"n"`
+			}{1},
+			decoded: map[string]interface{}{
+				"n": float64(1),
+			},
+			encodedJSON: `{"data":"{\"n\":1}","encoding":"json"}`,
+		},
+		{
+			desc:        "with a json encoding RSL4d3 typed map data",
+			data:        map[string]int{"n": 1},
+			decoded:     map[string]interface{}{"n": float64(1)},
+			encodedJSON: `{"data":"{\"n\":1}","encoding":"json"}`,
+		},
 		{
 			desc:        "with a base64 encoding RSL4d3 binary data",
 			data:        []byte(proto.Base64),
@@ -167,3 +196,144 @@ func TestMessage_CryptoDataFixtures_RSL6a1_RSL5b_RSL5c(t *testing.T) {
 		})
 	}
 }
+
+func TestMessage_OrderingKey(t *testing.T) {
+	t.Parallel()
+	msg := &proto.Message{Extras: map[string]interface{}{
+		"headers": map[interface{}]interface{}{proto.OrderingKeyHeader: []byte("a")},
+	}}
+	if key := msg.OrderingKey(); key != "a" {
+		t.Fatalf("want key=a; got %q", key)
+	}
+	msg = &proto.Message{}
+	if key := msg.OrderingKey(); key != "" {
+		t.Fatalf("want empty key; got %q", key)
+	}
+	msg.SetOrderingKey("b")
+	want := map[string]interface{}{
+		"headers": map[string]interface{}{proto.OrderingKeyHeader: "b"},
+	}
+	if !reflect.DeepEqual(want, msg.Extras) {
+		t.Fatalf("want extras=%v; got %v", want, msg.Extras)
+	}
+}
+
+type (
+	namedString string
+	namedBytes  []byte
+	textID      int
+	binaryID    int
+)
+
+func (id textID) MarshalText() ([]byte, error) {
+	return []byte(fmt.Sprintf("id-%d", int(id))), nil
+}
+
+func (id binaryID) MarshalBinary() ([]byte, error) {
+	return []byte{byte(id)}, nil
+}
+
+func TestMessage_DataTypes(t *testing.T) {
+	t.Parallel()
+	key := make([]byte, 16)
+	cipherOpts := &proto.ChannelOptions{
+		Cipher: proto.CipherParams{Key: key, KeyLength: 128, IV: key, Algorithm: proto.AES},
+	}
+	protocols := map[string]struct {
+		marshal   func(interface{}) ([]byte, error)
+		unmarshal func([]byte, interface{}) error
+	}{
+		"json":    {json.Marshal, json.Unmarshal},
+		"msgpack": {ablyutil.Marshal, ablyutil.Unmarshal},
+	}
+	ts := time.Date(2020, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	for _, c := range []struct {
+		desc    string
+		data    interface{}
+		decoded interface{}
+	}{
+		{"string", "text", "text"},
+		{"bytes", []byte("bin"), []byte("bin")},
+		{"named string", namedString("text"), "text"},
+		{"named bytes", namedBytes("bin"), []byte("bin")},
+		{"number", 42, float64(42)},
+		{"bool", true, true},
+		{"struct", struct{ N int }{1}, map[string]interface{}{"N": float64(1)}},
+		{"map", map[string]bool{"ok": true}, map[string]interface{}{"ok": true}},
+		{"time", ts, "2020-01-02T03:04:05.006Z"},
+		{"time pointer", &ts, "2020-01-02T03:04:05.006Z"},
+		{"raw JSON", json.RawMessage(`{"ok":true}`), map[string]interface{}{"ok": true}},
+		{"text marshaler", textID(7), "id-7"},
+		{"binary marshaler", binaryID(7), []byte{7}},
+	} {
+		for name, p := range protocols {
+			for _, opts := range []*proto.ChannelOptions{nil, cipherOpts} {
+				b, err := p.marshal(proto.Message{Data: c.data, ChannelOptions: opts})
+				if err != nil {
+					t.Fatalf("%s/%s: %v", c.desc, name, err)
+				}
+				decoded := proto.Message{ChannelOptions: opts}
+				if err := p.unmarshal(b, &decoded); err != nil {
+					t.Fatalf("%s/%s: %v", c.desc, name, err)
+				}
+				if !reflect.DeepEqual(decoded.Data, c.decoded) {
+					t.Errorf("%s/%s (cipher: %t): want data %#v; got %#v", c.desc, name, opts != nil, c.decoded, decoded.Data)
+				}
+			}
+		}
+	}
+}
+
+func TestMessage_ConnectionKeyAndLatency(t *testing.T) {
+	msg := proto.Message{ID: "id", ConnectionKey: "connection-key", Timestamp: 1700000000000}
+
+	b, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON proto.Message
+	if err := json.Unmarshal(b, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	b, err = ablyutil.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromMsgpack proto.Message
+	if err := ablyutil.Unmarshal(b, &fromMsgpack); err != nil {
+		t.Fatal(err)
+	}
+	for _, got := range []proto.Message{fromJSON, fromMsgpack} {
+		if got.ConnectionKey != msg.ConnectionKey {
+			t.Errorf("expected connection key %q; got %q", msg.ConnectionKey, got.ConnectionKey)
+		}
+	}
+
+	now := time.Unix(1700000000, int64(250*time.Millisecond))
+	if latency := msg.LatencyFrom(now); latency != 250*time.Millisecond {
+		t.Errorf("expected latency 250ms; got %v", latency)
+	}
+	if latency := (&proto.Message{}).LatencyFrom(now); latency != 0 {
+		t.Errorf("expected no latency without timestamp; got %v", latency)
+	}
+}
+
+func TestMessage_InvalidRawJSON(t *testing.T) {
+	t.Parallel()
+	_, err := json.Marshal(proto.Message{Data: json.RawMessage(`{"ok":`)})
+	if err == nil {
+		t.Fatal("expected an error encoding invalid raw JSON")
+	}
+}
+
+func TestMessage_EncodingFixtures(t *testing.T) {
+	t.Parallel()
+	ablytest.RunMessageEncodingFixtures(t)
+}
+
+func TestMessage_CryptoFixtures(t *testing.T) {
+	t.Parallel()
+	for _, keyLength := range []int{128, 256} {
+		ablytest.RunCryptoFixtures(t, keyLength)
+	}
+}
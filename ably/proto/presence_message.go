@@ -2,6 +2,8 @@ package proto
 
 import (
 	"encoding/json"
+	"reflect"
+	"time"
 
 	"github.com/ugorji/go/codec"
 )
@@ -16,11 +18,76 @@ const (
 	PresenceUpdate
 )
 
+var presenceStates = map[PresenceState]string{
+	PresenceAbsent:  "absent",
+	PresencePresent: "present",
+	PresenceEnter:   "enter",
+	PresenceLeave:   "leave",
+	PresenceUpdate:  "update",
+}
+
+func (s PresenceState) String() string {
+	return presenceStates[s]
+}
+
+// PresenceMessage is a presence event of a member, identified by its
+// ClientID and ConnectionID, carrying the member's Data.
 type PresenceMessage struct {
 	Message
 	State PresenceState `json:"action" codec:"action"`
 }
 
+// Action gives the presence event's action, named after the field sent by
+// Ably; it's the same as State.
+func (m *PresenceMessage) Action() PresenceState {
+	return m.State
+}
+
+// Time gives the time the presence event was received by Ably, from its
+// Timestamp. It's the zero time if the message has no timestamp.
+func (m *PresenceMessage) Time() time.Time {
+	if m.Timestamp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, m.Timestamp*int64(time.Millisecond))
+}
+
+// PresenceDiff tells how the members present on a channel changed between
+// two snapshots, e.g. as given by successive calls to RealtimePresence.Get.
+type PresenceDiff struct {
+	Joined  []*PresenceMessage // members in the later snapshot only
+	Left    []*PresenceMessage // members in the earlier snapshot only
+	Updated []*PresenceMessage // members in both, whose data changed, as in the later snapshot
+}
+
+// DiffPresence compares the members in snapshots before and after, which are
+// matched by their client and connection IDs.
+func DiffPresence(before, after []*PresenceMessage) PresenceDiff {
+	var diff PresenceDiff
+	prev := make(map[string]*PresenceMessage, len(before))
+	for _, m := range before {
+		prev[m.MemberKey()] = m
+	}
+	next := make(map[string]struct{}, len(after))
+	for _, m := range after {
+		key := m.MemberKey()
+		next[key] = struct{}{}
+		old, ok := prev[key]
+		switch {
+		case !ok:
+			diff.Joined = append(diff.Joined, m)
+		case !reflect.DeepEqual(old.Data, m.Data):
+			diff.Updated = append(diff.Updated, m)
+		}
+	}
+	for _, m := range before {
+		if _, ok := next[m.MemberKey()]; !ok {
+			diff.Left = append(diff.Left, m)
+		}
+	}
+	return diff
+}
+
 func (m PresenceMessage) MarshalJSON() ([]byte, error) {
 	e, err := m.encodeJSON()
 	if err != nil {
@@ -39,24 +106,29 @@ func (m *PresenceMessage) UnmarshalJSON(data []byte) error {
 	return m.FromMap(ctx)
 }
 
+// presenceMessageWire is the msgpack representation of PresenceMessage.
+type presenceMessageWire struct {
+	messageWire
+	State PresenceState `codec:"action"`
+}
+
 // CodecEncodeSelf encodes PresenceMessage into a msgpack format.
 func (m PresenceMessage) CodecEncodeSelf(encoder *codec.Encoder) {
 	e, err := m.encode()
 	if err != nil {
 		panic(err)
 	}
-	ctx := e.ToMap()
-	ctx["action"] = m.State
-	encoder.MustEncode(ctx)
+	encoder.MustEncode(presenceMessageWire{messageWire: e.toWire(), State: m.State})
 }
 
 // CodecDecodeSelf implements codec.Selfer interface for msgpack decoding.
 func (m *PresenceMessage) CodecDecodeSelf(decoder *codec.Decoder) {
-	ctx := make(map[string]interface{})
-	decoder.MustDecode(&ctx)
-	if err := m.FromMap(ctx); err != nil {
+	var w presenceMessageWire
+	decoder.MustDecode(&w)
+	if err := m.Message.fromWire(&w.messageWire); err != nil {
 		panic(err)
 	}
+	m.State = w.State
 }
 
 func (m *PresenceMessage) FromMap(ctx map[string]interface{}) error {
@@ -3,7 +3,9 @@ package proto_test
 import (
 	"encoding/json"
 	"fmt"
+	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ably/ably-go/ably/internal/ablyutil"
 	"github.com/ably/ably-go/ably/proto"
@@ -64,3 +66,68 @@ func TestPresenceMessage(t *testing.T) {
 		})
 	}
 }
+
+func TestPresenceMessage_Fields(t *testing.T) {
+	var m proto.PresenceMessage
+	err := json.Unmarshal([]byte(`{"action":2,"clientId":"alice","connectionId":"conn","data":"here","timestamp":1500000000000}`), &m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Action() != proto.PresenceEnter || m.Action().String() != "enter" {
+		t.Errorf("expected action enter; got %v", m.Action())
+	}
+	if m.ClientID != "alice" || m.ConnectionID != "conn" || m.Data != "here" {
+		t.Errorf("unexpected member %+v", m.Message)
+	}
+	if expected := time.Unix(1500000000, 0); !m.Time().Equal(expected) {
+		t.Errorf("expected time %v; got %v", expected, m.Time())
+	}
+	if !(&proto.PresenceMessage{}).Time().IsZero() {
+		t.Error("expected zero time without a timestamp")
+	}
+}
+
+func TestDiffPresence(t *testing.T) {
+	member := func(clientID, connID string, data interface{}) *proto.PresenceMessage {
+		return &proto.PresenceMessage{
+			State:   proto.PresencePresent,
+			Message: proto.Message{ClientID: clientID, ConnectionID: connID, Data: data},
+		}
+	}
+	keys := func(members []*proto.PresenceMessage) []string {
+		var keys []string
+		for _, m := range members {
+			keys = append(keys, fmt.Sprintf("%s/%v", m.MemberKey(), m.Data))
+		}
+		return keys
+	}
+
+	before := []*proto.PresenceMessage{
+		member("alice", "conn1", "a"),
+		member("alice", "conn2", "a"),
+		member("bob", "conn2", "b"),
+	}
+	after := []*proto.PresenceMessage{
+		member("alice", "conn1", "a"),
+		member("bob", "conn2", "b2"),
+		member("carol", "conn3", "c"),
+	}
+	diff := proto.DiffPresence(before, after)
+	for _, c := range []struct {
+		name     string
+		got      []*proto.PresenceMessage
+		expected []string
+	}{
+		{"joined", diff.Joined, []string{"conn3:carol/c"}},
+		{"left", diff.Left, []string{"conn2:alice/a"}},
+		{"updated", diff.Updated, []string{"conn2:bob/b2"}},
+	} {
+		if got := keys(c.got); !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("expected %s %v; got %v", c.name, c.expected, got)
+		}
+	}
+
+	if diff := proto.DiffPresence(after, after); diff.Joined != nil || diff.Left != nil || diff.Updated != nil {
+		t.Errorf("expected no difference; got %+v", diff)
+	}
+}
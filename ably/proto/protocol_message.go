@@ -3,14 +3,40 @@ package proto
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"time"
 )
 
+// Flags of ATTACHED messages: FlagPresence is set for channels with members
+// present, whose presence set is then synced, and FlagBacklog for channels
+// with messages to be delivered from before the attachment, e.g. as
+// requested with the rewind param.
 const (
 	FlagPresence Flag = iota + 1
 	FlagBacklog
 )
 
+// FlagResumed is set on ATTACHED messages for channels whose attachment
+// carries on from the previous one, with no message lost in between.
+const FlagResumed Flag = 1 << 2
+
+// FlagTransient is set on ATTACH messages of channels attached only for
+// publishing, and FlagAttachResume on those reattaching a channel whose
+// attachment is to carry on from the previous one.
+const (
+	FlagTransient    Flag = 1 << 4
+	FlagAttachResume Flag = 1 << 5
+)
+
+// Flags of ATTACH and ATTACHED messages giving the modes requested for, and
+// granted to, the channel; see ChannelMode.
+const (
+	FlagModePresence Flag = 1 << (iota + 16)
+	FlagModePublish
+	FlagModeSubscribe
+	FlagModePresenceSubscribe
+)
+
 type Flag int64
 
 func (f Flag) Has(flag Flag) bool {
@@ -91,6 +117,52 @@ type ProtocolMessage struct {
 	Count             int                `json:"count,omitempty" codec:"count,omitempty"`
 	Action            Action             `json:"action,omitempty" codec:"action,omitempty"`
 	Flags             Flag               `json:"flags,omitempty" codec:"flags,omitempty"`
+	Params            map[string]string  `json:"params,omitempty" codec:"params,omitempty"`
+}
+
+// HasPresence tells whether the message has FlagPresence set.
+func (p *ProtocolMessage) HasPresence() bool {
+	return p.Flags.Has(FlagPresence)
+}
+
+// HasBacklog tells whether the message has FlagBacklog set.
+func (p *ProtocolMessage) HasBacklog() bool {
+	return p.Flags.Has(FlagBacklog)
+}
+
+// Resumed tells whether the message has FlagResumed set.
+func (p *ProtocolMessage) Resumed() bool {
+	return p.Flags.Has(FlagResumed)
+}
+
+// AttachResume tells whether the message has FlagAttachResume set.
+func (p *ProtocolMessage) AttachResume() bool {
+	return p.Flags.Has(FlagAttachResume)
+}
+
+// PopulateMessageFields sets the ID, ConnectionID and Timestamp of the
+// messages and presence messages carried by p which lack them from those of
+// p, the ID being p's ID followed by the message's index, e.g. "abc:0"
+// (TM2a, TM2c, TM2f, TP3a, TP3c, TP3g). It sets their ChannelSerial to p's.
+func (p *ProtocolMessage) PopulateMessageFields() {
+	populate := func(m *Message, i int) {
+		if m.ID == "" && p.ID != "" {
+			m.ID = p.ID + ":" + strconv.Itoa(i)
+		}
+		if m.ConnectionID == "" {
+			m.ConnectionID = p.ConnectionID
+		}
+		if m.Timestamp == 0 {
+			m.Timestamp = p.Timestamp
+		}
+		m.ChannelSerial = p.ChannelSerial
+	}
+	for i, m := range p.Messages {
+		populate(m, i)
+	}
+	for i, m := range p.Presence {
+		populate(&m.Message, i)
+	}
 }
 
 func (p *ProtocolMessage) UnmarshalJSON(b []byte) error {
@@ -166,6 +238,13 @@ func (p *ProtocolMessage) FromMap(ctx map[string]interface{}) {
 	if v, ok := ctx["flags"]; ok {
 		p.Flags = Flag(coerceInt64(v))
 	}
+	if v, ok := ctx["params"]; ok {
+		params := make(map[string]string)
+		for k, v := range v.(map[string]interface{}) {
+			params[k], _ = v.(string)
+		}
+		p.Params = params
+	}
 }
 
 func (msg *ProtocolMessage) String() string {
@@ -189,7 +268,7 @@ func (msg *ProtocolMessage) String() string {
 	case ActionError:
 		return fmt.Sprintf("(action=%q, error=%# v)", msg.Action, msg.Error)
 	case ActionAttach:
-		return fmt.Sprintf("(action=%q, channel=%q)", msg.Action, msg.Channel)
+		return fmt.Sprintf("(action=%q, channel=%q, flags=%x, params=%v)", msg.Action, msg.Channel, msg.Flags, msg.Params)
 	case ActionAttached:
 		return fmt.Sprintf("(action=%q, channel=%q, channelSerial=%q, flags=%x)",
 			msg.Action, msg.Channel, msg.ChannelSerial, msg.Flags)
@@ -2,6 +2,8 @@ package proto_test
 
 import (
 	"bytes"
+	"encoding/json"
+	"reflect"
 	"testing"
 
 	"github.com/ably/ably-go/ably/internal/ablyutil"
@@ -26,3 +28,142 @@ func TestProtocolMessageEncodeZeroSerials(t *testing.T) {
 		t.Fatalf("unexpected msgpack encoding\nexpected: %x\nactual:   %x", expected, encoded)
 	}
 }
+
+func TestChannelModes(t *testing.T) {
+	opts := &proto.ChannelOptions{Modes: []proto.ChannelMode{proto.ModePublish, proto.ModePresenceSubscribe}}
+	flags := opts.ModeFlags() | proto.FlagPresence
+	msg := proto.ProtocolMessage{
+		Action: proto.ActionAttached,
+		Flags:  flags,
+		Params: map[string]string{"rewind": "1"},
+	}
+	p, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded proto.ProtocolMessage
+	if err := json.Unmarshal(p, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Params["rewind"] != "1" {
+		t.Errorf("want params decoded; got %v", decoded.Params)
+	}
+	modes := proto.ChannelModes(decoded.Flags)
+	if len(modes) != 2 || modes[0] != proto.ModePublish || modes[1] != proto.ModePresenceSubscribe {
+		t.Fatalf("want publish and presence_subscribe modes; got %v", modes)
+	}
+	if s := modes[1].String(); s != "presence_subscribe" {
+		t.Errorf("want presence_subscribe; got %s", s)
+	}
+}
+
+func TestProtocolMessage_PopulateMessageFields(t *testing.T) {
+	msg := proto.ProtocolMessage{
+		ID:            "protocol-id",
+		ConnectionID:  "connection-id",
+		ChannelSerial: "channel-serial",
+		Timestamp:     1700000000000,
+		Messages: []*proto.Message{
+			{Name: "first"},
+			{Name: "second", ID: "own-id", ConnectionID: "own-connection", Timestamp: 1600000000000},
+		},
+		Presence: []*proto.PresenceMessage{
+			{Message: proto.Message{ClientID: "client"}},
+		},
+	}
+	msg.PopulateMessageFields()
+
+	for _, c := range []struct {
+		got, expected proto.Message
+	}{
+		{*msg.Messages[0], proto.Message{Name: "first", ID: "protocol-id:0", ConnectionID: "connection-id", Timestamp: 1700000000000, ChannelSerial: "channel-serial"}},
+		{*msg.Messages[1], proto.Message{Name: "second", ID: "own-id", ConnectionID: "own-connection", Timestamp: 1600000000000, ChannelSerial: "channel-serial"}},
+		{msg.Presence[0].Message, proto.Message{ClientID: "client", ID: "protocol-id:0", ConnectionID: "connection-id", Timestamp: 1700000000000, ChannelSerial: "channel-serial"}},
+	} {
+		if !reflect.DeepEqual(c.expected, c.got) {
+			t.Errorf("expected %+v; got %+v", c.expected, c.got)
+		}
+	}
+}
+
+func TestProtocolMessage_ActionsAndFlags(t *testing.T) {
+	t.Parallel()
+	// The wire values of actions (TR2) and flags (TR3), as defined by the
+	// protocol.
+	actions := []struct {
+		action proto.Action
+		wire   int
+		name   string
+	}{
+		{proto.ActionHeartbeat, 0, "heartbeat"},
+		{proto.ActionAck, 1, "ack"},
+		{proto.ActionNack, 2, "nack"},
+		{proto.ActionConnect, 3, "connect"},
+		{proto.ActionConnected, 4, "connected"},
+		{proto.ActionDisconnect, 5, "disconnect"},
+		{proto.ActionDisconnected, 6, "disconnected"},
+		{proto.ActionClose, 7, "close"},
+		{proto.ActionClosed, 8, "closed"},
+		{proto.ActionError, 9, "error"},
+		{proto.ActionAttach, 10, "attach"},
+		{proto.ActionAttached, 11, "attached"},
+		{proto.ActionDetach, 12, "detach"},
+		{proto.ActionDetached, 13, "detached"},
+		{proto.ActionPresence, 14, "presence"},
+		{proto.ActionMessage, 15, "message"},
+		{proto.ActionSync, 16, "sync"},
+		{proto.ActionAuth, 17, "auth"},
+		{proto.ActionActivate, 18, "activate"},
+	}
+	flags := []struct {
+		flag proto.Flag
+		wire int64
+	}{
+		{proto.FlagPresence, 1 << 0},
+		{proto.FlagBacklog, 1 << 1},
+		{proto.FlagResumed, 1 << 2},
+		{proto.FlagTransient, 1 << 4},
+		{proto.FlagAttachResume, 1 << 5},
+		{proto.FlagModePresence, 1 << 16},
+		{proto.FlagModePublish, 1 << 17},
+		{proto.FlagModeSubscribe, 1 << 18},
+		{proto.FlagModePresenceSubscribe, 1 << 19},
+	}
+	for _, c := range actions {
+		if int(c.action) != c.wire || c.action.String() != c.name {
+			t.Errorf("want action %q to be %d; got %q as %d", c.name, c.wire, c.action, int(c.action))
+		}
+	}
+	for _, c := range flags {
+		if int64(c.flag) != c.wire {
+			t.Errorf("want flag %d; got %d", c.wire, int64(c.flag))
+		}
+	}
+	if s := proto.Action(99).String(); s != "action(99)" {
+		t.Errorf("want unknown action as %q; got %q", "action(99)", s)
+	}
+
+	for _, contentType := range []string{proto.ContentTypeJSON, proto.ContentTypeMsgpack} {
+		for _, a := range actions {
+			for _, f := range flags {
+				msg := &proto.ProtocolMessage{Action: a.action, Channel: "test", Flags: f.flag | proto.FlagResumed}
+				b, err := proto.Marshal(contentType, msg)
+				if err != nil {
+					t.Fatalf("%s: %v", contentType, err)
+				}
+				decoded, err := proto.Unmarshal(contentType, b)
+				if err != nil {
+					t.Fatalf("%s: %v", contentType, err)
+				}
+				if !reflect.DeepEqual(decoded, msg) {
+					t.Fatalf("%s: want %#v; got %#v", contentType, msg, decoded)
+				}
+			}
+		}
+	}
+
+	msg := proto.ProtocolMessage{Flags: proto.FlagPresence | proto.FlagResumed}
+	if !msg.HasPresence() || msg.HasBacklog() || !msg.Resumed() || msg.AttachResume() {
+		t.Errorf("unexpected flags accessors for %x", msg.Flags)
+	}
+}
@@ -0,0 +1,104 @@
+package proto
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// MaxPushPayloadSize is the maximum size of the JSON encoded push payload
+// of a message, which is the size of the payloads APNs accepts.
+const MaxPushPayloadSize = 4096
+
+// PushNotification is the notification displayed by the devices receiving
+// a push notification.
+type PushNotification struct {
+	Title       string `json:"title,omitempty" codec:"title,omitempty"`
+	Body        string `json:"body,omitempty" codec:"body,omitempty"`
+	Icon        string `json:"icon,omitempty" codec:"icon,omitempty"`
+	Sound       string `json:"sound,omitempty" codec:"sound,omitempty"`
+	CollapseKey string `json:"collapseKey,omitempty" codec:"collapseKey,omitempty"`
+}
+
+// PushPayload is a push notification published with a message, and
+// delivered to the devices subscribed to the channel's push notifications.
+type PushPayload struct {
+	Notification PushNotification
+
+	// Data is delivered to the app receiving the notification; its values
+	// must be strings, as required by FCM.
+	Data map[string]interface{}
+
+	// FCM, APNs and Web override the payload sent to devices using each of
+	// those transports, e.g. {"notification": {"sound": "chime.aiff"}}.
+	FCM  map[string]interface{}
+	APNs map[string]interface{}
+	Web  map[string]interface{}
+}
+
+// Validate checks that p can be published: it must have a notification or
+// data, its data values must be strings, and it must fit MaxPushPayloadSize
+// once encoded.
+func (p *PushPayload) Validate() error {
+	if p.Notification == (PushNotification{}) && len(p.Data) == 0 {
+		return errors.New("push payload has neither a notification nor data")
+	}
+	for k, v := range p.Data {
+		if _, ok := v.(string); !ok {
+			return fmt.Errorf("push payload data %q is a %T; it must be a string", k, v)
+		}
+	}
+	b, err := json.Marshal(p.ToMap())
+	if err != nil {
+		return fmt.Errorf("encoding push payload: %w", err)
+	}
+	if len(b) > MaxPushPayloadSize {
+		return fmt.Errorf("push payload is %d bytes; it must be at most %d bytes", len(b), MaxPushPayloadSize)
+	}
+	return nil
+}
+
+// ToMap gives the extras.push value of p.
+func (p *PushPayload) ToMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	n := p.Notification
+	if n != (PushNotification{}) {
+		notification := make(map[string]interface{})
+		for k, v := range map[string]string{
+			"title":       n.Title,
+			"body":        n.Body,
+			"icon":        n.Icon,
+			"sound":       n.Sound,
+			"collapseKey": n.CollapseKey,
+		} {
+			if v != "" {
+				notification[k] = v
+			}
+		}
+		m["notification"] = notification
+	}
+	for k, v := range map[string]map[string]interface{}{
+		"data": p.Data,
+		"fcm":  p.FCM,
+		"apns": p.APNs,
+		"web":  p.Web,
+	} {
+		if len(v) != 0 {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// WithPush validates p and sets it as the push notification published with
+// the message, in its extras. It returns m.
+func (m *Message) WithPush(p *PushPayload) (*Message, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if m.Extras == nil {
+		m.Extras = make(map[string]interface{})
+	}
+	m.Extras["push"] = p.ToMap()
+	return m, nil
+}
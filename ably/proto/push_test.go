@@ -0,0 +1,50 @@
+package proto_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestMessage_WithPush(t *testing.T) {
+	t.Parallel()
+
+	payload := &proto.PushPayload{
+		Notification: proto.PushNotification{Title: "Hello", Body: "World"},
+		Data:         map[string]interface{}{"id": "42"},
+		APNs:         map[string]interface{}{"notification": map[string]interface{}{"sound": "chime.aiff"}},
+	}
+	msg, err := (&proto.Message{Name: "greeting"}).WithPush(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(msg.Extras)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"push":{"apns":{"notification":{"sound":"chime.aiff"}},"data":{"id":"42"},"notification":{"body":"World","title":"Hello"}}}`
+	if got := string(b); got != want {
+		t.Fatalf("want extras %s; got %s", want, got)
+	}
+
+	for _, c := range []struct {
+		desc    string
+		payload *proto.PushPayload
+		err     string
+	}{
+		{"empty", &proto.PushPayload{}, "neither a notification nor data"},
+		{"non-string data", &proto.PushPayload{Data: map[string]interface{}{"n": 1}}, `"n" is a int`},
+		{"too large", &proto.PushPayload{Notification: proto.PushNotification{Body: strings.Repeat("x", proto.MaxPushPayloadSize)}}, "must be at most"},
+	} {
+		msg := &proto.Message{}
+		_, err := msg.WithPush(c.payload)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Errorf("%s: want error containing %q; got %v", c.desc, c.err, err)
+		}
+		if msg.Extras != nil {
+			t.Errorf("%s: want extras unset; got %v", c.desc, msg.Extras)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package proto
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 const (
 	StatGranularityMinute = "minute"
@@ -22,6 +25,22 @@ func IntervalFormatFor(t time.Time, granulatity string) string {
 	return t.Format(intervalFormats[granulatity])
 }
 
+// ParseInterval parses a stats interval ID, as found in Stats.IntervalID
+// and Stats.InProgress, giving the UTC start of the interval and its
+// granularity.
+func ParseInterval(id string) (t time.Time, granularity string, err error) {
+	for _, g := range []string{StatGranularityMinute, StatGranularityHour, StatGranularityDay, StatGranularityMonth} {
+		format := intervalFormats[g]
+		if len(id) != len(format) {
+			continue
+		}
+		if t, err = time.Parse(format, id); err == nil {
+			return t, g, nil
+		}
+	}
+	return time.Time{}, "", fmt.Errorf("invalid stats interval ID %q", id)
+}
+
 type ResourceCount struct {
 	Peak    float64 `json:"peak" codec:"peak"`
 	Min     float64 `json:"min" codec:"min"`
@@ -136,3 +155,26 @@ type Stats struct {
 	XchgConsumer  XchgMessages    `json:"xchgConsumer" codec:"xchgConsumer"`
 	PeakRates     Rates           `json:"peakRates" codec:"peakRates"`
 }
+
+// IntervalTime gives the UTC start of the interval the stats are for.
+func (s *Stats) IntervalTime() (time.Time, error) {
+	t, _, err := ParseInterval(s.IntervalID)
+	return t, err
+}
+
+// IsInProgress tells whether the interval the stats are for isn't over
+// yet, so that they're partial.
+func (s *Stats) IsInProgress() bool {
+	return s.InProgress != ""
+}
+
+// InProgressTime gives the UTC start of the last sub-interval included in
+// the stats of an interval in progress, e.g. the last minute of the current
+// hour; it's zero if the interval is over.
+func (s *Stats) InProgressTime() (time.Time, error) {
+	if !s.IsInProgress() {
+		return time.Time{}, nil
+	}
+	t, _, err := ParseInterval(s.InProgress)
+	return t, err
+}
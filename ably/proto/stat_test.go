@@ -0,0 +1,60 @@
+package proto_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestStats_Intervals(t *testing.T) {
+	t.Parallel()
+
+	var stats []*proto.Stats
+	js := `[
+		{"intervalId": "2024-03-05:14", "unit": "hour", "inProgress": "2024-03-05:14:27"},
+		{"intervalId": "2024-02", "unit": "month"}
+	]`
+	if err := json.Unmarshal([]byte(js), &stats); err != nil {
+		t.Fatal(err)
+	}
+
+	current := stats[0]
+	if !current.IsInProgress() {
+		t.Error("expected the current hour to be in progress")
+	}
+	if at, err := current.IntervalTime(); err != nil || !at.Equal(time.Date(2024, 3, 5, 14, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected interval time %v (err=%v)", at, err)
+	}
+	if at, err := current.InProgressTime(); err != nil || !at.Equal(time.Date(2024, 3, 5, 14, 27, 0, 0, time.UTC)) {
+		t.Errorf("unexpected in progress time %v (err=%v)", at, err)
+	}
+
+	past := stats[1]
+	if past.IsInProgress() {
+		t.Error("expected a past month not to be in progress")
+	}
+	if at, err := past.InProgressTime(); err != nil || !at.IsZero() {
+		t.Errorf("expected no in progress time; got %v (err=%v)", at, err)
+	}
+	if at, err := past.IntervalTime(); err != nil || !at.Equal(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected interval time %v (err=%v)", at, err)
+	}
+
+	for _, id := range []string{"", "2024-03-05T14", "2024-13"} {
+		if _, _, err := proto.ParseInterval(id); err == nil {
+			t.Errorf("expected an error parsing %q", id)
+		}
+	}
+	for id, granularity := range map[string]string{
+		"2024-03-05:14:27": proto.StatGranularityMinute,
+		"2024-03-05:14":    proto.StatGranularityHour,
+		"2024-03-05":       proto.StatGranularityDay,
+		"2024-03":          proto.StatGranularityMonth,
+	} {
+		if _, g, err := proto.ParseInterval(id); err != nil || g != granularity {
+			t.Errorf("expected %q to have granularity %q; got %q (err=%v)", id, granularity, g, err)
+		}
+	}
+}
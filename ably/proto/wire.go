@@ -0,0 +1,128 @@
+package proto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/ugorji/go/codec"
+)
+
+// Content types of the formats protocol messages are encoded in, as given
+// to ably.ClientOptions.Dial.
+const (
+	ContentTypeJSON    = "application/json"
+	ContentTypeMsgpack = "application/x-msgpack"
+)
+
+// msgpackHandle encodes msgpack as the realtime client does.
+var msgpackHandle codec.MsgpackHandle
+
+func init() {
+	msgpackHandle.Raw = true
+	msgpackHandle.WriteExt = true
+}
+
+// Marshal encodes msg in the format of the given content type.
+func Marshal(contentType string, msg *ProtocolMessage) ([]byte, error) {
+	switch contentType {
+	case ContentTypeJSON:
+		return json.Marshal(msg)
+	case ContentTypeMsgpack:
+		var b []byte
+		err := codec.NewEncoderBytes(&b, &msgpackHandle).Encode(msg)
+		return b, err
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// Unmarshal decodes a protocol message in the format of the given content
+// type.
+func Unmarshal(contentType string, data []byte) (*ProtocolMessage, error) {
+	msg := &ProtocolMessage{}
+	var err error
+	switch contentType {
+	case ContentTypeJSON:
+		err = json.Unmarshal(data, msg)
+	case ContentTypeMsgpack:
+		err = codec.NewDecoderBytes(data, &msgpackHandle).Decode(msg)
+	default:
+		err = fmt.Errorf("unsupported content type %q", contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// MaxStreamFrame is the size above which StreamConn refuses to receive a
+// frame, as a corrupted stream would otherwise have it allocate any size.
+const MaxStreamFrame = 16 << 20
+
+// StreamConn is a Conn over a byte stream, e.g. a net.Conn, sending each
+// protocol message framed by its size as a 4-byte big-endian integer. It
+// lets tools exchange protocol messages among themselves; Ably itself is
+// connected to over websockets.
+type StreamConn struct {
+	rwc         io.ReadWriteCloser
+	r           *bufio.Reader
+	contentType string
+	mtx         sync.Mutex // serializes writes
+}
+
+// NewStreamConn gives a StreamConn over rwc, encoding protocol messages in
+// the format of the given content type.
+func NewStreamConn(rwc io.ReadWriteCloser, contentType string) (*StreamConn, error) {
+	if contentType != ContentTypeJSON && contentType != ContentTypeMsgpack {
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+	return &StreamConn{rwc: rwc, r: bufio.NewReader(rwc), contentType: contentType}, nil
+}
+
+// Send writes msg as a single frame.
+func (c *StreamConn) Send(msg *ProtocolMessage) error {
+	b, err := Marshal(c.contentType, msg)
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(frame, uint32(len(b)))
+	copy(frame[4:], b)
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	_, err = c.rwc.Write(frame)
+	return err
+}
+
+// Receive reads the next frame. The deadline is only enforced if the stream
+// has a SetReadDeadline method, as net.Conn does.
+func (c *StreamConn) Receive(deadline time.Time) (*ProtocolMessage, error) {
+	if d, ok := c.rwc.(interface{ SetReadDeadline(time.Time) error }); ok {
+		if err := d.SetReadDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(c.r, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxStreamFrame {
+		return nil, fmt.Errorf("frame of %d bytes exceeds MaxStreamFrame", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return nil, err
+	}
+	return Unmarshal(c.contentType, b)
+}
+
+// Close closes the stream.
+func (c *StreamConn) Close() error {
+	return c.rwc.Close()
+}
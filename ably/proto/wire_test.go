@@ -0,0 +1,77 @@
+package proto_test
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably/internal/ablyutil"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestStreamConn(t *testing.T) {
+	t.Parallel()
+	sent := &proto.ProtocolMessage{
+		Action:    proto.ActionMessage,
+		Channel:   "test",
+		MsgSerial: 3,
+		Messages: []*proto.Message{
+			{Name: "greeting", Data: "hello"},
+			{Name: "binary", Data: []byte{1, 2, 3}},
+		},
+	}
+	for _, contentType := range []string{proto.ContentTypeJSON, proto.ContentTypeMsgpack} {
+		client, server := net.Pipe()
+		clientConn, err := proto.NewStreamConn(client, contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		serverConn, err := proto.NewStreamConn(server, contentType)
+		if err != nil {
+			t.Fatal(err)
+		}
+		go clientConn.Send(sent)
+		got, err := serverConn.Receive(time.Now().Add(time.Second))
+		if err != nil {
+			t.Fatalf("%s: %v", contentType, err)
+		}
+		if got.Action != sent.Action || got.Channel != sent.Channel || got.MsgSerial != sent.MsgSerial || len(got.Messages) != 2 {
+			t.Fatalf("%s: want %v; got %v", contentType, sent, got)
+		}
+		if data := got.Messages[0].Data; data != "hello" {
+			t.Errorf("%s: want data %q; got %#v", contentType, "hello", data)
+		}
+		// The receive deadline is enforced by net.Conn.
+		if _, err := serverConn.Receive(time.Now().Add(10 * time.Millisecond)); err == nil {
+			t.Errorf("%s: expected a timeout receiving", contentType)
+		} else if err, ok := err.(net.Error); !ok || !err.Timeout() {
+			t.Errorf("%s: expected a timeout receiving; got %v", contentType, err)
+		}
+		clientConn.Close()
+		serverConn.Close()
+	}
+}
+
+func TestMarshal_SameAsClient(t *testing.T) {
+	t.Parallel()
+	msg := &proto.ProtocolMessage{
+		Action:   proto.ActionMessage,
+		Channel:  "test",
+		Messages: []*proto.Message{{Name: "greeting", Data: "hello"}},
+	}
+	b, err := proto.Marshal(proto.ContentTypeMsgpack, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := ablyutil.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, expected) {
+		t.Errorf("want msgpack % x; got % x", expected, b)
+	}
+	if _, err := proto.Marshal("text/plain", msg); err == nil {
+		t.Error("expected an error marshaling to an unsupported content type")
+	}
+}
@@ -0,0 +1,105 @@
+package ably
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// ProtocolRecordEvent identifies the kind of a ProtocolRecord.
+type ProtocolRecordEvent string
+
+const (
+	// ProtocolRecordDial is recorded once a transport was opened; the record's
+	// URL is the one dialed.
+	ProtocolRecordDial ProtocolRecordEvent = "dial"
+	// ProtocolRecordSend is recorded before a protocol message is sent.
+	ProtocolRecordSend ProtocolRecordEvent = "send"
+	// ProtocolRecordReceive is recorded once a protocol message was received,
+	// or receiving failed.
+	ProtocolRecordReceive ProtocolRecordEvent = "receive"
+	// ProtocolRecordClose is recorded when the client closes a transport.
+	ProtocolRecordClose ProtocolRecordEvent = "close"
+)
+
+// ProtocolRecord is an entry of a protocol recording written to
+// ClientOptions.ProtocolRecorder. A recording is a sequence of ProtocolRecord
+// values, encoded as JSON one per line.
+type ProtocolRecord struct {
+	Time    time.Time              `json:"time"`
+	Event   ProtocolRecordEvent    `json:"event"`
+	URL     string                 `json:"url,omitempty"`     // for ProtocolRecordDial
+	Message *proto.ProtocolMessage `json:"message,omitempty"` // for ProtocolRecordSend and ProtocolRecordReceive
+	Error   string                 `json:"error,omitempty"`   // for a failed ProtocolRecordReceive
+}
+
+// protocolRecorder writes the protocol messages exchanged over the realtime
+// transports of a connection.
+type protocolRecorder struct {
+	mtx    sync.Mutex
+	enc    *json.Encoder
+	now    func() time.Time
+	logger func() *LoggerOptions
+	failed bool
+}
+
+func newProtocolRecorder(w io.Writer, opts *ClientOptions, logger func() *LoggerOptions) *protocolRecorder {
+	return &protocolRecorder{
+		enc:    json.NewEncoder(w),
+		now:    opts.now,
+		logger: logger,
+	}
+}
+
+// wrap records the dialing of u and gives conn recording everything sent
+// over it. Credentials in the URL aren't recorded.
+func (r *protocolRecorder) wrap(conn proto.Conn, u *url.URL) proto.Conn {
+	r.record(ProtocolRecord{
+		Event: ProtocolRecordDial,
+		URL:   redactQueryParam.ReplaceAllString(u.String(), "${1}"+redacted),
+	})
+	return recordingConn{conn: conn, rec: r}
+}
+
+func (r *protocolRecorder) record(rec ProtocolRecord) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	rec.Time = r.now()
+	if err := r.enc.Encode(rec); err != nil && !r.failed {
+		// Report only the first failure, as the writer is likely broken for
+		// good.
+		r.failed = true
+		r.logger().Printf(LogWarning, "Realtime Connection: failed to record protocol message: %v", err)
+	}
+}
+
+type recordingConn struct {
+	conn proto.Conn
+	rec  *protocolRecorder
+}
+
+func (rc recordingConn) Send(msg *proto.ProtocolMessage) error {
+	// Recording before sending keeps the send ahead of the server's reply in
+	// the recording.
+	rc.rec.record(ProtocolRecord{Event: ProtocolRecordSend, Message: msg})
+	return rc.conn.Send(msg)
+}
+
+func (rc recordingConn) Receive(deadline time.Time) (*proto.ProtocolMessage, error) {
+	msg, err := rc.conn.Receive(deadline)
+	if err != nil {
+		rc.rec.record(ProtocolRecord{Event: ProtocolRecordReceive, Error: err.Error()})
+		return nil, err
+	}
+	rc.rec.record(ProtocolRecord{Event: ProtocolRecordReceive, Message: msg})
+	return msg, nil
+}
+
+func (rc recordingConn) Close() error {
+	rc.rec.record(ProtocolRecord{Event: ProtocolRecordClose})
+	return rc.conn.Close()
+}
@@ -0,0 +1,90 @@
+package ably_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablymock"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestClientOptions_ProtocolRecorder(t *testing.T) {
+	t.Parallel()
+
+	// session attaches, publishes and receives the echoed message, then
+	// closes the client.
+	session := func(t *testing.T, opts *ably.ClientOptions) {
+		t.Helper()
+		opts.Key = "xxxxxxx.yyyyyyy:zzzzzzz"
+		client, err := ably.NewRealtimeClient(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		channel := client.Channels.Get("test")
+		sub, err := channel.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+		if err := ablytest.Wait(channel.Attach()); err != nil {
+			t.Fatal(err)
+		}
+		if err := ablytest.Wait(channel.Publish("event", "data")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case msg := <-sub.MessageChannel():
+			if msg.Name != "event" || msg.Data != "data" {
+				t.Errorf("want event=data; got %s=%v", msg.Name, msg.Data)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for message")
+		}
+		if err := client.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var recording bytes.Buffer
+	server := ablymock.NewServer(nil)
+	session(t, &ably.ClientOptions{
+		Dial:             server.Dial,
+		ProtocolRecorder: &recording,
+	})
+
+	var sent []proto.Action
+	for _, line := range strings.Split(strings.TrimSpace(recording.String()), "\n") {
+		var rec ably.ProtocolRecord
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		if rec.Time.IsZero() {
+			t.Errorf("want timestamp in %q", line)
+		}
+		switch rec.Event {
+		case ably.ProtocolRecordDial:
+			if strings.Contains(rec.URL, "zzzzzzz") {
+				t.Errorf("want key redacted from %q", rec.URL)
+			}
+		case ably.ProtocolRecordSend:
+			sent = append(sent, rec.Message.Action)
+		}
+	}
+	assertDeepEquals(t, []proto.Action{proto.ActionAttach, proto.ActionMessage, proto.ActionClose}, sent)
+
+	replayer, err := ablytest.NewProtocolReplayer(&recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session(t, &ably.ClientOptions{Dial: replayer.Dial})
+	var replayed []proto.Action
+	for _, msg := range replayer.Sent() {
+		replayed = append(replayed, msg.Action)
+	}
+	assertDeepEquals(t, sent, replayed)
+}
@@ -0,0 +1,584 @@
+package ably
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// PushActivationState is a state of the push activation state machine of
+// the local device (RSH3).
+type PushActivationState int
+
+const (
+	PushNotActivated PushActivationState = iota
+	PushWaitingForPushDeviceDetails
+	PushWaitingForDeviceRegistration
+	PushWaitingForNewPushDeviceDetails
+	PushWaitingForRegistrationSync
+	PushAfterRegistrationSyncFailed
+	PushWaitingForDeregistration
+)
+
+var pushActivationStateNames = map[PushActivationState]string{
+	PushNotActivated:                   "NotActivated",
+	PushWaitingForPushDeviceDetails:    "WaitingForPushDeviceDetails",
+	PushWaitingForDeviceRegistration:   "WaitingForDeviceRegistration",
+	PushWaitingForNewPushDeviceDetails: "WaitingForNewPushDeviceDetails",
+	PushWaitingForRegistrationSync:     "WaitingForRegistrationSync",
+	PushAfterRegistrationSyncFailed:    "AfterRegistrationSyncFailed",
+	PushWaitingForDeregistration:       "WaitingForDeregistration",
+}
+
+func (s PushActivationState) String() string {
+	return pushActivationStateNames[s]
+}
+
+// DeviceDetails describes a device registered as a push target (PCD).
+type DeviceDetails struct {
+	ID           string                 `json:"id" codec:"id"`
+	ClientID     string                 `json:"clientId,omitempty" codec:"clientId,omitempty"`
+	FormFactor   string                 `json:"formFactor" codec:"formFactor"`
+	Platform     string                 `json:"platform" codec:"platform"`
+	Metadata     map[string]interface{} `json:"metadata,omitempty" codec:"metadata,omitempty"`
+	DeviceSecret string                 `json:"deviceSecret,omitempty" codec:"deviceSecret,omitempty"`
+	Push         DevicePushDetails      `json:"push" codec:"push"`
+}
+
+// DevicePushDetails gives where and how push notifications are delivered
+// to a device (PCP).
+type DevicePushDetails struct {
+	// Recipient identifies the device with its push transport, e.g.
+	// {"transportType": "fcm", "registrationToken": "..."}.
+	Recipient   map[string]interface{} `json:"recipient,omitempty" codec:"recipient,omitempty"`
+	State       string                 `json:"state,omitempty" codec:"state,omitempty"`
+	ErrorReason *proto.ErrorInfo       `json:"errorReason,omitempty" codec:"errorReason,omitempty"`
+}
+
+// LocalDevice is the device the client runs on, as registered for push
+// notifications by Push.Activate (RSH8).
+type LocalDevice struct {
+	DeviceDetails
+
+	// DeviceIdentityToken is given by Ably once the device is registered,
+	// and authenticates the device's requests to update its registration.
+	DeviceIdentityToken string `json:"deviceIdentityToken,omitempty"`
+}
+
+// deviceRegistration is the response to a device registration.
+type deviceRegistration struct {
+	DeviceDetails
+	DeviceIdentityToken struct {
+		Token string `json:"token" codec:"token"`
+	} `json:"deviceIdentityToken" codec:"deviceIdentityToken"`
+}
+
+// DeviceStorage persists the local device and the state of its push
+// activation, so that a device keeps its identity and registration across
+// restarts (RSH8a).
+type DeviceStorage interface {
+	// Load gives the data last saved, or nil if there's none.
+	Load() ([]byte, error)
+	Save(data []byte) error
+}
+
+// NewFileDeviceStorage gives a DeviceStorage keeping its data in the file at
+// path, which is created when first saved.
+func NewFileDeviceStorage(path string) DeviceStorage {
+	return fileDeviceStorage(path)
+}
+
+type fileDeviceStorage string
+
+func (s fileDeviceStorage) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(string(s))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s fileDeviceStorage) Save(data []byte) error {
+	return ioutil.WriteFile(string(s), data, 0600)
+}
+
+type memDeviceStorage struct {
+	data []byte
+}
+
+func (s *memDeviceStorage) Load() ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memDeviceStorage) Save(data []byte) error {
+	s.data = data
+	return nil
+}
+
+// PushOptions configures the registration of the local device for push
+// notifications.
+type PushOptions struct {
+	// Storage persists the local device. If nil, it's kept in memory and the
+	// device gets a new identity whenever the process restarts.
+	Storage DeviceStorage
+
+	// Platform is the platform of the device: "android", "ios" or "browser".
+	// If empty, it's given by the recipient's transport: "android" for
+	// "fcm", "ios" for "apns" and "browser" for "web".
+	Platform string
+
+	// FormFactor is the form factor of the device, e.g. "desktop" or "tv";
+	// it defaults to "embedded".
+	FormFactor string
+
+	// Metadata is registered with the device.
+	Metadata map[string]interface{}
+}
+
+var platformsByTransport = map[string]string{
+	"fcm":  "android",
+	"apns": "ios",
+	"web":  "browser",
+}
+
+type pushEventKind int
+
+const (
+	pushCalledActivate pushEventKind = iota
+	pushCalledDeactivate
+	pushGotPushDeviceDetails
+	pushGotDeviceRegistration
+	pushGettingDeviceRegistrationFailed
+	pushRegistrationSynced
+	pushSyncRegistrationFailed
+	pushDeregistrationSucceeded
+	pushDeregistrationFailed
+)
+
+type pushEvent struct {
+	kind      pushEventKind
+	err       error
+	token     string                 // for pushGotDeviceRegistration
+	recipient map[string]interface{} // for pushGotPushDeviceDetails
+}
+
+// pushData is what Push saves in its DeviceStorage.
+type pushData struct {
+	State  PushActivationState `json:"state"`
+	Device LocalDevice         `json:"device"`
+}
+
+// Push registers the local device for push notifications with Ably, as
+// targets of notifications published to the device or to its client ID, or
+// on the channels it subscribes to with PushChannel.
+//
+// Ably needs the device's recipient, given by the push transport, e.g. the
+// registration token of FCM, to register it: it's given with SetRecipient,
+// which can be called before or after Activate.
+type Push struct {
+	// Admin publishes push notifications directly to devices.
+	Admin *PushAdmin
+
+	client *RestClient
+
+	// mtx guards the fields below, and serializes the events of the state
+	// machine.
+	mtx          sync.Mutex
+	loaded       bool
+	state        PushActivationState
+	device       LocalDevice
+	pending      []pushEvent
+	activating   []chan error
+	deactivating []chan error
+}
+
+func newPush(client *RestClient) *Push {
+	return &Push{Admin: &PushAdmin{client: client}, client: client}
+}
+
+// Activate registers the local device with Ably if it isn't already, and
+// blocks until it's registered, ctx is done or the registration fails
+// (RSH2a). Registering waits for the recipient of the device to be given
+// with SetRecipient.
+func (p *Push) Activate(ctx context.Context) error {
+	return p.call(ctx, pushCalledActivate, &p.activating)
+}
+
+// Deactivate deregisters the local device from Ably, and blocks until it's
+// deregistered, ctx is done or the deregistration fails (RSH2b). The device
+// gets a new identity when activated again.
+func (p *Push) Deactivate(ctx context.Context) error {
+	return p.call(ctx, pushCalledDeactivate, &p.deactivating)
+}
+
+func (p *Push) call(ctx context.Context, kind pushEventKind, waiters *[]chan error) error {
+	res := make(chan error, 1)
+	p.mtx.Lock()
+	*waiters = append(*waiters, res)
+	p.mtx.Unlock()
+	if err := p.handle(ctx, pushEvent{kind: kind}); err != nil {
+		p.removeWaiter(waiters, res)
+		return err
+	}
+	select {
+	case err := <-res:
+		return err
+	case <-ctx.Done():
+		p.removeWaiter(waiters, res)
+		return ctx.Err()
+	}
+}
+
+func (p *Push) removeWaiter(waiters *[]chan error, res chan error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for i, w := range *waiters {
+		if w == res {
+			*waiters = append((*waiters)[:i], (*waiters)[i+1:]...)
+			return
+		}
+	}
+}
+
+// SetRecipient gives the recipient of the local device, e.g.
+// {"transportType": "fcm", "registrationToken": "..."}, when it's first
+// obtained from the push transport or whenever it changes, e.g. when the
+// registration token is refreshed (RSH3, GotPushDeviceDetails). The
+// registration of an activated device is updated with it.
+func (p *Push) SetRecipient(ctx context.Context, recipient map[string]interface{}) error {
+	return p.handle(ctx, pushEvent{kind: pushGotPushDeviceDetails, recipient: recipient})
+}
+
+// State gives the state of the activation of the local device.
+func (p *Push) State() (PushActivationState, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if err := p.load(); err != nil {
+		return 0, err
+	}
+	return p.state, nil
+}
+
+// LocalDevice gives the local device.
+func (p *Push) LocalDevice() (LocalDevice, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if err := p.load(); err != nil {
+		return LocalDevice{}, err
+	}
+	return p.device, nil
+}
+
+func (p *Push) opts() *PushOptions {
+	return &p.client.opts.Push
+}
+
+// load restores the state machine from storage the first time it's needed.
+func (p *Push) load() error {
+	if p.loaded {
+		return nil
+	}
+	if p.opts().Storage == nil {
+		p.opts().Storage = &memDeviceStorage{}
+	}
+	data, err := p.opts().Storage.Load()
+	if err != nil {
+		return newError(ErrInternalError, err)
+	}
+	if data != nil {
+		var d pushData
+		if err := json.Unmarshal(data, &d); err != nil {
+			return newError(ErrInternalError, err)
+		}
+		p.state, p.device = restoredPushState(d.State), d.Device
+	}
+	p.loaded = true
+	return nil
+}
+
+// restoredPushState gives the state to resume from after a restart in the
+// middle of a request to Ably.
+func restoredPushState(state PushActivationState) PushActivationState {
+	switch state {
+	case PushWaitingForDeviceRegistration:
+		return PushWaitingForPushDeviceDetails
+	case PushWaitingForRegistrationSync:
+		return PushAfterRegistrationSyncFailed
+	case PushWaitingForDeregistration:
+		return PushWaitingForNewPushDeviceDetails
+	}
+	return state
+}
+
+func (p *Push) save() error {
+	data, err := json.Marshal(pushData{State: p.state, Device: p.device})
+	if err != nil {
+		return newError(ErrInternalError, err)
+	}
+	if err := p.opts().Storage.Save(data); err != nil {
+		return newError(ErrInternalError, err)
+	}
+	return nil
+}
+
+// handle runs ev through the state machine, together with the events
+// following from it. Events a state doesn't handle are queued until the
+// state changes.
+func (p *Push) handle(ctx context.Context, ev pushEvent) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if err := p.load(); err != nil {
+		return err
+	}
+	events := []pushEvent{ev}
+	for len(events) > 0 {
+		ev := events[0]
+		events = events[1:]
+		next, handled, follow := p.transition(ctx, ev)
+		if !handled {
+			p.pending = append(p.pending, ev)
+			continue
+		}
+		if follow != nil {
+			events = append([]pushEvent{*follow}, events...)
+		}
+		if next != p.state {
+			p.client.logger().Printf(LogVerbose, "Push: activation state %v -> %v", p.state, next)
+			p.state = next
+			events = append(events, p.pending...)
+			p.pending = nil
+		}
+	}
+	return p.save()
+}
+
+// transition gives the state ev leads to from the current one, whether it's
+// handled, and the event following from it, if any (RSH3).
+func (p *Push) transition(ctx context.Context, ev pushEvent) (next PushActivationState, handled bool, follow *pushEvent) {
+	if ev.kind == pushGotPushDeviceDetails && ev.recipient != nil {
+		p.device.Push.Recipient = ev.recipient
+	}
+	switch p.state {
+	case PushNotActivated:
+		switch ev.kind {
+		case pushCalledActivate:
+			if p.device.DeviceIdentityToken != "" {
+				p.notify(&p.activating, nil)
+				return PushWaitingForNewPushDeviceDetails, true, nil
+			}
+			if err := p.ensureIdentity(); err != nil {
+				p.notify(&p.activating, err)
+				return p.state, true, nil
+			}
+			return PushWaitingForPushDeviceDetails, true, p.gotRecipient()
+		case pushCalledDeactivate:
+			p.notify(&p.deactivating, nil)
+		}
+		return p.state, true, nil
+
+	case PushWaitingForPushDeviceDetails:
+		switch ev.kind {
+		case pushCalledActivate:
+			return p.state, true, p.gotRecipient()
+		case pushCalledDeactivate:
+			p.notify(&p.deactivating, nil)
+			return PushNotActivated, true, nil
+		case pushGotPushDeviceDetails:
+			return PushWaitingForDeviceRegistration, true, p.register(ctx)
+		}
+		return p.state, true, nil
+
+	case PushWaitingForDeviceRegistration:
+		switch ev.kind {
+		case pushCalledActivate:
+			return p.state, true, nil
+		case pushGotDeviceRegistration:
+			p.device.DeviceIdentityToken = ev.token
+			p.notify(&p.activating, nil)
+			return PushWaitingForNewPushDeviceDetails, true, nil
+		case pushGettingDeviceRegistrationFailed:
+			p.notify(&p.activating, ev.err)
+			return PushNotActivated, true, nil
+		}
+		return p.state, false, nil
+
+	case PushWaitingForNewPushDeviceDetails:
+		switch ev.kind {
+		case pushCalledActivate:
+			p.notify(&p.activating, nil)
+			return p.state, true, nil
+		case pushCalledDeactivate:
+			return PushWaitingForDeregistration, true, p.deregister(ctx)
+		case pushGotPushDeviceDetails:
+			return PushWaitingForRegistrationSync, true, p.update(ctx)
+		}
+		return p.state, true, nil
+
+	case PushWaitingForRegistrationSync:
+		switch ev.kind {
+		case pushRegistrationSynced:
+			p.notify(&p.activating, nil)
+			return PushWaitingForNewPushDeviceDetails, true, nil
+		case pushSyncRegistrationFailed:
+			p.notify(&p.activating, ev.err)
+			return PushAfterRegistrationSyncFailed, true, nil
+		}
+		return p.state, false, nil
+
+	case PushAfterRegistrationSyncFailed:
+		switch ev.kind {
+		case pushCalledActivate, pushGotPushDeviceDetails:
+			return PushWaitingForRegistrationSync, true, p.update(ctx)
+		case pushCalledDeactivate:
+			return PushWaitingForDeregistration, true, p.deregister(ctx)
+		}
+		return p.state, true, nil
+
+	case PushWaitingForDeregistration:
+		switch ev.kind {
+		case pushCalledDeactivate:
+			return p.state, true, nil
+		case pushDeregistrationSucceeded:
+			p.resetDevice()
+			p.notify(&p.deactivating, nil)
+			return PushNotActivated, true, nil
+		case pushDeregistrationFailed:
+			p.notify(&p.deactivating, ev.err)
+			return PushWaitingForNewPushDeviceDetails, true, nil
+		}
+		return p.state, false, nil
+	}
+	return p.state, true, nil
+}
+
+// gotRecipient gives a GotPushDeviceDetails event if the recipient of the
+// device is already known.
+func (p *Push) gotRecipient() *pushEvent {
+	if p.device.Push.Recipient == nil {
+		return nil
+	}
+	return &pushEvent{kind: pushGotPushDeviceDetails}
+}
+
+func (p *Push) notify(waiters *[]chan error, err error) {
+	for _, w := range *waiters {
+		w <- err
+	}
+	*waiters = nil
+}
+
+// ensureIdentity gives the device an ID and a secret if it has none yet
+// (RSH8b).
+func (p *Push) ensureIdentity() error {
+	if p.device.ID != "" {
+		return nil
+	}
+	id, err := ULIDGenerator{}.NewID()
+	if err != nil {
+		return newError(ErrInternalError, err)
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return newError(ErrInternalError, err)
+	}
+	p.device.ID = id
+	p.device.DeviceSecret = base64.StdEncoding.EncodeToString(secret)
+	return nil
+}
+
+// resetDevice forgets the identity of the deregistered device, keeping its
+// recipient (RSH3g2a).
+func (p *Push) resetDevice() {
+	p.device = LocalDevice{DeviceDetails: DeviceDetails{
+		Push: DevicePushDetails{Recipient: p.device.Push.Recipient},
+	}}
+}
+
+// register registers the device with Ably (RSH3b3).
+func (p *Push) register(ctx context.Context) *pushEvent {
+	d := &p.device.DeviceDetails
+	d.ClientID = p.client.Auth.ClientID()
+	d.Platform = p.opts().Platform
+	if d.Platform == "" {
+		transport, _ := d.Push.Recipient["transportType"].(string)
+		d.Platform = platformsByTransport[transport]
+	}
+	d.FormFactor = p.opts().FormFactor
+	if d.FormFactor == "" {
+		d.FormFactor = "embedded"
+	}
+	d.Metadata = p.opts().Metadata
+	var reg deviceRegistration
+	_, err := p.client.do(&Request{
+		Method: "POST",
+		Path:   "/push/deviceRegistrations",
+		In:     d,
+		Out:    &reg,
+		ctx:    ctx,
+	})
+	if err != nil {
+		return &pushEvent{kind: pushGettingDeviceRegistrationFailed, err: err}
+	}
+	return &pushEvent{kind: pushGotDeviceRegistration, token: reg.DeviceIdentityToken.Token}
+}
+
+// update updates the recipient of the registered device (RSH3d3).
+func (p *Push) update(ctx context.Context) *pushEvent {
+	_, err := p.client.do(&Request{
+		Method: "PATCH",
+		Path:   "/push/deviceRegistrations/" + encodeURIComponent.Replace(p.device.ID),
+		In:     map[string]interface{}{"push": map[string]interface{}{"recipient": p.device.Push.Recipient}},
+		header: p.deviceAuthHeader(),
+		ctx:    ctx,
+	})
+	if err != nil {
+		return &pushEvent{kind: pushSyncRegistrationFailed, err: err}
+	}
+	return &pushEvent{kind: pushRegistrationSynced}
+}
+
+// deregister deregisters the device from Ably (RSH3d2).
+func (p *Push) deregister(ctx context.Context) *pushEvent {
+	_, err := p.client.do(&Request{
+		Method: "DELETE",
+		Path:   "/push/deviceRegistrations/" + encodeURIComponent.Replace(p.device.ID),
+		header: p.deviceAuthHeader(),
+		ctx:    ctx,
+	})
+	if err != nil {
+		return &pushEvent{kind: pushDeregistrationFailed, err: err}
+	}
+	return &pushEvent{kind: pushDeregistrationSucceeded}
+}
+
+// deviceAuthHeader gives the header authenticating requests on behalf of
+// the device (RSH6).
+func (p *Push) deviceAuthHeader() http.Header {
+	h := make(http.Header)
+	switch {
+	case p.device.DeviceIdentityToken != "":
+		h.Set("X-Ably-DeviceToken", base64.StdEncoding.EncodeToString([]byte(p.device.DeviceIdentityToken)))
+	case p.device.DeviceSecret != "":
+		h.Set("X-Ably-DeviceSecret", p.device.DeviceSecret)
+	}
+	return h
+}
+
+// NewPushPayload gives a push notification to publish with a message, with
+// proto.Message.WithPush, from notification and data, whose values must be
+// strings. The transport overrides of the returned payload can be set before
+// publishing it.
+func NewPushPayload(notification proto.PushNotification, data map[string]interface{}) (*proto.PushPayload, error) {
+	p := &proto.PushPayload{Notification: notification, Data: data}
+	if err := p.Validate(); err != nil {
+		return nil, newError(ErrInvalidParameterValue, err)
+	}
+	return p, nil
+}
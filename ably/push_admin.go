@@ -0,0 +1,106 @@
+package ably
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// PushRecipient is the target of a push notification published with
+// PushAdmin.Publish: a DeviceIDRecipient, a ClientIDRecipient, a
+// FCMTokenRecipient or an APNSTokenRecipient.
+type PushRecipient interface {
+	// recipient gives the recipient's representation sent to Ably, or an
+	// error if it's malformed.
+	recipient() (map[string]interface{}, error)
+}
+
+// DeviceIDRecipient targets the device registered with the given ID.
+type DeviceIDRecipient struct {
+	DeviceID string
+}
+
+func (r DeviceIDRecipient) recipient() (map[string]interface{}, error) {
+	if r.DeviceID == "" {
+		return nil, errInvalidRecipient("DeviceIDRecipient", "DeviceID")
+	}
+	return map[string]interface{}{"deviceId": r.DeviceID}, nil
+}
+
+// ClientIDRecipient targets all the devices registered with the given
+// client ID.
+type ClientIDRecipient struct {
+	ClientID string
+}
+
+func (r ClientIDRecipient) recipient() (map[string]interface{}, error) {
+	if r.ClientID == "" {
+		return nil, errInvalidRecipient("ClientIDRecipient", "ClientID")
+	}
+	return map[string]interface{}{"clientId": r.ClientID}, nil
+}
+
+// FCMTokenRecipient targets the device with the given FCM registration
+// token, whether it's registered with Ably or not.
+type FCMTokenRecipient struct {
+	RegistrationToken string
+}
+
+func (r FCMTokenRecipient) recipient() (map[string]interface{}, error) {
+	if r.RegistrationToken == "" || strings.ContainsAny(r.RegistrationToken, " \t\r\n") {
+		return nil, errInvalidRecipient("FCMTokenRecipient", "RegistrationToken")
+	}
+	return map[string]interface{}{"transportType": "fcm", "registrationToken": r.RegistrationToken}, nil
+}
+
+// APNSTokenRecipient targets the device with the given APNs device token,
+// a hexadecimal string, whether it's registered with Ably or not.
+type APNSTokenRecipient struct {
+	DeviceToken string
+}
+
+func (r APNSTokenRecipient) recipient() (map[string]interface{}, error) {
+	if _, err := hex.DecodeString(r.DeviceToken); r.DeviceToken == "" || err != nil {
+		return nil, errInvalidRecipient("APNSTokenRecipient", "DeviceToken")
+	}
+	return map[string]interface{}{"transportType": "apns", "deviceToken": r.DeviceToken}, nil
+}
+
+func errInvalidRecipient(typ, field string) error {
+	return newErrorf(ErrInvalidParameterValue, "invalid push recipient: %s has a missing or malformed %s", typ, field)
+}
+
+// PushAdmin publishes push notifications directly to devices, and requires
+// the push-admin capability (RSH1).
+type PushAdmin struct {
+	client *RestClient
+}
+
+// Publish publishes payload to recipient (RSH1a). The recipient and the
+// payload are validated before sending the request.
+func (a *PushAdmin) Publish(ctx context.Context, recipient PushRecipient, payload *proto.PushPayload) error {
+	if recipient == nil {
+		return newErrorf(ErrInvalidParameterValue, "missing push recipient")
+	}
+	r, err := recipient.recipient()
+	if err != nil {
+		return err
+	}
+	if payload == nil {
+		return newErrorf(ErrInvalidParameterValue, "missing push payload")
+	}
+	if err := payload.Validate(); err != nil {
+		return newError(ErrInvalidParameterValue, err)
+	}
+	body := payload.ToMap()
+	body["recipient"] = r
+	_, err = a.client.do(&Request{
+		Method: "POST",
+		Path:   "/push/publish",
+		In:     body,
+		ctx:    ctx,
+	})
+	return err
+}
@@ -0,0 +1,195 @@
+package ably
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+)
+
+// PushChannelSubscription subscribes a device, or all the devices of a
+// client ID, to the push notifications of a channel (PCS).
+type PushChannelSubscription struct {
+	Channel  string `json:"channel" codec:"channel"`
+	DeviceID string `json:"deviceId,omitempty" codec:"deviceId,omitempty"`
+	ClientID string `json:"clientId,omitempty" codec:"clientId,omitempty"`
+}
+
+var pushChannelSubscriptionType = reflect.TypeOf((*[]*PushChannelSubscription)(nil)).Elem()
+
+// PushSubscriptionsParams selects the subscriptions listed by
+// PushChannel.ListSubscriptions.
+type PushSubscriptionsParams struct {
+	DeviceID string // lists only the subscriptions of the device, if set
+	ClientID string // lists only the subscriptions of the client ID, if set
+	Limit    int    // maximum number of subscriptions per page, if set
+}
+
+// PushChannel subscribes the local device, or its client ID, to the push
+// notifications published on a channel (RSH7).
+type PushChannel struct {
+	name   string
+	client *RestClient
+}
+
+func newPushChannel(name string, client *RestClient) *PushChannel {
+	return &PushChannel{name: name, client: client}
+}
+
+// SubscribeDevice subscribes the local device to the channel's push
+// notifications. The device must be activated with Push.Activate (RSH7a).
+func (c *PushChannel) SubscribeDevice(ctx context.Context) error {
+	device, header, err := c.device()
+	if err != nil {
+		return err
+	}
+	return c.subscribe(ctx, &PushChannelSubscription{DeviceID: device.ID}, header)
+}
+
+// UnsubscribeDevice unsubscribes the local device from the channel's push
+// notifications (RSH7c).
+func (c *PushChannel) UnsubscribeDevice(ctx context.Context) error {
+	device, header, err := c.device()
+	if err != nil {
+		return err
+	}
+	return c.unsubscribe(ctx, &PushChannelSubscription{DeviceID: device.ID}, header)
+}
+
+// SubscribeClient subscribes the client ID of the client, and so all the
+// devices registered with it, to the channel's push notifications (RSH7b).
+func (c *PushChannel) SubscribeClient(ctx context.Context) error {
+	clientID, err := c.clientID()
+	if err != nil {
+		return err
+	}
+	return c.subscribe(ctx, &PushChannelSubscription{ClientID: clientID}, nil)
+}
+
+// UnsubscribeClient unsubscribes the client ID of the client from the
+// channel's push notifications (RSH7d).
+func (c *PushChannel) UnsubscribeClient(ctx context.Context) error {
+	clientID, err := c.clientID()
+	if err != nil {
+		return err
+	}
+	return c.unsubscribe(ctx, &PushChannelSubscription{ClientID: clientID}, nil)
+}
+
+// SubscribeDeviceID subscribes the device with the given ID to the
+// channel's push notifications. It's meant for servers managing the
+// subscriptions of devices, and requires the push-admin capability.
+func (c *PushChannel) SubscribeDeviceID(ctx context.Context, deviceID string) error {
+	return c.subscribe(ctx, &PushChannelSubscription{DeviceID: deviceID}, nil)
+}
+
+// SubscribeClientID subscribes all the devices of the given client ID to
+// the channel's push notifications. It's meant for servers managing the
+// subscriptions of clients, and requires the push-admin capability.
+func (c *PushChannel) SubscribeClientID(ctx context.Context, clientID string) error {
+	return c.subscribe(ctx, &PushChannelSubscription{ClientID: clientID}, nil)
+}
+
+// Unsubscribe removes the subscription of sub's device or client ID to the
+// channel's push notifications; sub.Channel is ignored. It requires the
+// push-admin capability.
+func (c *PushChannel) Unsubscribe(ctx context.Context, sub PushChannelSubscription) error {
+	return c.unsubscribe(ctx, &sub, nil)
+}
+
+// ListSubscriptions gives the subscriptions to the channel's push
+// notifications selected by params, which may be nil to list them all. The
+// returned result can be inspected for the subscriptions via the
+// PushChannelSubscriptions method. It requires the push-admin capability.
+func (c *PushChannel) ListSubscriptions(ctx context.Context, params *PushSubscriptionsParams) (*PaginatedResult, error) {
+	query := url.Values{"channel": {c.name}}
+	if params != nil {
+		if params.DeviceID != "" {
+			query.Set("deviceId", params.DeviceID)
+		}
+		if params.ClientID != "" {
+			query.Set("clientId", params.ClientID)
+		}
+		if params.Limit > 0 {
+			query.Set("limit", strconv.Itoa(params.Limit))
+		}
+	}
+	get := func(path string) (*http.Response, error) {
+		return c.client.do(&Request{Method: "GET", Path: path, ctx: ctx})
+	}
+	return newPaginatedResult(nil, paginatedRequest{
+		typ:       pushChannelSubscriptionType,
+		path:      "/push/channelSubscriptions",
+		extra:     query,
+		query:     get,
+		logger:    c.client.logger(),
+		respCheck: checkValidHTTPResponse,
+	})
+}
+
+func (c *PushChannel) subscribe(ctx context.Context, sub *PushChannelSubscription, header http.Header) error {
+	if err := c.checkSubscription(sub); err != nil {
+		return err
+	}
+	sub.Channel = c.name
+	_, err := c.client.do(&Request{
+		Method: "POST",
+		Path:   "/push/channelSubscriptions",
+		In:     sub,
+		header: header,
+		ctx:    ctx,
+	})
+	return err
+}
+
+func (c *PushChannel) unsubscribe(ctx context.Context, sub *PushChannelSubscription, header http.Header) error {
+	if err := c.checkSubscription(sub); err != nil {
+		return err
+	}
+	query := url.Values{"channel": {c.name}}
+	if sub.DeviceID != "" {
+		query.Set("deviceId", sub.DeviceID)
+	}
+	if sub.ClientID != "" {
+		query.Set("clientId", sub.ClientID)
+	}
+	_, err := c.client.do(&Request{
+		Method: "DELETE",
+		Path:   "/push/channelSubscriptions?" + query.Encode(),
+		header: header,
+		ctx:    ctx,
+	})
+	return err
+}
+
+// checkSubscription checks that sub has either a device ID or a client ID.
+func (c *PushChannel) checkSubscription(sub *PushChannelSubscription) error {
+	if (sub.DeviceID == "") == (sub.ClientID == "") {
+		return newErrorf(ErrInvalidParameterValue, "push subscription to channel %q must have either a device ID or a client ID", c.name)
+	}
+	return nil
+}
+
+// device gives the activated local device, and the header authenticating
+// requests on its behalf.
+func (c *PushChannel) device() (LocalDevice, http.Header, error) {
+	push := c.client.Push
+	push.mtx.Lock()
+	defer push.mtx.Unlock()
+	if err := push.load(); err != nil {
+		return LocalDevice{}, nil, err
+	}
+	if push.device.DeviceIdentityToken == "" {
+		return LocalDevice{}, nil, newErrorf(ErrBadRequest, "cannot subscribe the local device to channel %q's push notifications: the device isn't activated", c.name)
+	}
+	return push.device, push.deviceAuthHeader(), nil
+}
+
+func (c *PushChannel) clientID() (string, error) {
+	clientID := c.client.Auth.ClientID()
+	if clientID == "" {
+		return "", newErrorf(ErrBadRequest, "cannot subscribe to channel %q's push notifications: the client has no ClientID", c.name)
+	}
+	return clientID, nil
+}
@@ -0,0 +1,416 @@
+package ably_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// pushServer fakes the push admin endpoints Push and PushChannel use.
+type pushServer struct {
+	*httptest.Server
+	mtx      sync.Mutex
+	requests []pushRequest
+	fail     bool
+}
+
+type pushRequest struct {
+	method, path string
+	header       http.Header
+	body         map[string]interface{}
+}
+
+func newPushServer(t *testing.T) *pushServer {
+	s := &pushServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := pushRequest{method: r.Method, path: r.URL.RequestURI(), header: r.Header}
+		if r.ContentLength > 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req.body); err != nil {
+				t.Error(err)
+			}
+		}
+		s.mtx.Lock()
+		s.requests = append(s.requests, req)
+		fail := s.fail
+		s.mtx.Unlock()
+		if fail {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":{"code":40000,"statusCode":400,"message":"rejected"}}`))
+			return
+		}
+		if r.Method == "POST" && r.URL.Path == "/push/deviceRegistrations" {
+			resp := map[string]interface{}{"deviceIdentityToken": map[string]interface{}{"token": "identity"}}
+			for k, v := range req.body {
+				resp[k] = v
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	return s
+}
+
+func (s *pushServer) takeRequests() []pushRequest {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	requests := s.requests
+	s.requests = nil
+	return requests
+}
+
+func TestPush_Activate(t *testing.T) {
+	t.Parallel()
+
+	server := newPushServer(t)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "ably-push")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	storage := ably.NewFileDeviceStorage(filepath.Join(dir, "device.json"))
+	client := newTestRestClient(t, server.Server, func(o *ably.ClientOptions) {
+		o.ClientID = "alice"
+		o.Push.Storage = storage
+	})
+	ctx := context.Background()
+	checkState := func(t *testing.T, client *ably.RestClient, want ably.PushActivationState) {
+		t.Helper()
+		state, err := client.Push.State()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != want {
+			t.Fatalf("want state %v; got %v", want, state)
+		}
+	}
+
+	// Activating waits for the recipient.
+	done := make(chan error, 1)
+	go func() {
+		done <- client.Push.Activate(ctx)
+	}()
+	for deadline := time.Now().Add(ablytest.Timeout); ; time.Sleep(time.Millisecond) {
+		if state, _ := client.Push.State(); state == ably.PushWaitingForPushDeviceDetails {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the device to wait for its details")
+		}
+	}
+	recipient := map[string]interface{}{"transportType": "fcm", "registrationToken": "token1"}
+	if err := client.Push.SetRecipient(ctx, recipient); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	checkState(t, client, ably.PushWaitingForNewPushDeviceDetails)
+	device, err := client.Push.LocalDevice()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if device.ID == "" || device.DeviceSecret == "" {
+		t.Fatalf("want device with ID and secret; got %+v", device)
+	}
+	assertDeepEquals(t, "identity", device.DeviceIdentityToken)
+	requests := server.takeRequests()
+	if len(requests) != 1 {
+		t.Fatalf("want 1 request; got %d", len(requests))
+	}
+	reg := requests[0]
+	assertDeepEquals(t, "POST /push/deviceRegistrations", reg.method+" "+reg.path)
+	assertDeepEquals(t, map[string]interface{}{
+		"id":           device.ID,
+		"clientId":     "alice",
+		"platform":     "android",
+		"formFactor":   "embedded",
+		"deviceSecret": device.DeviceSecret,
+		"push":         map[string]interface{}{"recipient": recipient},
+	}, reg.body)
+
+	// A new recipient updates the registration, authenticated as the device.
+	recipient = map[string]interface{}{"transportType": "fcm", "registrationToken": "token2"}
+	if err := client.Push.SetRecipient(ctx, recipient); err != nil {
+		t.Fatal(err)
+	}
+	requests = server.takeRequests()
+	if len(requests) != 1 {
+		t.Fatalf("want 1 request; got %d", len(requests))
+	}
+	update := requests[0]
+	assertDeepEquals(t, "PATCH /push/deviceRegistrations/"+device.ID, update.method+" "+update.path)
+	assertDeepEquals(t, base64.StdEncoding.EncodeToString([]byte("identity")), update.header.Get("X-Ably-DeviceToken"))
+	assertDeepEquals(t, map[string]interface{}{"push": map[string]interface{}{"recipient": recipient}}, update.body)
+
+	// Channel subscriptions.
+	channel := client.Channels.Get("news")
+	if err := channel.Push.SubscribeDevice(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.Push.SubscribeClient(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.Push.UnsubscribeDevice(ctx); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range server.takeRequests() {
+		got = append(got, r.method+" "+r.path)
+	}
+	assertDeepEquals(t, []string{
+		"POST /push/channelSubscriptions",
+		"POST /push/channelSubscriptions",
+		"DELETE /push/channelSubscriptions?channel=news&deviceId=" + device.ID,
+	}, got)
+
+	// The activation is restored from storage by a new client.
+	restored := newTestRestClient(t, server.Server, func(o *ably.ClientOptions) {
+		o.ClientID = "alice"
+		o.Push.Storage = storage
+	})
+	if err := restored.Push.Activate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if requests := server.takeRequests(); len(requests) != 0 {
+		t.Fatalf("want no request to activate a restored device; got %d", len(requests))
+	}
+
+	// Deactivating deregisters the device and forgets its identity.
+	if err := restored.Push.Deactivate(ctx); err != nil {
+		t.Fatal(err)
+	}
+	requests = server.takeRequests()
+	if len(requests) != 1 {
+		t.Fatalf("want 1 request; got %d", len(requests))
+	}
+	assertDeepEquals(t, "DELETE /push/deviceRegistrations/"+device.ID, requests[0].method+" "+requests[0].path)
+	checkState(t, restored, ably.PushNotActivated)
+	if device, _ := restored.Push.LocalDevice(); device.ID != "" || device.DeviceIdentityToken != "" {
+		t.Fatalf("want device identity reset; got %+v", device)
+	}
+}
+
+func TestPush_ActivateFailure(t *testing.T) {
+	t.Parallel()
+
+	server := newPushServer(t)
+	defer server.Close()
+	server.fail = true
+	client := newTestRestClient(t, server.Server)
+	ctx := context.Background()
+
+	if err := client.Push.SetRecipient(ctx, map[string]interface{}{"transportType": "apns", "deviceToken": "token"}); err != nil {
+		t.Fatal(err)
+	}
+	err := client.Push.Activate(ctx)
+	if err := checkError(40000, err); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := client.Push.State(); state != ably.PushNotActivated {
+		t.Fatalf("want state %v; got %v", ably.PushNotActivated, state)
+	}
+
+	err = client.Channels.Get("news").Push.SubscribeDevice(ctx)
+	if err := checkError(ably.ErrBadRequest, err); err != nil {
+		t.Fatal(err)
+	}
+	err = client.Channels.Get("news").Push.SubscribeClient(ctx)
+	if err := checkError(ably.ErrBadRequest, err); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	unregistered := newTestRestClient(t, server.Server)
+	if err := unregistered.Push.Activate(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want error %v waiting for the recipient; got %v", context.DeadlineExceeded, err)
+	}
+}
+
+func TestPushChannel_Subscriptions(t *testing.T) {
+	t.Parallel()
+
+	server := newPushServer(t)
+	defer server.Close()
+	firstQuery := make(chan string, 1)
+	listing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			server.Config.Handler.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "" {
+			firstQuery <- r.URL.RawQuery
+			w.Header().Set("Link", `<./channelSubscriptions?channel=news&page=2>; rel="next"`)
+			w.Write([]byte(`[{"channel":"news","deviceId":"device1"},{"channel":"news","clientId":"alice"}]`))
+			return
+		}
+		w.Write([]byte(`[{"channel":"news","deviceId":"device2"}]`))
+	}))
+	defer listing.Close()
+	client := newTestRestClient(t, listing)
+	channel := client.Channels.Get("news")
+	ctx := context.Background()
+
+	if err := channel.Push.SubscribeDeviceID(ctx, "device1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.Push.SubscribeClientID(ctx, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.Push.Unsubscribe(ctx, ably.PushChannelSubscription{DeviceID: "device1"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range server.takeRequests() {
+		got = append(got, r.method+" "+r.path)
+		if r.body != nil {
+			b, _ := json.Marshal(r.body)
+			got = append(got, string(b))
+		}
+	}
+	assertDeepEquals(t, []string{
+		"POST /push/channelSubscriptions",
+		`{"channel":"news","deviceId":"device1"}`,
+		"POST /push/channelSubscriptions",
+		`{"channel":"news","clientId":"alice"}`,
+		"DELETE /push/channelSubscriptions?channel=news&deviceId=device1",
+	}, got)
+
+	for _, sub := range []ably.PushChannelSubscription{{}, {DeviceID: "device1", ClientID: "alice"}} {
+		err := channel.Push.Unsubscribe(ctx, sub)
+		if err := checkError(ably.ErrInvalidParameterValue, err); err != nil {
+			t.Fatalf("%+v: %v", sub, err)
+		}
+	}
+
+	page, err := channel.Push.ListSubscriptions(ctx, &ably.PushSubscriptionsParams{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, "channel=news&limit=2", <-firstQuery)
+	assertDeepEquals(t, []*ably.PushChannelSubscription{
+		{Channel: "news", DeviceID: "device1"},
+		{Channel: "news", ClientID: "alice"},
+	}, page.PushChannelSubscriptions())
+	page, err = page.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, []*ably.PushChannelSubscription{
+		{Channel: "news", DeviceID: "device2"},
+	}, page.PushChannelSubscriptions())
+}
+
+func TestNewPushPayload(t *testing.T) {
+	t.Parallel()
+
+	_, err := ably.NewPushPayload(proto.PushNotification{}, nil)
+	if err := checkError(ably.ErrInvalidParameterValue, err); err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := ably.NewPushPayload(proto.PushNotification{Title: "Hello"}, map[string]interface{}{"id": "42"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload.FCM = map[string]interface{}{"android": map[string]interface{}{"priority": "high"}}
+	msg, err := (&proto.Message{Name: "greeting"}).WithPush(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	client := newTestRestClient(t, server)
+	if err := client.Channels.Get("news").PublishAll([]*proto.Message{msg}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("want 1 message sent; got %d", len(sent))
+	}
+	assertDeepEquals(t, map[string]interface{}{
+		"push": map[string]interface{}{
+			"notification": map[string]interface{}{"title": "Hello"},
+			"data":         map[string]interface{}{"id": "42"},
+			"fcm":          map[string]interface{}{"android": map[string]interface{}{"priority": "high"}},
+		},
+	}, sent[0]["extras"])
+}
+
+func TestPushAdmin_Publish(t *testing.T) {
+	t.Parallel()
+
+	server := newPushServer(t)
+	defer server.Close()
+	client := newTestRestClient(t, server.Server)
+	ctx := context.Background()
+	payload := &proto.PushPayload{Notification: proto.PushNotification{Title: "Hello"}}
+
+	for _, c := range []struct {
+		recipient ably.PushRecipient
+		want      map[string]interface{}
+	}{
+		{ably.DeviceIDRecipient{DeviceID: "device1"}, map[string]interface{}{"deviceId": "device1"}},
+		{ably.ClientIDRecipient{ClientID: "alice"}, map[string]interface{}{"clientId": "alice"}},
+		{ably.FCMTokenRecipient{RegistrationToken: "token"}, map[string]interface{}{"transportType": "fcm", "registrationToken": "token"}},
+		{ably.APNSTokenRecipient{DeviceToken: "00ff"}, map[string]interface{}{"transportType": "apns", "deviceToken": "00ff"}},
+	} {
+		if err := client.Push.Admin.Publish(ctx, c.recipient, payload); err != nil {
+			t.Fatalf("%T: %v", c.recipient, err)
+		}
+		requests := server.takeRequests()
+		if len(requests) != 1 {
+			t.Fatalf("%T: want 1 request; got %d", c.recipient, len(requests))
+		}
+		assertDeepEquals(t, "POST /push/publish", requests[0].method+" "+requests[0].path)
+		assertDeepEquals(t, map[string]interface{}{
+			"recipient":    c.want,
+			"notification": map[string]interface{}{"title": "Hello"},
+		}, requests[0].body)
+	}
+
+	for _, c := range []struct {
+		recipient ably.PushRecipient
+		payload   *proto.PushPayload
+	}{
+		{nil, payload},
+		{ably.DeviceIDRecipient{}, payload},
+		{ably.ClientIDRecipient{}, payload},
+		{ably.FCMTokenRecipient{RegistrationToken: "two words"}, payload},
+		{ably.APNSTokenRecipient{DeviceToken: "not hex"}, payload},
+		{ably.DeviceIDRecipient{DeviceID: "device1"}, nil},
+		{ably.DeviceIDRecipient{DeviceID: "device1"}, &proto.PushPayload{}},
+	} {
+		err := client.Push.Admin.Publish(ctx, c.recipient, c.payload)
+		if err := checkError(ably.ErrInvalidParameterValue, err); err != nil {
+			t.Errorf("%#v: %v", c.recipient, err)
+		}
+	}
+	if requests := server.takeRequests(); len(requests) != 0 {
+		t.Fatalf("want no request for invalid recipients or payloads; got %d", len(requests))
+	}
+}
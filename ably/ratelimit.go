@@ -0,0 +1,177 @@
+package ably
+
+import (
+	"context"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ably/ably-go/ably/internal/ablyutil"
+)
+
+// RateLimit configures a token bucket limiting how many messages are
+// published, to keep bursty producers from exceeding Ably's rate limits; see
+// ClientOptions.PublishRateLimit and the channels' SetPublishRateLimit.
+type RateLimit struct {
+	// Rate is how many messages can be published per second, on average.
+	Rate float64
+
+	// Burst is how many messages can be published at once, after none was
+	// for a while. It defaults to Rate, rounded up. Unless Wait is set,
+	// publishing more messages than Burst in a single call always fails,
+	// with an error with code ErrRateLimitExceededFatal.
+	Burst int
+
+	// Wait makes publishing wait until the limit allows it. By default,
+	// publishing fails right away instead, with an error with code
+	// ErrRateLimitExceededNonfatal.
+	Wait bool
+}
+
+func (l *RateLimit) validate() error {
+	if l.Rate <= 0 || l.Burst < 0 {
+		return newErrorf(ErrInvalidParameterValue, "PublishRateLimit must have a positive Rate and a non-negative Burst; got %v and %d", l.Rate, l.Burst)
+	}
+	return nil
+}
+
+// rateLimiter enforces a RateLimit. A nil *rateLimiter doesn't limit.
+type rateLimiter struct {
+	mtx    sync.Mutex
+	limit  RateLimit
+	clock  ablyutil.Clock
+	tokens float64
+	last   time.Time // when tokens was last updated
+}
+
+func newRateLimiter(limit *RateLimit, clock ablyutil.Clock) *rateLimiter {
+	if limit == nil {
+		return nil
+	}
+	l := &rateLimiter{limit: *limit, clock: clock}
+	if l.limit.Burst == 0 {
+		l.limit.Burst = int(math.Ceil(l.limit.Rate))
+	}
+	l.tokens = float64(l.limit.Burst)
+	l.last = clock.Now()
+	return l
+}
+
+// take takes a token for each of n messages. If there aren't enough, it
+// fails or, with RateLimit.Wait, waits until they're replenished or ctx is
+// done.
+func (l *rateLimiter) take(ctx context.Context, n int) error {
+	if l == nil {
+		return nil
+	}
+	return l.takeWait(ctx, n, l.limit.Wait)
+}
+
+// tryTake is take, but fails rather than wait even with RateLimit.Wait.
+func (l *rateLimiter) tryTake(n int) error {
+	if l == nil {
+		return nil
+	}
+	return l.takeWait(context.Background(), n, false)
+}
+
+// takeWait is take, waiting for the tokens only if canWait is set.
+func (l *rateLimiter) takeWait(ctx context.Context, n int, canWait bool) error {
+	if n > l.limit.Burst && !canWait {
+		return newErrorf(ErrRateLimitExceededFatal, "publishing %d messages at once exceeds the publish rate limit's burst of %d", n, l.limit.Burst)
+	}
+	l.mtx.Lock()
+	now := l.clock.Now()
+	l.tokens = math.Min(l.tokens+now.Sub(l.last).Seconds()*l.limit.Rate, float64(l.limit.Burst))
+	l.last = now
+	if l.tokens >= float64(n) {
+		l.tokens -= float64(n)
+		l.mtx.Unlock()
+		return nil
+	}
+	if !canWait {
+		l.mtx.Unlock()
+		return newErrorf(ErrRateLimitExceededNonfatal, "publish rate limit of %v messages per second exceeded", l.limit.Rate)
+	}
+	// The tokens are taken in advance, so that later calls wait for their
+	// own tokens after these.
+	l.tokens -= float64(n)
+	wait := time.Duration(-l.tokens / l.limit.Rate * float64(time.Second))
+	l.mtx.Unlock()
+	timer := l.clock.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		l.refund(n)
+		return ctx.Err()
+	}
+}
+
+// refund gives back the tokens taken for n messages which weren't
+// published after all.
+func (l *rateLimiter) refund(n int) {
+	if l == nil {
+		return
+	}
+	l.mtx.Lock()
+	l.tokens = math.Min(l.tokens+float64(n), float64(l.limit.Burst))
+	l.mtx.Unlock()
+}
+
+// publishLimit holds a channel's rate limiter, set by SetPublishRateLimit.
+type publishLimit struct {
+	v atomic.Value // of *rateLimiter
+}
+
+func (p *publishLimit) set(limit *RateLimit, clock ablyutil.Clock) error {
+	if limit != nil {
+		if err := limit.validate(); err != nil {
+			return err
+		}
+	}
+	p.v.Store(newRateLimiter(limit, clock))
+	return nil
+}
+
+func (p *publishLimit) get() *rateLimiter {
+	l, _ := p.v.Load().(*rateLimiter)
+	return l
+}
+
+// takePublish takes tokens for publishing n messages, from the channel's
+// limiter and then the client's. The channel's tokens are given back if the
+// client's limiter fails.
+func takePublish(ctx context.Context, channel *publishLimit, client *rateLimiter, n int) error {
+	limiter := channel.get()
+	if err := limiter.take(ctx, n); err != nil {
+		return err
+	}
+	if err := client.take(ctx, n); err != nil {
+		limiter.refund(n)
+		return err
+	}
+	return nil
+}
+
+// refundPublish gives back the tokens takePublish took for n messages which
+// weren't published after all.
+func refundPublish(channel *publishLimit, client *rateLimiter, n int) {
+	channel.get().refund(n)
+	client.refund(n)
+}
+
+// tryTakePublish is takePublish, but fails rather than wait for tokens.
+func tryTakePublish(channel *publishLimit, client *rateLimiter, n int) error {
+	limiter := channel.get()
+	if err := limiter.tryTake(n); err != nil {
+		return err
+	}
+	if err := client.tryTake(n); err != nil {
+		limiter.refund(n)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package ably_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/internal/ablyutil"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestPublishRateLimit(t *testing.T) {
+	t.Parallel()
+
+	var published int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&published, 1)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	clock := ablyutil.NewFakeClock(time.Unix(1500000000, 0))
+	client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+		o.PublishRateLimit = &ably.RateLimit{Rate: 2}
+		o.Clock = clock
+	})
+
+	t.Run("fails when exceeded", func(t *testing.T) {
+		channel := client.Channels.Get("client", nil)
+		for i := 0; i < 2; i++ {
+			if err := channel.Publish("e", "data"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := channel.Publish("e", "data")
+		if code := ably.ErrorCode(err); code != ably.ErrRateLimitExceededNonfatal {
+			t.Fatalf("want code=%d; got %d (%v)", ably.ErrRateLimitExceededNonfatal, code, err)
+		}
+		if n := atomic.LoadInt32(&published); n != 2 {
+			t.Fatalf("want 2 publishes; got %d", n)
+		}
+
+		// The bucket refills at Rate.
+		clock.Advance(500 * time.Millisecond)
+		if err := channel.Publish("e", "data"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Advance(time.Second)
+	})
+
+	t.Run("channel limit waits", func(t *testing.T) {
+		channel := client.Channels.Get("channel", nil)
+		if err := channel.SetPublishRateLimit(&ably.RateLimit{Rate: 1, Wait: true}); err != nil {
+			t.Fatal(err)
+		}
+		if err := channel.Publish("e", "data"); err != nil {
+			t.Fatal(err)
+		}
+		before := atomic.LoadInt32(&published)
+		done := make(chan error, 1)
+		go func() {
+			done <- channel.Publish("e", "data")
+		}()
+		clock.BlockUntil(1)
+		select {
+		case err := <-done:
+			t.Fatalf("expected Publish to wait; got %v", err)
+		default:
+		}
+		clock.Advance(time.Second)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("expected Publish to be done waiting")
+		}
+		if n := atomic.LoadInt32(&published); n != before+1 {
+			t.Fatalf("want %d publishes; got %d", before+1, n)
+		}
+
+		// Waiting stops with the context, without using up tokens.
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			done <- channel.PublishContext(ctx, "e", "data")
+		}()
+		clock.BlockUntil(1)
+		cancel()
+		if err := <-done; err != context.Canceled {
+			t.Fatalf("want context.Canceled; got %v", err)
+		}
+		clock.Advance(time.Second)
+		if err := channel.Publish("e", "data"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("more than burst", func(t *testing.T) {
+		channel := client.Channels.Get("burst", nil)
+		err := channel.PublishAll([]*proto.Message{{Name: "a"}, {Name: "b"}, {Name: "c"}})
+		if code := ably.ErrorCode(err); code != ably.ErrRateLimitExceededFatal {
+			t.Fatalf("want code=%d; got %d (%v)", ably.ErrRateLimitExceededFatal, code, err)
+		}
+	})
+
+	t.Run("client limit gives back channel tokens", func(t *testing.T) {
+		client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+			o.PublishRateLimit = &ably.RateLimit{Rate: 1}
+			o.Clock = clock
+		})
+		channel := client.Channels.Get("refund", nil)
+		if err := channel.SetPublishRateLimit(&ably.RateLimit{Rate: 0.001, Burst: 3}); err != nil {
+			t.Fatal(err)
+		}
+		if err := channel.Publish("e", "data"); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			err := channel.Publish("e", "data")
+			if code := ably.ErrorCode(err); code != ably.ErrRateLimitExceededNonfatal {
+				t.Fatalf("want code=%d; got %d (%v)", ably.ErrRateLimitExceededNonfatal, code, err)
+			}
+		}
+		clock.Advance(time.Second)
+		if err := channel.Publish("e", "data"); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		err := client.Channels.Get("invalid", nil).SetPublishRateLimit(&ably.RateLimit{Rate: 0})
+		if code := ably.ErrorCode(err); code != ably.ErrInvalidParameterValue {
+			t.Fatalf("want code=%d; got %d (%v)", ably.ErrInvalidParameterValue, code, err)
+		}
+	})
+}
@@ -1,11 +1,15 @@
 package ably
 
 import (
+	"context"
 	"errors"
-	"fmt"
+	"math/rand"
+	"net/url"
 	"sort"
 	"sync"
+	"time"
 
+	"github.com/ably/ably-go/ably/internal/ablyutil"
 	"github.com/ably/ably-go/ably/proto"
 )
 
@@ -42,7 +46,12 @@ func newChannels(client *RealtimeClient) *Channels {
 // It is safe to call Get from multiple goroutines - a single channel is
 // guaranteed to be created only once for multiple calls to Get from different
 // goroutines.
-func (ch *Channels) Get(name string) *RealtimeChannel {
+//
+// If non-nil options are given, they replace the ones of the channel; they're
+// used to encode the messages published on it, and their params and modes are
+// requested when the channel attaches. Use RealtimeChannel.SetOptions to
+// reattach an already attached channel with new params or modes.
+func (ch *Channels) Get(name string, opts ...*proto.ChannelOptions) *RealtimeChannel {
 	ch.mtx.Lock()
 	c, ok := ch.chans[name]
 	if !ok {
@@ -50,17 +59,47 @@ func (ch *Channels) Get(name string) *RealtimeChannel {
 		ch.chans[name] = c
 	}
 	ch.mtx.Unlock()
+	if o := lastChannelOptions(opts); o != nil {
+		c.state.Lock()
+		c.options = o
+		c.state.Unlock()
+	}
 	return c
 }
 
-// All returns a list of created channels.
-//
-// It is safe to call All from multiple goroutines, however there's no guarantee
-// the returned list would not list a channel that was already released from
-// different goroutine.
+// lastChannelOptions gives the last non-nil options of opts, if any.
+func lastChannelOptions(opts []*proto.ChannelOptions) *proto.ChannelOptions {
+	for i := len(opts) - 1; i >= 0; i-- {
+		if opts[i] != nil {
+			return opts[i]
+		}
+	}
+	return nil
+}
+
+// Exists reports whether a channel with the given name was created and not
+// released since.
+func (ch *Channels) Exists(name string) bool {
+	ch.mtx.Lock()
+	_, ok := ch.chans[name]
+	ch.mtx.Unlock()
+	return ok
+}
+
+// lookup gives the channel with the given name, if it exists.
+func (ch *Channels) lookup(name string) (*RealtimeChannel, bool) {
+	ch.mtx.Lock()
+	c, ok := ch.chans[name]
+	ch.mtx.Unlock()
+	return c, ok
+}
+
+// Iterate returns a snapshot of the created channels, sorted by name.
 //
-// The returned list is sorted by channel names.
-func (ch *Channels) All() []*RealtimeChannel {
+// It is safe to call Iterate from multiple goroutines, however there's no
+// guarantee the returned list would not list a channel that was already
+// released from different goroutine.
+func (ch *Channels) Iterate() []*RealtimeChannel {
 	ch.mtx.Lock()
 	chans := make([]*RealtimeChannel, 0, len(ch.chans))
 	for _, c := range ch.chans {
@@ -71,18 +110,34 @@ func (ch *Channels) All() []*RealtimeChannel {
 	return chans
 }
 
-// Release closes a channel looked up by the name.
+// All returns a list of created channels, sorted by name.
+//
+// Deprecated: use Iterate instead.
+func (ch *Channels) All() []*RealtimeChannel {
+	return ch.Iterate()
+}
+
+// Release closes a channel looked up by the name, which detaches it and closes
+// its subscriptions, and removes it from the collection, so that its state
+// can be garbage collected. A later Get creates a new channel.
 //
 // It is safe to call Release from multiple goroutines - if a channel happened
-// to be already concurrently released, the method is a nop.
+// to be already concurrently released, the method is a nop. Release doesn't
+// block calls to Get for other channels while the channel is detaching.
 func (ch *Channels) Release(name string) error {
+	c, ok := ch.lookup(name)
+	if !ok {
+		return nil
+	}
+	// The channel is removed only once closed, so that it's notified of
+	// the DETACHED reply.
+	err := c.Close()
 	ch.mtx.Lock()
-	defer ch.mtx.Unlock()
-	if c, ok := ch.chans[name]; ok {
+	if ch.chans[name] == c {
 		delete(ch.chans, name)
-		return c.Close()
 	}
-	return nil
+	ch.mtx.Unlock()
+	return err
 }
 
 func (ch *Channels) broadcastConnStateChange(state State) {
@@ -97,43 +152,118 @@ func (ch *Channels) broadcastConnStateChange(state State) {
 type RealtimeChannel struct {
 	Name     string            // name used to create the channel
 	Presence *RealtimePresence //
+	Push     *PushChannel      // subscribes to the channel's push notifications
 
 	client *RealtimeClient
 	state  *stateEmitter
 	subs   *subscriptions
 	queue  *msgQueue
+	batch  publishBatch
 	listen chan State
+	dedupe *dedupeCache // nil unless ClientOptions.Dedupe is set
+
+	// enqueue is subs.messageEnqueue, bound once rather than for every
+	// received message.
+	enqueue func(*proto.ProtocolMessage)
+
+	// subsMtx guards subs, enqueue and Presence.subs, which
+	// RealtimeClient.Replace moves to another channel while messages may be
+	// delivered.
+	subsMtx sync.Mutex
+
+	// retryAttempt and retryTimer track automatic reattaching after the
+	// server detached the channel (RTL13); they're guarded by state's lock.
+	retryAttempt int
+	retryTimer   ablyutil.Timer
+
+	// options, set with Channels.Get, encode the messages published on
+	// the channel; they're guarded by state's lock.
+	options *proto.ChannelOptions
+
+	// modes are the flags of the last ATTACHED message, giving the modes
+	// granted by the server; they're guarded by state's lock.
+	modes proto.Flag
+
+	// publishLimit is set with SetPublishRateLimit.
+	publishLimit publishLimit
+
+	// attachSerial is the channel serial of the last ATTACHED message, which
+	// marks the point of attachment in the channel's history; it's guarded
+	// by state's lock.
+	attachSerial string
+
+	// handoff, when non-nil, gates message delivery while the channel is
+	// being moved between clients with RealtimeClient.Replace.
+	handoffMtx sync.Mutex
+	handoff    *handoff
+	handoffOld bool
 }
 
 func newRealtimeChannel(name string, client *RealtimeClient) *RealtimeChannel {
 	c := &RealtimeChannel{
 		Name:   name,
 		client: client,
-		state:  newStateEmitter(StateChan, StateChanInitialized, name, client.logger()),
 		subs:   newSubscriptions(subscriptionMessages, client.logger()),
 		listen: make(chan State, 1),
 	}
+	c.state = newStateEmitter(StateChan, StateChanInitialized, name, c.logger)
+	c.state.hook = c.opts().OnChannelStateChange
+	c.state.tags = c.opts().Logger.tags
+	c.enqueue = c.subs.messageEnqueue
 	c.Presence = newRealtimePresence(c)
-	c.queue = newMsgQueue(client.Connection)
+	c.Push = newPushChannel(name, client.rest)
+	c.queue = newMsgQueue(client.Connection, client.Connection.send)
+	if opts := c.opts(); opts.Dedupe.enabled() {
+		c.dedupe = newDedupeCache(opts.Dedupe, opts.now)
+	}
 	if c.opts().Listener != nil {
 		c.On(c.opts().Listener)
 	}
 	return c
 }
 
+// messageSubs gives the channel's message subscriptions.
+func (c *RealtimeChannel) messageSubs() *subscriptions {
+	c.subsMtx.Lock()
+	defer c.subsMtx.Unlock()
+	return c.subs
+}
+
+// messageEnqueue gives the func enqueueing messages to the channel's
+// subscriptions.
+func (c *RealtimeChannel) messageEnqueue() func(*proto.ProtocolMessage) {
+	c.subsMtx.Lock()
+	defer c.subsMtx.Unlock()
+	return c.enqueue
+}
+
+// setSubs sets the channel's message and presence subscriptions.
+func (c *RealtimeChannel) setSubs(subs, presence *subscriptions) {
+	c.subsMtx.Lock()
+	defer c.subsMtx.Unlock()
+	c.subs = subs
+	c.enqueue = subs.messageEnqueue
+	c.Presence.subs = presence
+}
+
 func (c *RealtimeChannel) onConnState(state State) {
 	c.state.Lock()
 	active := c.isActive()
 	c.state.Unlock()
 	switch state.State {
 	case StateConnFailed:
-		if active {
+		if active || c.State() == StateChanSuspended {
 			c.state.syncSet(StateChanFailed, state.Err)
 		}
 	case StateConnClosed:
-		if active {
+		if active || c.State() == StateChanSuspended {
 			c.state.syncSet(StateChanClosed, state.Err)
 		}
+	case StateConnSuspended:
+		// The channel is attached again once the connection is (RTL3c).
+		if active {
+			c.state.syncSet(StateChanSuspended, state.Err)
+		}
 	}
 }
 
@@ -183,7 +313,15 @@ func (c *RealtimeChannel) mayAttach(result, checkActive bool) (Result, error) {
 		return goWaiter(func() error {
 			connected := make(chan State, 1)
 			c.client.Connection.On(connected, StateConnConnected, StateConnClosed, StateConnFailed)
-			state := <-connected
+			// The connection may have left the connecting state before the
+			// listener was registered.
+			var state State
+			switch current := c.client.Connection.State(); current {
+			case StateConnConnected, StateConnClosed, StateConnFailed:
+				state = State{State: current, Err: c.client.Connection.Reason()}
+			default:
+				state = <-connected
+			}
 			c.client.Connection.Off(connected)
 			if state.State != StateConnConnected {
 				return state.Err
@@ -206,15 +344,92 @@ func (c *RealtimeChannel) mayAttach(result, checkActive bool) (Result, error) {
 	if result {
 		res = c.state.listenResult(attachResultStates...)
 	}
+	_, span := c.opts().startSpan(nil, "ably.channel.attach", Field{Key: "ably.channel", Value: c.Name})
+	endSpanOnState(span, c.state, attachResultStates[0], attachResultStates...)
+	err := c.client.Connection.send(c.attachMessage(), nil)
+	if err != nil {
+		return nil, c.state.set(StateChanFailed, err)
+	}
+	return res, nil
+}
+
+// attachMessage gives the ATTACH message requesting the params and modes of
+// the channel's options. It must be called with the state lock held.
+func (c *RealtimeChannel) attachMessage() *proto.ProtocolMessage {
 	msg := &proto.ProtocolMessage{
 		Action:  proto.ActionAttach,
 		Channel: c.state.channel,
 	}
-	err := c.client.Connection.send(msg, nil)
-	if err != nil {
-		return nil, c.state.set(StateChanFailed, err)
+	if c.options != nil {
+		msg.Params = c.options.Params
+		msg.Flags = c.options.ModeFlags()
 	}
-	return res, nil
+	return msg
+}
+
+// SetOptions replaces the options of the channel.
+//
+// If the channel is attached or attaching and the new options change its
+// params or modes, the channel is reattached with them: SetOptions then
+// blocks until the server confirms the new attachment, which emits
+// StateChanUpdate, or ctx is done. Otherwise it returns right away.
+func (c *RealtimeChannel) SetOptions(ctx context.Context, opts *proto.ChannelOptions) error {
+	c.state.Lock()
+	reattach := (c.state.current == StateChanAttached || c.state.current == StateChanAttaching) &&
+		attachOptionsChanged(c.options, opts)
+	c.options = opts
+	if !reattach {
+		c.state.Unlock()
+		return nil
+	}
+	listen := make(chan State, 1)
+	c.state.once(listen, StateChanUpdate, StateChanAttached, StateChanDetached, StateChanSuspended, StateChanFailed)
+	if err := c.client.Connection.send(c.attachMessage(), nil); err != nil {
+		c.state.offOnce(listen)
+		c.state.Unlock()
+		return err
+	}
+	c.state.Unlock()
+	select {
+	case state := <-listen:
+		switch state.State {
+		case StateChanUpdate, StateChanAttached:
+			return nil
+		}
+		if state.Err != nil {
+			return state.Err
+		}
+		return newErrorf(ErrChannelOperationFailed, "channel %q failed to reattach with new options; it is %v", c.Name, state.State)
+	case <-ctx.Done():
+		c.state.Lock()
+		c.state.offOnce(listen)
+		c.state.Unlock()
+		return ctx.Err()
+	}
+}
+
+// attachOptionsChanged reports whether the params or modes of a and b
+// differ.
+func attachOptionsChanged(a, b *proto.ChannelOptions) bool {
+	if a.ModeFlags() != b.ModeFlags() {
+		return true
+	}
+	var pa, pb map[string]string
+	if a != nil {
+		pa = a.Params
+	}
+	if b != nil {
+		pb = b.Params
+	}
+	if len(pa) != len(pb) {
+		return true
+	}
+	for k, v := range pa {
+		if w, ok := pb[k]; !ok || v != w {
+			return true
+		}
+	}
+	return false
 }
 
 // Detach initiates detach request, which is being processed on a separate
@@ -241,6 +456,10 @@ func (c *RealtimeChannel) detach(result bool) (Result, error) {
 	switch {
 	case c.state.current == StateChanFailed:
 		return nil, stateError(StateChanFailed, errDetach)
+	case c.state.current == StateChanSuspended:
+		c.stopRetry()
+		c.state.set(StateChanDetached, nil)
+		return nopResult, nil
 	case !c.isActive():
 		return nopResult, nil
 	}
@@ -252,6 +471,8 @@ func (c *RealtimeChannel) detach(result bool) (Result, error) {
 	if result {
 		res = c.state.listenResult(detachResultStates...)
 	}
+	_, span := c.opts().startSpan(nil, "ably.channel.detach", Field{Key: "ably.channel", Value: c.Name})
+	endSpanOnState(span, c.state, detachResultStates[0], detachResultStates...)
 	msg := &proto.ProtocolMessage{
 		Action:  proto.ActionDetach,
 		Channel: c.state.channel,
@@ -270,7 +491,7 @@ func (c *RealtimeChannel) detach(result bool) (Result, error) {
 // If sending close message succeeds, it closes and unsubscribes all channels.
 func (c *RealtimeChannel) Close() error {
 	err := wait(c.Detach())
-	c.subs.close()
+	c.messageSubs().close()
 	if err != nil {
 		return c.state.syncSet(StateChanClosed, err)
 	}
@@ -281,13 +502,25 @@ func (c *RealtimeChannel) Close() error {
 // messages relayed to the returned Subscription value.
 //
 // If no names are given, returned Subscription will receive all messages.
+// Names may contain wildcards: '*' matches any sequence of characters and '?'
+// any single character, e.g. "update:*" matches "update:user" and "update:".
+// A message matching several names is delivered once.
 // If ch is non-nil and it was already registered to receive messages with different
 // names than the ones given, it will be added to receive also the new ones.
 func (c *RealtimeChannel) Subscribe(names ...string) (*Subscription, error) {
 	if _, err := c.attach(false); err != nil {
 		return nil, err
 	}
-	return c.subs.subscribe(namesToKeys(names)...)
+	return c.messageSubs().subscribe(namesToKeys(names)...)
+}
+
+// SubscribeWithOptions subscribes like Subscribe, with a subscription
+// configured by opts, e.g. to bound its queue of undelivered messages.
+func (c *RealtimeChannel) SubscribeWithOptions(opts *SubscriptionOptions, names ...string) (*Subscription, error) {
+	if _, err := c.attach(false); err != nil {
+		return nil, err
+	}
+	return c.messageSubs().subscribeWithOptions(opts, namesToKeys(names)...)
 }
 
 // Unsubscribe removes previous Subscription for the given message names.
@@ -300,7 +533,7 @@ func (c *RealtimeChannel) Unsubscribe(sub *Subscription, names ...string) {
 	if sub.typ != subscriptionMessages {
 		panic(errInvalidType{typ: sub.typ})
 	}
-	c.subs.unsubscribe(true, sub, namesToKeys(names)...)
+	c.messageSubs().unsubscribe(true, sub, namesToKeys(names)...)
 }
 
 // On relays request channel states to c; on state transition
@@ -314,6 +547,26 @@ func (c *RealtimeChannel) On(ch chan<- State, states ...StateEnum) {
 	c.state.on(ch, states...)
 }
 
+// StateChanges gives a channel receiving the current state of the channel,
+// then every subsequent state change, until ctx is done, after which the
+// channel is closed.
+//
+// Unlike with On, state changes aren't dropped if the receiver falls behind;
+// they're buffered until received.
+func (c *RealtimeChannel) StateChanges(ctx context.Context) <-chan State {
+	return c.state.changes(ctx)
+}
+
+// WaitForState blocks until the channel is in one of the given states,
+// returning the state it's in, or until ctx is done, returning ctx.Err().
+//
+// If the channel is already in one of the states, WaitForState returns
+// immediately. If no states are given, it waits for the next state change.
+// If any state isn't a channel state, the method panics.
+func (c *RealtimeChannel) WaitForState(ctx context.Context, states ...StateEnum) (State, error) {
+	return c.state.waitFor(ctx, states...)
+}
+
 // Off removes c from listening on the given channel state transitions.
 //
 // If no states are given, c is removed for all of the connection's states.
@@ -323,31 +576,181 @@ func (c *RealtimeChannel) Off(ch chan<- State, states ...StateEnum) {
 }
 
 // Publish publishes a message on the channel, which is send on separate
-// goroutine. Publish does not block.
+// goroutine. Publish doesn't wait for the message to be acknowledged; it
+// only blocks while waiting for a PublishRateLimit with Wait set, or for
+// room for the message with InFlightBlock. Data which isn't a string or
+// []byte, e.g. a struct or a map, is sent encoded as JSON.
+//
+// Messages published on a channel one after the other, e.g. from a single
+// goroutine, are sent and acknowledged in that order, including when they're
+// queued until the channel is attached or the connection is reconnected, and
+// when they're sent again after the connection is resumed.
 //
 // This implicitly attaches the channel if it's not already attached.
 func (c *RealtimeChannel) Publish(name string, data interface{}) (Result, error) {
 	return c.PublishAll([]*proto.Message{{Name: name, Data: data}})
 }
 
-// PublishAll publishes all given messages on the channel at once.
-// PublishAll does not block.
+// PublishContext publishes a message on the channel, and blocks until it's
+// acknowledged, ctx is done or publishing fails. Data which isn't a string
+// or []byte, e.g. a struct or a map, is sent encoded as JSON.
+//
+// This implicitly attaches the channel if it's not already attached.
+func (c *RealtimeChannel) PublishContext(ctx context.Context, name string, data interface{}) error {
+	return c.PublishMultiple(ctx, []*proto.Message{{Name: name, Data: data}})
+}
+
+// PublishMultiple publishes all given messages on the channel at once, as
+// PublishAll does, and blocks until they're acknowledged, ctx is done or
+// publishing fails.
+//
+// This implicitly attaches the channel if it's not already attached.
+func (c *RealtimeChannel) PublishMultiple(ctx context.Context, messages []*proto.Message) error {
+	res, err := c.publishAll(ctx, messages)
+	if err != nil {
+		return err
+	}
+	return waitContext(ctx, res)
+}
+
+// PublishOptions configures RealtimeChannel.PublishWithOptions.
+type PublishOptions struct {
+	// FailFast, when true, makes publishing fail right away, rather than
+	// queue the messages, if the channel isn't attached or the connection
+	// isn't connected; the channel isn't attached implicitly. The error's
+	// code then tells the connection's state, e.g. ErrDisconnected. Nor does
+	// publishing wait for the publish rate limits, even with RateLimit.Wait,
+	// or for room for the messages with InFlightBlock; it fails with
+	// ErrRateLimitExceededNonfatal instead. It suits latency-sensitive
+	// publishers which would rather shed messages than publish them late.
+	// The messages are sent right away, regardless of
+	// ClientOptions.PublishBatchWindow.
+	FailFast bool
+}
+
+// PublishWithOptions publishes all given messages on the channel at once, as
+// configured by opts, and blocks until they're acknowledged, ctx is done or
+// publishing fails.
+func (c *RealtimeChannel) PublishWithOptions(ctx context.Context, messages []*proto.Message, opts PublishOptions) error {
+	if !opts.FailFast {
+		return c.PublishMultiple(ctx, messages)
+	}
+	if err := c.checkPublish(messages); err != nil {
+		return err
+	}
+	// Nothing is waited for: neither the states, the publish rate limits
+	// nor room for the messages.
+	if err := c.failFastError(); err != nil {
+		return err
+	}
+	if err := tryTakePublish(&c.publishLimit, c.client.rest.publishLimit, len(messages)); err != nil {
+		return err
+	}
+	res, listen := newErrResult()
+	if err := c.sendMsg(c.messageProtocol(messages), listen, true); err != nil {
+		return err
+	}
+	return waitContext(ctx, res)
+}
+
+// PublishAll publishes all given messages on the channel at once. Like
+// Publish, it doesn't wait for them to be acknowledged, but may block for
+// rate limits or room for them.
+//
+// If ClientOptions.PublishBatchWindow is set, the messages may be sent
+// together with the ones of other calls; see PublishBatch to send a batch
+// of messages right away.
 //
 // This implicitly attaches the channel if it's not already attached.
 func (c *RealtimeChannel) PublishAll(messages []*proto.Message) (Result, error) {
-	id := c.client.Auth.clientIDForCheck()
+	return c.publishAll(context.Background(), messages)
+}
+
+// publishAll is PublishAll, with ctx bounding the wait for the publish rate
+// limits and for room for the messages, if any.
+func (c *RealtimeChannel) publishAll(ctx context.Context, messages []*proto.Message) (Result, error) {
+	if err := c.preparePublish(ctx, messages); err != nil {
+		return nil, err
+	}
+	if c.opts().PublishBatchWindow > 0 {
+		return c.batch.add(c, messages), nil
+	}
+	return c.send(c.messageProtocol(messages))
+}
+
+// preparePublish checks that messages can be published by the client and
+// sets their encodings. It waits for the publish rate limits and for room
+// for the messages, if any, until ctx is done.
+func (c *RealtimeChannel) preparePublish(ctx context.Context, messages []*proto.Message) error {
+	if err := c.checkPublish(messages); err != nil {
+		return err
+	}
+	if err := takePublish(ctx, &c.publishLimit, c.client.rest.publishLimit, len(messages)); err != nil {
+		return err
+	}
+	if err := c.client.Connection.waitInFlight(ctx); err != nil {
+		refundPublish(&c.publishLimit, c.client.rest.publishLimit, len(messages))
+		return err
+	}
+	return nil
+}
+
+// checkPublish checks that messages can be published by the client and sets
+// their encodings.
+func (c *RealtimeChannel) checkPublish(messages []*proto.Message) error {
+	if err := c.opts().checkWritable(); err != nil {
+		return err
+	}
+	if err := c.opts().interceptPublish(messages); err != nil {
+		return err
+	}
 	for _, v := range messages {
-		if v.ClientID != "" && id != wildcardClientID && v.ClientID != id {
-			// Spec RSL1g3,RSL1g4
-			return nil, fmt.Errorf("Unable to publish message containing a clientId (%s) that is incompatible with the library clientId (%s)", v.ClientID, id)
+		// Spec RSL1g3,RSL1g4
+		if err := c.client.Auth.checkClientID(v.ClientID); err != nil {
+			return err
 		}
 	}
-	msg := &proto.ProtocolMessage{
+	c.state.Lock()
+	opts := messageOptions(c.options, c.opts().MessageEncodings)
+	c.state.Unlock()
+	if opts != nil {
+		for _, v := range messages {
+			if v.ChannelOptions == nil {
+				v.ChannelOptions = opts
+			}
+		}
+	}
+	return nil
+}
+
+// failFastError gives the error publishing with PublishOptions.FailFast fails
+// with right away, if the connection isn't connected or the channel isn't
+// attached.
+func (c *RealtimeChannel) failFastError() error {
+	if state := c.client.Connection.State(); state != StateConnConnected {
+		return stateError(state, errQueueing)
+	}
+	c.state.Lock()
+	state := c.state.current
+	c.state.Unlock()
+	if state != StateChanAttached {
+		return newErrorf(ErrChannelOperationFailedInvalidChannelState, "channel %q is %v, not attached", c.Name, state)
+	}
+	return nil
+}
+
+// SetPublishRateLimit limits how many messages are published on the
+// channel, on top of ClientOptions.PublishRateLimit; nil removes the limit.
+func (c *RealtimeChannel) SetPublishRateLimit(limit *RateLimit) error {
+	return c.publishLimit.set(limit, c.opts().getClock())
+}
+
+func (c *RealtimeChannel) messageProtocol(messages []*proto.Message) *proto.ProtocolMessage {
+	return &proto.ProtocolMessage{
 		Action:   proto.ActionMessage,
 		Channel:  c.state.channel,
 		Messages: messages,
 	}
-	return c.send(msg)
 }
 
 // History gives the channel's message history according to the given parameters.
@@ -357,23 +760,123 @@ func (c *RealtimeChannel) History(params *PaginateParams) (*PaginatedResult, err
 	return c.client.rest.Channels.Get(c.Name, nil).History(params)
 }
 
+// HistoryOptions configures RealtimeChannel.HistoryWithOptions.
+type HistoryOptions struct {
+	// Params selects the retrieved messages, as for History.
+	Params *PaginateParams
+
+	// UntilAttach when true limits the history to the messages published
+	// before the channel was attached. Together with the messages received
+	// since attaching, they make the channel's messages without gaps or
+	// duplicates. The channel must be attached.
+	//
+	// Spec RTL10b
+	UntilAttach bool
+}
+
+// HistoryWithOptions gives the channel's message history according to opts;
+// ctx is used for the history requests, including those for next pages.
+func (c *RealtimeChannel) HistoryWithOptions(ctx context.Context, opts HistoryOptions) (*PaginatedResult, error) {
+	var extra url.Values
+	if opts.UntilAttach {
+		c.state.Lock()
+		state, serial := c.state.current, c.attachSerial
+		c.state.Unlock()
+		if state != StateChanAttached {
+			return nil, newErrorf(ErrBadRequest, "untilAttach requires channel %q to be attached; it is %v", c.Name, state)
+		}
+		extra = url.Values{"fromSerial": {serial}}
+	}
+	return c.client.rest.Channels.Get(c.Name, nil).history(ctx, opts.Params, extra)
+}
+
 func (c *RealtimeChannel) send(msg *proto.ProtocolMessage) (Result, error) {
-	if _, err := c.attach(false); err != nil {
+	res, listen := newErrResult()
+	if err := c.sendListen(msg, listen); err != nil {
 		return nil, err
 	}
-	res, listen := newErrResult()
-	switch c.State() {
-	case StateChanInitialized, StateChanAttaching:
+	return res, nil
+}
+
+// sendListen sends msg, or queues it until the channel is attached, and
+// reports the outcome on listen.
+func (c *RealtimeChannel) sendListen(msg *proto.ProtocolMessage, listen chan<- error) error {
+	return c.sendMsg(msg, listen, false)
+}
+
+// sendMsg sends msg as sendListen does or, if failFast is set, fails rather
+// than queueing it when the channel isn't attached or the connection isn't
+// connected.
+func (c *RealtimeChannel) sendMsg(msg *proto.ProtocolMessage, listen chan<- error, failFast bool) error {
+	if !failFast {
+		if _, err := c.attach(false); err != nil {
+			return err
+		}
+	}
+	if msg.Action == proto.ActionMessage {
+		_, span := c.opts().startSpan(nil, "ably.channel.publish",
+			Field{Key: "ably.channel", Value: c.Name},
+			Field{Key: "ably.messages", Value: len(msg.Messages)})
+		listen = endSpanOnResult(span, listen)
+	}
+	// Messages queued until the channel is attached are sent first (RTL6c2).
+	c.state.Lock()
+	switch state := c.state.current; {
+	case failFast && state != StateChanAttached:
+		c.state.Unlock()
+		err := newErrorf(ErrChannelOperationFailedInvalidChannelState, "channel %q is %v, not attached", c.Name, state)
+		listen <- err
+		return err
+	case state == StateChanInitialized, state == StateChanAttaching:
 		c.queue.Enqueue(msg, listen)
-		return res, nil
-	case StateChanAttached:
+		c.state.Unlock()
+		return nil
+	case state == StateChanAttached:
+		if c.queue.busy() {
+			c.queue.Enqueue(msg, listen)
+			c.state.Unlock()
+			return nil
+		}
+		c.state.Unlock()
 	default:
-		return nil, &Error{Code: 90001}
+		c.state.Unlock()
+		err := &Error{Code: 90001}
+		listen <- err
+		return err
 	}
-	if err := c.client.Connection.send(msg, listen); err != nil {
-		return nil, err
+	send := c.client.Connection.send
+	if failFast {
+		send = c.client.Connection.sendNoQueue
 	}
-	return res, nil
+	if err := send(msg, listen); err != nil {
+		listen <- err
+		return err
+	}
+	return nil
+}
+
+// Modes gives the modes the channel was granted by the server when it was
+// last attached, which may be less than the ones requested with
+// ChannelOptions.Modes, e.g. if the client's capabilities don't allow them.
+// It gives nil if the channel was never attached.
+func (c *RealtimeChannel) Modes() []proto.ChannelMode {
+	c.state.Lock()
+	defer c.state.Unlock()
+	return proto.ChannelModes(c.modes)
+}
+
+// AttachSerial gives the channel serial of the ATTACHED message received
+// when the channel was last attached, which marks the point in the channel's
+// history from which messages have been received since. It gives "" if the
+// channel was never attached.
+//
+// Together with the ChannelSerial of the messages received and
+// Connection.Serial, it lets applications checkpoint their progress, e.g. in
+// a database, to resume from it after a restart.
+func (c *RealtimeChannel) AttachSerial() string {
+	c.state.Lock()
+	defer c.state.Unlock()
+	return c.attachSerial
 }
 
 // State gives current state of the channel.
@@ -391,13 +894,34 @@ func (c *RealtimeChannel) Reason() error {
 }
 
 func (c *RealtimeChannel) notify(msg *proto.ProtocolMessage) {
+	msg.PopulateMessageFields()
 	switch msg.Action {
 	case proto.ActionAttached:
 		c.Presence.onAttach(msg)
-		c.state.syncSet(StateChanAttached, nil)
+		resumed := msg.Resumed()
+		c.state.Lock()
+		c.attachSerial = msg.ChannelSerial
+		c.modes = msg.Flags
+		c.stopRetry()
+		if c.state.current == StateChanAttached {
+			// RTL12
+			var err error
+			if msg.Error != nil {
+				err = newErrorProto(msg.Error)
+			}
+			c.state.update(err, resumed)
+		} else {
+			c.state.setAttached(resumed)
+		}
+		c.queue.startFlush()
+		c.state.Unlock()
 		c.queue.Flush()
+		if !resumed {
+			// RTP17i
+			c.Presence.reenter()
+		}
 	case proto.ActionDetached:
-		c.state.syncSet(StateChanDetached, nil)
+		c.onDetached(msg)
 	case proto.ActionSync:
 		c.Presence.processIncomingMessage(msg, syncSerial(msg))
 	case proto.ActionPresence:
@@ -406,11 +930,119 @@ func (c *RealtimeChannel) notify(msg *proto.ProtocolMessage) {
 		c.state.syncSet(StateChanFailed, newErrorProto(msg.Error))
 		c.queue.Fail(newErrorProto(msg.Error))
 	case proto.ActionMessage:
-		c.subs.messageEnqueue(msg)
+		if c.dedupe != nil && !c.dropDuplicates(msg) {
+			return
+		}
+		if !c.interceptReceived(msg) {
+			return
+		}
+		countMessages(c.opts().metrics(), msg.Messages, MetricMessagesReceived, MetricBytesReceived)
+		c.deliver(msg, c.messageEnqueue())
 	default:
 	}
 }
 
+// dropDuplicates removes from msg the messages already delivered within the
+// dedupe window, and reports whether msg is still to be delivered, that is
+// unless all of its messages were dropped.
+func (c *RealtimeChannel) dropDuplicates(msg *proto.ProtocolMessage) bool {
+	messages := c.dedupe.filter(msg.Messages)
+	n := len(msg.Messages) - len(messages)
+	if n == 0 {
+		return true
+	}
+	c.logger().Printf(LogVerbose, "dropped %d duplicate message(s) on channel %q", n, c.Name)
+	c.opts().metrics().Add(MetricMessagesDeduplicated, float64(n))
+	msg.Messages = messages
+	return len(messages) > 0
+}
+
+func (c *RealtimeChannel) onDetached(msg *proto.ProtocolMessage) {
+	c.state.Lock()
+	defer c.state.Unlock()
+	err := newErrorProto(msg.Error)
+	switch {
+	case c.state.current == StateChanAttached:
+		// The server detached the channel unexpectedly, e.g. because the
+		// client's capabilities were revoked; try to reattach right away.
+		//
+		// RTL13a
+		c.reattach(err)
+	case c.state.current == StateChanAttaching && c.retryAttempt != 0:
+		// Reattaching failed, suspend the channel and try again later.
+		//
+		// RTL13b
+		c.state.set(StateChanSuspended, err)
+		delay := retryDelay(c.opts().channelRetryTimeout(), c.retryAttempt)
+		c.logger().Printf(LogInfo, "Realtime channel %q: detached by server, retrying attach in %v (attempt %d): %v", c.Name, delay, c.retryAttempt, err)
+		c.retryTimer = c.opts().getClock().AfterFunc(delay, func() {
+			c.state.Lock()
+			defer c.state.Unlock()
+			if c.state.current == StateChanSuspended && c.client.Connection.State() == StateConnConnected {
+				c.reattach(err)
+			}
+		})
+	default:
+		c.stopRetry()
+		c.Presence.forgetOwn() // RTP5a
+		c.state.set(StateChanDetached, err)
+	}
+}
+
+// reattach moves the channel back to the attaching state with the given
+// reason and sends a new ATTACH message. It must be called with the state
+// lock held.
+func (c *RealtimeChannel) reattach(reason error) {
+	c.retryAttempt++
+	c.state.set(StateChanAttaching, reason)
+	if err := c.client.Connection.send(c.attachMessage(), nil); err != nil {
+		c.state.set(StateChanFailed, err)
+	}
+}
+
+// stopRetry resets the automatic reattach state. It must be called with the
+// state lock held.
+func (c *RealtimeChannel) stopRetry() {
+	if c.retryTimer != nil {
+		c.retryTimer.Stop()
+		c.retryTimer = nil
+	}
+	c.retryAttempt = 0
+}
+
+// retryDelay gives the time to wait before the given retry attempt, backing
+// off from the initial timeout up to twice its value, with up to 20% of
+// random jitter subtracted.
+//
+// RTB1
+func retryDelay(timeout time.Duration, attempt int) time.Duration {
+	backoff := float64(attempt+2) / 3
+	if backoff > 2 {
+		backoff = 2
+	}
+	jitter := 1 - rand.Float64()*0.2
+	return time.Duration(float64(timeout) * backoff * jitter)
+}
+
+// deliver passes msg to enqueue, unless the channel is being handed over to
+// a different client, in which case the handoff decides.
+func (c *RealtimeChannel) deliver(msg *proto.ProtocolMessage, enqueue func(*proto.ProtocolMessage)) {
+	c.handoffMtx.Lock()
+	h, old := c.handoff, c.handoffOld
+	c.handoffMtx.Unlock()
+	if h == nil {
+		enqueue(msg)
+		return
+	}
+	h.deliver(msg, old, enqueue)
+}
+
+func (c *RealtimeChannel) setHandoff(h *handoff, old bool) {
+	c.handoffMtx.Lock()
+	c.handoff, c.handoffOld = h, old
+	c.handoffMtx.Unlock()
+}
+
 func (c *RealtimeChannel) isActive() bool {
 	return c.state.current == StateChanAttaching || c.state.current == StateChanAttached
 }
@@ -420,5 +1052,15 @@ func (c *RealtimeChannel) opts() *ClientOptions {
 }
 
 func (c *RealtimeChannel) logger() *LoggerOptions {
-	return c.client.logger()
+	return channelLogger(c.client, c.Name)
+}
+
+func channelLogger(client *RealtimeClient, name string) *LoggerOptions {
+	log := *client.logger()
+	if conn := client.Connection; conn != nil {
+		if id, _ := conn.logID.Load().(string); id != "" {
+			log = log.With(Field{Key: "connectionID", Value: id})
+		}
+	}
+	return log.With(Field{Key: "channel", Value: name}).forSubsystem(SubsystemChannel)
 }
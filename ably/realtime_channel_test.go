@@ -1,14 +1,20 @@
 package ably_test
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"reflect"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablymock"
 	"github.com/ably/ably-go/ably/ablytest"
 	"github.com/ably/ably-go/ably/internal/ablyutil"
 	"github.com/ably/ably-go/ably/proto"
@@ -225,3 +231,583 @@ func TestRealtimeChannel_AttachWhileDisconnected(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRealtimeChannel_RTL13(t *testing.T) {
+	t.Parallel()
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.ChannelRetryTimeout = time.Millisecond
+	})
+	in <- connectedMessage("connection-id")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+
+	channel := client.Channels.Get("test")
+	states := make(chan ably.State, 10)
+	channel.On(states)
+	expectAttach := func() {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != proto.ActionAttach || msg.Channel != "test" {
+				t.Fatalf("want ATTACH for channel test; got %v", msg)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for ATTACH")
+		}
+	}
+	expectState := func(want ably.StateEnum, code int) {
+		t.Helper()
+		select {
+		case state := <-states:
+			if state.State != want {
+				t.Fatalf("want state %v; got %v", want, state.State)
+			}
+			if code != 0 {
+				if err := checkError(code, state.Err); err != nil {
+					t.Fatal(err)
+				}
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+	attached := &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	detached := &proto.ProtocolMessage{
+		Action:  proto.ActionDetached,
+		Channel: "test",
+		Error:   &proto.ErrorInfo{Code: 40160, StatusCode: 401, Message: "capability revoked"},
+	}
+
+	if _, err := channel.Attach(); err != nil {
+		t.Fatal(err)
+	}
+	expectState(ably.StateChanAttaching, 0)
+	expectAttach()
+	in <- attached
+	expectState(ably.StateChanAttached, 0)
+
+	// RTL13a
+	in <- detached
+	expectState(ably.StateChanAttaching, 40160)
+	expectAttach()
+
+	// RTL13b
+	in <- detached
+	expectState(ably.StateChanSuspended, 40160)
+	expectState(ably.StateChanAttaching, 40160)
+	expectAttach()
+	in <- attached
+	expectState(ably.StateChanAttached, 0)
+}
+
+func TestRealtimeChannel_HistoryUntilAttach(t *testing.T) {
+	t.Parallel()
+
+	var query url.Values
+	rest := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`[]`))
+	}))
+	defer rest.Close()
+	u, _ := url.Parse(rest.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	server := ablymock.NewServer(nil)
+	client, err := ably.NewRealtimeClient(&ably.ClientOptions{
+		AuthOptions:      ably.AuthOptions{Token: "token"},
+		Dial:             server.Dial,
+		RestHost:         u.Hostname(),
+		Port:             port,
+		NoTLS:            true,
+		NoBinaryProtocol: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	channel := client.Channels.Get("test")
+	_, err = channel.HistoryWithOptions(context.Background(), ably.HistoryOptions{UntilAttach: true})
+	if err := checkError(ably.ErrBadRequest, err); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Publish("test", &proto.Message{Name: "before"})
+	server.Publish("test", &proto.Message{Name: "before"})
+	if err := ablytest.Wait(channel.Attach()); err != nil {
+		t.Fatal(err)
+	}
+	_, err = channel.HistoryWithOptions(context.Background(), ably.HistoryOptions{
+		Params:      &ably.PaginateParams{Limit: 10},
+		UntilAttach: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := query.Get("fromSerial"); got != "test:2" {
+		t.Errorf("want fromSerial=test:2; got %q", got)
+	}
+	if got := query.Get("limit"); got != "10" {
+		t.Errorf("want limit=10; got %q", got)
+	}
+}
+
+func TestRealtimeChannel_PublishMultiple(t *testing.T) {
+	t.Parallel()
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	_, channel := newAttachedPipeClient(t, in, out)
+	recv := func() *proto.ProtocolMessage {
+		for {
+			select {
+			case msg := <-out:
+				if msg.Action == proto.ActionMessage {
+					return msg
+				}
+			case <-time.After(ablytest.Timeout):
+				t.Fatal("timed out waiting for MESSAGE")
+			}
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- channel.PublishContext(context.Background(), "struct", struct {
+			Text string `json:"text"`
+		}{"hello"})
+	}()
+	msg := recv()
+	b, err := json.Marshal(msg.Messages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sent proto.Message
+	if err := json.Unmarshal(b, &sent); err != nil {
+		t.Fatal(err)
+	}
+	if data := sent.Data; !reflect.DeepEqual(data, map[string]interface{}{"text": "hello"}) {
+		t.Fatalf("want struct data sent as JSON; got %#v", data)
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = channel.PublishMultiple(ctx, []*proto.Message{{Name: "a"}, {Name: "b"}})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want error %v; got %v", context.DeadlineExceeded, err)
+	}
+	if n := len(recv().Messages); n != 2 {
+		t.Fatalf("want 2 messages; got %d", n)
+	}
+}
+
+func TestRealtimeChannel_AttachSerial(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client := newPipeRealtimeClient(t, in, out)
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+
+	channel := client.Channels.Get("test")
+	if serial := channel.AttachSerial(); serial != "" {
+		t.Errorf("expected no attach serial before attaching; got %q", serial)
+	}
+	for _, serial := range []string{"serial:1", "serial:2"} {
+		res, err := channel.Attach()
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-out // ATTACH
+		in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test", ChannelSerial: serial}
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		if got := channel.AttachSerial(); got != serial {
+			t.Errorf("expected attach serial %q; got %q", serial, got)
+		}
+		res, err = channel.Detach()
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-out // DETACH
+		in <- &proto.ProtocolMessage{Action: proto.ActionDetached, Channel: "test"}
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRealtimeChannel_ReattachAfterNewConnection_RTN15c3(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	dial := ablytest.MessagePipe(in, out)
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			conns <- conn
+			return conn, err
+		}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	first := <-conns
+
+	channel := client.Channels.Get("test")
+	res, err := channel.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-out // ATTACH
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	if err := res.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the members entered over the client's connection are entered
+	// again.
+	sub, err := channel.Presence.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionPresence,
+		Channel: "test",
+		Presence: []*proto.PresenceMessage{
+			{State: proto.PresenceEnter, Message: proto.Message{ID: "conn:0:0", ClientID: "alice", ConnectionID: "conn", Data: "here"}},
+			{State: proto.PresenceEnter, Message: proto.Message{ID: "other:0:0", ClientID: "bob", ConnectionID: "other", Data: "there"}},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-sub.PresenceChannel():
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for presence messages")
+		}
+	}
+
+	states := make(chan ably.State, 16)
+	channel.On(states, ably.StateChanAttaching, ably.StateChanAttached, ably.StateChanUpdate)
+	expectState := func(expected ably.StateEnum, resumed bool) {
+		t.Helper()
+		select {
+		case state := <-states:
+			if state.State != expected || state.Resumed != resumed {
+				t.Fatalf("expected %v with resumed %t; got %v with resumed %t", expected, resumed, state.State, state.Resumed)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v", expected)
+		}
+	}
+	expectSent := func(action proto.Action) *proto.ProtocolMessage {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != action {
+				t.Fatalf("expected %v to be sent; got %v", action, msg.Action)
+			}
+			return msg
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v to be sent", action)
+			return nil
+		}
+	}
+
+	first.Close()
+	in <- connectedMessage("new-conn")
+	expectState(ably.StateChanAttaching, false)
+	expectSent(proto.ActionAttach)
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	expectState(ably.StateChanAttached, false)
+	msg := expectSent(proto.ActionPresence)
+	if n := len(msg.Presence); n != 1 {
+		t.Fatalf("expected 1 presence message; got %d", n)
+	}
+	if p := msg.Presence[0]; p.State != proto.PresenceEnter || p.ClientID != "alice" || p.Data != "here" {
+		t.Errorf("expected alice to be entered again with %q; got %v for %q with %v", "here", p.State, p.ClientID, p.Data)
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+
+	// An ATTACHED resuming the attachment doesn't enter members again.
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test", Flags: proto.FlagResumed}
+	expectState(ably.StateChanUpdate, true)
+	select {
+	case msg := <-out:
+		t.Errorf("expected nothing to be sent; got %v", msg.Action)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestRealtimeChannel_MessageMetadata(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client, channel := newAttachedPipeClient(t, in, out)
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+
+	sub, err := channel.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	in <- &proto.ProtocolMessage{
+		Action:        proto.ActionMessage,
+		Channel:       "test",
+		ID:            "protocol-id",
+		ConnectionID:  "publisher",
+		ChannelSerial: "serial:1",
+		Timestamp:     1700000000000,
+		Messages: []*proto.Message{
+			{Name: "a", Data: "first"},
+			{Name: "b", Data: "second"},
+		},
+	}
+	for i, name := range []string{"a", "b"} {
+		select {
+		case msg := <-sub.MessageChannel():
+			type metadata struct {
+				ID, ConnectionID, ChannelSerial string
+				Timestamp                       int64
+			}
+			expected := metadata{"protocol-id:" + strconv.Itoa(i), "publisher", "serial:1", 1700000000000}
+			got := metadata{msg.ID, msg.ConnectionID, msg.ChannelSerial, msg.Timestamp}
+			if msg.Name != name || got != expected {
+				t.Errorf("expected message %q with %+v; got %q with %+v", name, expected, msg.Name, got)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for message %q", name)
+		}
+	}
+}
+
+func TestRealtimeChannel_SendOrderWhileAttaching_RTL6c2(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 64)
+	out := make(chan *proto.ProtocolMessage, 64)
+	client := newPipeRealtimeClient(t, in, out)
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages published while the channel is being attached are sent once
+	// it is, before the ones published as it gets attached.
+	channel := client.Channels.Get("test")
+	const n = 20
+	results := make(chan ably.Result, n)
+	go func() {
+		for i := 0; i < n; i++ {
+			res, err := channel.Publish(fmt.Sprint(i), nil)
+			if err != nil {
+				t.Error(err)
+				break
+			}
+			results <- res
+		}
+		close(results)
+	}()
+	select {
+	case msg := <-out:
+		if msg.Action != proto.ActionAttach {
+			t.Fatalf("expected ATTACH to be sent; got %v", msg.Action)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for ATTACH to be sent")
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	for i := 0; i < n; i++ {
+		select {
+		case msg := <-out:
+			if msg.Action != proto.ActionMessage || msg.Messages[0].Name != fmt.Sprint(i) {
+				t.Fatalf("expected message %d to be sent; got %v", i, msg)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for message %d to be sent", i)
+		}
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 1, Count: n}
+	for res := range results {
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRealtimeChannel_PublishFailFast(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	dial := ablytest.MessagePipe(in, out)
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			conns <- conn
+			return conn, err
+		}
+		o.NoAutoReconnect = true
+	})
+	defer client.Close()
+	failFast := ably.PublishOptions{FailFast: true}
+	publish := func(channel *ably.RealtimeChannel) error {
+		ctx, cancel := context.WithTimeout(context.Background(), ablytest.Timeout)
+		defer cancel()
+		return channel.PublishWithOptions(ctx, []*proto.Message{{Name: "greeting", Data: "hello"}}, failFast)
+	}
+
+	errc := make(chan error, 1)
+	go func() { errc <- publish(channel) }()
+	select {
+	case msg := <-out:
+		if msg.Action != proto.ActionMessage {
+			t.Fatalf("expected MESSAGE to be sent; got %v", msg.Action)
+		}
+		in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for MESSAGE to be sent")
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	// A channel which isn't attached isn't attached implicitly.
+	if err := publish(client.Channels.Get("other")); ably.ErrorCode(err) != ably.ErrChannelOperationFailedInvalidChannelState {
+		t.Errorf("expected code %d; got %v", ably.ErrChannelOperationFailedInvalidChannelState, err)
+	}
+
+	(<-conns).Close()
+	ablytest.Soon.WaitForState(t, client.Connection, ably.StateConnDisconnected)
+	if err := publish(channel); ably.ErrorCode(err) != ably.ErrDisconnected {
+		t.Errorf("expected code %d; got %v", ably.ErrDisconnected, err)
+	}
+	select {
+	case msg := <-out:
+		t.Fatalf("expected nothing to be sent; got %v", msg.Action)
+	default:
+	}
+}
+
+func TestRealtimeChannel_PublishFailFastNoWait(t *testing.T) {
+	t.Parallel()
+
+	failFast := ably.PublishOptions{FailFast: true}
+	publish := func(channel *ably.RealtimeChannel) error {
+		ctx, cancel := context.WithTimeout(context.Background(), ablytest.Timeout)
+		defer cancel()
+		return channel.PublishWithOptions(ctx, []*proto.Message{{Name: "greeting", Data: "hello"}}, failFast)
+	}
+
+	t.Run("rate limit", func(t *testing.T) {
+		t.Parallel()
+
+		in := make(chan *proto.ProtocolMessage, 16)
+		out := make(chan *proto.ProtocolMessage, 16)
+		client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.Clock = ablyutil.NewFakeClock(time.Unix(1500000000, 0))
+		})
+		defer func() {
+			in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+			client.Close()
+		}()
+		if err := channel.SetPublishRateLimit(&ably.RateLimit{Rate: 1, Wait: true}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := channel.Publish("greeting", "hello"); err != nil {
+			t.Fatal(err)
+		}
+		<-out // MESSAGE
+
+		// The limit would have Publish wait, but not a publish failing fast.
+		if err := publish(channel); ably.ErrorCode(err) != ably.ErrRateLimitExceededNonfatal {
+			t.Fatalf("expected code %d; got %v", ably.ErrRateLimitExceededNonfatal, err)
+		}
+		select {
+		case msg := <-out:
+			t.Fatalf("expected nothing to be sent; got %v", msg.Action)
+		default:
+		}
+	})
+
+	t.Run("in-flight window", func(t *testing.T) {
+		t.Parallel()
+
+		in := make(chan *proto.ProtocolMessage, 16)
+		out := make(chan *proto.ProtocolMessage, 16)
+		client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.MaxInFlightMessages = 1
+			o.InFlightPolicy = ably.InFlightBlock
+		})
+		defer func() {
+			in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+			client.Close()
+		}()
+		res, err := channel.Publish("greeting", "hello")
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-out // MESSAGE
+
+		// The full window would have Publish wait, but not a publish
+		// failing fast.
+		if err := publish(channel); ably.ErrorCode(err) != ably.ErrRateLimitExceededNonfatal {
+			t.Fatalf("expected code %d; got %v", ably.ErrRateLimitExceededNonfatal, err)
+		}
+		select {
+		case msg := <-out:
+			t.Fatalf("expected nothing to be sent; got %v", msg.Action)
+		default:
+		}
+
+		// Once there's room, it's published.
+		in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 1, Count: 1}
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		errc := make(chan error, 1)
+		go func() { errc <- publish(channel) }()
+		select {
+		case msg := <-out:
+			in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for MESSAGE to be sent")
+		}
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -1,6 +1,7 @@
 package ably
 
 import (
+	"context"
 	"net/http"
 	"time"
 
@@ -14,8 +15,10 @@ type RealtimeClient struct {
 	Auth       *Auth
 	Channels   *Channels
 	Connection *Conn
+	Push       *Push
 
 	rest *RestClient
+	tags tags
 }
 
 // NewRealtimeClient
@@ -32,7 +35,9 @@ func NewRealtimeClient(opts *ClientOptions) (*RealtimeClient, error) {
 		return nil, err
 	}
 	c.rest = rest
+	c.rest.opts.Logger.tags = &c.tags
 	c.Auth = rest.Auth
+	c.Push = rest.Push
 	c.Channels = newChannels(c)
 	conn, err := newConn(c.opts(), rest.Auth, connCallbacks{
 		c.onChannelMsg, c.onReconnectMsg, c.onConnStateChange,
@@ -61,29 +66,76 @@ func (c *RealtimeClient) Time() (time.Time, error) {
 	return c.rest.Time()
 }
 
+// TimeContext is like Time, with ctx used for the request.
+func (c *RealtimeClient) TimeContext(ctx context.Context) (time.Time, error) {
+	return c.rest.TimeContext(ctx)
+}
+
+// VerifyAuth checks the client's credentials with Ably; see
+// RestClient.VerifyAuth. The report includes the client's tags.
+func (c *RealtimeClient) VerifyAuth(ctx context.Context) (*AuthReport, error) {
+	report, err := c.rest.VerifyAuth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report.Tags = c.tags.snapshot()
+	return report, nil
+}
+
+// SetTag attaches the given application metadata to the client, replacing
+// a previous value set for the key.
+//
+// Tags are appended to every log line of the client, which helps correlating
+// the library logs with e.g. the tenant a client was created for when
+// running multiple clients in a single process.
+func (c *RealtimeClient) SetTag(key, value string) {
+	c.tags.set(key, value)
+}
+
+// GetTag gives the value of the tag set for the key with SetTag.
+func (c *RealtimeClient) GetTag(key string) (value string, ok bool) {
+	return c.tags.get(key)
+}
+
+// DeleteTag removes the tag set for the key with SetTag.
+func (c *RealtimeClient) DeleteTag(key string) {
+	c.tags.delete(key)
+}
+
+// Tags gives a copy of all the tags attached to the client.
+func (c *RealtimeClient) Tags() map[string]string {
+	return c.tags.all()
+}
+
 func (c *RealtimeClient) onChannelMsg(msg *proto.ProtocolMessage) {
-	c.Channels.Get(msg.Channel).notify(msg)
+	ch, ok := c.Channels.lookup(msg.Channel)
+	if !ok {
+		// Don't recreate a released channel.
+		c.logger().Printf(LogVerbose, "Realtime Client: dropping %v message for unknown channel %q", msg.Action, msg.Channel)
+		return
+	}
+	ch.notify(msg)
 }
 
 func (c *RealtimeClient) onReconnectMsg(msg *proto.ProtocolMessage) {
 	switch msg.Action {
 	case proto.ActionConnected:
-		if msg.Error != nil {
-			// (RTN15c3)
-			for _, ch := range c.Channels.All() {
-				switch ch.State() {
-				case StateConnSuspended:
-					ch.attach(false)
-				case StateChanAttaching, StateChanAttached:
-					ch.mayAttach(false, false)
-				}
+		// The connection is a new one, rather than the resumed one, whether
+		// Ably tells why or not; channels are attached again, and their
+		// presence members entered again once attached (RTN15c3, RTP17i).
+		for _, ch := range c.Channels.Iterate() {
+			switch ch.State() {
+			case StateChanSuspended:
+				ch.attach(false)
+			case StateChanAttaching, StateChanAttached:
+				ch.mayAttach(false, false)
 			}
 		}
 
 	case proto.ActionError:
 		// (RTN15c4)
 
-		for _, ch := range c.Channels.All() {
+		for _, ch := range c.Channels.Iterate() {
 			ch.state.syncSet(StateChanFailed, msg.Error)
 		}
 	}
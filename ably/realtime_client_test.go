@@ -1,12 +1,17 @@
 package ably_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/ably/ably-go/ably"
 	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
 )
 
 func TestRealtimeClient_RealtimeHost(t *testing.T) {
@@ -28,7 +33,9 @@ func TestRealtimeClient_RealtimeHost(t *testing.T) {
 	for _, host := range hosts {
 		opts := rec.Options(host)
 		opts.Listener = stateRec.Channel()
-		client, err := ably.NewRealtimeClient(app.Options(opts))
+		opts = app.Options(opts)
+		opts.Environment = "" // can't be set along with RealtimeHost
+		client, err := ably.NewRealtimeClient(opts)
 		if err != nil {
 			t.Errorf("NewRealtimeClient=%s (host=%s)", err, host)
 			continue
@@ -165,3 +172,307 @@ func TestRealtimeClient_DontCrashOnCloseWhenEchoOff(t *testing.T) {
 	app, client := ablytest.NewRealtimeClient(&ably.ClientOptions{NoConnect: true})
 	defer safeclose(t, app, client)
 }
+
+func TestRealtimeClient_Tags(t *testing.T) {
+	t.Parallel()
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	logs := &logRecorder{}
+	var mtx sync.Mutex
+	var stateTags, transportTags []map[string]string
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Logger = ably.LoggerOptions{Level: ably.LogVerbose, Logger: logs}
+		o.OnConnectionStateChange = func(st ably.State) {
+			mtx.Lock()
+			stateTags = append(stateTags, st.Tags)
+			mtx.Unlock()
+		}
+		o.OnTransportEvent = func(ev ably.TransportEvent) {
+			mtx.Lock()
+			transportTags = append(transportTags, ev.Tags)
+			mtx.Unlock()
+		}
+	})
+
+	client.SetTag("tenant", "acme")
+	client.SetTag("region", "eu")
+	client.SetTag("stale", "x")
+	client.DeleteTag("stale")
+	if v, ok := client.GetTag("tenant"); !ok || v != "acme" {
+		t.Fatalf("want tenant=acme; got %q (ok=%t)", v, ok)
+	}
+	if _, ok := client.GetTag("stale"); ok {
+		t.Fatal("want stale tag to be deleted")
+	}
+	assertDeepEquals(t, map[string]string{"tenant": "acme", "region": "eu"}, client.Tags())
+
+	in <- connectedMessage("connection-id")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	var tagged bool
+	for _, line := range logs.Lines() {
+		if strings.HasSuffix(line, "[region=eu tenant=acme]") {
+			tagged = true
+		}
+	}
+	if !tagged {
+		t.Fatalf("want log lines to be tagged; got %q", logs.Lines())
+	}
+
+	// The hooks' payloads are tagged too.
+	mtx.Lock()
+	defer mtx.Unlock()
+	tags := map[string]string{"tenant": "acme", "region": "eu"}
+	if len(stateTags) == 0 || len(transportTags) == 0 {
+		t.Fatalf("want hooks to be called; got %d state changes and %d transport events", len(stateTags), len(transportTags))
+	}
+	for _, got := range append(stateTags, transportTags...) {
+		assertDeepEquals(t, tags, got)
+	}
+}
+
+func TestRealtimeClient_Replace(t *testing.T) {
+	t.Parallel()
+	in1 := make(chan *proto.ProtocolMessage, 16)
+	out1 := make(chan *proto.ProtocolMessage, 16)
+	in2 := make(chan *proto.ProtocolMessage, 16)
+	out2 := make(chan *proto.ProtocolMessage, 16)
+	recv := func(out chan *proto.ProtocolMessage, action proto.Action) {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != action {
+				t.Fatalf("want %v; got %v", action, msg)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v", action)
+		}
+	}
+	message := func(ids ...string) *proto.ProtocolMessage {
+		msg := &proto.ProtocolMessage{Action: proto.ActionMessage, Channel: "test"}
+		for _, id := range ids {
+			msg.Messages = append(msg.Messages, &proto.Message{ID: id, Name: "event", Data: id})
+		}
+		return msg
+	}
+	attached := &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+
+	client := newPipeRealtimeClient(t, in1, out1)
+	client.SetTag("tenant", "acme")
+	in1 <- connectedMessage("old")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	channel := client.Channels.Get("test")
+	sub, err := channel.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	recv(out1, proto.ActionAttach)
+	in1 <- attached
+
+	expect := func(id string) {
+		t.Helper()
+		select {
+		case msg := <-sub.MessageChannel():
+			if msg.ID != id {
+				t.Fatalf("want message %q; got %q", id, msg.ID)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for message %q", id)
+		}
+	}
+
+	type result struct {
+		client *ably.RealtimeClient
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		c, err := client.Replace(&ably.ClientOptions{
+			AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+			Dial:        ablytest.MessagePipe(in2, out2),
+		})
+		done <- result{c, err}
+	}()
+	in2 <- connectedMessage("new")
+	recv(out2, proto.ActionAttach)
+	in1 <- message("a")
+	expect("a")
+	// Received by the new connection before the swap; "a" was already
+	// delivered through the old one.
+	in2 <- message("a", "b")
+	in2 <- attached
+	recv(out1, proto.ActionClose)
+	in1 <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+
+	var res result
+	select {
+	case res = <-done:
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for Replace")
+	}
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	expect("b")
+	in2 <- message("c")
+	expect("c")
+
+	// Shutting the replaced client down leaves the moved subscriptions be.
+	ctx, cancel := context.WithTimeout(context.Background(), ablytest.Timeout)
+	defer cancel()
+	if err := client.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	in2 <- message("d")
+	expect("d")
+
+	if state := client.Connection.State(); state != ably.StateConnClosed {
+		t.Errorf("want old client to be closed; got %v", state)
+	}
+	if state := res.client.Channels.Get("test").State(); state != ably.StateChanAttached {
+		t.Errorf("want channel to be attached; got %v", state)
+	}
+	if v, _ := res.client.GetTag("tenant"); v != "acme" {
+		t.Errorf("want tag tenant=acme; got %q", v)
+	}
+}
+
+func TestRealtimeClient_ReplaceWithTraffic(t *testing.T) {
+	t.Parallel()
+	in1 := make(chan *proto.ProtocolMessage, 16)
+	out1 := make(chan *proto.ProtocolMessage, 16)
+	in2 := make(chan *proto.ProtocolMessage, 16)
+	out2 := make(chan *proto.ProtocolMessage, 16)
+	message := func(id string) *proto.ProtocolMessage {
+		return &proto.ProtocolMessage{
+			Action:   proto.ActionMessage,
+			Channel:  "test",
+			Messages: []*proto.Message{{ID: id, Name: "event"}},
+		}
+	}
+	attached := &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+
+	client := newPipeRealtimeClient(t, in1, out1)
+	in1 <- connectedMessage("old")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := client.Channels.Get("test").Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	<-out1 // ATTACH
+	in1 <- attached
+	received := make(chan string, 1)
+	go func() {
+		for msg := range sub.MessageChannel() {
+			if msg.ID == "end" {
+				received <- msg.ID
+			}
+		}
+	}()
+
+	// The old connection keeps receiving messages while the channel is
+	// moved, including after it's told to close.
+	closing := make(chan struct{})
+	stop := make(chan struct{})
+	pumped := make(chan struct{})
+	go func() {
+		defer close(pumped)
+		closing := closing
+		for i := 0; ; i++ {
+			select {
+			case <-closing:
+				closing = nil
+				in1 <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+			case in1 <- message(fmt.Sprint("old-", i)):
+			case <-stop:
+				return
+			}
+		}
+	}()
+	done := make(chan error, 1)
+	go func() {
+		c, err := client.Replace(&ably.ClientOptions{
+			AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+			Dial:        ablytest.MessagePipe(in2, out2),
+		})
+		if err == nil {
+			defer func() {
+				in2 <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+				c.Close()
+			}()
+			in2 <- message("end")
+			select {
+			case <-received:
+			case <-time.After(ablytest.Timeout):
+				err = errors.New("timed out waiting for a message through the new client")
+			}
+		}
+		done <- err
+	}()
+	in2 <- connectedMessage("new")
+	<-out2 // ATTACH
+	in2 <- attached
+	for msg := range out1 {
+		if msg.Action == proto.ActionClose {
+			break
+		}
+	}
+	close(closing)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for Replace")
+	}
+	close(stop)
+	<-pumped
+}
+
+func TestClientOptions_LifecycleHooks(t *testing.T) {
+	t.Parallel()
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	var mtx sync.Mutex
+	var events []string
+	record := func(s string) {
+		mtx.Lock()
+		events = append(events, s)
+		mtx.Unlock()
+	}
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.OnConnectionStateChange = func(s ably.State) { record("connection " + s.State.String()) }
+		o.OnChannelStateChange = func(s ably.State) { record(s.Channel + " " + s.State.String()) }
+		o.OnTransportEvent = func(e ably.TransportEvent) { record("transport " + e.Type.String()) }
+	})
+	in <- connectedMessage("id")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	res, err := client.Channels.Get("test").Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	if err := res.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	assertDeepEquals(t, []string{
+		"connection " + ably.StateConnConnecting.String(),
+		"transport dialing",
+		"transport opened",
+		"connection " + ably.StateConnConnected.String(),
+		"test " + ably.StateChanAttaching.String(),
+		"test " + ably.StateChanAttached.String(),
+	}, events)
+}
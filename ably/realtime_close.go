@@ -0,0 +1,81 @@
+package ably
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// drainInterval is how often CloseContext checks whether all published
+// messages were acknowledged.
+const drainInterval = 10 * time.Millisecond
+
+// UnacknowledgedError is returned by RealtimeClient.CloseContext when
+// messages published before it was called weren't acknowledged by the time
+// its context was done.
+type UnacknowledgedError struct {
+	// Messages lists the protocol messages, published or presence, which
+	// were either still queued or waiting for an acknowledgement.
+	Messages []*proto.ProtocolMessage
+
+	// Err is the error of the context.
+	Err error
+}
+
+func (e *UnacknowledgedError) Error() string {
+	return fmt.Sprintf("closing with %d unacknowledged protocol messages: %v", len(e.Messages), e.Err)
+}
+
+// Unwrap gives e.Err.
+func (e *UnacknowledgedError) Unwrap() error {
+	return e.Err
+}
+
+// CloseContext closes the client like Close, after waiting for the messages
+// published, including the ones queued and not yet sent, to be acknowledged
+// by the server.
+//
+// If ctx is done first, the connection is closed nonetheless and
+// CloseContext returns an *UnacknowledgedError listing the messages which
+// may not have been published.
+func (c *RealtimeClient) CloseContext(ctx context.Context) error {
+	chans := c.Channels.Iterate()
+	for _, ch := range chans {
+		ch.batch.flush(ch)
+	}
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	var unacked error
+drain:
+	for atomic.LoadInt64(&c.Connection.queueDepth) > 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			unacked = &UnacknowledgedError{
+				Messages: c.unacknowledged(chans),
+				Err:      ctx.Err(),
+			}
+			break drain
+		}
+	}
+	if err := c.Close(); err != nil && unacked == nil {
+		return err
+	}
+	return unacked
+}
+
+// unacknowledged gives the protocol messages either queued by the connection
+// or chans, or waiting for an ACK.
+func (c *RealtimeClient) unacknowledged(chans []*RealtimeChannel) []*proto.ProtocolMessage {
+	c.Connection.state.Lock()
+	msgs := c.Connection.pending.messages()
+	c.Connection.state.Unlock()
+	msgs = append(msgs, c.Connection.queue.messages()...)
+	for _, ch := range chans {
+		msgs = append(msgs, ch.queue.messages()...)
+	}
+	return msgs
+}
@@ -0,0 +1,87 @@
+package ably_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestRealtimeClient_CloseContext(t *testing.T) {
+	t.Parallel()
+
+	setup := func(t *testing.T) (client *ably.RealtimeClient, in, out chan *proto.ProtocolMessage) {
+		in = make(chan *proto.ProtocolMessage, 1)
+		out = make(chan *proto.ProtocolMessage, 16)
+		client, channel := newAttachedPipeClient(t, in, out)
+		if _, err := channel.Publish("event", "data"); err != nil {
+			t.Fatal(err)
+		}
+		return client, in, out
+	}
+	recv := func(t *testing.T, out chan *proto.ProtocolMessage, action proto.Action) *proto.ProtocolMessage {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != action {
+				t.Fatalf("want %v; got %v", action, msg.Action)
+			}
+			return msg
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v", action)
+			return nil
+		}
+	}
+
+	t.Run("drained", func(t *testing.T) {
+		t.Parallel()
+		client, in, out := setup(t)
+		msg := recv(t, out, proto.ActionMessage)
+
+		done := make(chan error, 1)
+		go func() {
+			done <- client.CloseContext(context.Background())
+		}()
+		select {
+		case msg := <-out:
+			t.Fatalf("want nothing sent before the ACK; got %v", msg)
+		case <-time.After(50 * time.Millisecond):
+		}
+		in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+		recv(t, out, proto.ActionClose)
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("unacknowledged", func(t *testing.T) {
+		t.Parallel()
+		client, in, out := setup(t)
+		recv(t, out, proto.ActionMessage)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		done := make(chan error, 1)
+		go func() {
+			done <- client.CloseContext(ctx)
+		}()
+		recv(t, out, proto.ActionClose)
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		err := <-done
+		var unacked *ably.UnacknowledgedError
+		if !errors.As(err, &unacked) {
+			t.Fatalf("want *ably.UnacknowledgedError; got %v", err)
+		}
+		if len(unacked.Messages) != 1 || unacked.Messages[0].Messages[0].Name != "event" {
+			t.Errorf("want the published message listed; got %v", unacked.Messages)
+		}
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("want context.DeadlineExceeded; got %v", err)
+		}
+	})
+}
@@ -1,10 +1,14 @@
 package ably
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/ably/ably-go/ably/internal/ablyutil"
@@ -18,8 +22,17 @@ var (
 // Conn represents a single connection RealtimeClient instantiates for
 // communication with Ably servers.
 type Conn struct {
+	// queueDepth and the times below, in Unix nanoseconds, are accessed
+	// atomically; they're first in the struct to keep them 64-bit aligned.
+	queueDepth            int64
+	lastActivity          int64 // last message sent or received
+	lastHeartbeatSent     int64
+	lastHeartbeatReceived int64
+
 	details      proto.ConnectionDetails
 	id           string
+	lastID       string // ID of the last connection, kept while disconnected
+	idListeners  map[chan<- ConnectionIDChange]struct{}
 	serial       int64
 	msgSerial    int64
 	err          error
@@ -31,7 +44,28 @@ type Conn struct {
 	queue        *msgQueue
 	auth         *Auth
 	callbacks    connCallbacks
+	inFlight     *sync.Cond // signaled when ACKs, NACKs or state changes make room for messages
 	reconnecting bool
+	recorder     *protocolRecorder
+
+	// retryTimer, retryAt and retryAttempt track the automatic reconnection
+	// attempts while disconnected or suspended.
+	retryTimer   ablyutil.Timer
+	retryAt      time.Time
+	retryAttempt int
+
+	// resumeErr is the error a resume was refused with, when the connection
+	// is being replaced with a new one as set by RetryOnResumeFailure.
+	resumeErr *proto.ErrorInfo
+
+	// sendMtx is held from numbering a message until it's written to the
+	// transport, for messages to be sent in the order of their serials. It
+	// may be locked while holding c.state, but not the other way around.
+	sendMtx sync.Mutex
+
+	// logID mirrors id for use by logger, which can be called with or
+	// without the state lock held.
+	logID atomic.Value
 }
 
 type connCallbacks struct {
@@ -50,12 +84,18 @@ type connCallbacks struct {
 func newConn(opts *ClientOptions, auth *Auth, callbacks connCallbacks) (*Conn, error) {
 	c := &Conn{
 		opts:      opts,
-		state:     newStateEmitter(StateConn, StateConnInitialized, "", auth.logger()),
-		pending:   newPendingEmitter(auth.logger()),
 		auth:      auth,
 		callbacks: callbacks,
 	}
-	c.queue = newMsgQueue(c)
+	c.state = newStateEmitter(StateConn, StateConnInitialized, "", c.logger)
+	c.pending = newPendingEmitter(c.logger, opts.metrics())
+	c.state.hook = opts.OnConnectionStateChange
+	c.state.tags = opts.Logger.tags
+	c.inFlight = sync.NewCond(&c.state.Mutex)
+	c.queue = newMsgQueue(c, c.sendQueued)
+	if opts.ProtocolRecorder != nil {
+		c.recorder = newProtocolRecorder(opts.ProtocolRecorder, opts, c.logger)
+	}
 	if opts.Listener != nil {
 		c.On(opts.Listener)
 	}
@@ -71,7 +111,7 @@ func (c *Conn) dial(proto string, u *url.URL) (proto.Conn, error) {
 	if c.opts.Dial != nil {
 		return c.opts.Dial(proto, u)
 	}
-	return ablyutil.DialWebsocket(proto, u)
+	return ablyutil.DialWebsocketTimeouts(proto, u, c.opts.websocketTimeouts())
 }
 
 // Connect is used to connect to Ably servers manually, when the client owning
@@ -83,14 +123,97 @@ func (c *Conn) dial(proto string, u *url.URL) (proto.Conn, error) {
 // is non-nil.
 // If authorization succeeds, the returned Result value can be used to wait
 // until connection confirmation is received from a server.
+//
+// If the connection is disconnected or suspended, either waiting to
+// reconnect automatically or with ClientOptions.NoAutoReconnect set, it's
+// reconnected right away, resuming the previous connection if possible.
 func (c *Conn) Connect() (Result, error) {
+	c.state.Lock()
+	retrying := c.stopRetry()
+	broken := c.lastID != "" && (c.state.current == StateConnDisconnected || c.state.current == StateConnSuspended)
+	c.state.Unlock()
+	if retrying || broken {
+		return c.reconnect(true)
+	}
 	return c.connect(true)
 }
 
+// RetryIn gives the time left until the connection, being disconnected or
+// suspended, is reconnected automatically. It gives 0 if no reconnection is
+// scheduled.
+func (c *Conn) RetryIn() time.Duration {
+	c.state.Lock()
+	defer c.state.Unlock()
+	if c.retryTimer == nil {
+		return 0
+	}
+	if d := c.retryAt.Sub(c.opts.getClock().Now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// retryLater moves the connection to the disconnected state, or to the
+// suspended one once it has been inactive for longer than TimeoutSuspended,
+// and schedules the next reconnection attempt (RTN14d, RTN14e). It must be
+// called with c.state held.
+func (c *Conn) retryLater(err error) error {
+	if c.opts.NoAutoReconnect {
+		return c.setState(StateConnDisconnected, err)
+	}
+	c.retryAttempt++
+	state := StateConnDisconnected
+	delay := retryDelay(c.opts.disconnectedRetryTimeout(), c.retryAttempt)
+	last := atomic.LoadInt64(&c.lastActivity)
+	if last != 0 && c.since(last) > c.opts.timeoutSuspended() {
+		state = StateConnSuspended
+		delay = c.opts.suspendedRetryTimeout()
+	}
+	c.stopRetry()
+	clock := c.opts.getClock()
+	at := clock.Now().Add(delay)
+	c.retryAt = at
+	c.retryTimer = clock.AfterFunc(delay, func() { c.retry(at) })
+	c.logger().Printf(LogInfo, "Conn: reconnecting in %v (attempt %d): %v", delay, c.retryAttempt, err)
+	err = c.setState(state, err)
+	if state == StateConnSuspended {
+		// (RTN7e)
+		c.queue.Fail(err)
+	}
+	return err
+}
+
+// retry reconnects once the retry timer scheduled for at fires, unless it
+// was stopped meanwhile.
+func (c *Conn) retry(at time.Time) {
+	c.state.Lock()
+	if c.retryTimer == nil || !c.retryAt.Equal(at) {
+		c.state.Unlock()
+		return
+	}
+	c.retryTimer = nil
+	c.retryAt = time.Time{}
+	c.state.Unlock()
+	c.reconnect(false)
+}
+
+// stopRetry stops the retry timer, telling whether one was pending. It must
+// be called with c.state held.
+func (c *Conn) stopRetry() bool {
+	if c.retryTimer == nil {
+		return false
+	}
+	c.retryTimer.Stop()
+	c.retryTimer = nil
+	c.retryAt = time.Time{}
+	return true
+}
+
 var connectResultStates = []StateEnum{
 	StateConnConnected, // expected state
 	StateConnFailed,
 	StateConnDisconnected,
+	StateConnSuspended,
 }
 
 func (c *Conn) connect(result bool) (Result, error) {
@@ -99,22 +222,57 @@ func (c *Conn) connect(result bool) (Result, error) {
 
 func (c *Conn) reconnect(result bool) (Result, error) {
 	c.state.Lock()
+	if c.stateExpired() {
+		// Ably would refuse to resume the connection; connect anew right
+		// away, reporting it as if Ably had accepted the connection but not
+		// its resume (RTN15g, RTN15c3).
+		c.resumeErr = &proto.ErrorInfo{
+			StatusCode: http.StatusBadRequest,
+			Code:       ErrUnableToRecoverConnectionConnectionExpired,
+			Message:    "unable to resume connection: its state expired while disconnected",
+		}
+		c.state.Unlock()
+		c.logger().Printf(LogWarning, "Conn: connection state expired, connecting anew")
+		return c.connect(result)
+	}
 	connKey := c.details.ConnectionKey
 	connSerial := c.serial
+	// We need to set this so when the next message arrives it will be treated
+	// as the response to reconnection request. It's set before dialing, as
+	// the message may be received as soon as the transport is opened.
+	c.reconnecting = true
 	c.state.Unlock()
+	c.opts.metrics().Add(MetricReconnects, 1)
 	r, err := c.connectWithRecovery(result, connKey, connSerial)
 	if err != nil {
+		c.state.Lock()
+		c.reconnecting = false
+		c.state.Unlock()
 		return nil, err
 	}
-	// We have successfully dialed reconnection request. We need to set this so
-	// when the next message arrives it will be treated as the response to
-	// reconnection request.
-	c.state.Lock()
-	c.reconnecting = true
-	c.state.Unlock()
 	return r, nil
 }
 
+// stateExpired tells whether the connection has been inactive for longer
+// than Ably keeps its state, given by the connectionStateTtl and
+// maxIdleInterval of the last CONNECTED message, so that it can't be
+// resumed (RTN15g1). It must be called with the state lock held.
+func (c *Conn) stateExpired() bool {
+	ttl := time.Duration(c.details.ConnectionStateTTL) * time.Millisecond
+	last := atomic.LoadInt64(&c.lastActivity)
+	if ttl <= 0 || last == 0 {
+		return false
+	}
+	maxIdleInterval := time.Duration(c.details.MaxIdleInterval) * time.Millisecond
+	return c.since(last) > ttl+maxIdleInterval
+}
+
+// since gives the time elapsed since the given Unix nanoseconds, according
+// to the client's clock.
+func (c *Conn) since(ns int64) time.Duration {
+	return c.opts.getClock().Now().Sub(time.Unix(0, ns))
+}
+
 func (c *Conn) connectWithRecovery(result bool, connKey string, connSerial int64) (Result, error) {
 	c.state.Lock()
 	defer c.state.Unlock()
@@ -130,11 +288,14 @@ func (c *Conn) connectWithRecovery(result bool, connKey string, connSerial int64
 	if result {
 		res = c.state.listenResult(connectResultStates...)
 	}
+	_, span := c.opts.startSpan(nil, "ably.realtime.connect", Field{Key: "ably.resume", Value: connKey != ""})
+	endSpanOnState(span, c.state, connectResultStates[0], connectResultStates...)
 	proto := c.opts.protocol()
 	query := url.Values{
-		"timestamp": []string{strconv.FormatInt(TimeNow(), 10)},
+		"timestamp": []string{strconv.FormatInt(Time(c.opts.now()), 10)},
 		"echo":      []string{"true"},
 		"format":    []string{"msgpack"},
+		agentParam:  []string{c.opts.agent()},
 	}
 	if c.opts.NoEcho {
 		query.Set("echo", "false")
@@ -157,12 +318,23 @@ func (c *Conn) connectWithRecovery(result bool, connKey string, connSerial int64
 		query.Set("connectionSerial", fmt.Sprint(connSerial))
 	}
 	u.RawQuery = query.Encode()
+	c.transportEvent(TransportDialing, u.Host, nil)
 	conn, err := c.dial(proto, u)
 	if err != nil {
+		c.transportEvent(TransportDialFailed, u.Host, err)
+		if c.reconnecting || c.resumeErr != nil {
+			// The connection was established before; it's reconnected later
+			// rather than failed (RTN15a).
+			return nil, c.retryLater(err)
+		}
 		return nil, c.setState(StateConnFailed, err)
 	}
+	c.transportEvent(TransportOpened, u.Host, nil)
+	if c.recorder != nil {
+		conn = c.recorder.wrap(conn, u)
+	}
 	if c.logger().Is(LogVerbose) {
-		c.setConn(verboseConn{conn: conn, logger: c.logger()})
+		c.setConn(verboseConn{conn: conn, logger: c.logger})
 	} else {
 		c.setConn(conn)
 	}
@@ -198,13 +370,22 @@ func (c *Conn) close() (Result, error) {
 		StateConnClosing,
 		StateConnClosed,
 		StateConnInitialized,
-		StateConnFailed,
-		StateConnDisconnected:
+		StateConnFailed:
+		return nopResult, nil
+	case
+		StateConnDisconnected,
+		StateConnSuspended:
+		if c.stopRetry() || c.opts.NoAutoReconnect {
+			// There's no transport to close (RTN12d).
+			c.setState(StateConnClosed, nil)
+		}
 		return nopResult, nil
 	}
 	res := c.state.listenResult(closeResultStates...)
 	c.setState(StateConnClosing, nil)
 	msg := &proto.ProtocolMessage{Action: proto.ActionClose}
+	c.sendMtx.Lock()
+	defer c.sendMtx.Unlock()
 	c.updateSerial(msg, nil)
 	return res, c.conn.Send(msg)
 }
@@ -220,13 +401,55 @@ func (c *Conn) ID() string {
 
 // Key gives unique key string obtained from Ably upon successful connection.
 // The key may change due to reconnection and recovery; on every received
-// StatConnConnected event previously obtained Key is no longer valid.
+// StateConnConnected event previously obtained Key is no longer valid.
 func (c *Conn) Key() string {
 	c.state.Lock()
 	defer c.state.Unlock()
 	return c.details.ConnectionKey
 }
 
+// ConnectionIDChange is emitted when the connection is given a new ID, i.e.
+// when it's established for the first time, or anew after it couldn't be
+// resumed. The state Ably kept for the previous connection, e.g. the presence
+// members it entered, is then gone.
+type ConnectionIDChange struct {
+	Previous string // empty for the first connection
+	ID       string
+	Key      string
+	Err      error // why the previous connection wasn't resumed, if Ably told
+}
+
+// OnIDChange registers ch to receive a ConnectionIDChange every time the
+// connection ID changes. The event is emitted before the
+// StateConnConnected one; it's dropped if ch isn't ready to receive it.
+func (c *Conn) OnIDChange(ch chan<- ConnectionIDChange) {
+	c.state.Lock()
+	defer c.state.Unlock()
+	if c.idListeners == nil {
+		c.idListeners = make(map[chan<- ConnectionIDChange]struct{})
+	}
+	c.idListeners[ch] = struct{}{}
+}
+
+// OffIDChange unregisters ch registered with OnIDChange.
+func (c *Conn) OffIDChange(ch chan<- ConnectionIDChange) {
+	c.state.Lock()
+	defer c.state.Unlock()
+	delete(c.idListeners, ch)
+}
+
+// emitIDChange emits change to the listeners registered with OnIDChange. It
+// must be called with c.state held.
+func (c *Conn) emitIDChange(change ConnectionIDChange) {
+	for ch := range c.idListeners {
+		select {
+		case ch <- change:
+		default:
+			c.logger().Printf(LogWarning, "dropping connection ID change to %s due to slow receiver", change.ID)
+		}
+	}
+}
+
 // Ping issues a ping request against configured endpoint and returns TTR times
 // for ping request and pong response.
 //
@@ -236,6 +459,22 @@ func (c *Conn) Ping() (ping, pong time.Duration, err error) {
 	return 0, 0, errors.New("TODO")
 }
 
+// LastHeartbeat gives the times the last heartbeat was sent to Ably, as
+// enabled by ClientOptions.HeartbeatInterval, and received from Ably. Either
+// is zero if no heartbeat was. Health checks may use them to tell whether
+// the connection is still alive.
+func (c *Conn) LastHeartbeat() (sent, received time.Time) {
+	return unixNanoTime(atomic.LoadInt64(&c.lastHeartbeatSent)),
+		unixNanoTime(atomic.LoadInt64(&c.lastHeartbeatReceived))
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
 // Reason gives last known error that caused connection transit to
 // StateConnFailed state.
 func (c *Conn) Reason() error {
@@ -270,6 +509,26 @@ func (c *Conn) On(ch chan<- State, states ...StateEnum) {
 	c.state.on(ch, states...)
 }
 
+// StateChanges gives a channel receiving the current state of the connection,
+// then every subsequent state change, until ctx is done, after which the
+// channel is closed.
+//
+// Unlike with On, state changes aren't dropped if the receiver falls behind;
+// they're buffered until received.
+func (c *Conn) StateChanges(ctx context.Context) <-chan State {
+	return c.state.changes(ctx)
+}
+
+// WaitForState blocks until the connection is in one of the given states,
+// returning the state it's in, or until ctx is done, returning ctx.Err().
+//
+// If the connection is already in one of the states, WaitForState returns
+// immediately. If no states are given, it waits for the next state change.
+// If any state isn't a connection state, the method panics.
+func (c *Conn) WaitForState(ctx context.Context, states ...StateEnum) (State, error) {
+	return c.state.waitFor(ctx, states...)
+}
+
 // Off removes c from listening on the given connection state transitions.
 //
 // If no states are given, c is removed for all of the connection's states.
@@ -279,26 +538,140 @@ func (c *Conn) Off(ch chan<- State, states ...StateEnum) {
 	c.state.off(ch, states...)
 }
 
+// requeuePending puts the messages still waiting for an ACK back at the head
+// of the queue, in order, for them to be sent again over a new connection
+// before any other, numbered anew (RTN19a, RTN19a2). The ones which can't be
+// sent over the new connection, e.g. as a client ID it doesn't allow, fail
+// when the queue is flushed. It must be called with c.state held.
+func (c *Conn) requeuePending() {
+	pending := c.pending.take()
+	c.msgSerial = 0
+	if len(pending) == 0 {
+		return
+	}
+	msgs := make([]msgch, 0, len(pending))
+	for _, sch := range pending {
+		msgs = append(msgs, msgch{sch.msg, sch.ch})
+	}
+	c.queue.requeue(msgs...)
+}
+
+// resumePending gives the messages still waiting for an ACK, to be sent
+// again with resendPending over the resumed connection with their serials,
+// which the following messages are numbered after (RTN19a). It must be
+// called with c.state held.
+func (c *Conn) resumePending() []*proto.ProtocolMessage {
+	msgs := c.pending.messages()
+	if len(msgs) != 0 {
+		c.msgSerial = msgs[len(msgs)-1].MsgSerial + 1
+	}
+	return msgs
+}
+
+// resendPending sends msgs, given by resumePending, again. It must be called
+// before the queue is flushed, for them to be sent before the queued ones.
+// Messages which can't be sent stay pending, to be sent again once
+// reconnected.
+func (c *Conn) resendPending(msgs []*proto.ProtocolMessage) {
+	c.sendMtx.Lock()
+	defer c.sendMtx.Unlock()
+	for _, msg := range msgs {
+		if err := c.conn.Send(msg); err != nil {
+			c.logger().Printf(LogWarning, "failure sending message (serial=%d) again: %v", msg.MsgSerial, err)
+			return
+		}
+	}
+}
+
+// failPending fails the messages waiting for an ACK with err. It must be
+// called with c.state held.
+func (c *Conn) failPending(err error) {
+	n := c.pending.Len()
+	c.pending.Fail(err)
+	c.addQueueDepth(-n)
+}
+
 func (c *Conn) updateSerial(msg *proto.ProtocolMessage, listen chan<- error) {
 	const maxint64 = 1<<63 - 1
 	msg.MsgSerial = c.msgSerial
 	c.msgSerial = (c.msgSerial + 1) % maxint64
 	if listen != nil {
-		c.pending.Enqueue(msg.MsgSerial, listen)
+		c.pending.Enqueue(msg, listen)
+		c.addQueueDepth(1)
 	}
 }
 
+// errRequeued is returned by sendQueued when the message was put back in the
+// queue.
+var errRequeued = errors.New("message requeued")
+
+// sendMode tells how Conn.sendMsg deals with a message which can't be sent
+// right away.
+type sendMode int
+
+const (
+	sendOrQueue sendMode = iota // queue it, unless ClientOptions.NoQueueing is set
+	sendQueued                  // put it back at the head of the queue it was taken from
+	sendNoQueue                 // fail
+)
+
+// send sends msg if the connection is connected, or queues it to be sent once
+// it is. Messages are sent in the order send is called: while queued messages
+// are being flushed, msg is queued behind them (RTL6c2).
 func (c *Conn) send(msg *proto.ProtocolMessage, listen chan<- error) error {
+	return c.sendMsg(msg, listen, sendOrQueue)
+}
+
+// sendQueued sends msg, taken from the head of the queue by msgQueue.Flush.
+// If the connection isn't connected anymore, msg is put back at the head of
+// the queue and errRequeued is returned.
+func (c *Conn) sendQueued(msg *proto.ProtocolMessage, listen chan<- error) error {
+	return c.sendMsg(msg, listen, sendQueued)
+}
+
+// sendNoQueue sends msg like send, but fails rather than queueing it if the
+// connection isn't connected or MaxInFlightMessages are awaiting an ACK.
+func (c *Conn) sendNoQueue(msg *proto.ProtocolMessage, listen chan<- error) error {
+	return c.sendMsg(msg, listen, sendNoQueue)
+}
+
+func (c *Conn) sendMsg(msg *proto.ProtocolMessage, listen chan<- error, mode sendMode) error {
 	c.state.Lock()
 	switch state := c.state.current; state {
 	case StateConnInitialized, StateConnConnecting, StateConnDisconnected:
 		c.state.Unlock()
-		if c.opts.NoQueueing {
+		if mode == sendQueued {
+			c.queue.requeue(msgch{msg, listen})
+			return errRequeued
+		}
+		if mode == sendNoQueue || c.opts.NoQueueing {
 			return stateError(state, errQueueing)
 		}
 		c.queue.Enqueue(msg, listen)
 		return nil
 	case StateConnConnected:
+		// While the queue is flushed right after connecting, even a message
+		// not to be queued is, for it not to overtake the queued ones.
+		if mode != sendQueued && c.queue.busy() {
+			c.state.Unlock()
+			c.queue.Enqueue(msg, listen)
+			return nil
+		}
+		if listen != nil && c.inFlightFull() {
+			if mode == sendNoQueue {
+				c.state.Unlock()
+				return newErrorf(ErrRateLimitExceededNonfatal, "%d messages are already awaiting an ACK", c.opts.MaxInFlightMessages)
+			}
+			// The message waits in the queue for ACKs or NACKs to make
+			// room for it; see resumeFlush.
+			c.state.Unlock()
+			if mode == sendQueued {
+				c.queue.requeue(msgch{msg, listen})
+				return errRequeued
+			}
+			c.queue.Enqueue(msg, listen)
+			return nil
+		}
 	default:
 		c.state.Unlock()
 		return stateError(state, nil)
@@ -307,9 +680,74 @@ func (c *Conn) send(msg *proto.ProtocolMessage, listen chan<- error) error {
 		c.state.Unlock()
 		return err
 	}
+	c.sendMtx.Lock()
 	c.updateSerial(msg, listen)
 	c.state.Unlock()
-	return c.conn.Send(msg)
+	err := c.conn.Send(msg)
+	c.sendMtx.Unlock()
+	if err != nil {
+		if listen != nil {
+			// The message waits for an ACK, so it's sent again once
+			// connected anew (RTN19a).
+			c.logger().Printf(LogWarning, "failure sending message (serial=%d), to be sent again: %v", msg.MsgSerial, err)
+			return nil
+		}
+		return err
+	}
+	atomic.StoreInt64(&c.lastActivity, c.opts.getClock().Now().UnixNano())
+	countMessages(c.opts.metrics(), msg.Messages, MetricMessagesSent, MetricBytesSent)
+	return nil
+}
+
+// inFlightFull tells whether ClientOptions.MaxInFlightMessages messages are
+// awaiting an ACK. It must be called with c.state held.
+func (c *Conn) inFlightFull() bool {
+	max := c.opts.MaxInFlightMessages
+	return max > 0 && c.pending.Len() >= max
+}
+
+// resumeFlush wakes up the publishes waiting for room for their messages,
+// and tells whether the queue, holding messages for which there was no
+// room, is to be flushed now that there is. It must be called with c.state
+// held, after ACKs or NACKs.
+func (c *Conn) resumeFlush() bool {
+	if c.opts.MaxInFlightMessages <= 0 || c.inFlightFull() {
+		return false
+	}
+	c.inFlight.Broadcast()
+	return c.state.current == StateConnConnected && c.queue.startFlushIfQueued()
+}
+
+// waitInFlight blocks, with InFlightBlock, until there's room for a new
+// message to be sent, the connection isn't connected or ctx is done, in
+// which case it returns ctx's error.
+func (c *Conn) waitInFlight(ctx context.Context) error {
+	if c.opts.InFlightPolicy != InFlightBlock {
+		return nil
+	}
+	if done := ctx.Done(); done != nil {
+		// Wake up the wait below if ctx is done first.
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				c.state.Lock()
+				c.inFlight.Broadcast()
+				c.state.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	c.state.Lock()
+	defer c.state.Unlock()
+	for c.state.current == StateConnConnected && c.inFlightFull() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.inFlight.Wait()
+	}
+	return nil
 }
 
 // verifyAndUpdateMessages ensures the ClientID sent with published messages or
@@ -324,7 +762,7 @@ func (c *Conn) verifyAndUpdateMessages(msg *proto.ProtocolMessage) (err error) {
 	switch msg.Action {
 	case proto.ActionMessage:
 		for _, msg := range msg.Messages {
-			if !isClientIDAllowed(clientID, msg.ClientID) {
+			if !canPublishAs(clientID, msg.ClientID) {
 				return newError(90000, fmt.Errorf("unable to send message as %q", msg.ClientID))
 			}
 			if clientID == msg.ClientID {
@@ -369,31 +807,99 @@ func (c *Conn) setConn(conn proto.Conn) {
 	go c.eventloop()
 }
 
+// setID sets the connection ID; it must be called with the state lock held.
+func (c *Conn) setID(id string) {
+	c.id = id
+	if id != "" {
+		c.lastID = id
+	}
+	c.logID.Store(id)
+}
+
+// addQueueDepth adds delta to the number of protocol messages either queued
+// or waiting for an ACK, and reports it to the metrics sink.
+func (c *Conn) addQueueDepth(delta int) {
+	if delta == 0 {
+		return
+	}
+	n := atomic.AddInt64(&c.queueDepth, int64(delta))
+	c.opts.metrics().Set(MetricQueueDepth, float64(n))
+}
+
 func (c *Conn) logger() *LoggerOptions {
-	return c.auth.logger()
+	log := *c.auth.client.logger()
+	if id, _ := c.logID.Load().(string); id != "" {
+		log = log.With(Field{Key: "connectionID", Value: id})
+	}
+	return log.forSubsystem(SubsystemTransport)
+}
+
+// sendHeartbeats sends a heartbeat over conn whenever the connection was
+// idle for interval, until done is closed.
+func (c *Conn) sendHeartbeats(conn proto.Conn, interval time.Duration, done <-chan struct{}) {
+	clock := c.opts.getClock()
+	timer := clock.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C():
+		}
+		idle := c.since(atomic.LoadInt64(&c.lastActivity))
+		if idle < interval {
+			timer.Reset(interval - idle)
+			continue
+		}
+		if c.State() == StateConnConnected {
+			if err := conn.Send(&proto.ProtocolMessage{Action: proto.ActionHeartbeat}); err != nil {
+				// A broken connection is detected, and recovered from, by
+				// the event loop.
+				c.logger().Printf(LogWarning, "Conn: failed sending heartbeat: %v", err)
+			} else {
+				now := clock.Now().UnixNano()
+				atomic.StoreInt64(&c.lastActivity, now)
+				atomic.StoreInt64(&c.lastHeartbeatSent, now)
+			}
+		}
+		timer.Reset(interval)
+	}
 }
 
 func (c *Conn) eventloop() {
-	var receiveTimeout time.Duration
+	receiveTimeout := c.opts.WebsocketReadTimeout
+	if interval := c.opts.HeartbeatInterval; interval > 0 {
+		done := make(chan struct{})
+		defer close(done)
+		go c.sendHeartbeats(c.conn, interval, done)
+	}
 
 	for c.lockCanReceiveMessages() {
 		var deadline time.Time
 		if receiveTimeout != 0 {
+			// Transports enforce deadlines with the system clock.
 			deadline = time.Now().Add(receiveTimeout) // RTN23a
 		}
 		msg, err := c.conn.Receive(deadline)
 		if err != nil {
 			c.state.Lock()
 			if c.state.current == StateConnClosed {
+				c.transportEvent(TransportClosed, "", nil)
 				c.state.Unlock()
 				return
 			}
+			c.transportEvent(TransportClosed, "", err)
 
 			c.setState(StateConnDisconnected, err)
 			c.state.Unlock()
+			if c.opts.NoAutoReconnect {
+				return
+			}
 			c.reconnect(false)
 			return
 		}
+		now := c.opts.getClock().Now().UnixNano()
+		atomic.StoreInt64(&c.lastActivity, now)
 		if msg.ConnectionSerial != 0 {
 			c.state.Lock()
 			c.serial = msg.ConnectionSerial
@@ -401,15 +907,28 @@ func (c *Conn) eventloop() {
 		}
 		switch msg.Action {
 		case proto.ActionHeartbeat:
+			atomic.StoreInt64(&c.lastHeartbeatReceived, now)
 		case proto.ActionAck:
 			c.state.Lock()
+			n := c.pending.Len()
 			c.pending.Ack(msg.MsgSerial, msg.Count, newErrorProto(msg.Error))
+			c.addQueueDepth(c.pending.Len() - n)
 			c.serial++
+			flush := c.resumeFlush()
 			c.state.Unlock()
+			if flush {
+				c.queue.Flush()
+			}
 		case proto.ActionNack:
 			c.state.Lock()
+			n := c.pending.Len()
 			c.pending.Nack(msg.MsgSerial, msg.Count, newErrorProto(msg.Error))
+			c.addQueueDepth(c.pending.Len() - n)
+			flush := c.resumeFlush()
 			c.state.Unlock()
+			if flush {
+				c.queue.Flush()
+			}
 		case proto.ActionError:
 			if msg.Channel != "" {
 				c.callbacks.onChannelMsg(msg)
@@ -421,66 +940,118 @@ func (c *Conn) eventloop() {
 				if tokenError(msg.Error) {
 					// (RTN15c5)
 					// TODO: (gernest) implement (RTN15h) This can be done as a separate task?
+				} else if c.opts.RetryOnResumeFailure && msg.Error != nil && isResumeErrorCode(msg.Error.Code) {
+					// The connection is replaced with a new one, as if Ably
+					// had reported the resume failure with it (RTN15c3).
+					c.resumeErr = msg.Error
+					c.setState(StateConnDisconnected, newErrorProto(msg.Error))
+					c.state.Unlock()
+					c.logger().Printf(LogWarning, "Conn: unable to resume connection, connecting anew: %v", msg.Error)
+					c.conn.Close()
+					c.connect(false)
+					return
 				} else {
 					// (RTN15c4)
 					c.callbacks.onReconnectMsg(msg)
 				}
 			}
+			c.resumeErr = nil
 			c.setState(StateConnFailed, newErrorProto(msg.Error))
 			c.state.Unlock()
 			c.queue.Fail(newErrorProto(msg.Error))
 		case proto.ActionConnected:
-			c.auth.updateClientID(msg.ConnectionDetails.ClientID)
 			if msg.ConnectionDetails != nil {
+				// Spec RSA7b3, RSA7b4, RSA12a
+				if err := c.auth.updateClientID(msg.ConnectionDetails.ClientID); err != nil {
+					c.state.Lock()
+					c.setState(StateConnFailed, err)
+					c.state.Unlock()
+					c.queue.Fail(err)
+					c.conn.Close()
+					break
+				}
 				c.state.Lock()
 				c.details = *msg.ConnectionDetails
 				c.state.Unlock()
 
-				// Spec RSA7b3, RSA7b4, RSA12a
-				c.auth.updateClientID(c.details.ClientID)
-
-				maxIdleInterval := time.Duration(msg.ConnectionDetails.MaxIdleInterval) * time.Millisecond
-				receiveTimeout = c.opts.realtimeRequestTimeout() + maxIdleInterval // RTN23a
+				if c.opts.WebsocketReadTimeout == 0 {
+					maxIdleInterval := time.Duration(msg.ConnectionDetails.MaxIdleInterval) * time.Millisecond
+					receiveTimeout = c.opts.realtimeRequestTimeout() + maxIdleInterval // RTN23a
+				}
 			}
 			c.state.Lock()
 			reconnecting := c.reconnecting
 			if reconnecting {
 				c.reconnecting = false
 			}
-			c.state.Unlock()
-			if reconnecting {
-				// (RTN15c1) (RTN15c2)
-				c.state.Lock()
-				c.setState(StateConnConnected, msg.Error)
-				id := c.id
-				c.state.Unlock()
-				if id != msg.ConnectionID {
-					// (RTN15c3)
-					// we are calling this outside of locks to avoid deadlock because in the
-					// RealtimeClient client where this callback is implemented we do some ops
-					// with this Conn where we re acquire Conn.state.Lock again.
-					c.callbacks.onReconnectMsg(msg)
+			if c.resumeErr != nil {
+				// The connection replaces one which couldn't be resumed.
+				reconnecting = true
+				if msg.Error == nil {
+					msg.Error = c.resumeErr
 				}
+				c.resumeErr = nil
+			}
+			// The state is entered, the unacknowledged messages are set to be
+			// sent again and the queue is marked as being flushed all at
+			// once, for messages sent meanwhile to be queued behind them
+			// (RTN19a).
+			var err error
+			if reconnecting && msg.Error != nil {
+				// (RTN15c1) (RTN15c2)
+				err = msg.Error
+			}
+			previous := c.lastID
+			resumed := previous == msg.ConnectionID
+			var resend []*proto.ProtocolMessage
+			if resumed {
+				resend = c.resumePending()
 			} else {
-				// preserve old behavior.
-				c.state.Lock()
-				c.setState(StateConnConnected, nil)
-				c.state.Unlock()
+				c.requeuePending()
 			}
-			c.state.Lock()
-			c.id = msg.ConnectionID
+			c.queue.startFlush()
+			c.setID(msg.ConnectionID)
+			if !resumed {
+				change := ConnectionIDChange{
+					Previous: previous,
+					ID:       msg.ConnectionID,
+					Key:      c.details.ConnectionKey,
+				}
+				if err != nil {
+					change.Err = newErrorProto(msg.Error)
+				}
+				c.emitIDChange(change)
+			}
+			c.setState(StateConnConnected, err)
 			c.serial = -1
-			c.msgSerial = 0
+			c.retryAttempt = 0
 			c.state.Unlock()
+			c.resendPending(resend)
+			if reconnecting && !resumed {
+				// (RTN15c3)
+				// we are calling this outside of locks to avoid deadlock because in the
+				// RealtimeClient client where this callback is implemented we do some ops
+				// with this Conn where we re acquire Conn.state.Lock again.
+				c.callbacks.onReconnectMsg(msg)
+			}
 			c.queue.Flush()
 		case proto.ActionDisconnected:
+			// Ably is about to close the transport; reconnect later, as
+			// for any other disconnection (RTN15a, RTN14d).
+			var err error
+			if msg.Error != nil {
+				err = newErrorProto(msg.Error)
+			}
 			c.state.Lock()
-			c.id = ""
-			c.setState(StateConnDisconnected, nil)
+			c.setID("")
+			conn := c.conn
+			c.retryLater(err)
 			c.state.Unlock()
+			conn.Close()
+			return
 		case proto.ActionClosed:
 			c.state.Lock()
-			c.id = ""
+			c.setID("")
 			c.setState(StateConnClosed, nil)
 			c.state.Unlock()
 		default:
@@ -498,16 +1069,27 @@ func (c *Conn) setState(state StateEnum, err error) error {
 	c.state.once(ch)
 	go func() { c.callbacks.onStateChange(<-ch) }()
 
+	switch state {
+	case StateConnClosed:
+		// The messages waiting for an ACK won't get one (RTN7e).
+		c.failPending(newErrorf(ErrConnectionClosed, "connection closed before the message was acknowledged"))
+	case StateConnFailed:
+		c.failPending(newErrorf(ErrConnectionFailed, "connection failed before the message was acknowledged: %v", err))
+	case StateConnSuspended:
+		c.failPending(newErrorf(ErrConnectionSuspended, "connection suspended before the message was acknowledged"))
+	}
+	c.inFlight.Broadcast()
 	return c.state.set(state, err)
 }
 
 type verboseConn struct {
 	conn   proto.Conn
-	logger *LoggerOptions
+	logger func() *LoggerOptions
 }
 
 func (vc verboseConn) Send(msg *proto.ProtocolMessage) error {
-	vc.logger.Printf(LogVerbose, "Realtime Connection: sending %s", msg)
+	log := vc.logger()
+	log.Printf(LogVerbose, "Realtime Connection: sending %s", log.redactProtocolMessage(msg))
 	return vc.conn.Send(msg)
 }
 
@@ -516,11 +1098,12 @@ func (vc verboseConn) Receive(deadline time.Time) (*proto.ProtocolMessage, error
 	if err != nil {
 		return nil, err
 	}
-	vc.logger.Printf(LogVerbose, "Realtime Connection: received %s", msg)
+	log := vc.logger()
+	log.Printf(LogVerbose, "Realtime Connection: received %s", log.redactProtocolMessage(msg))
 	return msg, nil
 }
 
 func (vc verboseConn) Close() error {
-	vc.logger.Printf(LogVerbose, "Realtime Connection: closed")
+	vc.logger().Printf(LogVerbose, "Realtime Connection: closed")
 	return vc.conn.Close()
 }
@@ -1,12 +1,18 @@
 package ably_test
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/ably/ably-go/ably"
 	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/internal/ablyutil"
 	"github.com/ably/ably-go/ably/proto"
 )
 
@@ -193,6 +199,301 @@ func TestRealtimeConn_ReceiveTimeout(t *testing.T) {
 	}
 }
 
+func TestRealtimeConn_WebsocketReadTimeout(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	in <- &proto.ProtocolMessage{
+		Action:       proto.ActionConnected,
+		ConnectionID: "connection-id",
+		ConnectionDetails: &proto.ConnectionDetails{
+			MaxIdleInterval: 60000,
+		},
+	}
+
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.WebsocketReadTimeout = 20 * time.Millisecond
+	})
+	defer client.Close()
+
+	states := make(chan ably.State, 10)
+	client.Connection.On(states, ably.StateConnConnected, ably.StateConnDisconnected)
+	client.Connection.Connect()
+
+	for _, expected := range []ably.StateEnum{ably.StateConnConnected, ably.StateConnDisconnected} {
+		// Without the read timeout overriding the one derived from
+		// maxIdleInterval, the connection would be dropped after a minute.
+		select {
+		case state := <-states:
+			if state.State != expected {
+				t.Fatalf("expected %v; got %v", expected, state.State)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("didn't receive %v state change event", expected)
+		}
+	}
+}
+
+func TestRealtimeConn_Heartbeats(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	in <- &proto.ProtocolMessage{
+		Action:       proto.ActionConnected,
+		ConnectionID: "connection-id",
+	}
+
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.HeartbeatInterval = 20 * time.Millisecond
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	if sent, received := client.Connection.LastHeartbeat(); !sent.IsZero() || !received.IsZero() {
+		t.Fatalf("expected no heartbeat yet; got sent %v, received %v", sent, received)
+	}
+
+	connected := time.Now()
+	timeout := time.After(ablytest.Timeout)
+	for heartbeat := false; !heartbeat; {
+		select {
+		case msg := <-out:
+			heartbeat = msg.Action == proto.ActionHeartbeat
+		case <-timeout:
+			t.Fatal("timed out waiting for a heartbeat from the idle client")
+		}
+	}
+	if elapsed := time.Since(connected); elapsed < 20*time.Millisecond {
+		t.Errorf("expected a heartbeat after 20ms of idleness; got one after %v", elapsed)
+	}
+	if sent, _ := client.Connection.LastHeartbeat(); sent.Before(connected) {
+		t.Errorf("expected the last sent heartbeat to be recorded; got %v", sent)
+	}
+
+	before := time.Now()
+	in <- &proto.ProtocolMessage{Action: proto.ActionHeartbeat}
+	for deadline := time.Now().Add(ablytest.Timeout); ; {
+		if _, received := client.Connection.LastHeartbeat(); !received.Before(before) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the received heartbeat to be recorded")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRealtimeConn_ConnectionLimitError(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	in <- &proto.ProtocolMessage{
+		Action: proto.ActionError,
+		Error: &proto.ErrorInfo{
+			StatusCode: 401,
+			Code:       ably.ErrAccountRestrictedConnectionLimitsExceeded,
+			Message:    "Account restricted (connection limits exceeded)",
+		},
+	}
+
+	client := newPipeRealtimeClient(t, in, out)
+	err := ablytest.Wait(client.Connection.Connect())
+	if !ably.IsConnectionLimitError(err) {
+		t.Fatalf("expected a connection limit error; got %v", err)
+	}
+	if reason := client.Connection.Reason(); !ably.IsConnectionLimitError(reason) || ably.IsResumeError(reason) {
+		t.Errorf("expected reason to be a connection limit error only; got %v", reason)
+	}
+}
+
+func TestRealtimeConn_ResumeFailure(t *testing.T) {
+	t.Parallel()
+
+	for _, retry := range []bool{false, true} {
+		retry := retry
+		t.Run(fmt.Sprintf("RetryOnResumeFailure=%v", retry), func(t *testing.T) {
+			t.Parallel()
+
+			in := make(chan *proto.ProtocolMessage, 16)
+			out := make(chan *proto.ProtocolMessage, 16)
+			type dialed struct {
+				conn proto.Conn
+				url  *url.URL
+			}
+			dials := make(chan dialed, 4)
+			dial := ablytest.MessagePipe(in, out)
+
+			client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+				o.RetryOnResumeFailure = retry
+				o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+					conn, err := dial(protocol, u)
+					dials <- dialed{conn: conn, url: u}
+					return conn, err
+				}
+			})
+			defer client.Close()
+
+			in <- &proto.ProtocolMessage{
+				Action:            proto.ActionConnected,
+				ConnectionID:      "first",
+				ConnectionDetails: &proto.ConnectionDetails{ConnectionKey: "first-key"},
+			}
+			if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+				t.Fatal(err)
+			}
+			first := <-dials
+			states := make(chan ably.State, 16)
+			client.Connection.On(states)
+
+			// Break the transport; the client tries to resume the connection.
+			first.conn.Close()
+			var resume dialed
+			select {
+			case resume = <-dials:
+			case <-time.After(ablytest.Timeout):
+				t.Fatal("timed out waiting for the client to reconnect")
+			}
+			if key := resume.url.Query().Get("resume"); key != "first-key" {
+				t.Fatalf("expected resume of %q; got %q", "first-key", key)
+			}
+			in <- &proto.ProtocolMessage{
+				Action: proto.ActionError,
+				Error: &proto.ErrorInfo{
+					StatusCode: 400,
+					Code:       ably.ErrUnableToRecoverConnectionConnectionExpired,
+					Message:    "Unable to recover connection: connection expired",
+				},
+			}
+
+			expected := ably.StateConnFailed
+			if retry {
+				expected = ably.StateConnConnected
+				var fresh dialed
+				select {
+				case fresh = <-dials:
+				case <-time.After(ablytest.Timeout):
+					t.Fatal("timed out waiting for the client to connect anew")
+				}
+				if key := fresh.url.Query().Get("resume"); key != "" {
+					t.Fatalf("expected a new connection; got resume of %q", key)
+				}
+				in <- &proto.ProtocolMessage{
+					Action:            proto.ActionConnected,
+					ConnectionID:      "second",
+					ConnectionDetails: &proto.ConnectionDetails{ConnectionKey: "second-key"},
+				}
+			}
+			for {
+				select {
+				case state := <-states:
+					if state.State != expected {
+						continue
+					}
+					if !ably.IsResumeError(state.Err) {
+						t.Errorf("expected a resume error; got %v", state.Err)
+					}
+				case <-time.After(ablytest.Timeout):
+					t.Fatalf("timed out waiting for %v", expected)
+				}
+				break
+			}
+			if retry {
+				if id := client.Connection.ID(); id != "second" {
+					t.Errorf("expected connection %q; got %q", "second", id)
+				}
+				in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+			}
+		})
+	}
+}
+
+func TestRealtimeConn_StateTTL_RTN15g(t *testing.T) {
+	t.Parallel()
+
+	for _, expired := range []bool{false, true} {
+		expired := expired
+		t.Run(fmt.Sprintf("expired=%v", expired), func(t *testing.T) {
+			t.Parallel()
+
+			in := make(chan *proto.ProtocolMessage, 16)
+			out := make(chan *proto.ProtocolMessage, 16)
+			dials := make(chan *url.URL, 4)
+			conns := make(chan proto.Conn, 4)
+			dial := ablytest.MessagePipe(in, out)
+
+			client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+				o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+					conn, err := dial(protocol, u)
+					dials <- u
+					conns <- conn
+					return conn, err
+				}
+			})
+			defer func() {
+				in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+				client.Close()
+			}()
+
+			ttl := time.Minute
+			if expired {
+				ttl = time.Millisecond
+			}
+			in <- &proto.ProtocolMessage{
+				Action:       proto.ActionConnected,
+				ConnectionID: "first",
+				ConnectionDetails: &proto.ConnectionDetails{
+					ConnectionKey:      "first-key",
+					ConnectionStateTTL: int64(ttl / time.Millisecond),
+				},
+			}
+			if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+				t.Fatal(err)
+			}
+			<-dials
+			first := <-conns
+			states := make(chan ably.State, 16)
+			client.Connection.On(states, ably.StateConnConnected)
+
+			time.Sleep(10 * time.Millisecond)
+			first.Close()
+			var u *url.URL
+			select {
+			case u = <-dials:
+			case <-time.After(ablytest.Timeout):
+				t.Fatal("timed out waiting for the client to reconnect")
+			}
+			expectedKey := "first-key"
+			if expired {
+				expectedKey = ""
+			}
+			if key := u.Query().Get("resume"); key != expectedKey {
+				t.Fatalf("expected resume key %q; got %q", expectedKey, key)
+			}
+
+			in <- &proto.ProtocolMessage{
+				Action:            proto.ActionConnected,
+				ConnectionID:      "second",
+				ConnectionDetails: &proto.ConnectionDetails{ConnectionKey: "second-key"},
+			}
+			select {
+			case state := <-states:
+				if lost := ably.IsResumeError(state.Err); lost != expired {
+					t.Errorf("expected continuity lost to be %t; got error %v", expired, state.Err)
+				}
+			case <-time.After(ablytest.Timeout):
+				t.Fatal("timed out waiting for the connection to be connected")
+			}
+		})
+	}
+}
+
 func TestRealtimeConn_BreakConnLoopOnInactiveState(t *testing.T) {
 	t.Parallel()
 
@@ -238,3 +539,765 @@ func TestRealtimeConn_BreakConnLoopOnInactiveState(t *testing.T) {
 		})
 	}
 }
+
+func TestRealtimeConn_SendOrderAcrossReconnection_RTN19a(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 64)
+	out := make(chan *proto.ProtocolMessage, 64)
+	conns := make(chan proto.Conn, 4)
+	dial := ablytest.MessagePipe(in, out)
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			conns <- conn
+			return conn, err
+		}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	first := <-conns
+
+	var results []ably.Result
+	publish := func(from, to int) {
+		for i := from; i < to; i++ {
+			res, err := channel.Publish(fmt.Sprint(i), nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			results = append(results, res)
+		}
+	}
+	expectSent := func(from, to int, serial int64) {
+		t.Helper()
+		for i := from; i < to; i, serial = i+1, serial+1 {
+			select {
+			case msg := <-out:
+				if msg.Action != proto.ActionMessage {
+					t.Fatalf("expected MESSAGE %d to be sent; got %v", i, msg.Action)
+				}
+				if name := msg.Messages[0].Name; name != fmt.Sprint(i) || msg.MsgSerial != serial {
+					t.Fatalf("expected message %d with serial %d to be sent; got %s with serial %d", i, serial, name, msg.MsgSerial)
+				}
+			case <-time.After(ablytest.Timeout):
+				t.Fatalf("timed out waiting for message %d to be sent", i)
+			}
+		}
+	}
+	disconnect := func(conn proto.Conn) {
+		t.Helper()
+		states := make(chan ably.State, 1)
+		client.Connection.On(states, ably.StateConnDisconnected, ably.StateConnConnecting)
+		defer client.Connection.Off(states)
+		conn.Close()
+		select {
+		case <-states:
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for the connection to be broken")
+		}
+	}
+
+	// The ATTACH was sent with serial 0.
+	publish(0, 2)
+	expectSent(0, 2, 1)
+
+	// The messages waiting for an ACK are sent again with their serials once
+	// the connection is resumed, before the ones queued meanwhile, and before
+	// the ones published as the connection is resumed.
+	disconnect(first)
+	publish(2, 4)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		publish(4, 20)
+	}()
+	in <- connectedMessage("conn")
+	<-done
+	expectSent(0, 20, 1)
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 1, Count: 20}
+	for i, res := range results {
+		if err := res.Wait(); err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+	}
+
+	// Over a new connection, they're numbered anew.
+	publish(20, 21)
+	expectSent(20, 21, 21)
+	disconnect(<-conns)
+	in <- connectedMessage("new-conn")
+	expectSent(20, 21, 0)
+	select {
+	case msg := <-out:
+		if msg.Action != proto.ActionAttach {
+			t.Fatalf("expected ATTACH to be sent; got %v", msg.Action)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for ATTACH to be sent")
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 0, Count: 1}
+	if err := results[20].Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// slowSendConn is a proto.Conn taking a while to send, so that concurrent
+// sends overlap.
+type slowSendConn struct {
+	proto.Conn
+}
+
+func (c slowSendConn) Send(msg *proto.ProtocolMessage) error {
+	time.Sleep(time.Millisecond)
+	return c.Conn.Send(msg)
+}
+
+func TestRealtimeConn_ConcurrentSendOrder_RTN7b(t *testing.T) {
+	t.Parallel()
+
+	const n = 50
+	in := make(chan *proto.ProtocolMessage, n)
+	out := make(chan *proto.ProtocolMessage, n)
+	dial := ablytest.MessagePipe(in, out)
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			return slowSendConn{conn}, err
+		}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := channel.Publish(fmt.Sprint(i), nil); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Whatever order they're published in, they're sent in serial order.
+	// The ATTACH was sent with serial 0.
+	for serial := int64(1); serial <= n; serial++ {
+		select {
+		case msg := <-out:
+			if msg.Action != proto.ActionMessage || msg.MsgSerial != serial {
+				t.Fatalf("expected MESSAGE with serial %d to be sent; got %v with serial %d", serial, msg.Action, msg.MsgSerial)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for message with serial %d to be sent", serial)
+		}
+	}
+}
+
+func TestRealtimeConn_ResendWithSerials_RTN19a(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	dial := ablytest.MessagePipe(in, out)
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			conns <- conn
+			return conn, err
+		}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	first := <-conns
+
+	expectSent := func(action proto.Action, serial int64) {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != action || msg.MsgSerial != serial {
+				t.Fatalf("expected %v with serial %d to be sent; got %v with serial %d", action, serial, msg.Action, msg.MsgSerial)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v to be sent", action)
+		}
+	}
+	if _, err := channel.Publish("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Channels.Get("other").Attach(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channel.Publish("b", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(proto.ActionMessage, 1)
+	expectSent(proto.ActionAttach, 2)
+	expectSent(proto.ActionMessage, 3)
+
+	// Only the messages waiting for an ACK are sent again once resumed, each
+	// with the serial it was first sent with; the ATTACH isn't one of them.
+	first.Close()
+	in <- connectedMessage("conn")
+	expectSent(proto.ActionMessage, 1)
+	expectSent(proto.ActionMessage, 3)
+	if _, err := channel.Publish("c", nil); err != nil {
+		t.Fatal(err)
+	}
+	expectSent(proto.ActionMessage, 4)
+}
+
+func TestRealtimeConn_FailUnsendableOnNewConnection_RTN19a(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	dial := ablytest.MessagePipe(in, out)
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			conns <- conn
+			return conn, err
+		}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+
+	expectSent := func(action proto.Action, serial int64) {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != action || msg.MsgSerial != serial {
+				t.Fatalf("expected %v with serial %d to be sent; got %v with serial %d", action, serial, msg.Action, msg.MsgSerial)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v to be sent", action)
+		}
+	}
+	asAlice, err := channel.PublishAll([]*proto.Message{{Name: "a", ClientID: "alice"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymous, err := channel.Publish("b", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectSent(proto.ActionMessage, 1)
+	expectSent(proto.ActionMessage, 2)
+
+	// The new connection is identified as another client, so the message
+	// published as alice can't be sent again, unlike the other one.
+	(<-conns).Close()
+	connected := connectedMessage("new-conn")
+	connected.ConnectionDetails.ClientID = "bob"
+	in <- connected
+	if err := asAlice.Wait(); ably.ErrorCode(err) != 90000 {
+		t.Fatalf("expected the message published as alice to fail with code 90000; got %v", err)
+	}
+	expectSent(proto.ActionMessage, 0)
+	expectSent(proto.ActionAttach, 1)
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 0, Count: 1}
+	if err := anonymous.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRealtimeConn_FailPendingOnFailure_RTN7e(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client, channel := newAttachedPipeClient(t, in, out)
+	defer client.Close()
+
+	res, err := channel.Publish("greeting", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-out:
+		if msg.Action != proto.ActionMessage || msg.MsgSerial != 1 {
+			t.Fatalf("expected MESSAGE with serial 1 to be sent; got %v with serial %d", msg.Action, msg.MsgSerial)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for MESSAGE to be sent")
+	}
+	in <- &proto.ProtocolMessage{
+		Action: proto.ActionError,
+		Error:  &proto.ErrorInfo{StatusCode: 400, Code: 40000, Message: "fatal"},
+	}
+	if err := res.Wait(); ably.ErrorCode(err) != ably.ErrConnectionFailed {
+		t.Fatalf("expected publish to fail with code %d; got %v", ably.ErrConnectionFailed, err)
+	}
+}
+
+func TestRealtimeConn_OnIDChange(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	dial := ablytest.MessagePipe(in, out)
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			conns <- conn
+			return conn, err
+		}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	changes := make(chan ably.ConnectionIDChange, 4)
+	client.Connection.OnIDChange(changes)
+	defer client.Connection.OffIDChange(changes)
+	expectChange := func(expected ably.ConnectionIDChange) {
+		t.Helper()
+		select {
+		case change := <-changes:
+			if change.Previous != expected.Previous || change.ID != expected.ID || change.Key != expected.Key {
+				t.Fatalf("expected change from %q to %q with key %q; got %+v", expected.Previous, expected.ID, expected.Key, change)
+			}
+			if ably.ErrorCode(change.Err) != ably.ErrorCode(expected.Err) {
+				t.Fatalf("expected error %v; got %v", expected.Err, change.Err)
+			}
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for the connection ID to change")
+		}
+	}
+	reconnect := func(connected *proto.ProtocolMessage) {
+		t.Helper()
+		states := make(chan ably.State, 1)
+		client.Connection.On(states, ably.StateConnConnected)
+		defer client.Connection.Off(states)
+		(<-conns).Close()
+		in <- connected
+		select {
+		case <-states:
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for the connection to be connected")
+		}
+	}
+
+	in <- connectedMessage("first")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	expectChange(ably.ConnectionIDChange{ID: "first", Key: "first-key"})
+
+	// Resuming the connection doesn't change its ID.
+	reconnect(connectedMessage("first"))
+	select {
+	case change := <-changes:
+		t.Fatalf("unexpected change %+v", change)
+	default:
+	}
+
+	second := connectedMessage("second")
+	second.Error = &proto.ErrorInfo{StatusCode: 400, Code: int(ably.ErrUnableToRecoverConnectionConnectionExpired)}
+	reconnect(second)
+	expectChange(ably.ConnectionIDChange{
+		Previous: "first",
+		ID:       "second",
+		Key:      "second-key",
+		Err:      &ably.Error{Code: ably.ErrUnableToRecoverConnectionConnectionExpired},
+	})
+	if id, key := client.Connection.ID(), client.Connection.Key(); id != "second" || key != "second-key" {
+		t.Errorf("expected ID %q and key %q; got %q and %q", "second", "second-key", id, key)
+	}
+}
+
+// unreachableDial dials with dial, or fails while unreachable is set.
+func unreachableDial(dial func(string, *url.URL) (proto.Conn, error), conns chan<- proto.Conn, unreachable *int32) func(string, *url.URL) (proto.Conn, error) {
+	return func(protocol string, u *url.URL) (proto.Conn, error) {
+		if atomic.LoadInt32(unreachable) != 0 {
+			return nil, errors.New("unreachable")
+		}
+		conn, err := dial(protocol, u)
+		conns <- conn
+		return conn, err
+	}
+}
+
+func TestRealtimeConn_RetryIn_RTN14d(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	var unreachable int32
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = unreachableDial(ablytest.MessagePipe(in, out), conns, &unreachable)
+		o.DisconnectedRetryTimeout = time.Hour
+	})
+	defer client.Close()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	if d := client.Connection.RetryIn(); d != 0 {
+		t.Fatalf("expected no reconnection to be scheduled while connected; got %v", d)
+	}
+	disconnect := func() {
+		t.Helper()
+		states := make(chan ably.State, 4)
+		client.Connection.On(states, ably.StateConnDisconnected)
+		defer client.Connection.Off(states)
+		atomic.StoreInt32(&unreachable, 1)
+		(<-conns).Close()
+		// The immediate reconnection fails too.
+		for i := 0; i < 2; i++ {
+			select {
+			case <-states:
+			case <-time.After(ablytest.Timeout):
+				t.Fatal("timed out waiting for the connection to be disconnected")
+			}
+		}
+		if d := client.Connection.RetryIn(); d <= 0 || d > time.Hour {
+			t.Fatalf("expected a reconnection to be scheduled within %v; got %v", time.Hour, d)
+		}
+		atomic.StoreInt32(&unreachable, 0)
+	}
+
+	// Connect reconnects right away.
+	disconnect()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	if d := client.Connection.RetryIn(); d != 0 {
+		t.Fatalf("expected no reconnection to be scheduled once connected; got %v", d)
+	}
+
+	// Close closes the disconnected connection, with no reconnection.
+	disconnect()
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if state := client.Connection.State(); state != ably.StateConnClosed {
+		t.Fatalf("expected %v; got %v", ably.StateConnClosed, state)
+	}
+	if d := client.Connection.RetryIn(); d != 0 {
+		t.Fatalf("expected no reconnection to be scheduled once closed; got %v", d)
+	}
+}
+
+func TestRealtimeConn_SuspendedRetry_RTN14e(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	var unreachable int32
+	clock := ablyutil.NewFakeClock(time.Unix(1500000000, 0))
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = unreachableDial(ablytest.MessagePipe(in, out), conns, &unreachable)
+		o.DisconnectedRetryTimeout = 15 * time.Second
+		o.TimeoutSuspended = time.Minute
+		o.SuspendedRetryTimeout = 30 * time.Second
+		o.Clock = clock
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The connection is disconnected, and retried, until it has been
+	// inactive for longer than TimeoutSuspended.
+	atomic.StoreInt32(&unreachable, 1)
+	(<-conns).Close()
+	clock.BlockUntil(1)
+	if state := client.Connection.State(); state != ably.StateConnDisconnected {
+		t.Fatalf("expected %v; got %v", ably.StateConnDisconnected, state)
+	}
+	clock.Advance(45 * time.Second)
+	if state := client.Connection.State(); state != ably.StateConnDisconnected {
+		t.Fatalf("expected %v; got %v", ably.StateConnDisconnected, state)
+	}
+
+	// It's then suspended, and retried every SuspendedRetryTimeout. With
+	// backoff, attempts are at most twice DisconnectedRetryTimeout apart,
+	// so one is made after TimeoutSuspended by then.
+	clock.Advance(45 * time.Second)
+	if state := client.Connection.State(); state != ably.StateConnSuspended {
+		t.Fatalf("expected %v; got %v", ably.StateConnSuspended, state)
+	}
+	if d := client.Connection.RetryIn(); d <= 0 || d > 30*time.Second {
+		t.Fatalf("expected a reconnection to be scheduled within %v; got %v", 30*time.Second, d)
+	}
+	in <- connectedMessage("new-conn")
+	atomic.StoreInt32(&unreachable, 0)
+	clock.Advance(client.Connection.RetryIn())
+	ablytest.Soon.WaitForState(t, client.Connection, ably.StateConnConnected)
+	if id := client.Connection.ID(); id != "new-conn" {
+		t.Errorf("expected connection ID %q; got %q", "new-conn", id)
+	}
+}
+
+func TestRealtimeConn_DisconnectedByServer_RTN15a(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	urls := make(chan *url.URL, 4)
+	dial := ablytest.MessagePipe(in, out)
+	clock := ablyutil.NewFakeClock(time.Unix(1500000000, 0))
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			urls <- u
+			return dial(protocol, u)
+		}
+		o.DisconnectedRetryTimeout = 10 * time.Second
+		o.Clock = clock
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	<-urls
+
+	states := make(chan ably.State, 4)
+	client.Connection.On(states)
+	defer client.Connection.Off(states)
+	in <- &proto.ProtocolMessage{
+		Action: proto.ActionDisconnected,
+		Error:  &proto.ErrorInfo{StatusCode: 503, Code: 80003, Message: "going away"},
+	}
+	select {
+	case state := <-states:
+		if state.State != ably.StateConnDisconnected || ably.ErrorCode(state.Err) != 80003 {
+			t.Fatalf("expected %v with code 80003; got %v with %v", ably.StateConnDisconnected, state.State, state.Err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for the connection to be disconnected")
+	}
+
+	// The first attempt is made after DisconnectedRetryTimeout, less up to
+	// 20% of jitter (RTB1).
+	if d := client.Connection.RetryIn(); d < 8*time.Second || d > 10*time.Second {
+		t.Fatalf("expected a reconnection to be scheduled within 8s to 10s; got %v", d)
+	}
+	select {
+	case u := <-urls:
+		t.Fatalf("expected no reconnection attempt before the retry timeout; got one to %v", u)
+	default:
+	}
+
+	// The connection is then resumed.
+	in <- connectedMessage("conn")
+	clock.Advance(client.Connection.RetryIn())
+	select {
+	case u := <-urls:
+		if resume := u.Query().Get("resume"); resume != "conn-key" {
+			t.Errorf("expected to resume with key %q; got %q", "conn-key", resume)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for a reconnection attempt")
+	}
+	ablytest.Soon.WaitForState(t, client.Connection, ably.StateConnConnected)
+}
+
+func TestRealtimeConn_NoAutoReconnect(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	urls := make(chan *url.URL, 4)
+	dial := ablytest.MessagePipe(in, out)
+	client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			conns <- conn
+			urls <- u
+			return conn, err
+		}
+		o.NoAutoReconnect = true
+	})
+	defer client.Close()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	<-urls
+
+	states := make(chan ably.State, 4)
+	client.Connection.On(states)
+	(<-conns).Close()
+	select {
+	case state := <-states:
+		if state.State != ably.StateConnDisconnected {
+			t.Fatalf("expected %v; got %v", ably.StateConnDisconnected, state.State)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for the connection to be disconnected")
+	}
+	select {
+	case state := <-states:
+		t.Fatalf("expected the connection to stay disconnected; got %v", state.State)
+	case <-conns:
+		t.Fatal("expected no reconnection attempt")
+	case <-time.After(10 * time.Millisecond):
+	}
+	if d := client.Connection.RetryIn(); d != 0 {
+		t.Fatalf("expected no reconnection to be scheduled; got %v", d)
+	}
+
+	// Connect resumes the connection.
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	if resume := (<-urls).Query().Get("resume"); resume != "conn-key" {
+		t.Errorf("expected to resume with key %q; got %q", "conn-key", resume)
+	}
+	if id := client.Connection.ID(); id != "conn" {
+		t.Errorf("expected connection ID %q; got %q", "conn", id)
+	}
+
+	// Once broken again, Close closes it.
+	(<-conns).Close()
+	if _, err := client.Connection.WaitForState(context.Background(), ably.StateConnDisconnected); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if state := client.Connection.State(); state != ably.StateConnClosed {
+		t.Fatalf("expected %v; got %v", ably.StateConnClosed, state)
+	}
+}
+
+func TestRealtimeConn_MaxInFlightMessages(t *testing.T) {
+	t.Parallel()
+
+	for _, policy := range []ably.InFlightPolicy{ably.InFlightQueue, ably.InFlightBlock} {
+		in := make(chan *proto.ProtocolMessage, 16)
+		out := make(chan *proto.ProtocolMessage, 16)
+		client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.MaxInFlightMessages = 2
+			o.InFlightPolicy = policy
+		})
+		sent := func(serial int64) {
+			t.Helper()
+			select {
+			case msg := <-out:
+				if msg.Action != proto.ActionMessage || msg.MsgSerial != serial {
+					t.Fatalf("%v: expected MESSAGE with serial %d; got %v with %d", policy, serial, msg.Action, msg.MsgSerial)
+				}
+			case <-time.After(ablytest.Timeout):
+				t.Fatalf("%v: timed out waiting for MESSAGE with serial %d", policy, serial)
+			}
+		}
+		published := make(chan ably.Result, 3)
+		go func() {
+			for i := 0; i < 3; i++ {
+				res, err := channel.Publish("greeting", "hello")
+				if err != nil {
+					t.Error(err)
+				}
+				published <- res
+			}
+		}()
+		// The ATTACH was sent with serial 0.
+		sent(1)
+		sent(2)
+		<-published
+		<-published
+		if policy == ably.InFlightBlock {
+			select {
+			case <-published:
+				t.Fatalf("%v: expected publishing to block", policy)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		select {
+		case msg := <-out:
+			t.Fatalf("%v: expected nothing sent while 2 messages are in flight; got %v", policy, msg)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 1, Count: 1}
+		sent(3)
+		in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 2, Count: 2}
+		if err := ablytest.Wait(<-published, nil); err != nil {
+			t.Errorf("%v: %v", policy, err)
+		}
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}
+}
+
+func TestRealtimeConn_MaxInFlightMessages_Context(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.MaxInFlightMessages = 1
+		o.InFlightPolicy = ably.InFlightBlock
+		o.PublishRateLimit = &ably.RateLimit{Rate: 0.001, Burst: 2}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	if err := channel.SetPublishRateLimit(&ably.RateLimit{Rate: 0.001, Burst: 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := channel.Publish("greeting", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- channel.PublishContext(ctx, "greeting", "hello")
+	}()
+	select {
+	case err := <-errs:
+		t.Fatalf("expected publishing to block while a message is in flight; got %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	cancel()
+	select {
+	case err := <-errs:
+		if err != context.Canceled {
+			t.Fatalf("expected %v; got %v", context.Canceled, err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for publishing to give up")
+	}
+
+	// The rate limits' tokens taken for the message which wasn't published
+	// are given back.
+	<-out // MESSAGE
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: 1, Count: 1}
+	if err := res.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channel.Publish("greeting", "hello"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,6 +1,7 @@
 package ably
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"sync"
@@ -30,14 +31,25 @@ type RealtimePresence struct {
 	state     proto.PresenceState
 	syncMtx   sync.Mutex
 	syncState syncState
+
+	// own are the data of the members entered over the client's current
+	// connection, by client ID, as given to EnterClient and UpdateClient or
+	// told by the presence messages received for them. They're entered again
+	// when the channel is attached without resuming, e.g. after connecting
+	// anew (RTP17).
+	own map[string]interface{}
+	// reenterPending tells that the own members missing from the sync in
+	// progress are to be entered again once it completes (RTP17g).
+	reenterPending bool
 }
 
 func newRealtimePresence(channel *RealtimeChannel) *RealtimePresence {
 	pres := &RealtimePresence{
-		subs:      newSubscriptions(subscriptionPresenceMessages, channel.logger()),
+		subs:      newSubscriptions(subscriptionPresenceMessages, channel.logger().forSubsystem(SubsystemPresence)),
 		channel:   channel,
 		members:   make(map[string]*proto.PresenceMessage),
 		syncState: syncInitial,
+		own:       make(map[string]interface{}),
 	}
 	// Lock syncMtx to make all callers to Get(true) wait until the presence
 	// is in initial sync state. This is to not make them early return
@@ -56,6 +68,9 @@ func (pres *RealtimePresence) verifyChanState() error {
 }
 
 func (pres *RealtimePresence) send(msg *proto.PresenceMessage) (Result, error) {
+	if err := pres.channel.opts().checkWritable(); err != nil {
+		return nil, err
+	}
 	if _, err := pres.channel.attach(false); err != nil {
 		return nil, err
 	}
@@ -90,7 +105,7 @@ func (pres *RealtimePresence) onAttach(msg *proto.ProtocolMessage) {
 	pres.mtx.Lock()
 	defer pres.mtx.Unlock()
 	switch {
-	case msg.Flags.Has(proto.FlagPresence) || serial != "":
+	case msg.HasPresence() || serial != "":
 		pres.syncStart(serial)
 	case pres.syncState == syncInitial:
 		pres.syncState = syncComplete
@@ -142,11 +157,7 @@ func (pres *RealtimePresence) syncEnd() {
 }
 
 func (pres *RealtimePresence) processIncomingMessage(msg *proto.ProtocolMessage, syncSerial string) {
-	for _, presmsg := range msg.Presence {
-		if presmsg.Timestamp == 0 {
-			presmsg.Timestamp = msg.Timestamp
-		}
-	}
+	connID := pres.channel.client.Connection.ID()
 	pres.mtx.Lock()
 	if syncSerial != "" {
 		pres.syncStart(syncSerial)
@@ -161,6 +172,15 @@ func (pres *RealtimePresence) processIncomingMessage(msg *proto.ProtocolMessage,
 				continue // do not process old message
 			}
 		}
+		if member.ConnectionID == connID && connID != "" {
+			// RTP17b
+			switch member.State {
+			case proto.PresenceEnter, proto.PresenceUpdate, proto.PresencePresent:
+				pres.own[member.ClientID] = member.Data
+			case proto.PresenceLeave:
+				delete(pres.own, member.ClientID)
+			}
+		}
 		switch member.State {
 		case proto.PresenceUpdate:
 			memberCopy := *member
@@ -175,30 +195,61 @@ func (pres *RealtimePresence) processIncomingMessage(msg *proto.ProtocolMessage,
 		}
 		messages = append(messages, member)
 	}
+	var reenter map[string]interface{}
 	if syncSerial == "" {
 		pres.syncEnd()
+		if pres.reenterPending && pres.syncState == syncComplete {
+			pres.reenterPending = false
+			reenter = pres.absentOwn(connID)
+		}
 	}
 	pres.mtx.Unlock()
+	pres.enterAgain(reenter)
 	msg.Count = len(messages)
 	msg.Presence = messages
-	pres.subs.presenceEnqueue(msg)
+	pres.channel.deliver(msg, pres.subscriptions().presenceEnqueue)
+}
+
+// PresenceParams selects the members returned by RealtimePresence.Get.
+type PresenceParams struct {
+	ClientID     string // returns only the members with the client ID, if set (RTP11c2)
+	ConnectionID string // returns only the members with the connection ID, if set (RTP11c3)
+
+	// WaitForSync makes Get wait for an undergoing sync to complete, so that
+	// the members are up to date (RTP11c1).
+	WaitForSync bool
 }
 
-// Get returns a list of current members on the channel.
+// Get returns the current members on the channel, as known by the client,
+// which are filtered by params. If the channel is not attached, Get implicitly
+// attaches it.
 //
-// If wait is true it blocks until undergoing sync operation completes.
-// If wait is false or sync already completed, the function returns immediately.
-func (pres *RealtimePresence) Get(wait bool) ([]*proto.PresenceMessage, error) {
+// If params.WaitForSync is true, it blocks until an undergoing sync completes
+// or ctx is done. Otherwise, or if the sync already completed, it returns
+// immediately.
+func (pres *RealtimePresence) Get(ctx context.Context, params PresenceParams) ([]*proto.PresenceMessage, error) {
 	if _, err := pres.channel.attach(false); err != nil {
 		return nil, err
 	}
-	if wait {
-		pres.syncWait()
+	if params.WaitForSync {
+		err := waitContext(ctx, resultFunc(func() error {
+			pres.syncWait()
+			return nil
+		}))
+		if err != nil {
+			return nil, err
+		}
 	}
 	pres.mtx.Lock()
 	defer pres.mtx.Unlock()
 	members := make([]*proto.PresenceMessage, 0, len(pres.members))
 	for _, member := range pres.members {
+		if params.ClientID != "" && member.ClientID != params.ClientID {
+			continue
+		}
+		if params.ConnectionID != "" && member.ConnectionID != params.ConnectionID {
+			continue
+		}
 		members = append(members, member)
 	}
 	return members, nil
@@ -212,14 +263,22 @@ func (pres *RealtimePresence) Subscribe(states ...proto.PresenceState) (*Subscri
 	if _, err := pres.channel.attach(false); err != nil {
 		return nil, err
 	}
-	return pres.subs.subscribe(statesToKeys(states)...)
+	return pres.subscriptions().subscribe(statesToKeys(states)...)
 }
 
 // Unsubscribe removes previous Subscription for the given presence states.
 //
 // If sub was already unsubscribed, the method is a nop.
 func (pres *RealtimePresence) Unsubscribe(sub *Subscription, states ...proto.PresenceState) {
-	pres.subs.unsubscribe(true, sub, statesToKeys(states)...)
+	pres.subscriptions().unsubscribe(true, sub, statesToKeys(states)...)
+}
+
+// subscriptions gives the presence subscriptions, guarded by the channel's
+// subsMtx.
+func (pres *RealtimePresence) subscriptions() *subscriptions {
+	pres.channel.subsMtx.Lock()
+	defer pres.channel.subsMtx.Unlock()
+	return pres.subs
 }
 
 // Enter announces presence of the current client with an enter message
@@ -260,6 +319,7 @@ func (pres *RealtimePresence) EnterClient(clientID string, data interface{}) (Re
 	pres.mtx.Lock()
 	pres.data = data
 	pres.state = proto.PresenceEnter
+	pres.own[clientID] = data
 	pres.mtx.Unlock()
 	msg := &proto.PresenceMessage{
 		State: proto.PresenceEnter,
@@ -288,6 +348,7 @@ func (pres *RealtimePresence) UpdateClient(clientID string, data interface{}) (R
 		return pres.EnterClient(clientID, nonnil(data, oldData))
 	}
 	pres.data = data
+	pres.own[clientID] = data
 	pres.mtx.Unlock()
 	msg := &proto.PresenceMessage{
 		State: proto.PresenceUpdate,
@@ -308,6 +369,7 @@ func (pres *RealtimePresence) LeaveClient(clientID string, data interface{}) (Re
 	if pres.data == nil {
 		pres.data = data
 	}
+	delete(pres.own, clientID)
 	pres.mtx.Unlock()
 
 	msg := &proto.PresenceMessage{
@@ -318,10 +380,76 @@ func (pres *RealtimePresence) LeaveClient(clientID string, data interface{}) (Re
 	return pres.send(msg)
 }
 
+// reenter enters again the members entered over the client's previous
+// connection, after the channel was attached without resuming (RTP17i). If
+// the attachment started a sync, only the members missing from it are
+// entered again, once it completes (RTP17g).
+func (pres *RealtimePresence) reenter() {
+	connID := pres.channel.client.Connection.ID()
+	pres.mtx.Lock()
+	if pres.syncState == syncInProgress {
+		pres.reenterPending = true
+		pres.mtx.Unlock()
+		return
+	}
+	reenter := pres.absentOwn(connID)
+	pres.mtx.Unlock()
+	pres.enterAgain(reenter)
+}
+
+// absentOwn gives the own members which aren't present with the connection
+// ID connID. It must be called with pres.mtx held.
+func (pres *RealtimePresence) absentOwn(connID string) map[string]interface{} {
+	absent := make(map[string]interface{}, len(pres.own))
+	for clientID, data := range pres.own {
+		if _, ok := pres.members[connID+clientID]; !ok {
+			absent[clientID] = data
+		}
+	}
+	return absent
+}
+
+// enterAgain sends an ENTER for each of members, with their stored data.
+// Failures are reported with a StateChanUpdate carrying the error (RTP17e).
+func (pres *RealtimePresence) enterAgain(members map[string]interface{}) {
+	for clientID, data := range members {
+		msg := &proto.PresenceMessage{
+			State: proto.PresenceEnter,
+		}
+		msg.ClientID = clientID
+		msg.Data = data
+		res, err := pres.send(msg)
+		if err != nil {
+			pres.reenterFailed(clientID, err)
+			continue
+		}
+		go func(clientID string) {
+			if err := res.Wait(); err != nil {
+				pres.reenterFailed(clientID, err)
+			}
+		}(clientID)
+	}
+}
+
+// forgetOwn drops the members to enter again, once the channel is detached.
+func (pres *RealtimePresence) forgetOwn() {
+	pres.mtx.Lock()
+	defer pres.mtx.Unlock()
+	pres.own = make(map[string]interface{})
+	pres.reenterPending = false
+}
+
+func (pres *RealtimePresence) reenterFailed(clientID string, err error) {
+	pres.logger().Printf(LogWarning, "failed re-entering presence of %q on channel %q: %v", clientID, pres.channel.Name, err)
+	pres.channel.state.Lock()
+	defer pres.channel.state.Unlock()
+	pres.channel.state.update(newErrorf(ErrUnableToAutomaticallyReEnterPresenceChannel, "unable to re-enter presence of %q: %v", clientID, err), false)
+}
+
 func (pres *RealtimePresence) auth() *Auth {
 	return pres.channel.client.Auth
 }
 
 func (pres *RealtimePresence) logger() *LoggerOptions {
-	return pres.channel.logger()
+	return pres.channel.logger().forSubsystem(SubsystemPresence)
 }
@@ -1,7 +1,11 @@
 package ably_test
 
 import (
+	"context"
 	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
 	"strconv"
 	"testing"
 	"time"
@@ -44,7 +48,7 @@ func TestRealtimePresence_Sync(t *testing.T) {
 	app, client := ablytest.NewRealtimeClient(nil)
 	defer safeclose(t, client, app)
 
-	members, err := client.Channels.GetAndAttach("persisted:presence_fixtures").Presence.Get(true)
+	members, err := client.Channels.GetAndAttach("persisted:presence_fixtures").Presence.Get(context.Background(), ably.PresenceParams{WaitForSync: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -93,7 +97,7 @@ func TestRealtimePresence_Sync250(t *testing.T) {
 	if err = contains(members2, clients...); err != nil {
 		t.Fatalf("members2: %v", err)
 	}
-	members3, err := client3.Channels.GetAndAttach("sync250").Presence.Get(true)
+	members3, err := client3.Channels.GetAndAttach("sync250").Presence.Get(context.Background(), ably.PresenceParams{WaitForSync: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -124,7 +128,7 @@ func TestRealtimePresence_EnsureChannelIsAttached(t *testing.T) {
 	if err := rec.WaitFor(presTransitions[:2]); err != nil {
 		t.Fatal(err)
 	}
-	members, err := channel.Presence.Get(true)
+	members, err := channel.Presence.Get(context.Background(), ably.PresenceParams{WaitForSync: true})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -136,3 +140,176 @@ func TestRealtimePresence_EnsureChannelIsAttached(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestRealtimePresence_GetParams_RTP11(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client := newPipeRealtimeClient(t, in, out)
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	channel := client.Channels.Get("test")
+	res, err := channel.Attach()
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-out // ATTACH
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test", Flags: proto.FlagPresence}
+	if err := res.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The sync is still undergoing.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := channel.Presence.Get(ctx, ably.PresenceParams{WaitForSync: true}); err != context.DeadlineExceeded {
+		t.Fatalf("expected %v; got %v", context.DeadlineExceeded, err)
+	}
+
+	member := func(clientID, connID string) *proto.PresenceMessage {
+		return &proto.PresenceMessage{
+			State:   proto.PresencePresent,
+			Message: proto.Message{ID: connID + ":0:0", ClientID: clientID, ConnectionID: connID},
+		}
+	}
+	in <- &proto.ProtocolMessage{
+		Action:        proto.ActionSync,
+		Channel:       "test",
+		ChannelSerial: "sync:",
+		Presence: []*proto.PresenceMessage{
+			member("alice", "conn1"),
+			member("alice", "conn2"),
+			member("bob", "conn2"),
+		},
+	}
+
+	for _, c := range []struct {
+		params   ably.PresenceParams
+		expected []string
+	}{
+		{ably.PresenceParams{}, []string{"alice/conn1", "alice/conn2", "bob/conn2"}},
+		{ably.PresenceParams{ClientID: "alice"}, []string{"alice/conn1", "alice/conn2"}},
+		{ably.PresenceParams{ConnectionID: "conn2"}, []string{"alice/conn2", "bob/conn2"}},
+		{ably.PresenceParams{ClientID: "bob", ConnectionID: "conn1"}, []string{}},
+	} {
+		c.params.WaitForSync = true
+		members, err := channel.Presence.Get(context.Background(), c.params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(members))
+		for _, m := range members {
+			got = append(got, m.ClientID+"/"+m.ConnectionID)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, c.expected) {
+			t.Errorf("%+v: expected members %v; got %v", c.params, c.expected, got)
+		}
+	}
+}
+
+func TestRealtimePresence_ReenterMissingFromSync_RTP17g(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 16)
+	out := make(chan *proto.ProtocolMessage, 16)
+	conns := make(chan proto.Conn, 4)
+	dial := ablytest.MessagePipe(in, out)
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.Dial = func(protocol string, u *url.URL) (proto.Conn, error) {
+			conn, err := dial(protocol, u)
+			conns <- conn
+			return conn, err
+		}
+	})
+	defer func() {
+		in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+		client.Close()
+	}()
+	first := <-conns
+
+	expectSent := func(action proto.Action) *proto.ProtocolMessage {
+		t.Helper()
+		select {
+		case msg := <-out:
+			if msg.Action != action {
+				t.Fatalf("expected %v to be sent; got %v", action, msg.Action)
+			}
+			return msg
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v to be sent", action)
+			return nil
+		}
+	}
+	for _, clientID := range []string{"alice", "bob"} {
+		res, err := channel.Presence.EnterClient(clientID, clientID+" data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := expectSent(proto.ActionPresence)
+		in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	states := make(chan ably.State, 16)
+	channel.On(states, ably.StateChanUpdate)
+
+	first.Close()
+	in <- connectedMessage("new-conn")
+	expectSent(proto.ActionAttach)
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test", Flags: proto.FlagPresence}
+	select {
+	case msg := <-out:
+		t.Fatalf("expected nothing to be sent before the sync completes; got %v", msg.Action)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// bob is still present over the new connection; only alice is entered
+	// again, with the data she entered with.
+	in <- &proto.ProtocolMessage{
+		Action:        proto.ActionSync,
+		Channel:       "test",
+		ChannelSerial: "sync:",
+		Presence: []*proto.PresenceMessage{{
+			State:   proto.PresencePresent,
+			Message: proto.Message{ID: "new-conn:0:0", ClientID: "bob", ConnectionID: "new-conn", Data: "bob data"},
+		}},
+	}
+	msg := expectSent(proto.ActionPresence)
+	if n := len(msg.Presence); n != 1 {
+		t.Fatalf("expected 1 presence message; got %d", n)
+	}
+	if p := msg.Presence[0]; p.State != proto.PresenceEnter || p.ClientID != "alice" || p.Data != "alice data" {
+		t.Fatalf("expected alice to be entered again; got %v for %q with %v", p.State, p.ClientID, p.Data)
+	}
+	select {
+	case msg := <-out:
+		t.Fatalf("expected nothing else to be sent; got %v", msg.Action)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// A failed re-entry is reported with an UPDATE event.
+	in <- &proto.ProtocolMessage{
+		Action:    proto.ActionNack,
+		MsgSerial: msg.MsgSerial,
+		Count:     1,
+		Error:     &proto.ErrorInfo{StatusCode: 400, Code: 40160, Message: "denied"},
+	}
+	select {
+	case state := <-states:
+		if err := checkError(ably.ErrUnableToAutomaticallyReEnterPresenceChannel, state.Err); err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for an UPDATE event")
+	}
+}
@@ -0,0 +1,120 @@
+package ably
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// maxPublishBatch is the number of messages above which a batch of
+// coalesced publishes is sent without waiting for the batch window to end.
+const maxPublishBatch = 100
+
+// publishBatch coalesces the messages published on a channel within
+// ClientOptions.PublishBatchWindow into a single MESSAGE protocol message.
+//
+// As a protocol message is acknowledged as a whole, the result of every
+// publish call coalesced into it is resolved with the same outcome; calls
+// rejected before being coalesced, e.g. for an incompatible client ID, fail
+// on their own without affecting the batch.
+type publishBatch struct {
+	mtx      sync.Mutex
+	messages []*proto.Message
+	listens  []chan<- error
+	timer    *time.Timer
+}
+
+// add appends messages to the pending batch, starting a new one if there's
+// none, and gives the result of their publication.
+func (b *publishBatch) add(c *RealtimeChannel, messages []*proto.Message) Result {
+	res, listen := newErrResult()
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.messages = append(b.messages, messages...)
+	b.listens = append(b.listens, listen)
+	switch {
+	case len(b.messages) >= maxPublishBatch:
+		b.flushLocked(c)
+	case b.timer == nil:
+		b.timer = time.AfterFunc(c.opts().PublishBatchWindow, func() {
+			b.flush(c)
+		})
+	}
+	return res
+}
+
+// flush sends the pending batch, if any.
+func (b *publishBatch) flush(c *RealtimeChannel) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.flushLocked(c)
+}
+
+// flushLocked sends the pending batch, if any; it's called with b.mtx held,
+// which keeps batches sent in the order they were formed.
+func (b *publishBatch) flushLocked(c *RealtimeChannel) {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.messages) == 0 {
+		return
+	}
+	msg := c.messageProtocol(b.messages)
+	listens := b.listens
+	b.messages, b.listens = nil, nil
+	listen := make(chan error, 1)
+	if err := c.sendListen(msg, listen); err != nil {
+		fanOut(listens, err)
+		return
+	}
+	go func() {
+		fanOut(listens, <-listen)
+	}()
+}
+
+// fail fails the pending batch, if any, with err.
+func (b *publishBatch) fail(err error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	fanOut(b.listens, err)
+	b.messages, b.listens = nil, nil
+}
+
+func fanOut(listens []chan<- error, err error) {
+	for _, listen := range listens {
+		listen <- err
+	}
+}
+
+// PublishBatch publishes all given messages on the channel in a single
+// protocol message, and blocks until it's acknowledged, ctx is done or
+// publishing fails. Messages of pending Publish calls coalesced with
+// ClientOptions.PublishBatchWindow are sent first.
+//
+// This implicitly attaches the channel if it's not already attached.
+func (c *RealtimeChannel) PublishBatch(ctx context.Context, messages []*proto.Message) error {
+	if err := c.preparePublish(ctx, messages); err != nil {
+		return err
+	}
+	listen := make(chan error, 1)
+	c.batch.mtx.Lock()
+	c.batch.flushLocked(c)
+	err := c.sendListen(c.messageProtocol(messages), listen)
+	c.batch.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+	select {
+	case err := <-listen:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,76 @@
+package ably_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestRealtimeChannel_PublishBatch(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	channel := newAttachedPipeChannel(t, in, out, func(o *ably.ClientOptions) {
+		o.PublishBatchWindow = 100 * time.Millisecond
+	})
+	recv := func() *proto.ProtocolMessage {
+		t.Helper()
+		select {
+		case msg := <-out:
+			return msg
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for MESSAGE")
+			return nil
+		}
+	}
+
+	var results []ably.Result
+	for _, name := range []string{"a", "b", "c"} {
+		res, err := channel.Publish(name, "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	msg := recv()
+	var names []string
+	for _, m := range msg.Messages {
+		names = append(names, m.Name)
+	}
+	assertDeepEquals(t, []string{"a", "b", "c"}, names)
+
+	in <- &proto.ProtocolMessage{
+		Action:    proto.ActionNack,
+		MsgSerial: msg.MsgSerial,
+		Count:     1,
+		Error:     &proto.ErrorInfo{StatusCode: 400, Code: 40000, Message: "rejected"},
+	}
+	for i, res := range results {
+		if err := ablytest.Wait(res, nil); err == nil || ably.ErrorCode(err) != 40000 {
+			t.Errorf("publish %d: want error 40000; got %v", i, err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- channel.PublishBatch(context.Background(), []*proto.Message{{Name: "d"}, {Name: "e"}})
+	}()
+	msg = recv()
+	if n := len(msg.Messages); n != 2 {
+		t.Fatalf("want 2 messages in batch; got %d", n)
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(ablytest.Timeout):
+		t.Fatal("timed out waiting for PublishBatch")
+	}
+}
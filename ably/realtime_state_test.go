@@ -0,0 +1,201 @@
+package ably_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestConn_WaitForState(t *testing.T) {
+	t.Parallel()
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client := newPipeRealtimeClient(t, in, out)
+
+	// Current state is given right away.
+	st, err := client.Connection.WaitForState(context.Background(), ably.StateConnInitialized, ably.StateConnConnected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != ably.StateConnInitialized {
+		t.Fatalf("want state %v; got %v", ably.StateConnInitialized, st.State)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := client.Connection.WaitForState(ctx, ably.StateConnConnected); err != context.DeadlineExceeded {
+		t.Fatalf("want %v; got %v", context.DeadlineExceeded, err)
+	}
+
+	done := make(chan ably.State, 1)
+	go func() {
+		st, err := client.Connection.WaitForState(context.Background(), ably.StateConnConnected)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- st
+	}()
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case st := <-done:
+		if st.State != ably.StateConnConnected || st.Type != ably.StateConn {
+			t.Fatalf("want connected connection state; got %v", st)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for connected state")
+	}
+}
+
+func TestRealtimeChannel_WaitForState(t *testing.T) {
+	t.Parallel()
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	_, channel := newAttachedPipeClient(t, in, out)
+
+	st, err := channel.WaitForState(context.Background(), ably.StateChanAttached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != ably.StateChanAttached || st.Channel != "test" {
+		t.Fatalf("want attached state of channel %q; got %v", "test", st)
+	}
+
+	done := make(chan ably.State, 1)
+	go func() {
+		st, err := channel.WaitForState(context.Background(), ably.StateChanDetached, ably.StateChanFailed)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- st
+	}()
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionError,
+		Channel: "test",
+		Error:   &proto.ErrorInfo{StatusCode: 400, Code: 40000, Message: "bad"},
+	}
+	select {
+	case st := <-done:
+		if st.State != ably.StateChanFailed || st.Err == nil {
+			t.Fatalf("want failed state with an error; got %v", st)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for failed state")
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic for a connection state")
+		}
+	}()
+	channel.WaitForState(context.Background(), ably.StateConnConnected)
+}
+
+// receiveStates receives n states from ch, failing the test if it takes
+// longer than a second.
+func receiveStates(t *testing.T, ch <-chan ably.State, n int) []ably.StateEnum {
+	t.Helper()
+	var states []ably.StateEnum
+	for len(states) < n {
+		select {
+		case st := <-ch:
+			states = append(states, st.State)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for states; got %v", states)
+		}
+	}
+	return states
+}
+
+func TestConn_StateChanges(t *testing.T) {
+	t.Parallel()
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client := newPipeRealtimeClient(t, in, out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes := client.Connection.StateChanges(ctx)
+	in <- connectedMessage("conn")
+	if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	expected := []ably.StateEnum{
+		ably.StateConnInitialized,
+		ably.StateConnConnecting,
+		ably.StateConnConnected,
+	}
+	assertDeepEquals(t, expected, receiveStates(t, changes, len(expected)))
+
+	cancel()
+	for range changes {
+	}
+}
+
+func TestRealtimeChannel_StateChanges(t *testing.T) {
+	t.Parallel()
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	_, channel := newAttachedPipeClient(t, in, out)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := channel.StateChanges(ctx)
+	in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionError,
+		Channel: "test",
+		Error:   &proto.ErrorInfo{StatusCode: 400, Code: 40000, Message: "bad"},
+	}
+	expected := []ably.StateEnum{
+		ably.StateChanAttached,
+		ably.StateChanUpdate,
+		ably.StateChanFailed,
+	}
+	assertDeepEquals(t, expected, receiveStates(t, changes, len(expected)))
+}
+
+func TestStateEnum_Text(t *testing.T) {
+	t.Parallel()
+	b, err := json.Marshal(ably.State{State: ably.StateChanAttached, Type: ably.StateChan})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st ably.State
+	if err := json.Unmarshal(b, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.State != ably.StateChanAttached || st.Type != ably.StateChan {
+		t.Fatalf("want %v of type %v; got %v of type %v (from %s)", ably.StateChanAttached, ably.StateChan, st.State, st.Type, b)
+	}
+	if _, err := ably.StateEnum(3).MarshalText(); err == nil {
+		t.Error("expected an error marshaling an invalid state")
+	}
+	if err := st.State.UnmarshalText([]byte("attached")); err == nil {
+		t.Error("expected an error unmarshaling an unknown state")
+	}
+
+	for state, typ := range map[ably.StateEnum]ably.StateType{
+		ably.StateConnClosed:    ably.StateConn,
+		ably.StateChanClosed:    ably.StateChan,
+		ably.StateChanUpdate:    ably.StateChan,
+		ably.StateEnum(0):       0,
+		ably.StateEnum(1 << 20): 0,
+	} {
+		if got := state.Type(); got != typ {
+			t.Errorf("want %v to be of type %v; got %v", state, typ, got)
+		}
+	}
+	if !ably.StateConnClosed.In(ably.StateConnClosing, ably.StateConnClosed) {
+		t.Error("expected StateConnClosed to be in the given states")
+	}
+	if ably.StateConnClosed.In(ably.StateChanClosed) || ably.StateConnClosed.In() {
+		t.Error("expected StateConnClosed not to be in the given states")
+	}
+}
@@ -0,0 +1,161 @@
+package ably
+
+import (
+	"sync"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// Replace creates a new RealtimeClient with the given options and moves all
+// attached channels of c over to it without a gap in message delivery, which
+// allows changing client options or upgrading the library at runtime.
+//
+// Replace connects the new client and attaches all channels which are
+// attached or attaching on c. Only once all of them are attached, the
+// existing subscriptions, including presence ones, are atomically switched
+// over to the new client and the connection of c is closed. Messages
+// received on both connections during the handover are delivered only once,
+// provided they carry a message ID.
+//
+// If connecting or attaching fails, the new client is closed and c keeps
+// working as before. Channel state listeners registered with On are not moved
+// to the new client.
+func (c *RealtimeClient) Replace(opts *ClientOptions) (*RealtimeClient, error) {
+	o := *opts
+	o.NoConnect = true
+	client, err := NewRealtimeClient(&o)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.Tags() {
+		client.SetTag(k, v)
+	}
+	type pair struct {
+		old, new *RealtimeChannel
+		handoff  *handoff
+	}
+	var pairs []pair
+	for _, old := range c.Channels.Iterate() {
+		if state := old.State(); state != StateChanAttached && state != StateChanAttaching {
+			continue
+		}
+		old.state.Lock()
+		opts := old.options
+		old.state.Unlock()
+		ch := client.Channels.Get(old.Name, opts)
+		ch.setSubs(old.messageSubs(), old.Presence.subscriptions())
+		h := &handoff{seen: make(map[string]struct{})}
+		old.setHandoff(h, true)
+		ch.setHandoff(h, false)
+		pairs = append(pairs, pair{old: old, new: ch, handoff: h})
+	}
+	abort := func(err error) (*RealtimeClient, error) {
+		for _, p := range pairs {
+			p.old.setHandoff(nil, false)
+		}
+		client.Close()
+		return nil, err
+	}
+	if err := wait(client.Connection.Connect()); err != nil {
+		return abort(err)
+	}
+	results := make([]Result, 0, len(pairs))
+	for _, p := range pairs {
+		res, err := p.new.Attach()
+		if err != nil {
+			return abort(err)
+		}
+		results = append(results, res)
+	}
+	for _, res := range results {
+		if err := res.Wait(); err != nil {
+			return abort(err)
+		}
+	}
+	for _, p := range pairs {
+		p.handoff.swap()
+		p.new.setHandoff(nil, false)
+	}
+	if err := c.Close(); err != nil {
+		c.logger().Printf(LogWarning, "Realtime Client: error closing replaced client: %v", err)
+	}
+	// The subscriptions now belong to the new channels; the old ones get
+	// empty ones, so that closing or releasing them, e.g. with Shutdown,
+	// leaves the new ones alone.
+	for _, p := range pairs {
+		p.old.setSubs(
+			newSubscriptions(subscriptionMessages, c.logger()),
+			newSubscriptions(subscriptionPresenceMessages, p.old.logger().forSubsystem(SubsystemPresence)),
+		)
+	}
+	return client, nil
+}
+
+// handoff coordinates message delivery of a channel that is being moved
+// from one client to another with Replace.
+//
+// Until swap is called, messages received by the old channel are delivered
+// and their IDs recorded, while the ones received by the new channel are
+// held back. After swap, the held back messages which were not delivered
+// by the old channel are delivered, and the old channel is muted.
+type handoff struct {
+	mtx     sync.Mutex
+	swapped bool
+	seen    map[string]struct{}
+	pending []pendingDelivery
+}
+
+type pendingDelivery struct {
+	msg     *proto.ProtocolMessage
+	enqueue func(*proto.ProtocolMessage)
+}
+
+func (h *handoff) deliver(msg *proto.ProtocolMessage, old bool, enqueue func(*proto.ProtocolMessage)) {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	switch {
+	case old && h.swapped:
+	case old:
+		for _, m := range msg.Messages {
+			if m.ID != "" {
+				h.seen[m.ID] = struct{}{}
+			}
+		}
+		for _, m := range msg.Presence {
+			if m.ID != "" {
+				h.seen[m.ID] = struct{}{}
+			}
+		}
+		enqueue(msg)
+	case !h.swapped:
+		h.pending = append(h.pending, pendingDelivery{msg: msg, enqueue: enqueue})
+	default:
+		enqueue(msg)
+	}
+}
+
+func (h *handoff) swap() {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.swapped = true
+	for _, p := range h.pending {
+		msg := *p.msg
+		msg.Messages = nil
+		for _, m := range p.msg.Messages {
+			if _, ok := h.seen[m.ID]; !ok {
+				msg.Messages = append(msg.Messages, m)
+			}
+		}
+		msg.Presence = nil
+		for _, m := range p.msg.Presence {
+			if _, ok := h.seen[m.ID]; !ok {
+				msg.Presence = append(msg.Presence, m)
+			}
+		}
+		if len(msg.Messages) != 0 || len(msg.Presence) != 0 {
+			p.enqueue(&msg)
+		}
+	}
+	h.pending = nil
+	h.seen = nil
+}
@@ -0,0 +1,74 @@
+package ably
+
+import (
+	"regexp"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	redactAuthHeader = regexp.MustCompile(`(?mi)^(Authorization: ).*$`)
+	redactQueryParam = regexp.MustCompile(`\b((?:key|access_token)=)[^&\s]+`)
+)
+
+// redactCredentials tells whether API keys and tokens are to be removed
+// from log entries.
+func (l LoggerOptions) redactCredentials() bool {
+	return !l.Is(LogDebug)
+}
+
+// redactPayloads tells whether message payloads and HTTP bodies are to be
+// removed from log entries.
+func (l LoggerOptions) redactPayloads() bool {
+	return !l.LogPayloads && !l.Is(LogDebug)
+}
+
+// redactHTTPDump removes credentials from a dumped HTTP request or response.
+func (l LoggerOptions) redactHTTPDump(b []byte) []byte {
+	if !l.redactCredentials() {
+		return b
+	}
+	b = redactAuthHeader.ReplaceAll(b, []byte("${1}"+redacted))
+	return redactQueryParam.ReplaceAll(b, []byte("${1}"+redacted))
+}
+
+// redactProtocolMessage gives a copy of msg without credentials and, unless
+// enabled with LogPayloads, message payloads.
+func (l LoggerOptions) redactProtocolMessage(msg *proto.ProtocolMessage) *proto.ProtocolMessage {
+	credentials, payloads := l.redactCredentials(), l.redactPayloads()
+	if !credentials && !payloads {
+		return msg
+	}
+	cp := *msg
+	if credentials {
+		if cp.ConnectionKey != "" {
+			cp.ConnectionKey = redacted
+		}
+		if msg.ConnectionDetails != nil {
+			details := *msg.ConnectionDetails
+			details.ConnectionKey = redacted
+			cp.ConnectionDetails = &details
+		}
+	}
+	if payloads {
+		cp.Messages = make([]*proto.Message, len(msg.Messages))
+		for i, m := range msg.Messages {
+			m := *m
+			if m.Data != nil {
+				m.Data = redacted
+			}
+			cp.Messages[i] = &m
+		}
+		cp.Presence = make([]*proto.PresenceMessage, len(msg.Presence))
+		for i, m := range msg.Presence {
+			m := *m
+			if m.Data != nil {
+				m.Data = redacted
+			}
+			cp.Presence[i] = &m
+		}
+	}
+	return &cp
+}
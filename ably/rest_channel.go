@@ -1,11 +1,12 @@
 package ably
 
 import (
+	"context"
 	"fmt"
+	"net/http"
+	"net/url"
 	"strings"
 
-	"github.com/ably/ably-go/ably/internal/ablyutil"
-
 	"github.com/ably/ably-go/ably/proto"
 )
 
@@ -27,10 +28,13 @@ var encodeURIComponent = strings.NewReplacer(
 type RestChannel struct {
 	Name     string
 	Presence *RestPresence
+	Push     *PushChannel
 
 	client  *RestClient
 	baseURL string
 	options *proto.ChannelOptions
+
+	publishLimit publishLimit
 }
 
 func newRestChannel(name string, client *RestClient) *RestChannel {
@@ -43,23 +47,51 @@ func newRestChannel(name string, client *RestClient) *RestChannel {
 		client:  client,
 		channel: c,
 	}
+	c.Push = newPushChannel(name, client)
 	return c
 }
 
+// Publish publishes a message on the channel. Data which isn't a string
+// or []byte, e.g. a struct or a map, is sent encoded as JSON.
 func (c *RestChannel) Publish(name string, data interface{}) error {
+	return c.PublishContext(context.Background(), name, data)
+}
+
+// PublishContext is like Publish, with ctx used for the HTTP request.
+func (c *RestChannel) PublishContext(ctx context.Context, name string, data interface{}) error {
 	messages := []*proto.Message{
 		{Name: name, Data: data},
 	}
-	return c.PublishAll(messages)
+	return c.PublishMultiple(ctx, messages)
 }
 
 // PublishAll sends multiple messages in the same http call.
 // This is the more efficient way of transmitting a batch of messages
 // using the Rest API.
 func (c *RestChannel) PublishAll(messages []*proto.Message) error {
-	if c.options != nil {
+	return c.PublishMultiple(context.Background(), messages)
+}
+
+// PublishMultiple is like PublishAll, with ctx used for the HTTP request.
+func (c *RestChannel) PublishMultiple(ctx context.Context, messages []*proto.Message) error {
+	if err := c.client.opts.checkWritable(); err != nil {
+		return err
+	}
+	if err := c.client.opts.interceptPublish(messages); err != nil {
+		return err
+	}
+	for _, v := range messages {
+		// Spec RSL1g3,RSL1g4
+		if err := c.client.Auth.checkClientID(v.ClientID); err != nil {
+			return err
+		}
+	}
+	if err := takePublish(ctx, &c.publishLimit, c.client.publishLimit, len(messages)); err != nil {
+		return err
+	}
+	if opts := c.messageOptions(); opts != nil {
 		for _, v := range messages {
-			v.ChannelOptions = c.options
+			v.ChannelOptions = opts
 		}
 	}
 	useIdempotent := c.client.opts.idempotentRestPublishing()
@@ -69,7 +101,7 @@ func (c *RestChannel) PublishAll(messages []*proto.Message) error {
 			// spec RSL1k2 we preserve the id if we have one message and it contains the
 			// id.
 			if messages[0].ID == "" {
-				base, err := ablyutil.BaseID()
+				base, err := c.client.opts.baseID()
 				if err != nil {
 					return err
 				}
@@ -83,7 +115,7 @@ func (c *RestChannel) PublishAll(messages []*proto.Message) error {
 				}
 			}
 			if empty { // spec RSL1k3,RSL1k1
-				base, err := ablyutil.BaseID()
+				base, err := c.client.opts.baseID()
 				if err != nil {
 					return err
 				}
@@ -93,19 +125,61 @@ func (c *RestChannel) PublishAll(messages []*proto.Message) error {
 			}
 		}
 	}
-	res, err := c.client.post(c.baseURL+"/messages", messages, nil)
+	res, err := c.client.do(&Request{
+		Method: "POST",
+		Path:   c.baseURL + "/messages",
+		In:     messages,
+		ctx:    ctx,
+	})
 	if err != nil {
 		return err
 	}
+	c.client.opts.metrics().Add(MetricMessagesSent, float64(len(messages)))
 	return res.Body.Close()
 }
 
+// SetPublishRateLimit limits how many messages are published on the
+// channel, on top of ClientOptions.PublishRateLimit; nil removes the limit.
+func (c *RestChannel) SetPublishRateLimit(limit *RateLimit) error {
+	return c.publishLimit.set(limit, c.client.opts.getClock())
+}
+
+// messageOptions gives the options encoding the messages published on
+// the channel: the channel's, with the client's MessageEncodings unless
+// the channel sets its own encodings.
+func (c *RestChannel) messageOptions() *proto.ChannelOptions {
+	return messageOptions(c.options, c.client.opts.MessageEncodings)
+}
+
+// messageOptions gives the channel options opts with the given encodings,
+// unless opts sets its own.
+func messageOptions(opts *proto.ChannelOptions, encodings []string) *proto.ChannelOptions {
+	if len(encodings) == 0 || opts != nil && len(opts.Encodings) != 0 {
+		return opts
+	}
+	var o proto.ChannelOptions
+	if opts != nil {
+		o = *opts
+	}
+	o.Encodings = encodings
+	return &o
+}
+
 // History gives the channel's message history according to the given parameters.
 // The returned result can be inspected for the messages via the Messages()
 // method.
 func (c *RestChannel) History(params *PaginateParams) (*PaginatedResult, error) {
+	return c.history(context.Background(), params, nil)
+}
+
+// history requests the channel's history with ctx, adding extra to the
+// query parameters encoded from params.
+func (c *RestChannel) history(ctx context.Context, params *PaginateParams, extra url.Values) (*PaginatedResult, error) {
 	path := c.baseURL + "/history"
-	rst, err := newPaginatedResult(c.options, paginatedRequest{typ: msgType, path: path, params: params, query: query(c.client.get), logger: c.logger(), respCheck: checkValidHTTPResponse})
+	get := func(path string) (*http.Response, error) {
+		return c.client.do(&Request{Method: "GET", Path: path, ctx: ctx})
+	}
+	rst, err := newPaginatedResult(c.options, paginatedRequest{typ: msgType, path: path, params: params, extra: extra, query: get, logger: c.logger(), respCheck: checkValidHTTPResponse})
 	if err != nil {
 		return nil, err
 	}
@@ -113,5 +187,5 @@ func (c *RestChannel) History(params *PaginateParams) (*PaginatedResult, error)
 }
 
 func (c *RestChannel) logger() *LoggerOptions {
-	return c.client.logger()
+	return c.client.logger().forSubsystem(SubsystemHTTP)
 }
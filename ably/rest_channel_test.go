@@ -1,8 +1,11 @@
 package ably_test
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -60,7 +63,7 @@ func TestRestChannel(t *testing.T) {
 				data: "string",
 			}
 			m["binary"] = dataSample{
-				data: "string",
+				data: []byte("string"),
 			}
 			m["json"] = dataSample{
 				encoding: proto.JSON,
@@ -632,3 +635,47 @@ func TestRSL1g(t *testing.T) {
 		}
 	})
 }
+
+func TestRestChannel_PublishMultiple(t *testing.T) {
+	t.Parallel()
+	type event struct {
+		Text string `json:"text"`
+	}
+	var sent []*proto.Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msgs []*proto.Message
+		if err := json.NewDecoder(r.Body).Decode(&msgs); err != nil {
+			t.Error(err)
+		}
+		sent = append(sent, msgs...)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	channel := newTestRestClient(t, server).Channels.Get("test")
+
+	if err := channel.PublishContext(context.Background(), "struct", event{Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.PublishMultiple(context.Background(), []*proto.Message{
+		{Name: "a", Data: "data"},
+		{Name: "b", Data: map[string]int{"n": 1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var got [][2]interface{}
+	for _, m := range sent {
+		got = append(got, [2]interface{}{m.Name, m.Data})
+	}
+	assertDeepEquals(t, got, [][2]interface{}{
+		{"struct", map[string]interface{}{"text": "hello"}},
+		{"a", "data"},
+		{"b", map[string]interface{}{"n": float64(1)}},
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := channel.PublishMultiple(ctx, []*proto.Message{{Name: "c"}})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want error %v; got %v", context.Canceled, err)
+	}
+}
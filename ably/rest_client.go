@@ -1,7 +1,11 @@
 package ably
 
 import (
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
 	"context"
 	_ "crypto/sha512"
 	"encoding/base64"
@@ -9,12 +13,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"math/rand"
 	"mime"
 	"net/http"
 	"net/http/httptrace"
 	"net/http/httputil"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -94,28 +98,41 @@ func (c *RestChannels) Exists(name string) bool {
 // RSN3a: you can optionally pass ChannelOptions, if the channel exists it will
 // updated with the options and when it doesn't a new channel will be created
 // with the given options.
-func (c *RestChannels) Get(name string, opts *proto.ChannelOptions) *RestChannel {
-	c.mu.RLock()
+//
+// It is safe to call Get from multiple goroutines - a single channel is
+// guaranteed to be created only once for a given name.
+func (c *RestChannels) Get(name string, opts ...*proto.ChannelOptions) *RestChannel {
+	o := lastChannelOptions(opts)
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	v, ok := c.cache[name]
-	c.mu.RUnlock()
-	if ok {
-		if opts != nil {
-			v.options = opts
-		}
-		return v
+	if !ok {
+		v = newRestChannel(name, c.client)
+		c.cache[name] = v
+	}
+	if o != nil || !ok {
+		v.options = o
 	}
-	v = newRestChannel(name, c.client)
-	v.options = opts
-	c.mu.Lock()
-	c.cache[name] = v
-	c.mu.Unlock()
 	return v
 }
 
-// Release deletes the channel from the cache.
-func (c *RestChannels) Release(ch *RestChannel) {
+// Iterate returns a snapshot of the channels, sorted by name.
+func (c *RestChannels) Iterate() []*RestChannel {
+	c.mu.RLock()
+	chans := make([]*RestChannel, 0, len(c.cache))
+	for _, v := range c.cache {
+		chans = append(chans, v)
+	}
+	c.mu.RUnlock()
+	sort.Slice(chans, func(i, j int) bool { return chans[i].Name < chans[j].Name })
+	return chans
+}
+
+// Release deletes the channel with the given name from the cache, if it
+// exists. A later Get creates a new channel.
+func (c *RestChannels) Release(name string) {
 	c.mu.Lock()
-	delete(c.cache, ch.Name)
+	delete(c.cache, name)
 	c.mu.Unlock()
 }
 
@@ -130,8 +147,10 @@ func (c *RestChannels) Len() (size int) {
 type RestClient struct {
 	Auth                *Auth
 	Channels            *RestChannels
+	Push                *Push
 	opts                ClientOptions
 	successFallbackHost *fallbackCache
+	publishLimit        *rateLimiter // nil unless ClientOptions.PublishRateLimit is set
 }
 
 func NewRestClient(opts *ClientOptions) (*RestClient, error) {
@@ -144,6 +163,12 @@ func NewRestClient(opts *ClientOptions) (*RestClient, error) {
 	c := &RestClient{
 		opts: *opts,
 	}
+	if c.opts.HTTPClient == nil {
+		// Built once, for all requests to share its connections.
+		c.opts.HTTPClient = c.opts.newHTTPClient()
+	}
+	c.opts.HTTPClient = c.opts.withMiddleware(c.opts.HTTPClient)
+	c.publishLimit = newRateLimiter(c.opts.PublishRateLimit, c.opts.getClock())
 	auth, err := newAuth(c)
 	if err != nil {
 		return nil, err
@@ -153,16 +178,24 @@ func NewRestClient(opts *ClientOptions) (*RestClient, error) {
 		cache:  make(map[string]*RestChannel),
 		client: c,
 	}
+	c.Push = newPush(c)
 	return c, nil
 }
 
+// Time gives the time according to the Ably servers (RSC16).
 func (c *RestClient) Time() (time.Time, error) {
+	return c.TimeContext(context.Background())
+}
+
+// TimeContext is like Time, with ctx used for the request (RSC16).
+func (c *RestClient) TimeContext(ctx context.Context) (time.Time, error) {
 	var times []int64
 	r := &Request{
 		Method: "GET",
 		Path:   "/time",
 		Out:    &times,
 		NoAuth: true,
+		ctx:    ctx,
 	}
 	_, err := c.do(r)
 	if err != nil {
@@ -171,12 +204,13 @@ func (c *RestClient) Time() (time.Time, error) {
 	if len(times) != 1 {
 		return time.Time{}, newErrorf(ErrInternalError, "expected 1 timestamp, got %d", len(times))
 	}
-	return time.Unix(times[0]/1000, times[0]%1000), nil
+	return time.Unix(0, times[0]*int64(time.Millisecond)), nil
 }
 
 // Stats gives the channel's metrics according to the given parameters.
 // The returned result can be inspected for the statistics via the Stats()
-// method.
+// method. The params for a time window can be built with StatsBetween,
+// LastHours and the like.
 func (c *RestClient) Stats(params *PaginateParams) (*PaginatedResult, error) {
 	return newPaginatedResult(nil, paginatedRequest{typ: statType, path: "/stats", params: params, query: query(c.get), logger: c.logger(), respCheck: checkValidHTTPResponse})
 }
@@ -195,6 +229,13 @@ type Request struct {
 	// when true token is not refreshed when request fails with token expired response
 	NoRenew bool
 	header  http.Header
+
+	// requestID is sent as the request_id query parameter when
+	// ClientOptions.AddRequestIDs is true; it's kept across retries.
+	requestID string
+
+	// ctx, when non-nil, is used for every HTTP request sent for r.
+	ctx context.Context
 }
 
 // Request sends http request to ably.
@@ -310,7 +351,39 @@ func (f *fallbackCache) put(host string) {
 }
 
 func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interface{}) (*http.Response, error)) (*http.Response, error) {
-	log := c.opts.Logger.Sugar()
+	if c.opts.AddRequestIDs && r.requestID == "" {
+		id, err := c.opts.requestID()
+		if err != nil {
+			return nil, newError(ErrInternalError, err)
+		}
+		r.requestID = id
+	}
+	log := c.requestLogger(r)
+	if r.requestID != "" {
+		log.Verbosef("RestClient: using request_id for %s %s", r.Method, r.Path)
+	}
+	attrs := []Field{{Key: "http.method", Value: r.Method}, {Key: "ably.path", Value: r.Path}}
+	if r.requestID != "" {
+		attrs = append(attrs, Field{Key: "ably.request_id", Value: r.requestID})
+	}
+	var span Span
+	r.ctx, span = c.opts.startSpan(r.ctx, "ably.rest.request", attrs...)
+	resp, err := c.doWithFallback(r, handle)
+	span.End(err)
+	if err != nil && r.requestID != "" {
+		e, ok := err.(*Error)
+		if !ok {
+			e = newError(ErrInternalError, err)
+		}
+		e.RequestID = r.requestID
+		log.Errorf("RestClient: request failed: %v", e)
+		return nil, e
+	}
+	return resp, err
+}
+
+func (c *RestClient) doWithFallback(r *Request, handle func(*http.Response, interface{}) (*http.Response, error)) (*http.Response, error) {
+	log := c.requestLogger(r)
 	if c.successFallbackHost == nil {
 		c.successFallbackHost = &fallbackCache{
 			duration: c.opts.fallbackRetryTimeout(),
@@ -322,7 +395,7 @@ func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interf
 		return nil, err
 	}
 	if h := c.successFallbackHost.get(); h != "" {
-		req.URL.Host = h // RSC15f
+		setRequestHost(req, h) // RSC15f
 		log.Verbosef("RestClient: setting URL.Host=%q", h)
 	}
 	if c.opts.Trace != nil {
@@ -334,7 +407,7 @@ func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interf
 		if err != nil {
 			log.Error("RestClient: error trying to dump request: ", err)
 		} else {
-			log.Verbose("RestClient: ", string(b))
+			log.Verbose("RestClient: ", string(log.redactHTTPDump(b)))
 		}
 	}
 	resp, err := c.opts.httpclient().Do(req)
@@ -342,14 +415,19 @@ func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interf
 		log.Error("RestClient: failed sending a request ", err)
 		return nil, newError(ErrInternalError, err)
 	}
+	c.countBytes(req, resp)
+	if err := decompressResponse(resp); err != nil {
+		log.Error("RestClient: failed decompressing a response ", err)
+		return nil, err
+	}
 	if log.Is(LogVerbose) {
 		typ, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 		// dumping msgpack body isn't that helpbul when debugging
-		b, err := httputil.DumpResponse(resp, typ != "application/x-msgpack")
+		b, err := httputil.DumpResponse(resp, typ != "application/x-msgpack" && !log.redactPayloads())
 		if err != nil {
 			log.Error("RestClient: error trying to dump response: ", err)
 		} else {
-			log.Verbose("RestClient: ", string(b))
+			log.Verbose("RestClient: ", string(log.redactHTTPDump(b)))
 		}
 	}
 	resp, err = handle(resp, r.Out)
@@ -358,9 +436,9 @@ func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interf
 		if e, ok := err.(*Error); ok {
 			if canFallBack(e.StatusCode) {
 				fallbacks, _ := c.opts.getFallbackHosts()
-				log.Info("RestClient: trying to fallback with hosts=%v", fallbacks)
+				log.Infof("RestClient: trying to fallback with hosts=%v", fallbacks)
 				if len(fallbacks) > 0 {
-					left := fallbacks
+					left := c.opts.fallbackStrategy().Order(fallbacks)
 					iteration := 0
 					maxLimit := c.opts.HTTPMaxRetryCount
 					if maxLimit == 0 {
@@ -370,36 +448,24 @@ func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interf
 
 					for {
 						if len(left) == 0 {
-							log.Errorf("RestClient: exhausted fallback hosts", err)
+							log.Errorf("RestClient: exhausted fallback hosts: %v", err)
 							return nil, err
 						}
-						var h string
-						if len(left) == 1 {
-							h = left[0]
-						} else {
-							h = left[rand.Intn(len(left)-1)]
-						}
-						var n []string
-						for _, v := range left {
-							if v != h {
-								n = append(n, v)
-							}
-						}
-						left = n
+						h := left[0]
+						left = left[1:]
 						req, err := c.NewHTTPRequest(r)
 						if err != nil {
 							return nil, err
 						}
 						log.Infof("RestClient:  chose fallback host=%q ", h)
-						req.URL.Host = h
-						req.Host = ""
-						req.Header.Set(HostHeader, h)
+						c.opts.metrics().Add(MetricFallbacks, 1)
+						setRequestHost(req, h)
 						if log.Is(LogVerbose) {
-							b, err := httputil.DumpRequest(req, true)
+							b, err := httputil.DumpRequest(req, !log.redactPayloads())
 							if err != nil {
 								log.Error("RestClient: error trying to dump retry request with fallback host: ", err)
 							} else {
-								log.Verbose("RestClient: ", string(b))
+								log.Verbose("RestClient: ", string(log.redactHTTPDump(b)))
 							}
 						}
 						resp, err := c.opts.httpclient().Do(req)
@@ -407,6 +473,11 @@ func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interf
 							log.Error("RestClient: failed sending a request to a fallback host", err)
 							return nil, newError(ErrInternalError, err)
 						}
+						c.countBytes(req, resp)
+						if err := decompressResponse(resp); err != nil {
+							log.Error("RestClient: failed decompressing a response from a fallback host ", err)
+							return nil, err
+						}
 						resp, err = handle(resp, r.Out)
 						if err != nil {
 							log.Error("RestClient: error handling response: ", err)
@@ -424,11 +495,11 @@ func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interf
 						if log.Is(LogVerbose) {
 							typ, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
 							// dumping msgpack body isn't that helpbul when debugging
-							b, err := httputil.DumpResponse(resp, typ != "application/x-msgpack")
+							b, err := httputil.DumpResponse(resp, typ != "application/x-msgpack" && !log.redactPayloads())
 							if err != nil {
 								log.Error("RestClient: error trying to dump retry response: ", err)
 							} else {
-								log.Verbose("RestClient:: ", string(b))
+								log.Verbose("RestClient:: ", string(log.redactHTTPDump(b)))
 							}
 						}
 						c.successFallbackHost.put(h)
@@ -453,6 +524,74 @@ func (c *RestClient) doWithHandle(r *Request, handle func(*http.Response, interf
 	return resp, nil
 }
 
+// setRequestHost directs req to host, rather than to the primary host it was
+// made for. Both its Host header and the server name sent and verified in
+// the TLS handshake, which is taken from the URL, are set to host (RSC15j).
+func setRequestHost(req *http.Request, host string) {
+	req.URL.Host = host
+	req.Host = host
+}
+
+// countBytes reports the sizes of the request and response bodies, when
+// known, to the metrics sink.
+func (c *RestClient) countBytes(req *http.Request, resp *http.Response) {
+	metrics := c.opts.metrics()
+	if req.ContentLength > 0 {
+		metrics.Add(MetricBytesSent, float64(req.ContentLength))
+	}
+	if resp.ContentLength > 0 {
+		metrics.Add(MetricBytesReceived, float64(resp.ContentLength))
+	}
+}
+
+// decompressResponse replaces the body of a gzip or deflate encoded
+// response with its decompressed content. Responses decompressed by the
+// transport itself are left as they are.
+func decompressResponse(resp *http.Response) error {
+	var (
+		body io.Reader
+		err  error
+	)
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		body, err = gzip.NewReader(resp.Body)
+	case "deflate":
+		body, err = newDeflateReader(resp.Body)
+	default:
+		return nil
+	}
+	if err == io.EOF {
+		body, err = bytes.NewReader(nil), nil // empty body
+	}
+	if err != nil {
+		resp.Body.Close()
+		return newError(ErrInternalError, fmt.Errorf("decompressing response: %w", err))
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{body, resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// newDeflateReader reads a deflate encoded body, which per RFC 9110 is
+// zlib wrapped, though some servers send raw deflate data.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
 func canFallBack(code int) bool {
 	return http.StatusInternalServerError <= code &&
 		code <= http.StatusGatewayTimeout
@@ -471,7 +610,11 @@ func (c *RestClient) NewHTTPRequest(r *Request) (*http.Request, error) {
 		body = bytes.NewReader(p)
 	}
 
-	req, err := http.NewRequest(r.Method, c.opts.restURL()+r.Path, body)
+	ctx := r.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	req, err := http.NewRequestWithContext(ctx, r.Method, c.opts.restURL()+r.Path, body)
 	if err != nil {
 		return nil, newError(ErrInternalError, err)
 	}
@@ -481,9 +624,21 @@ func (c *RestClient) NewHTTPRequest(r *Request) (*http.Request, error) {
 	if r.header != nil {
 		copyHeader(req.Header, r.header)
 	}
+	if r.requestID != "" {
+		query := req.URL.Query()
+		query.Set("request_id", r.requestID) // RSC7c
+		req.URL.RawQuery = query.Encode()
+	}
 	req.Header.Set("Accept", proto) //spec RSC19c
+	if c.opts.DisableCompression {
+		// Prevents the default transport from asking for gzip on its own.
+		req.Header.Set("Accept-Encoding", "identity")
+	} else if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
 	req.Header.Set(AblyVersionHeader, AblyVersion)
 	req.Header.Set(AblyLibHeader, LibraryString)
+	req.Header.Set(AblyAgentHeader, c.opts.agent())
 	if c.opts.ClientID != "" && c.Auth.method == authBasic {
 		// References RSA7e2
 		h := base64.StdEncoding.EncodeToString([]byte(c.opts.ClientID))
@@ -520,6 +675,16 @@ func (c *RestClient) logger() *LoggerOptions {
 	return &c.opts.Logger
 }
 
+// requestLogger gives a logger for the http subsystem which adds the request
+// ID, if any, to every log entry.
+func (c *RestClient) requestLogger(r *Request) *SugaredLogger {
+	log := c.opts.Logger
+	if r.requestID != "" {
+		log = log.With(Field{Key: "requestID", Value: r.requestID})
+	}
+	return log.forSubsystem(SubsystemHTTP).Sugar()
+}
+
 func encode(typ string, in interface{}) ([]byte, error) {
 	switch typ {
 	case "application/json":
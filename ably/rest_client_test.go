@@ -1,17 +1,27 @@
 package ably_test
 
 import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httptrace"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -270,6 +280,67 @@ func TestRSC7(t *testing.T) {
 	})
 }
 
+func TestRSC7d(t *testing.T) {
+	t.Parallel()
+	opts := ably.NewClientOptions("xxxxxxx.yyyyyyy:zzzzzzz")
+	opts.Agents = map[string]string{
+		"ably-chat": "0.1.0",
+		"custom":    "",
+	}
+	c, err := ably.NewRestClient(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := c.NewHTTPRequest(&ably.Request{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	agent := regexp.MustCompile(`^ably-go/` + regexp.QuoteMeta(ably.LibraryVersion) + ` go/[^ ]+ os/[^ ]+ ably-chat/0\.1\.0 custom$`)
+	if h := req.Header.Get(ably.AblyAgentHeader); !agent.MatchString(h) {
+		t.Errorf("expected %s to match %s", h, agent)
+	}
+}
+
+func TestRSC7c(t *testing.T) {
+	t.Parallel()
+	var requestIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.URL.Query().Get("request_id"))
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions:      ably.AuthOptions{Token: "token"},
+		NoTLS:            true,
+		NoBinaryProtocol: true,
+		FallbackHosts:    []string{"a.example.com", "b.example.com"},
+		HTTPClient:       newHTTPClientMock(server),
+		AddRequestIDs:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Time()
+	e, ok := err.(*ably.Error)
+	if !ok {
+		t.Fatalf("want *ably.Error; got %T: %v", err, err)
+	}
+	if len(requestIDs) != 3 {
+		t.Fatalf("want 3 requests; got %d", len(requestIDs))
+	}
+	if e.RequestID == "" {
+		t.Fatal("want non-empty RequestID")
+	}
+	for _, id := range requestIDs {
+		if id != e.RequestID {
+			t.Errorf("want request_id=%q; got %q", e.RequestID, id)
+		}
+	}
+	if !strings.Contains(e.Error(), e.RequestID) {
+		t.Errorf("want %q to contain request ID", e.Error())
+	}
+}
+
 func TestRest_hostfallback(t *testing.T) {
 	app, err := ablytest.NewSandbox(nil)
 	if err != nil {
@@ -553,7 +624,7 @@ func TestRestChannels_RSN1(t *testing.T) {
 	t.Run("RSN4 RSN4a must release channels", func(ts *testing.T) {
 		for _, v := range sample {
 			ch := client.Channels.Get(v.name, nil)
-			client.Channels.Release(ch)
+			client.Channels.Release(ch.Name)
 		}
 		size := client.Channels.Len()
 		if size != 0 {
@@ -611,3 +682,276 @@ func TestFixConnLeak_ISSUE89(t *testing.T) {
 func connIsClosed(err error) bool {
 	return strings.Contains(err.Error(), "use of closed network connection")
 }
+
+func TestRestClient_Compression(t *testing.T) {
+	t.Parallel()
+	const body = `[{"id":"a:0","name":"greeting","data":"hello"},{"id":"a:1","name":"greeting","data":"world"}]`
+	compress := map[string]func(w io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"raw deflate": func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+	}
+	for name, newWriter := range compress {
+		name, newWriter := name, newWriter
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			var acceptEncoding string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				acceptEncoding = r.Header.Get("Accept-Encoding")
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Encoding", strings.TrimPrefix(name, "raw "))
+				cw := newWriter(w)
+				cw.Write([]byte(body))
+				cw.Close()
+			}))
+			defer server.Close()
+			client := newTestRestClient(t, server)
+
+			page, err := client.Channels.Get("test").History(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := "gzip, deflate"; acceptEncoding != expected {
+				t.Errorf("expected Accept-Encoding %q; got %q", expected, acceptEncoding)
+			}
+			msgs := page.Messages()
+			if len(msgs) != 2 || msgs[0].Data != "hello" || msgs[1].Data != "world" {
+				t.Errorf("unexpected messages: %+v", msgs)
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		t.Parallel()
+		var acceptEncoding string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			acceptEncoding = r.Header.Get("Accept-Encoding")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(body))
+		}))
+		defer server.Close()
+		client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+			o.DisableCompression = true
+		})
+
+		if _, err := client.Channels.Get("test").History(nil); err != nil {
+			t.Fatal(err)
+		}
+		if expected := "identity"; acceptEncoding != expected {
+			t.Errorf("expected Accept-Encoding %q; got %q", expected, acceptEncoding)
+		}
+	})
+}
+
+func TestRestClient_FallbackHostTLS_RSC15j(t *testing.T) {
+	t.Parallel()
+
+	const primaryHost, fallbackHost = "rest.example.com", "fallback.example.com"
+
+	var primaryRequests int32
+	primary := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&primaryRequests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer primary.Close()
+
+	type request struct{ Host, ServerName string }
+	var mtx sync.Mutex
+	var requests []request
+	fallback := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mtx.Lock()
+		requests = append(requests, request{r.Host, r.TLS.ServerName})
+		mtx.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[1700000000000]"))
+	}))
+	fallback.TLS = &tls.Config{
+		// Only serve the certificate, which is also valid for the primary
+		// host, to clients asking for the fallback host.
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if hello.ServerName != fallbackHost {
+				return nil, fmt.Errorf("unexpected server name %q", hello.ServerName)
+			}
+			return nil, nil
+		},
+	}
+	fallback.StartTLS()
+	defer fallback.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(primary.Certificate())
+	dialer := &net.Dialer{}
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions:      ably.AuthOptions{Token: "token"},
+		RestHost:         primaryHost,
+		FallbackHosts:    []string{fallbackHost},
+		NoBinaryProtocol: true,
+		HTTPClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+					host, _, _ := net.SplitHostPort(addr)
+					if host == fallbackHost {
+						return dialer.DialContext(ctx, network, fallback.Listener.Addr().String())
+					}
+					return dialer.DialContext(ctx, network, primary.Listener.Addr().String())
+				},
+				TLSClientConfig: &tls.Config{RootCAs: roots},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first request is retried against the fallback host, which is then
+	// used for the second one (RSC15f).
+	if _, err := client.Time(); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(ablytest.Timeout); client.GetCachedFallbackHost() != fallbackHost; {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the fallback host to be cached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := client.Time(); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&primaryRequests); n != 1 {
+		t.Errorf("expected 1 request to the primary host; got %d", n)
+	}
+	expected := request{fallbackHost, fallbackHost}
+	assertDeepEquals(t, requests, []request{expected, expected})
+}
+
+func TestRestClient_TimeContext(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[1700000000123]"))
+	}))
+	defer server.Close()
+	client := newTestRestClient(t, server)
+
+	serverTime, err := client.TimeContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := time.Unix(1700000000, 123*int64(time.Millisecond)); !serverTime.Equal(expected) {
+		t.Errorf("expected %v; got %v", expected, serverTime)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.TimeContext(ctx); err == nil {
+		t.Error("expected an error with a canceled context")
+	}
+}
+
+// roundTripperFunc is an http.RoundTripper calling itself.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRestClient_HTTPMiddleware(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	var mtx sync.Mutex
+	var calls []string
+	middleware := func(name string) func(http.RoundTripper) http.RoundTripper {
+		return func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				mtx.Lock()
+				calls = append(calls, name+" "+req.Header.Get("Authorization"))
+				mtx.Unlock()
+				return next.RoundTrip(req)
+			})
+		}
+	}
+	httpClient := &http.Client{}
+	client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+		o.HTTPClient = httpClient
+		o.HTTPMiddleware = []func(http.RoundTripper) http.RoundTripper{
+			middleware("outer"),
+			middleware("inner"),
+		}
+	})
+	if _, err := client.Channels.Get("test", nil).History(nil); err != nil {
+		t.Fatal(err)
+	}
+	mtx.Lock()
+	defer mtx.Unlock()
+	auth := "Bearer " + base64.StdEncoding.EncodeToString([]byte("token"))
+	assertDeepEquals(t, []string{"outer " + auth, "inner " + auth}, calls)
+	if httpClient.Transport != nil {
+		t.Errorf("expected the given HTTP client to be left as is; got transport %T", httpClient.Transport)
+	}
+}
+
+func TestRestClient_FallbackStrategy(t *testing.T) {
+	t.Parallel()
+
+	var mtx sync.Mutex
+	var hosts []string
+	newClient := func(strategy ably.FallbackStrategy) *ably.RestClient {
+		client, err := ably.NewRestClient(&ably.ClientOptions{
+			AuthOptions:       ably.AuthOptions{Token: "token"},
+			RestHost:          "rest.example.com",
+			FallbackHosts:     []string{"a.example.com", "b.example.com", "c.example.com"},
+			FallbackStrategy:  strategy,
+			HTTPMaxRetryCount: 3,
+			NoBinaryProtocol:  true,
+			HTTPClient: &http.Client{
+				Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					mtx.Lock()
+					hosts = append(hosts, req.URL.Hostname())
+					mtx.Unlock()
+					return &http.Response{
+						StatusCode: http.StatusInternalServerError,
+						Header:     http.Header{"Content-Type": {"application/json"}},
+						Body:       ioutil.NopCloser(strings.NewReader(`{"error":{"code":50000,"statusCode":500,"message":"down"}}`)),
+						Request:    req,
+					}, nil
+				}),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+	tried := func(client *ably.RestClient) []string {
+		mtx.Lock()
+		hosts = nil
+		mtx.Unlock()
+		if _, err := client.Time(); err == nil {
+			t.Fatal("expected an error with all hosts failing")
+		}
+		mtx.Lock()
+		defer mtx.Unlock()
+		return hosts
+	}
+
+	client := newClient(ably.PinnedFallbacks("c.example.com", "unknown.example.com"))
+	if got := tried(client); len(got) != 4 || got[0] != "rest.example.com" || got[1] != "c.example.com" {
+		t.Errorf("expected the primary host and then the pinned one to be tried first; got %v", got)
+	}
+
+	client = newClient(ably.RoundRobinFallbacks())
+	assertDeepEquals(t, []string{"rest.example.com", "a.example.com", "b.example.com", "c.example.com"}, tried(client))
+	assertDeepEquals(t, []string{"rest.example.com", "b.example.com", "c.example.com", "a.example.com"}, tried(client))
+
+	client = newClient(ably.WeightedFallbacks(map[string]float64{"a.example.com": 0, "b.example.com": 1e9}))
+	assertDeepEquals(t, []string{"rest.example.com", "b.example.com", "c.example.com", "a.example.com"}, tried(client))
+}
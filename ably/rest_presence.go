@@ -1,5 +1,14 @@
 package ably
 
+import "net/url"
+
+// RestPresenceParams selects the members returned by RestPresence.GetWithParams.
+type RestPresenceParams struct {
+	Limit        int    // maximum number of members per page, up to 1000, if set (RSP3a1)
+	ClientID     string // returns only the members with the client ID, if set (RSP3a2)
+	ConnectionID string // returns only the members with the connection ID, if set (RSP3a3)
+}
+
 type RestPresence struct {
 	client  *RestClient
 	channel *RestChannel
@@ -13,6 +22,26 @@ func (p *RestPresence) Get(params *PaginateParams) (*PaginatedResult, error) {
 	return newPaginatedResult(nil, paginatedRequest{typ: presMsgType, path: path, params: params, query: query(p.client.get), logger: p.logger(), respCheck: checkValidHTTPResponse})
 }
 
+// GetWithParams is like Get, giving only the members selected by params,
+// which may be nil to get them all. The result can be paged through for
+// channels with many members.
+func (p *RestPresence) GetWithParams(params *RestPresenceParams) (*PaginatedResult, error) {
+	path := p.channel.baseURL + "/presence"
+	var paginate *PaginateParams
+	var extra url.Values
+	if params != nil {
+		paginate = &PaginateParams{Limit: params.Limit}
+		extra = url.Values{}
+		if params.ClientID != "" {
+			extra.Set("clientId", params.ClientID)
+		}
+		if params.ConnectionID != "" {
+			extra.Set("connectionId", params.ConnectionID)
+		}
+	}
+	return newPaginatedResult(nil, paginatedRequest{typ: presMsgType, path: path, params: paginate, extra: extra, query: query(p.client.get), logger: p.logger(), respCheck: checkValidHTTPResponse})
+}
+
 // History gives the channel's presence messages history according to the given
 // parameters. The returned result can be inspected for the presence messages
 // via the PresenceMessages() method.
@@ -22,5 +51,5 @@ func (p *RestPresence) History(params *PaginateParams) (*PaginatedResult, error)
 }
 
 func (p *RestPresence) logger() *LoggerOptions {
-	return p.client.logger()
+	return p.client.logger().forSubsystem(SubsystemPresence)
 }
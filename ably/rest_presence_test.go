@@ -1,6 +1,8 @@
 package ably_test
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -99,3 +101,51 @@ func TestChannel_Presence(t *testing.T) {
 	})
 
 }
+
+func TestRestPresence_GetWithParams_RSP3a(t *testing.T) {
+	t.Parallel()
+
+	queries := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("page") == "" {
+			w.Header().Set("Link", `<./presence?clientId=alice&limit=2&page=2>; rel="next"`)
+			w.Write([]byte(`[{"action":1,"clientId":"alice","connectionId":"conn1"},{"action":1,"clientId":"alice","connectionId":"conn2"}]`))
+			return
+		}
+		w.Write([]byte(`[{"action":1,"clientId":"alice","connectionId":"conn3"}]`))
+	}))
+	defer server.Close()
+	client := newTestRestClient(t, server)
+	presence := client.Channels.Get("test").Presence
+
+	connIDs := func(page *ably.PaginatedResult) []string {
+		var ids []string
+		for _, m := range page.PresenceMessages() {
+			ids = append(ids, m.ConnectionID)
+		}
+		return ids
+	}
+	page, err := presence.GetWithParams(&ably.RestPresenceParams{Limit: 2, ClientID: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, "clientId=alice&limit=2", <-queries)
+	assertDeepEquals(t, []string{"conn1", "conn2"}, connIDs(page))
+	page, err = page.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, "clientId=alice&limit=2&page=2", <-queries)
+	assertDeepEquals(t, []string{"conn3"}, connIDs(page))
+
+	if _, err := presence.GetWithParams(&ably.RestPresenceParams{ConnectionID: "conn1"}); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, "connectionId=conn1", <-queries)
+	if _, err := presence.GetWithParams(nil); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, "", <-queries)
+}
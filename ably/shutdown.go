@@ -0,0 +1,65 @@
+package ably
+
+import (
+	"context"
+)
+
+// errShutdown fails the messages still queued or waiting for an ACK when
+// a client is shut down.
+var errShutdown = newErrorf(ErrConnectionClosed, "client shut down")
+
+// Shutdown closes the client like CloseContext, then releases all of its
+// resources: it stops its timers and goroutines, fails the messages whose
+// publication is still pending, closes all subscriptions and idle HTTP
+// connections, and empties its Channels.
+//
+// The client must not be used after Shutdown returns.
+func (c *RealtimeClient) Shutdown(ctx context.Context) error {
+	err := c.CloseContext(ctx)
+	c.Connection.state.Lock()
+	c.Connection.failPending(errShutdown)
+	c.Connection.state.Unlock()
+	c.Connection.queue.Fail(errShutdown)
+	for _, ch := range c.Channels.clear() {
+		ch.shutdown()
+	}
+	c.rest.Close()
+	return err
+}
+
+// clear removes all channels, returning them.
+func (ch *Channels) clear() []*RealtimeChannel {
+	chans := ch.Iterate()
+	ch.mtx.Lock()
+	ch.chans = make(map[string]*RealtimeChannel)
+	ch.mtx.Unlock()
+	return chans
+}
+
+// shutdown stops the channel's timers, fails its pending publishes and
+// closes its subscriptions.
+func (c *RealtimeChannel) shutdown() {
+	c.state.Lock()
+	c.stopRetry()
+	c.state.Unlock()
+	c.batch.fail(errShutdown)
+	c.queue.Fail(errShutdown)
+	c.messageSubs().close()
+	c.Presence.subscriptions().close()
+}
+
+// Close releases the resources of the client: it stops the timer of
+// the cached fallback host, closes idle HTTP connections and empties
+// Channels.
+//
+// The client must not be used after Close returns.
+func (c *RestClient) Close() error {
+	if f := c.successFallbackHost; f != nil && f.isRunning() {
+		f.stop()
+	}
+	c.opts.httpclient().CloseIdleConnections()
+	c.Channels.mu.Lock()
+	c.Channels.cache = make(map[string]*RestChannel)
+	c.Channels.mu.Unlock()
+	return nil
+}
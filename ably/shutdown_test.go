@@ -0,0 +1,104 @@
+package ably_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+
+	"go.uber.org/goleak"
+)
+
+// leakTestEnv is set when a test is re-run in its own process, so that
+// goroutines left by other tests don't show up as leaks.
+const leakTestEnv = "ABLY_LEAK_TEST"
+
+func runIsolated(t *testing.T) bool {
+	t.Helper()
+	if os.Getenv(leakTestEnv) != "" {
+		return false
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^"+t.Name()+"$", "-test.v")
+	cmd.Env = append(os.Environ(), leakTestEnv+"=1")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+	return true
+}
+
+func TestRealtimeClient_Shutdown(t *testing.T) {
+	if runIsolated(t) {
+		return
+	}
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	client, channel := newAttachedPipeClient(t, in, out, func(o *ably.ClientOptions) {
+		o.PublishBatchWindow = time.Hour
+		o.HandlerConcurrency = 2
+	})
+	sub, err := channel.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := channel.SubscribePooled(nil, func(*proto.Message) {}); err != nil {
+		t.Fatal(err)
+	}
+	res, err := channel.Publish("greeting", "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for msg := range out {
+			if msg.Action == proto.ActionClose {
+				in <- &proto.ProtocolMessage{Action: proto.ActionClosed}
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = client.Shutdown(ctx)
+	var unacked *ably.UnacknowledgedError
+	if !errors.As(err, &unacked) {
+		t.Fatalf("want *ably.UnacknowledgedError; got %#v", err)
+	}
+	if n := len(unacked.Messages); n != 1 {
+		t.Fatalf("want 1 unacknowledged message; got %d", n)
+	}
+	if err := res.Wait(); ably.ErrorCode(err) != ably.ErrConnectionClosed {
+		t.Fatalf("want publish to fail with code %d; got %v", ably.ErrConnectionClosed, err)
+	}
+	if _, ok := <-sub.MessageChannel(); ok {
+		t.Fatal("expected subscription to be closed")
+	}
+	if client.Channels.Exists("test") {
+		t.Fatal("expected channels to be released")
+	}
+	goleak.VerifyNoLeaks(t)
+}
+
+func TestRestClient_Close(t *testing.T) {
+	if runIsolated(t) {
+		return
+	}
+	client, err := ably.NewRestClient(&ably.ClientOptions{
+		AuthOptions: ably.AuthOptions{Key: "xxxxxxx.yyyyyyy:zzzzzzz"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Channels.Get("test")
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(client.Channels.Iterate()); n != 0 {
+		t.Fatalf("want no channels; got %d", n)
+	}
+	goleak.VerifyNoLeaks(t)
+}
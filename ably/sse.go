@@ -0,0 +1,209 @@
+package ably
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// SSEClient subscribes to channels over Ably's Server-Sent Events endpoint,
+// for consumers which only receive messages and can't, or needn't, keep a
+// realtime connection, e.g. where websockets are unavailable. Messages are
+// received as JSON, and there's no connection or channel state to track:
+// only the position in the stream, to resume from after a reconnection.
+type SSEClient struct {
+	rest *RestClient
+	http *http.Client
+}
+
+// NewSSEClient gives an SSEClient connecting to the realtime host, and
+// authenticating, as set by opts.
+func NewSSEClient(opts *ClientOptions) (*SSEClient, error) {
+	rest, err := NewRestClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	// The stream lasts for as long as the subscription; the request timeout
+	// only applies to getting the response.
+	client := *rest.opts.HTTPClient
+	client.Timeout = 0
+	return &SSEClient{rest: rest, http: &client}, nil
+}
+
+// Auth gives the client's Auth, e.g. to authorize it again.
+func (c *SSEClient) Auth() *Auth {
+	return c.rest.Auth
+}
+
+// Subscribe calls handler with every message published on channels, in the
+// order they're received, until ctx is done or subscribing fails for good.
+// The stream is resumed from the last received message after being broken,
+// once ClientOptions.DisconnectedRetryTimeout has elapsed.
+//
+// It returns ctx.Err() once ctx is done, or the error preventing to
+// subscribe, e.g. for lacking the capability to subscribe to a channel.
+func (c *SSEClient) Subscribe(ctx context.Context, handler func(channel string, msg *proto.Message), channels ...string) error {
+	if len(channels) == 0 {
+		return newErrorf(ErrInvalidParameterValue, "no channels to subscribe to")
+	}
+	var lastEvent string
+	renewed := false
+	for attempt := 0; ; attempt++ {
+		streamed, err := c.stream(ctx, channels, &lastEvent, handler)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if streamed {
+			attempt, renewed = 0, false
+		}
+		if e, ok := err.(*Error); ok {
+			switch {
+			case ErrTokenErrorUnspecified <= e.Code && e.Code < 40150:
+				// The token expired, or was revoked; a new one is tried
+				// once (RSA4b).
+				if renewed || !c.rest.Auth.isTokenRenewable() {
+					return err
+				}
+				if _, err := c.rest.Auth.reauthorize(); err != nil {
+					return err
+				}
+				renewed = true
+				continue
+			case e.StatusCode != 0 && e.StatusCode < http.StatusInternalServerError:
+				return err
+			}
+		}
+		delay := retryDelay(c.rest.opts.disconnectedRetryTimeout(), attempt)
+		c.logger().Printf(LogWarning, "SSE: stream broken, reconnecting in %v: %v", delay, err)
+		timer := c.rest.opts.getClock().NewTimer(delay)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// stream receives the messages of a single SSE request, keeping the ID of
+// the last one in lastEvent. It returns once the stream is broken, telling
+// whether it was established.
+func (c *SSEClient) stream(ctx context.Context, channels []string, lastEvent *string, handler func(string, *proto.Message)) (bool, error) {
+	query := url.Values{
+		"v":        {AblyVersion},
+		"channels": {strings.Join(channels, ",")},
+	}
+	if *lastEvent != "" {
+		query.Set("lastEvent", *lastEvent)
+	}
+	req, err := http.NewRequest("GET", c.sseURL()+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, newError(ErrBadRequest, err)
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set(AblyLibHeader, LibraryString)
+	req.Header.Set(AblyAgentHeader, c.rest.opts.agent())
+	if err := c.rest.Auth.authReq(req); err != nil {
+		return false, err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return false, err
+	}
+	if err := checkValidHTTPResponse(resp); err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	var event, id string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line != "" {
+			field, value := line, ""
+			if i := strings.IndexByte(line, ':'); i != -1 {
+				field, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+			}
+			switch field {
+			case "event":
+				event = value
+			case "id":
+				id = value
+			case "data":
+				if data.Len() != 0 {
+					data.WriteByte('\n')
+				}
+				data.WriteString(value)
+			}
+			continue
+		}
+		// A blank line dispatches the event.
+		switch event {
+		case "", "message":
+			if data.Len() == 0 {
+				break
+			}
+			channel, msg, err := decodeSSEMessage(data.Bytes())
+			if err != nil {
+				c.logger().Printf(LogWarning, "SSE: dropping malformed message: %v", err)
+				break
+			}
+			if id != "" {
+				*lastEvent = id
+			}
+			handler(channel, msg)
+		case "error":
+			var info proto.ErrorInfo
+			if err := json.Unmarshal(data.Bytes(), &info); err != nil {
+				return true, newError(ErrInternalError, err)
+			}
+			return true, newErrorProto(&info)
+		}
+		event, id = "", ""
+		data.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return true, err
+	}
+	return true, newErrorf(ErrDisconnected, "SSE stream ended")
+}
+
+// sseURL gives the URL of the SSE endpoint on the realtime host.
+func (c *SSEClient) sseURL() string {
+	port, _ := c.rest.opts.activePort()
+	host := net.JoinHostPort(c.rest.opts.getRealtimeHost(), strconv.Itoa(port))
+	if c.rest.opts.NoTLS {
+		return "http://" + host + "/sse"
+	}
+	return "https://" + host + "/sse"
+}
+
+func (c *SSEClient) logger() *LoggerOptions {
+	return c.rest.logger()
+}
+
+// decodeSSEMessage decodes an enveloped message, which has its channel
+// along the fields of the message.
+func decodeSSEMessage(data []byte) (string, *proto.Message, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return "", nil, err
+	}
+	channel, _ := fields["channel"].(string)
+	var msg proto.Message
+	if err := msg.FromMap(fields); err != nil {
+		return "", nil, err
+	}
+	return channel, &msg, nil
+}
@@ -0,0 +1,78 @@
+package ably_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestSSEClient_Subscribe(t *testing.T) {
+	t.Parallel()
+
+	queries := make(chan url.Values, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sse" || r.Header.Get("Accept") != "text/event-stream" || r.Header.Get("Authorization") == "" {
+			t.Errorf("unexpected request %s with headers %v", r.URL, r.Header)
+		}
+		queries <- r.URL.Query()
+		w.Header().Set("Content-Type", "text/event-stream")
+		if r.URL.Query().Get("lastEvent") == "" {
+			fmt.Fprint(w, ": heartbeat\n\n")
+			fmt.Fprint(w, "id: 1\ndata: {\"channel\":\"a\",\"name\":\"first\",\"data\":\"one\"}\n\n")
+			fmt.Fprint(w, "id: 2\ndata: {\"channel\":\"b\",\"name\":\"second\",\n")
+			fmt.Fprint(w, "data: \"data\":\"dHdv\",\"encoding\":\"base64\"}\n\n")
+			return // the stream breaks
+		}
+		fmt.Fprint(w, "id: 3\ndata: {\"channel\":\"a\",\"name\":\"third\",\"data\":\"three\"}\n\n")
+		fmt.Fprint(w, "event: error\ndata: {\"code\":40160,\"statusCode\":401,\"message\":\"denied\"}\n\n")
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+	client, err := ably.NewSSEClient(&ably.ClientOptions{
+		AuthOptions:              ably.AuthOptions{Token: "token"},
+		RestHost:                 u.Hostname(),
+		RealtimeHost:             u.Hostname(),
+		Port:                     port,
+		NoTLS:                    true,
+		DisconnectedRetryTimeout: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = client.Subscribe(context.Background(), func(channel string, msg *proto.Message) {
+		got = append(got, fmt.Sprintf("%s/%s/%v", channel, msg.Name, msg.Data))
+	}, "a", "b")
+	if err := checkError(ably.ErrOperationNotPermittedWithProvidedCapability, err); err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, []string{"a/first/one", "b/second/[116 119 111]", "a/third/three"}, got)
+
+	q := <-queries
+	if q.Get("channels") != "a,b" || q.Get("lastEvent") != "" {
+		t.Errorf("unexpected first query %v", q)
+	}
+	if q := <-queries; q.Get("lastEvent") != "2" {
+		t.Errorf("expected the stream to be resumed from event 2; got query %v", q)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := client.Subscribe(ctx, func(string, *proto.Message) {}, "a"); err != context.Canceled {
+		t.Errorf("expected %v; got %v", context.Canceled, err)
+	}
+	err = client.Subscribe(context.Background(), func(string, *proto.Message) {})
+	if err := checkError(ably.ErrInvalidParameterValue, err); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,9 +1,11 @@
 package ably
 
 import (
+	"context"
 	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/ably/ably-go/ably/proto"
 )
@@ -21,7 +23,7 @@ const (
 	StateChan
 )
 
-// Strings implements the fmt.Stringer interface.
+// String implements the fmt.Stringer interface.
 func (st StateType) String() string {
 	switch st {
 	case StateConn:
@@ -33,6 +35,29 @@ func (st StateType) String() string {
 	}
 }
 
+// MarshalText implements the encoding.TextMarshaler interface, giving the
+// same text as String.
+func (st StateType) MarshalText() ([]byte, error) {
+	if st != StateConn && st != StateChan {
+		return nil, fmt.Errorf("ably: invalid state type %d", int(st))
+	}
+	return []byte(st.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface, parsing
+// the text given by MarshalText.
+func (st *StateType) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "connection":
+		*st = StateConn
+	case "channel":
+		*st = StateChan
+	default:
+		return fmt.Errorf("ably: invalid state type %q", text)
+	}
+	return nil
+}
+
 // Contains returns true when the state belongs to the given type.
 func (st StateType) Contains(state StateEnum) bool {
 	return stateMasks[st]&state == state
@@ -49,6 +74,50 @@ func (sc StateEnum) String() string {
 	return "invalid"
 }
 
+// MarshalText implements the encoding.TextMarshaler interface, giving the
+// same text as String.
+func (sc StateEnum) MarshalText() ([]byte, error) {
+	s, ok := stateText[sc]
+	if !ok {
+		return nil, fmt.Errorf("ably: invalid state %d", int(sc))
+	}
+	return []byte(s), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface, parsing
+// the text given by MarshalText.
+func (sc *StateEnum) UnmarshalText(text []byte) error {
+	for state, s := range stateText {
+		if s == string(text) {
+			*sc = state
+			return nil
+		}
+	}
+	return fmt.Errorf("ably: invalid state %q", text)
+}
+
+// Type gives whether the state is a connection's or a channel's, or zero if
+// it's invalid.
+func (sc StateEnum) Type() StateType {
+	if _, ok := stateText[sc]; !ok {
+		return 0
+	}
+	if StateConn.Contains(sc) {
+		return StateConn
+	}
+	return StateChan
+}
+
+// In tells whether the state is any of the given ones.
+func (sc StateEnum) In(states ...StateEnum) bool {
+	for _, state := range states {
+		if sc == state {
+			return true
+		}
+	}
+	return false
+}
+
 // StateConn describes states of realtime connection.
 const (
 	StateConnInitialized StateEnum = 1 << iota
@@ -71,6 +140,12 @@ const (
 	StateChanClosing
 	StateChanClosed
 	StateChanFailed
+	StateChanSuspended
+
+	// StateChanUpdate isn't a state but an event, emitted without changing
+	// the channel's state when an attached channel receives an ATTACHED
+	// message, e.g. after its options were changed with SetOptions.
+	StateChanUpdate
 )
 
 // Result awaits completion of asynchronous operation.
@@ -88,6 +163,20 @@ func wait(res Result, err error) error {
 	return res.Wait()
 }
 
+// waitContext waits for res like Result.Wait, or until ctx is done.
+func waitContext(ctx context.Context, res Result) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- res.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 type resultFunc func() error
 
 func (f resultFunc) Wait() error {
@@ -122,6 +211,8 @@ var stateText = map[StateEnum]string{
 	StateChanClosing:      "ably.StateChanClosing",
 	StateChanClosed:       "ably.StateChanClosed",
 	StateChanFailed:       "ably.StateChanFailed",
+	StateChanSuspended:    "ably.StateChanSuspended",
+	StateChanUpdate:       "ably.StateChanUpdate",
 }
 
 // stateAll lists all valid connection and channel state values.
@@ -144,6 +235,8 @@ var stateAll = map[StateType][]StateEnum{
 		StateChanClosed,
 		StateChanDetached,
 		StateChanFailed,
+		StateChanSuspended,
+		StateChanUpdate,
 	},
 }
 
@@ -154,7 +247,7 @@ var stateMasks = map[StateType]StateEnum{
 		StateConnFailed,
 	StateChan: StateChanInitialized | StateChanAttaching | StateChanAttached |
 		StateChanDetaching | StateChanDetached | StateChanClosing | StateChanClosed |
-		StateChanFailed,
+		StateChanFailed | StateChanSuspended | StateChanUpdate,
 }
 
 var (
@@ -204,6 +297,16 @@ type State struct {
 	Err     error     // eventual error value associated with transition
 	State   StateEnum // state which connection or channel has transitioned to
 	Type    StateType // whether transition happened on connection or channel
+
+	// Resumed, for a channel's StateChanAttached and StateChanUpdate, tells
+	// whether the attachment carries on from the previous one, with no
+	// message lost in between (RTL2f).
+	Resumed bool
+
+	// Tags are the tags set on the client with RealtimeClient.SetTag when
+	// the state changed, e.g. to tell which tenant a hook's event concerns;
+	// nil if there are none.
+	Tags map[string]string
 }
 
 type stateEmitter struct {
@@ -214,10 +317,12 @@ type stateEmitter struct {
 	err       error
 	current   StateEnum
 	typ       StateType
-	logger    *LoggerOptions
+	logger    func() *LoggerOptions // resolved on use, for up to date fields
+	hook      func(State)
+	tags      *tags // set on the states emitted
 }
 
-func newStateEmitter(typ StateType, startState StateEnum, channel string, log *LoggerOptions) *stateEmitter {
+func newStateEmitter(typ StateType, startState StateEnum, channel string, log func() *LoggerOptions) *stateEmitter {
 	if !typ.Contains(startState) {
 		panic(`invalid start state: "` + startState.String() + `"`)
 	}
@@ -232,26 +337,58 @@ func newStateEmitter(typ StateType, startState StateEnum, channel string, log *L
 }
 
 func (s *stateEmitter) set(state StateEnum, err error) error {
+	return s.transition(state, err, false)
+}
+
+// setAttached sets a channel's state to StateChanAttached, with resumed as
+// given by the ATTACHED message.
+func (s *stateEmitter) setAttached(resumed bool) error {
+	return s.transition(StateChanAttached, nil, resumed)
+}
+
+func (s *stateEmitter) transition(state StateEnum, err error, resumed bool) error {
 	doemit := s.current != state
 	s.current = state
 	s.err = stateError(state, err)
 	if doemit {
-		s.emit(State{
+		st := State{
 			Channel: s.channel,
 			Err:     s.err,
 			State:   s.current,
 			Type:    s.typ,
-		})
+			Resumed: resumed,
+			Tags:    s.tags.snapshot(),
+		}
+		if s.hook != nil {
+			s.hook(st)
+		}
+		s.emit(st)
 	}
 	return s.err
 }
 
+// update emits StateChanUpdate, keeping the current state.
+func (s *stateEmitter) update(err error, resumed bool) {
+	st := State{
+		Channel: s.channel,
+		Err:     err,
+		State:   StateChanUpdate,
+		Type:    s.typ,
+		Resumed: resumed,
+		Tags:    s.tags.snapshot(),
+	}
+	if s.hook != nil {
+		s.hook(st)
+	}
+	s.emit(st)
+}
+
 func (s *stateEmitter) emit(st State) {
 	for ch := range s.listeners[st.State] {
 		select {
 		case ch <- st:
 		default:
-			s.logger.Printf(LogWarning, "dropping %s due to slow receiver", st)
+			s.logger().Printf(LogWarning, "dropping %v due to slow receiver", st)
 		}
 	}
 	onetime := s.onetime[st.State]
@@ -261,7 +398,7 @@ func (s *stateEmitter) emit(st State) {
 			select {
 			case ch <- st:
 			default:
-				s.logger.Printf(LogWarning, "dropping %s due to slow receiver", st)
+				s.logger().Printf(LogWarning, "dropping %v due to slow receiver", st)
 			}
 			for _, l := range s.onetime {
 				delete(l, ch)
@@ -290,6 +427,108 @@ func (s *stateEmitter) once(ch chan<- State, states ...StateEnum) {
 	}
 }
 
+// offOnce unregisters ch registered with once.
+func (s *stateEmitter) offOnce(ch chan<- State) {
+	for state, l := range s.onetime {
+		delete(l, ch)
+		if len(l) == 0 {
+			delete(s.onetime, state)
+		}
+	}
+}
+
+// waitFor blocks until the emitter is in one of the given states, or any
+// state change if none are given, or ctx is done.
+func (s *stateEmitter) waitFor(ctx context.Context, states ...StateEnum) (State, error) {
+	for _, state := range states {
+		if !s.typ.Contains(state) {
+			panic(fmt.Sprintf("ably: %s WaitForState using invalid state value: %s", s.typ, state.String()))
+		}
+	}
+	listen := make(chan State, 1)
+	s.Lock()
+	for _, state := range states {
+		if state == s.current {
+			st := s.currentState()
+			s.Unlock()
+			return st, nil
+		}
+	}
+	s.once(listen, states...)
+	s.Unlock()
+	select {
+	case st := <-listen:
+		return st, nil
+	case <-ctx.Done():
+		s.Lock()
+		s.offOnce(listen)
+		s.Unlock()
+		// The state may have been emitted right before unregistering.
+		select {
+		case st := <-listen:
+			return st, nil
+		default:
+			return State{}, ctx.Err()
+		}
+	}
+}
+
+// stateChangesBuffer is the capacity of the channels given by
+// stateEmitter.changes.
+const stateChangesBuffer = 16
+
+// changes gives a channel receiving the current state, then every state
+// change until ctx is done, after which it's closed.
+//
+// State changes are relayed through an unbounded queue, so none are dropped
+// if the receiver is slower than the emitter.
+func (s *stateEmitter) changes(ctx context.Context) <-chan State {
+	listen := make(chan State, stateChangesBuffer)
+	out := make(chan State, stateChangesBuffer)
+	s.Lock()
+	queue := []State{s.currentState()}
+	for _, state := range stateAll[s.typ] {
+		l, ok := s.listeners[state]
+		if !ok {
+			l = make(map[chan<- State]struct{})
+			s.listeners[state] = l
+		}
+		l[listen] = struct{}{}
+	}
+	s.Unlock()
+	go func() {
+		defer close(out)
+		defer s.off(listen)
+		for {
+			var send chan<- State
+			var next State
+			if len(queue) != 0 {
+				send, next = out, queue[0]
+			}
+			select {
+			case st := <-listen:
+				queue = append(queue, st)
+			case send <- next:
+				queue = queue[1:]
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// currentState gives the current state as a State value; it's called with
+// the lock held.
+func (s *stateEmitter) currentState() State {
+	return State{
+		Channel: s.channel,
+		Err:     s.err,
+		State:   s.current,
+		Type:    s.typ,
+	}
+}
+
 func (s *stateEmitter) on(ch chan<- State, states ...StateEnum) {
 	if ch == nil {
 		panic(fmt.Sprintf("ably: %s On using nil channel", s.typ))
@@ -334,19 +573,23 @@ func (s *stateEmitter) off(ch chan<- State, states ...StateEnum) {
 
 // queuedEmitter emits confirmation events triggered by ACK or NACK messages.
 type pendingEmitter struct {
-	queue  []serialCh
-	logger *LoggerOptions
+	queue   []serialCh
+	logger  func() *LoggerOptions // resolved on use, for up to date fields
+	metrics MetricsSink
 }
 
-func newPendingEmitter(log *LoggerOptions) pendingEmitter {
+func newPendingEmitter(log func() *LoggerOptions, metrics MetricsSink) pendingEmitter {
 	return pendingEmitter{
-		logger: log,
+		logger:  log,
+		metrics: metrics,
 	}
 }
 
 type serialCh struct {
 	serial int64
 	ch     chan<- error
+	sent   time.Time
+	msg    *proto.ProtocolMessage
 }
 
 func (q pendingEmitter) Len() int {
@@ -365,17 +608,34 @@ func (q pendingEmitter) Search(serial int64) int {
 	return sort.Search(q.Len(), func(i int) bool { return q.queue[i].serial >= serial })
 }
 
-func (q *pendingEmitter) Enqueue(serial int64, ch chan<- error) {
+func (q *pendingEmitter) Enqueue(msg *proto.ProtocolMessage, ch chan<- error) {
+	serial := msg.MsgSerial
 	switch i := q.Search(serial); {
 	case i == q.Len():
-		q.queue = append(q.queue, serialCh{serial, ch})
+		q.queue = append(q.queue, serialCh{serial, ch, time.Now(), msg})
 	case q.queue[i].serial == serial:
-		q.logger.Printf(LogWarning, "duplicated message serial: %d", serial)
+		q.logger().Printf(LogWarning, "duplicated message serial: %d", serial)
 	default:
 		q.queue = append(q.queue, serialCh{})
 		copy(q.queue[i+1:], q.queue[i:])
-		q.queue[i] = serialCh{serial, ch}
+		q.queue[i] = serialCh{serial, ch, time.Now(), msg}
+	}
+}
+
+// messages gives the messages waiting for an ACK.
+func (q *pendingEmitter) messages() []*proto.ProtocolMessage {
+	msgs := make([]*proto.ProtocolMessage, 0, len(q.queue))
+	for _, sch := range q.queue {
+		msgs = append(msgs, sch.msg)
 	}
+	return msgs
+}
+
+// take removes and gives the messages waiting for an ACK, in order.
+func (q *pendingEmitter) take() []serialCh {
+	queue := q.queue
+	q.queue = nil
+	return queue
 }
 
 func (q *pendingEmitter) Ack(serial int64, count int, err error) {
@@ -398,11 +658,14 @@ func (q *pendingEmitter) Ack(serial int64, count int, err error) {
 		err = newError(50000, err)
 	}
 	for _, sch := range q.queue[:nack] {
-		q.logger.Printf(LogVerbose, "received NACK for message serial %d", sch.serial)
+		q.logger().Printf(LogVerbose, "received NACK for message serial %d", sch.serial)
 		sch.ch <- err
 	}
 	for _, sch := range q.queue[nack:ack] {
-		q.logger.Printf(LogVerbose, "received ACK for message serial %d", sch.serial)
+		q.logger().Printf(LogVerbose, "received ACK for message serial %d", sch.serial)
+		if q.metrics != nil {
+			q.metrics.Observe(MetricACKLatency, time.Since(sch.sent).Seconds())
+		}
 		sch.ch <- nil
 	}
 	q.queue = q.queue[ack:]
@@ -425,26 +688,43 @@ func (q *pendingEmitter) Nack(serial int64, count int, err error) {
 		err = newError(50000, err)
 	}
 	for _, sch := range q.queue[:nack] {
-		q.logger.Printf(LogVerbose, "received NACK for message serial %d", sch.serial)
+		q.logger().Printf(LogVerbose, "received NACK for message serial %d", sch.serial)
 		sch.ch <- err
 	}
 	q.queue = q.queue[nack:]
 }
 
+// Fail fails all the messages waiting for an ACK with err.
+func (q *pendingEmitter) Fail(err error) {
+	for _, sch := range q.queue {
+		q.logger().Printf(LogVerbose, "failing message serial %d: %v", sch.serial, err)
+		sch.ch <- err
+	}
+	q.queue = nil
+}
+
 type msgch struct {
 	msg *proto.ProtocolMessage
 	ch  chan<- error
 }
 
+// msgQueue holds messages until they can be sent, and then sends them in the
+// order they were queued.
 type msgQueue struct {
 	mtx   sync.Mutex
 	queue []msgch
-	conn  *Conn
+	// flushing is set from the moment the state allowing to send is entered
+	// until Flush has sent every queued message; messages sent meanwhile are
+	// queued behind them instead, for them not to overtake the queued ones.
+	flushing bool
+	conn     *Conn
+	send     func(*proto.ProtocolMessage, chan<- error) error
 }
 
-func newMsgQueue(conn *Conn) *msgQueue {
+func newMsgQueue(conn *Conn, send func(*proto.ProtocolMessage, chan<- error) error) *msgQueue {
 	return &msgQueue{
 		conn: conn,
+		send: send,
 	}
 }
 
@@ -453,31 +733,102 @@ func (q *msgQueue) Enqueue(msg *proto.ProtocolMessage, listen chan<- error) {
 	// TODO(rjeczalik): reorder the queue so Presence / Messages can be merged
 	q.queue = append(q.queue, msgch{msg, listen})
 	q.mtx.Unlock()
+	q.conn.addQueueDepth(1)
 }
 
-func (q *msgQueue) Flush() {
+// requeue puts msgs, which are already counted in the queue depth, back at
+// the head of the queue.
+func (q *msgQueue) requeue(msgs ...msgch) {
 	q.mtx.Lock()
-	for _, msgch := range q.queue {
-		err := q.conn.send(msgch.msg, msgch.ch)
+	q.queue = append(msgs[:len(msgs):len(msgs)], q.queue...)
+	q.mtx.Unlock()
+}
+
+// busy tells whether a message must be queued rather than sent right away,
+// for being sent after the queued ones.
+func (q *msgQueue) busy() bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return q.flushing || len(q.queue) != 0
+}
+
+// startFlush marks the queue as being flushed until Flush returns. It must be
+// called while holding the lock of the state allowing to send, as it's
+// entered, so that no message sent in that state overtakes the queued ones.
+func (q *msgQueue) startFlush() {
+	q.mtx.Lock()
+	q.flushing = true
+	q.mtx.Unlock()
+}
+
+// startFlushIfQueued is like startFlush, but only if there are queued
+// messages and no flush is running, telling whether Flush is to be called.
+func (q *msgQueue) startFlushIfQueued() bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if q.flushing || len(q.queue) == 0 {
+		return false
+	}
+	q.flushing = true
+	return true
+}
+
+// Flush sends the queued messages, one at a time and in order, including the
+// ones queued while it runs. It stops, leaving the rest queued, if a message
+// can't be sent for the connection not being connected anymore.
+func (q *msgQueue) Flush() {
+	for {
+		q.mtx.Lock()
+		if len(q.queue) == 0 {
+			q.flushing = false
+			q.mtx.Unlock()
+			return
+		}
+		msgch := q.queue[0]
+		q.queue = q.queue[1:]
+		q.mtx.Unlock()
+		err := q.send(msgch.msg, msgch.ch)
+		if err == errRequeued {
+			q.mtx.Lock()
+			q.flushing = false
+			q.mtx.Unlock()
+			return
+		}
 		if err != nil {
 			q.logger().Printf(LogError, "failure sending message (serial=%d): %v", msgch.msg.MsgSerial, err)
-			msgch.ch <- newError(90000, err)
+			if msgch.ch != nil {
+				msgch.ch <- newError(90000, err)
+			}
 		}
+		q.conn.addQueueDepth(-1)
 	}
-	q.queue = nil
-	q.mtx.Unlock()
 }
 
 func (q *msgQueue) Fail(err error) {
 	q.mtx.Lock()
 	for _, msgch := range q.queue {
 		q.logger().Printf(LogError, "failure sending message (serial=%d): %v", msgch.msg.MsgSerial, err)
-		msgch.ch <- newError(90000, err)
+		if msgch.ch != nil {
+			msgch.ch <- newError(90000, err)
+		}
 	}
+	q.conn.addQueueDepth(-len(q.queue))
 	q.queue = nil
+	q.flushing = false
 	q.mtx.Unlock()
 }
 
+// messages gives the queued messages.
+func (q *msgQueue) messages() []*proto.ProtocolMessage {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	msgs := make([]*proto.ProtocolMessage, 0, len(q.queue))
+	for _, msgch := range q.queue {
+		msgs = append(msgs, msgch.msg)
+	}
+	return msgs
+}
+
 func (q *msgQueue) logger() *LoggerOptions {
 	return q.conn.logger()
 }
@@ -3,6 +3,8 @@ package ably
 import (
 	"errors"
 	"testing"
+
+	"github.com/ably/ably-go/ably/proto"
 )
 
 var errNotEmitted = errors.New("not emitted")
@@ -40,9 +42,9 @@ func testQueuedEmitter(t *testing.T, serials, ack, nack []int64, emit func(*pend
 	for i, serial := range serials {
 		index[serial] = i
 	}
-	q := &pendingEmitter{logger: &LoggerOptions{}}
+	q := &pendingEmitter{logger: func() *LoggerOptions { return &LoggerOptions{} }}
 	for serial, i := range index {
-		q.Enqueue(serial, ch[i])
+		q.Enqueue(&proto.ProtocolMessage{MsgSerial: serial}, ch[i])
 	}
 	emit(q)
 	errs := receive(ch...)
@@ -0,0 +1,107 @@
+package ably
+
+import (
+	"time"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// Units of the intervals stats are aggregated over, for ScopeParams.Unit.
+const (
+	UnitMinute = proto.StatGranularityMinute
+	UnitHour   = proto.StatGranularityHour
+	UnitDay    = proto.StatGranularityDay
+	UnitMonth  = proto.StatGranularityMonth
+)
+
+// maxStatsLimit is the most stats intervals Ably gives in a page.
+const maxStatsLimit = 1000
+
+// StatsBetween gives the parameters for RestClient.Stats to get the stats
+// of the intervals of the given unit from the one including start to the one
+// including end, oldest first. The limit is set for them all to be in the
+// first page, unless there are more than Ably gives in a page.
+func StatsBetween(start, end time.Time, unit string) *PaginateParams {
+	start = truncateStatsInterval(start.UTC(), unit)
+	limit := 0
+	for t := start; !t.After(end) && limit < maxStatsLimit; t = nextStatsInterval(t, unit) {
+		limit++
+	}
+	return &PaginateParams{
+		ScopeParams: ScopeParams{
+			Start: Time(start),
+			End:   Time(end),
+			Unit:  unit,
+		},
+		Limit:     limit,
+		Direction: "forwards",
+	}
+}
+
+// LastMinutes gives the parameters for RestClient.Stats to get the stats of
+// the last n minutes, in intervals of the given unit, up to the current one,
+// which is in progress.
+func LastMinutes(n int, unit string) *PaginateParams {
+	now := time.Now()
+	return statsLast(now, now.Add(-time.Duration(n)*time.Minute), unit)
+}
+
+// LastHours is like LastMinutes, for the last n hours; e.g.
+// LastHours(24, UnitHour) gives the stats of the last 24 hours, hour by
+// hour.
+func LastHours(n int, unit string) *PaginateParams {
+	now := time.Now()
+	return statsLast(now, now.Add(-time.Duration(n)*time.Hour), unit)
+}
+
+// LastDays is like LastMinutes, for the last n days.
+func LastDays(n int, unit string) *PaginateParams {
+	now := time.Now()
+	return statsLast(now, now.AddDate(0, 0, -n), unit)
+}
+
+// LastMonths is like LastMinutes, for the last n months.
+func LastMonths(n int, unit string) *PaginateParams {
+	now := time.Now()
+	return statsLast(now, now.AddDate(0, -n, 0), unit)
+}
+
+// statsLast gives the parameters to get the stats of the intervals starting
+// after since up to the one including now, which is always included.
+func statsLast(now, since time.Time, unit string) *PaginateParams {
+	start := nextStatsInterval(truncateStatsInterval(since.UTC(), unit), unit)
+	if current := truncateStatsInterval(now.UTC(), unit); current.Before(start) {
+		start = current
+	}
+	return StatsBetween(start, now, unit)
+}
+
+// truncateStatsInterval gives the start of the interval of the given unit
+// including the UTC time t.
+func truncateStatsInterval(t time.Time, unit string) time.Time {
+	switch unit {
+	case UnitHour:
+		return t.Truncate(time.Hour)
+	case UnitDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case UnitMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default: // Ably defaults to minutes.
+		return t.Truncate(time.Minute)
+	}
+}
+
+// nextStatsInterval gives the start of the interval of the given unit
+// following the one starting at t.
+func nextStatsInterval(t time.Time, unit string) time.Time {
+	switch unit {
+	case UnitHour:
+		return t.Add(time.Hour)
+	case UnitDay:
+		return t.AddDate(0, 0, 1)
+	case UnitMonth:
+		return t.AddDate(0, 1, 0)
+	default:
+		return t.Add(time.Minute)
+	}
+}
@@ -0,0 +1,55 @@
+package ably_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+)
+
+func TestStatsParams(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2024, 3, 5, 10, 30, 15, 0, time.UTC)
+	params := func(start time.Time, unit string, limit int) *ably.PaginateParams {
+		return &ably.PaginateParams{
+			ScopeParams: ably.ScopeParams{Start: ably.Time(start), End: ably.Time(now), Unit: unit},
+			Limit:       limit,
+			Direction:   "forwards",
+		}
+	}
+	for _, c := range []struct {
+		name     string
+		got      *ably.PaginateParams
+		expected *ably.PaginateParams
+	}{{
+		name:     "last 24 hours by hour",
+		got:      ably.StatsLast(now, now.Add(-24*time.Hour), ably.UnitHour),
+		expected: params(time.Date(2024, 3, 4, 11, 0, 0, 0, time.UTC), ably.UnitHour, 24),
+	}, {
+		name:     "last 90 minutes by minute",
+		got:      ably.StatsLast(now, now.Add(-90*time.Minute), ably.UnitMinute),
+		expected: params(time.Date(2024, 3, 5, 9, 1, 0, 0, time.UTC), ably.UnitMinute, 90),
+	}, {
+		name:     "last 2 hours by day",
+		got:      ably.StatsLast(now, now.Add(-2*time.Hour), ably.UnitDay),
+		expected: params(time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC), ably.UnitDay, 1),
+	}, {
+		name:     "last 3 months by month",
+		got:      ably.StatsLast(now, now.AddDate(0, -3, 0), ably.UnitMonth),
+		expected: params(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), ably.UnitMonth, 3),
+	}, {
+		name:     "a week by minute",
+		got:      ably.StatsBetween(now.AddDate(0, 0, -7), now, ably.UnitMinute),
+		expected: params(time.Date(2024, 2, 27, 10, 30, 0, 0, time.UTC), ably.UnitMinute, 1000),
+	}} {
+		if c.got.Start != c.expected.Start {
+			t.Errorf("%s: expected start %v; got %v", c.name, time.Unix(0, c.expected.Start*int64(time.Millisecond)).UTC(), time.Unix(0, c.got.Start*int64(time.Millisecond)).UTC())
+		}
+		assertDeepEquals(t, c.expected, c.got)
+	}
+
+	if got := ably.LastHours(24, ably.UnitHour); got.Limit != 24 || got.Unit != ably.UnitHour {
+		t.Errorf("expected 24 hourly intervals; got %+v", got)
+	}
+}
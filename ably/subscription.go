@@ -2,6 +2,7 @@ package ably
 
 import (
 	"reflect"
+	"strings"
 	"sync"
 
 	"github.com/ably/ably-go/ably/proto"
@@ -12,16 +13,65 @@ var (
 	subscriptionPresenceMessages = reflect.TypeOf((chan *proto.PresenceMessage)(nil))
 )
 
+// OverflowPolicy tells what happens to a message received for a subscription
+// whose queue is full; see SubscriptionOptions.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops the message; the number of dropped messages is given
+	// by Subscription.Dropped.
+	OverflowDrop OverflowPolicy = iota
+
+	// OverflowBlock waits until the queue has room for the message, which
+	// blocks the delivery of all messages received on the connection.
+	OverflowBlock
+
+	// OverflowError closes the subscription, dropping the queued messages;
+	// the error is given by Subscription.Err.
+	OverflowError
+)
+
+// SubscriptionOptions configures a subscription created with
+// RealtimeChannel.SubscribeWithOptions.
+type SubscriptionOptions struct {
+	// BufferSize, when positive, bounds the number of messages queued for
+	// the subscription waiting to be received from its MessageChannel. By
+	// default the queue grows as needed, so that a slow consumer can't delay
+	// the delivery of messages to other subscriptions, at the cost of memory.
+	BufferSize int
+
+	// Overflow is the policy applied to messages received while the queue
+	// holds BufferSize messages.
+	Overflow OverflowPolicy
+}
+
 // Subscription queues messages received from a realtime channel.
 type Subscription struct {
 	typ         reflect.Type
 	mtx         sync.Mutex
 	channel     interface{}
 	sleep       chan struct{}
+	closed      chan struct{} // closed once stopped
 	queue       []interface{}
+	head        int // index of the next message to pop from queue
 	unsubscribe func(*Subscription)
 	stopped     bool
 	logger      *LoggerOptions
+
+	// pool and handler are set for subscriptions created with
+	// RealtimeChannel.SubscribePooled, which call handler instead of
+	// delivering messages on channel.
+	pool        MessagePool
+	handler     func(*proto.Message)
+	concurrency int
+
+	// limit and overflow are set from SubscriptionOptions; space is
+	// signaled when a message is popped from a bounded queue.
+	limit    int
+	overflow OverflowPolicy
+	space    *sync.Cond
+	dropped  int
+	err      error
 }
 
 func newSubscription(typ reflect.Type, unsubscribe func(*Subscription), log *LoggerOptions) *Subscription {
@@ -29,6 +79,7 @@ func newSubscription(typ reflect.Type, unsubscribe func(*Subscription), log *Log
 		typ:         typ,
 		channel:     reflect.MakeChan(typ, 0).Interface(),
 		sleep:       make(chan struct{}, 1),
+		closed:      make(chan struct{}),
 		unsubscribe: unsubscribe,
 		logger:      log,
 	}
@@ -36,10 +87,41 @@ func newSubscription(typ reflect.Type, unsubscribe func(*Subscription), log *Log
 	return sub
 }
 
+func newPooledSubscription(unsubscribe func(*Subscription), log *LoggerOptions, pool MessagePool, handler func(*proto.Message), concurrency int) *Subscription {
+	sub := &Subscription{
+		typ:         subscriptionMessages,
+		sleep:       make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+		unsubscribe: unsubscribe,
+		logger:      log,
+		pool:        pool,
+		handler:     handler,
+		concurrency: concurrency,
+	}
+	if concurrency > 1 {
+		go sub.workerLoop()
+	} else {
+		go sub.handlerLoop()
+	}
+	return sub
+}
+
+func (sub *Subscription) setOptions(opts *SubscriptionOptions) {
+	if opts == nil || opts.BufferSize <= 0 {
+		return
+	}
+	sub.mtx.Lock()
+	sub.limit = opts.BufferSize
+	sub.overflow = opts.Overflow
+	sub.space = sync.NewCond(&sub.mtx)
+	sub.mtx.Unlock()
+}
+
 // MessageChannel gives a channel on which the messages are delivered.
-// It panics when sub was not subscribed to receive channel's messages.
+// It panics when sub was not subscribed to receive channel's messages, or
+// was subscribed with SubscribePooled.
 func (sub *Subscription) MessageChannel() <-chan *proto.Message {
-	if sub.typ != subscriptionMessages {
+	if sub.typ != subscriptionMessages || sub.handler != nil {
 		panic(errInvalidType{typ: sub.typ})
 	}
 	ch := sub.channel.(chan *proto.Message)
@@ -73,49 +155,119 @@ func (sub *Subscription) drain() {
 }
 
 func (sub *Subscription) close(unsubscribe bool) error {
+	// Stop before unsubscribing, which waits for the message being enqueued,
+	// if any, to be enqueued, possibly blocked by OverflowBlock.
+	sub.stop()
 	if unsubscribe {
 		sub.unsubscribe(sub)
 	}
+	return nil
+}
+
+func (sub *Subscription) stop() {
 	sub.mtx.Lock()
 	if sub.stopped {
 		sub.mtx.Unlock()
-		return nil
+		return
 	}
 	sub.stopped = true
-	sub.queue = nil
+	sub.queue, sub.head = nil, 0
 	close(sub.sleep)
+	close(sub.closed)
+	if sub.space != nil {
+		sub.space.Broadcast()
+	}
 	sub.mtx.Unlock()
-	sub.drain() // drain sub.channel to stop loop goroutine.
-	return nil
+	if sub.channel != nil {
+		sub.drain() // drain sub.channel to stop loop goroutine.
+	}
 }
 
 // Len gives a number of messages currently queued.
 func (sub *Subscription) Len() int {
 	sub.mtx.Lock()
 	defer sub.mtx.Unlock()
-	return len(sub.queue)
+	return len(sub.queue) - sub.head
 }
 
-func (sub *Subscription) enqueue(msg interface{}) {
+// Dropped gives the number of messages dropped because the subscription's
+// queue was full; see OverflowDrop.
+func (sub *Subscription) Dropped() int {
 	sub.mtx.Lock()
 	defer sub.mtx.Unlock()
+	return sub.dropped
+}
+
+// Err gives the error the subscription was closed with because its queue
+// was full, if any; see OverflowError.
+func (sub *Subscription) Err() error {
+	sub.mtx.Lock()
+	defer sub.mtx.Unlock()
+	return sub.err
+}
+
+// enqueue enqueues msg, reporting whether it closed the subscription because
+// its queue overflowed with OverflowError; then the caller must unsubscribe it
+// once it no longer holds the subscriptions' mutex.
+func (sub *Subscription) enqueue(msg interface{}) (overflowed bool) {
+	sub.mtx.Lock()
+	for sub.limit > 0 && !sub.stopped && len(sub.queue)-sub.head >= sub.limit {
+		switch sub.overflow {
+		case OverflowBlock:
+			sub.space.Wait()
+			continue
+		case OverflowError:
+			sub.err = newErrorf(ErrInternalChannelError, "subscription queue overflowed its buffer of %d messages", sub.limit)
+			sub.mtx.Unlock()
+			sub.logger.Printf(LogWarning, "Subscription: closing after queue overflow")
+			sub.stop()
+			return true
+		default:
+			sub.dropped++
+			sub.mtx.Unlock()
+			sub.logger.Printf(LogWarning, "Subscription: dropping message after queue overflow")
+			return false
+		}
+	}
+	defer sub.mtx.Unlock()
 	if sub.stopped {
-		return
+		return false
+	}
+	if sub.pool != nil {
+		// Other subscriptions may hold msg; hand a pooled copy to the handler.
+		m := sub.pool.Get()
+		*m = *msg.(*proto.Message)
+		msg = m
 	}
-	sleeping := len(sub.queue) == 0
+	sleeping := len(sub.queue) == sub.head
 	sub.queue = append(sub.queue, msg)
 	if sleeping {
-		sub.sleep <- struct{}{}
+		// A wakeup may be pending already, since the loop can empty the
+		// queue before taking it; don't block on it while holding mtx.
+		select {
+		case sub.sleep <- struct{}{}:
+		default:
+		}
 	}
+	return false
 }
 
 func (sub *Subscription) pop() (msg interface{}, n int) {
 	sub.mtx.Lock()
 	defer sub.mtx.Unlock()
-	if n = len(sub.queue); n == 0 {
+	if n = len(sub.queue) - sub.head; n == 0 {
 		return nil, 0
 	}
-	msg, sub.queue = sub.queue[0], sub.queue[1:]
+	msg = sub.queue[sub.head]
+	sub.queue[sub.head] = nil
+	sub.head++
+	if sub.head == len(sub.queue) {
+		// Reuse the queue's array rather than growing a new one.
+		sub.queue, sub.head = sub.queue[:0], 0
+	}
+	if sub.space != nil {
+		sub.space.Signal()
+	}
 	return msg, n
 }
 
@@ -128,25 +280,97 @@ func (sub *Subscription) loop() {
 	}
 }
 
+func (sub *Subscription) handlerLoop() {
+	for range sub.sleep {
+		for msg, n := sub.pop(); n != 0; msg, n = sub.pop() {
+			sub.handle(msg.(*proto.Message))
+		}
+	}
+}
+
+// workerLoop hands the queued messages to sub.concurrency goroutines calling
+// the handler, and waits for them to return once sub is stopped.
+func (sub *Subscription) workerLoop() {
+	work := make(chan *proto.Message)
+	var wg sync.WaitGroup
+	wg.Add(sub.concurrency)
+	for i := 0; i < sub.concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			for m := range work {
+				sub.handle(m)
+			}
+		}()
+	}
+	for range sub.sleep {
+		for msg, n := sub.pop(); n != 0; msg, n = sub.pop() {
+			work <- msg.(*proto.Message)
+		}
+	}
+	close(work)
+	wg.Wait()
+}
+
+func (sub *Subscription) handle(m *proto.Message) {
+	sub.handler(m)
+	sub.pool.Put(m)
+}
+
 var (
 	subsAll     struct{}
 	subsAllKeys = []interface{}{subsAll}
 )
 
+// namePattern is the subscription key of a message name containing
+// wildcards.
+type namePattern string
+
 func namesToKeys(names []string) []interface{} {
 	if len(names) == 0 {
 		return nil
 	}
 	keys := make([]interface{}, 0, len(names))
 	for _, name := range names {
-		// Ignore empty names.
-		if name != "" {
+		switch {
+		case name == "":
+			// Ignore empty names.
+		case strings.ContainsAny(name, "*?"):
+			keys = append(keys, namePattern(name))
+		default:
 			keys = append(keys, name)
 		}
 	}
 	return keys
 }
 
+// match reports whether name matches the pattern, in which '*' matches any
+// sequence of characters, including an empty one, and '?' matches any single
+// character.
+func (p namePattern) match(name string) bool {
+	// star is the index in p of the last '*' seen, and next the index in name
+	// it's tried to match up to when backtracking.
+	i, j, star, next := 0, 0, -1, 0
+	for j < len(name) {
+		switch {
+		case i < len(p) && p[i] == '*':
+			star, next = i, j
+			i++
+		case i < len(p) && (p[i] == '?' || p[i] == name[j]):
+			i++
+			j++
+		case star != -1:
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
 func statesToKeys(states []proto.PresenceState) []interface{} {
 	if len(states) == 0 {
 		return nil
@@ -159,10 +383,11 @@ func statesToKeys(states []proto.PresenceState) []interface{} {
 }
 
 type subscriptions struct {
-	typ    reflect.Type
-	mtx    sync.Mutex
-	all    map[interface{}]map[*Subscription]struct{}
-	logger *LoggerOptions
+	typ      reflect.Type
+	mtx      sync.Mutex
+	all      map[interface{}]map[*Subscription]struct{}
+	patterns []namePattern // keys of all which are name patterns
+	logger   *LoggerOptions
 }
 
 func newSubscriptions(typ reflect.Type, log *LoggerOptions) *subscriptions {
@@ -183,11 +408,29 @@ func (subs *subscriptions) close() {
 	}
 	// Unsubscribe all channels by creating new sub map for future use.
 	subs.all = make(map[interface{}]map[*Subscription]struct{})
+	subs.patterns = nil
 }
 
 func (subs *subscriptions) subscribe(keys ...interface{}) (*Subscription, error) {
+	return subs.subscribeWithOptions(nil, keys...)
+}
+
+func (subs *subscriptions) subscribeWithOptions(opts *SubscriptionOptions, keys ...interface{}) (*Subscription, error) {
 	unsubscribe := func(sub *Subscription) { subs.unsubscribe(false, sub, keys...) }
 	sub := newSubscription(subs.typ, unsubscribe, subs.logger)
+	sub.setOptions(opts)
+	subs.add(sub, keys...)
+	return sub, nil
+}
+
+func (subs *subscriptions) subscribePooled(pool MessagePool, handler func(*proto.Message), concurrency int, keys ...interface{}) (*Subscription, error) {
+	unsubscribe := func(sub *Subscription) { subs.unsubscribe(false, sub, keys...) }
+	sub := newPooledSubscription(unsubscribe, subs.logger, pool, handler, concurrency)
+	subs.add(sub, keys...)
+	return sub, nil
+}
+
+func (subs *subscriptions) add(sub *Subscription, keys ...interface{}) {
 	if len(keys) == 0 {
 		keys = subsAllKeys
 	}
@@ -197,11 +440,13 @@ func (subs *subscriptions) subscribe(keys ...interface{}) (*Subscription, error)
 		if !ok {
 			all = make(map[*Subscription]struct{})
 			subs.all[key] = all
+			if p, ok := key.(namePattern); ok {
+				subs.patterns = append(subs.patterns, p)
+			}
 		}
 		all[sub] = struct{}{}
 	}
 	subs.mtx.Unlock()
-	return sub, nil
 }
 
 func (subs *subscriptions) unsubscribe(stop bool, sub *Subscription, keys ...interface{}) {
@@ -213,9 +458,16 @@ func (subs *subscriptions) unsubscribe(stop bool, sub *Subscription, keys ...int
 	}
 	subs.mtx.Lock()
 	for _, key := range keys {
-		delete(subs.all[key], sub)
-		if len(subs.all[key]) == 0 {
+		all, ok := subs.all[key]
+		if !ok {
+			continue
+		}
+		delete(all, sub)
+		if len(all) == 0 {
 			delete(subs.all, key)
+			if p, ok := key.(namePattern); ok {
+				subs.removePattern(p)
+			}
 		}
 	}
 	// Don't try to stop when we got here from the (*Subscription).Close method.
@@ -234,36 +486,95 @@ func (subs *subscriptions) unsubscribe(stop bool, sub *Subscription, keys ...int
 	subs.mtx.Unlock()
 }
 
+func (subs *subscriptions) removePattern(p namePattern) {
+	for i, q := range subs.patterns {
+		if q == p {
+			subs.patterns = append(subs.patterns[:i], subs.patterns[i+1:]...)
+			return
+		}
+	}
+}
+
 func (subs *subscriptions) messageEnqueue(msg *proto.ProtocolMessage) {
+	var overflowed []*Subscription
 	subs.mtx.Lock()
 	for _, msg := range msg.Messages {
+		if len(subs.patterns) != 0 {
+			overflowed = subs.enqueueMatching(msg, overflowed)
+			continue
+		}
 		if subs, ok := subs.all[subsAll]; ok {
 			for sub := range subs {
-				sub.enqueue(msg)
+				if sub.enqueue(msg) {
+					overflowed = append(overflowed, sub)
+				}
 			}
 		}
 		if subs, ok := subs.all[msg.Name]; ok {
 			for sub := range subs {
-				sub.enqueue(msg)
+				if sub.enqueue(msg) {
+					overflowed = append(overflowed, sub)
+				}
 			}
 		}
 	}
 	subs.mtx.Unlock()
+	unsubscribeAll(overflowed)
+}
+
+// enqueueMatching enqueues msg to every subscription whose names or name
+// patterns match it, once even if several of them do, appending those closed
+// on overflow to overflowed. It must be called with subs.mtx held.
+func (subs *subscriptions) enqueueMatching(msg *proto.Message, overflowed []*Subscription) []*Subscription {
+	seen := make(map[*Subscription]struct{})
+	enqueue := func(all map[*Subscription]struct{}) {
+		for sub := range all {
+			if _, ok := seen[sub]; !ok {
+				seen[sub] = struct{}{}
+				if sub.enqueue(msg) {
+					overflowed = append(overflowed, sub)
+				}
+			}
+		}
+	}
+	enqueue(subs.all[subsAll])
+	enqueue(subs.all[msg.Name])
+	for _, p := range subs.patterns {
+		if p.match(msg.Name) {
+			enqueue(subs.all[p])
+		}
+	}
+	return overflowed
 }
 
 func (subs *subscriptions) presenceEnqueue(msg *proto.ProtocolMessage) {
+	var overflowed []*Subscription
 	subs.mtx.Lock()
 	for _, msg := range msg.Presence {
 		if subs, ok := subs.all[subsAll]; ok {
 			for sub := range subs {
-				sub.enqueue(msg)
+				if sub.enqueue(msg) {
+					overflowed = append(overflowed, sub)
+				}
 			}
 		}
 		if subs, ok := subs.all[msg.State]; ok {
 			for sub := range subs {
-				sub.enqueue(msg)
+				if sub.enqueue(msg) {
+					overflowed = append(overflowed, sub)
+				}
 			}
 		}
 	}
 	subs.mtx.Unlock()
+	unsubscribeAll(overflowed)
+}
+
+// unsubscribeAll unsubscribes the given subscriptions, closed on overflow
+// while enqueueing. It must be called without holding their subscriptions'
+// mutex.
+func unsubscribeAll(overflowed []*Subscription) {
+	for _, sub := range overflowed {
+		sub.unsubscribe(sub)
+	}
 }
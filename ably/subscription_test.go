@@ -0,0 +1,204 @@
+package ably_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestMatchNamePattern(t *testing.T) {
+	t.Parallel()
+
+	for _, c := range []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"update:*", "update:user", true},
+		{"update:*", "update:", true},
+		{"update:*", "update", false},
+		{"*:user", "update:user", true},
+		{"*:user", "update:users", false},
+		{"a*b*c", "a-b-b-c", true},
+		{"a*b*c", "a-c-b", false},
+		{"user.?", "user.1", true},
+		{"user.?", "user.12", false},
+		{"**", "", true},
+		{"*", "anything", true},
+	} {
+		if got := ably.MatchNamePattern(c.pattern, c.name); got != c.match {
+			t.Errorf("MatchNamePattern(%q, %q) = %v; want %v", c.pattern, c.name, got, c.match)
+		}
+	}
+}
+
+func TestRealtimeChannel_SubscribePatterns(t *testing.T) {
+	t.Parallel()
+
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	channel := newAttachedPipeChannel(t, in, out)
+
+	sub, err := channel.Subscribe("update:*", "*:user", "delete")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+	// A subscription with no pattern keeps receiving messages while others
+	// have patterns.
+	exact, err := channel.Subscribe("create")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer exact.Close()
+
+	var messages []*proto.Message
+	for _, name := range []string{"update:user", "update:order", "create", "delete", "create:user", "other"} {
+		messages = append(messages, &proto.Message{Name: name})
+	}
+	in <- &proto.ProtocolMessage{Action: proto.ActionMessage, Channel: "test", Messages: messages}
+
+	receive := func(sub *ably.Subscription, n int) []string {
+		t.Helper()
+		var names []string
+		for len(names) < n {
+			select {
+			case msg := <-sub.MessageChannel():
+				names = append(names, msg.Name)
+			case <-time.After(ablytest.Timeout):
+				t.Fatalf("timed out waiting for messages; got %v", names)
+			}
+		}
+		return names
+	}
+	assertDeepEquals(t, []string{"update:user", "update:order", "delete", "create:user"}, receive(sub, 4))
+	assertDeepEquals(t, []string{"create"}, receive(exact, 1))
+
+	channel.Unsubscribe(sub, "update:*", "*:user")
+	in <- &proto.ProtocolMessage{
+		Action:   proto.ActionMessage,
+		Channel:  "test",
+		Messages: []*proto.Message{{Name: "update:user"}, {Name: "delete"}},
+	}
+	assertDeepEquals(t, []string{"delete"}, receive(sub, 1))
+	if n := sub.Len(); n != 0 {
+		t.Errorf("want no more queued messages; got %d", n)
+	}
+}
+
+func TestRealtimeChannel_SubscribeWithOptions(t *testing.T) {
+	t.Parallel()
+
+	messages := func(names ...string) *proto.ProtocolMessage {
+		msg := &proto.ProtocolMessage{Action: proto.ActionMessage, Channel: "test"}
+		for _, name := range names {
+			msg.Messages = append(msg.Messages, &proto.Message{Name: name})
+		}
+		return msg
+	}
+	receive := func(t *testing.T, sub *ably.Subscription) (*proto.Message, bool) {
+		t.Helper()
+		select {
+		case msg, ok := <-sub.MessageChannel():
+			return msg, ok
+		case <-time.After(ablytest.Timeout):
+			t.Fatal("timed out waiting for message")
+			return nil, false
+		}
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		channel := newAttachedPipeChannel(t, in, out)
+		sub, err := channel.SubscribeWithOptions(&ably.SubscriptionOptions{BufferSize: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+
+		in <- messages("a", "b", "c", "d", "e")
+		in <- &proto.ProtocolMessage{Action: proto.ActionHeartbeat}
+		in <- &proto.ProtocolMessage{Action: proto.ActionHeartbeat}
+		// At most one message is in flight besides the queued ones.
+		dropped := sub.Dropped()
+		if dropped < 2 {
+			t.Fatalf("want at least 2 messages dropped; got %d", dropped)
+		}
+		var names []string
+		for len(names)+dropped < 5 {
+			msg, _ := receive(t, sub)
+			names = append(names, msg.Name)
+		}
+		if names[0] != "a" {
+			t.Errorf("want the first messages kept; got %v", names)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		channel := newAttachedPipeChannel(t, in, out)
+		sub, err := channel.SubscribeWithOptions(&ably.SubscriptionOptions{
+			BufferSize: 1,
+			Overflow:   ably.OverflowError,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sub.Close()
+
+		in <- messages("a", "b", "c", "d")
+		for {
+			if _, ok := receive(t, sub); !ok {
+				break
+			}
+		}
+		if err := sub.Err(); ably.ErrorCode(err) != ably.ErrInternalChannelError {
+			t.Errorf("want error %d; got %v", ably.ErrInternalChannelError, err)
+		}
+		if n := channel.Subscribed(); n != 0 {
+			t.Errorf("want the overflowed subscription unsubscribed; got %d subscriptions", n)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 1)
+		out := make(chan *proto.ProtocolMessage, 16)
+		channel := newAttachedPipeChannel(t, in, out)
+		blocking, err := channel.SubscribeWithOptions(&ably.SubscriptionOptions{
+			BufferSize: 1,
+			Overflow:   ably.OverflowBlock,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := channel.Subscribe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+
+		in <- messages("a", "b", "c", "d")
+		for _, name := range []string{"a", "b"} {
+			if msg, _ := receive(t, blocking); msg.Name != name {
+				t.Fatalf("want message %q; got %q", name, msg.Name)
+			}
+		}
+		// Closing the blocking subscription resumes delivery to the others.
+		blocking.Close()
+		for _, name := range []string{"a", "b", "c", "d"} {
+			if msg, _ := receive(t, other); msg.Name != name {
+				t.Fatalf("want message %q; got %q", name, msg.Name)
+			}
+		}
+		if n := blocking.Dropped(); n != 0 {
+			t.Errorf("want no message dropped; got %d", n)
+		}
+	})
+}
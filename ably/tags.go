@@ -0,0 +1,70 @@
+package ably
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tags is a goroutine-safe set of application metadata attached to a client.
+type tags struct {
+	mtx  sync.RWMutex
+	tags map[string]string
+}
+
+func (t *tags) set(key, value string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	if t.tags == nil {
+		t.tags = make(map[string]string)
+	}
+	t.tags[key] = value
+}
+
+func (t *tags) get(key string) (string, bool) {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	value, ok := t.tags[key]
+	return value, ok
+}
+
+func (t *tags) delete(key string) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	delete(t.tags, key)
+}
+
+func (t *tags) all() map[string]string {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	all := make(map[string]string, len(t.tags))
+	for k, v := range t.tags {
+		all[k] = v
+	}
+	return all
+}
+
+// snapshot is like all, but gives nil if there are no tags, or if t is nil.
+func (t *tags) snapshot() map[string]string {
+	if t == nil {
+		return nil
+	}
+	t.mtx.RLock()
+	n := len(t.tags)
+	t.mtx.RUnlock()
+	if n == 0 {
+		return nil
+	}
+	return t.all()
+}
+
+// String gives the tags formatted as sorted key=value pairs.
+func (t *tags) String() string {
+	all := t.all()
+	pairs := make([]string, 0, len(all))
+	for k, v := range all {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
@@ -1,6 +1,7 @@
 package ably
 
 import (
+	"bytes"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -8,6 +9,7 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -89,7 +91,85 @@ type TokenRequest struct {
 	Mac     string `json:"mac,omitempty" codec:"mac,omitempty"`     // message authentication code for the request
 }
 
+// NewTokenRequest gives a token request for the given API key, signed
+// locally without making any network call, e.g. for an auth server to hand
+// out to clients using AuthURL or AuthCallback.
+//
+// Unset params are given defaults: a random nonce, the current local time
+// as timestamp, a TTL of an hour and the capabilities of the key. Use
+// Auth.CreateTokenRequest to apply a client's options, e.g. to sign with
+// the server time.
+func NewTokenRequest(key string, params *TokenParams) (*TokenRequest, error) {
+	name, secret, err := parseKey(key)
+	if err != nil {
+		return nil, err
+	}
+	req := &TokenRequest{KeyName: name}
+	if params != nil {
+		req.TokenParams = *params
+	}
+	req.setDefaults()
+	if req.Timestamp == 0 {
+		req.Timestamp = Time(time.Now())
+	}
+	req.sign([]byte(secret))
+	return req, nil
+}
+
+// Verify checks that the request was signed with the given API key, so an
+// auth server can check token requests before requesting tokens with them.
+func (req *TokenRequest) Verify(key string) error {
+	name, secret, err := parseKey(key)
+	if err != nil {
+		return err
+	}
+	if req.KeyName != name {
+		return newErrorf(ErrIncompatibleCredentials, "token request is for key %q; want %q", req.KeyName, name)
+	}
+	if !hmac.Equal([]byte(req.Mac), []byte(req.mac([]byte(secret)))) {
+		return newErrorf(ErrInvalidCredentials, "invalid token request mac")
+	}
+	return nil
+}
+
+// ValidateKeyName checks that name is the name of an API key, i.e. the part
+// of the key before the colon, formatted as <app ID>.<key ID>.
+func ValidateKeyName(name string) error {
+	i := strings.IndexByte(name, '.')
+	if i <= 0 || i == len(name)-1 || strings.ContainsAny(name, ":") {
+		return newErrorf(ErrInvalidCredential, "invalid key name %q: want <app ID>.<key ID>", name)
+	}
+	return nil
+}
+
+// parseKey splits key into its name and secret, checking its format.
+func parseKey(key string) (name, secret string, err error) {
+	opts := AuthOptions{Key: key}
+	name, secret = opts.KeyName(), opts.KeySecret()
+	if secret == "" || ValidateKeyName(name) != nil {
+		return "", "", newError(ErrInvalidCredential, errInvalidKey)
+	}
+	return name, secret, nil
+}
+
+// setDefaults sets the nonce, capability and TTL of req if they're unset.
+func (req *TokenRequest) setDefaults() {
+	if req.Nonce == "" {
+		req.Nonce = randomString(32)
+	}
+	if req.RawCapability == "" {
+		req.RawCapability = (Capability{"*": {"*"}}).Encode()
+	}
+	if req.TTL == 0 {
+		req.TTL = 60 * 60 * 1000
+	}
+}
+
 func (req *TokenRequest) sign(secret []byte) {
+	req.Mac = req.mac(secret)
+}
+
+func (req *TokenRequest) mac(secret []byte) string {
 	mac := hmac.New(sha256.New, secret)
 	fmt.Fprintln(mac, req.KeyName)
 	fmt.Fprintln(mac, req.TTL)
@@ -97,7 +177,7 @@ func (req *TokenRequest) sign(secret []byte) {
 	fmt.Fprintln(mac, req.ClientID)
 	fmt.Fprintln(mac, req.Timestamp)
 	fmt.Fprintln(mac, req.Nonce)
-	req.Mac = base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
 }
 
 // TokenDetails
@@ -140,6 +220,75 @@ func (tok *TokenDetails) ExpireTime() time.Time {
 	return time.Unix(tok.Expires/1000, tok.Expires%1000*int64(time.Millisecond))
 }
 
+// ToJSON encodes tok with the field names used by all Ably SDKs, e.g. for
+// an auth endpoint to return it to ably-js clients using authUrl.
+func (tok *TokenDetails) ToJSON() ([]byte, error) {
+	return json.Marshal(tok)
+}
+
+// TokenDetailsFromJSON decodes token details encoded with ToJSON or by
+// another Ably SDK. The capability may be given either as a string or as
+// a JSON object.
+func TokenDetailsFromJSON(p []byte) (*TokenDetails, error) {
+	var tok TokenDetails
+	if err := unmarshalWithCapability(p, &tok, &tok.RawCapability); err != nil {
+		return nil, err
+	}
+	if tok.Token == "" {
+		return nil, newErrorf(ErrInvalidTokenFormat, "missing token in token details")
+	}
+	return &tok, nil
+}
+
+// ToJSON encodes req with the field names used by all Ably SDKs, e.g. for
+// an auth endpoint to return it to ably-js clients using authUrl.
+func (req *TokenRequest) ToJSON() ([]byte, error) {
+	return json.Marshal(req)
+}
+
+// TokenRequestFromJSON decodes a signed token request encoded with ToJSON
+// or by another Ably SDK. The capability may be given either as a string or
+// as a JSON object.
+func TokenRequestFromJSON(p []byte) (*TokenRequest, error) {
+	var req TokenRequest
+	if err := unmarshalWithCapability(p, &req, &req.RawCapability); err != nil {
+		return nil, err
+	}
+	if req.KeyName == "" || req.Nonce == "" || req.Mac == "" || req.Timestamp == 0 {
+		return nil, newErrorf(ErrInvalidRequestBody, "token request must have keyName, nonce, mac and timestamp set")
+	}
+	return &req, nil
+}
+
+// unmarshalWithCapability decodes p into v, storing the capability field in
+// raw as a string even if it's encoded as an object.
+func unmarshalWithCapability(p []byte, v interface{}, raw *string) error {
+	var fields struct {
+		Capability json.RawMessage `json:"capability"`
+	}
+	if err := json.Unmarshal(p, &fields); err != nil {
+		return newError(ErrBadRequest, err)
+	}
+	var capability bytes.Buffer
+	if c := fields.Capability; len(c) != 0 && c[0] == '{' {
+		// Decode the rest without the capability object.
+		var m map[string]json.RawMessage
+		if err := json.Unmarshal(p, &m); err != nil {
+			return newError(ErrBadRequest, err)
+		}
+		delete(m, "capability")
+		p, _ = json.Marshal(m)
+		json.Compact(&capability, c)
+	}
+	if err := json.Unmarshal(p, v); err != nil {
+		return newError(ErrBadRequest, err)
+	}
+	if capability.Len() != 0 {
+		*raw = capability.String()
+	}
+	return nil
+}
+
 func newTokenDetails(token string) *TokenDetails {
 	return &TokenDetails{
 		Token: token,
@@ -0,0 +1,67 @@
+package ably
+
+import (
+	"context"
+)
+
+// TracerProvider is implemented by tracing libraries to receive spans for
+// the library's operations: REST requests, token requests, realtime
+// connection attempts, channel attach and detach, and publishes, which end
+// once Ably acknowledged the published messages.
+//
+// The github.com/ably/ably-go/ablyotel module provides an implementation
+// for OpenTelemetry.
+type TracerProvider interface {
+	// Start starts a new span, a child of the span in ctx if any.
+	Start(ctx context.Context, name string, attrs ...Field) (context.Context, Span)
+}
+
+// Span is a single operation traced by a TracerProvider.
+type Span interface {
+	// End ends the span, recording err as the reason of the operation's
+	// failure if it's non-nil.
+	End(err error)
+}
+
+type nopSpan struct{}
+
+func (nopSpan) End(error) {}
+
+func (opts *ClientOptions) startSpan(ctx context.Context, name string, attrs ...Field) (context.Context, Span) {
+	if opts.TracerProvider == nil {
+		return ctx, nopSpan{}
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return opts.TracerProvider.Start(ctx, name, attrs...)
+}
+
+// endSpanOnState ends span once the state emitter transitions to one of the
+// given states, recording an error unless it's the expected one. It must be
+// called with the state lock held.
+func endSpanOnState(span Span, s *stateEmitter, expected StateEnum, states ...StateEnum) {
+	if _, ok := span.(nopSpan); ok {
+		return
+	}
+	res, listen := newResult(expected)
+	s.once(listen, states...)
+	go func() {
+		span.End(res.Wait())
+	}()
+}
+
+// endSpanOnResult gives a channel to be used in place of listen, which ends
+// span with the received error before passing it on to listen.
+func endSpanOnResult(span Span, listen chan<- error) chan<- error {
+	if _, ok := span.(nopSpan); ok {
+		return listen
+	}
+	ch := make(chan error, 1)
+	go func() {
+		err := <-ch
+		span.End(err)
+		listen <- err
+	}()
+	return ch
+}
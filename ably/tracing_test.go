@@ -0,0 +1,123 @@
+package ably_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// spanRecorder is an ably.TracerProvider which records ended spans.
+type spanRecorder struct {
+	mtx   sync.Mutex
+	ended map[string]error
+}
+
+type recordedSpan struct {
+	r    *spanRecorder
+	name string
+}
+
+func (r *spanRecorder) Start(ctx context.Context, name string, attrs ...ably.Field) (context.Context, ably.Span) {
+	return ctx, recordedSpan{r: r, name: name}
+}
+
+func (s recordedSpan) End(err error) {
+	s.r.mtx.Lock()
+	defer s.r.mtx.Unlock()
+	if s.r.ended == nil {
+		s.r.ended = make(map[string]error)
+	}
+	s.r.ended[s.name] = err
+}
+
+// waitSpans waits until all the given spans have ended without an error.
+func (r *spanRecorder) waitSpans(t *testing.T, names ...string) {
+	t.Helper()
+	deadline := time.Now().Add(ablytest.Timeout)
+	for {
+		r.mtx.Lock()
+		var missing []string
+		for _, name := range names {
+			if err, ok := r.ended[name]; !ok {
+				missing = append(missing, name)
+			} else if err != nil {
+				t.Errorf("span %q ended with error: %v", name, err)
+			}
+		}
+		r.mtx.Unlock()
+		if len(missing) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			sort.Strings(missing)
+			t.Fatalf("timed out waiting for spans %v", missing)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	t.Run("rest", func(t *testing.T) {
+		t.Parallel()
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[1500000000000]`))
+		}))
+		defer server.Close()
+		tracer := &spanRecorder{}
+		client := newTestRestClient(t, server, func(o *ably.ClientOptions) {
+			o.TracerProvider = tracer
+		})
+		if _, err := client.Time(); err != nil {
+			t.Fatal(err)
+		}
+		tracer.waitSpans(t, "ably.rest.request")
+	})
+
+	t.Run("realtime", func(t *testing.T) {
+		t.Parallel()
+		in := make(chan *proto.ProtocolMessage, 16)
+		out := make(chan *proto.ProtocolMessage, 16)
+		tracer := &spanRecorder{}
+		client := newPipeRealtimeClient(t, in, out, func(o *ably.ClientOptions) {
+			o.TracerProvider = tracer
+		})
+		in <- connectedMessage("id")
+		if err := ablytest.Wait(client.Connection.Connect()); err != nil {
+			t.Fatal(err)
+		}
+		channel := client.Channels.Get("test")
+		res, err := channel.Attach()
+		if err != nil {
+			t.Fatal(err)
+		}
+		in <- &proto.ProtocolMessage{Action: proto.ActionAttached, Channel: "test"}
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		res, err = channel.Publish("event", "data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for msg := range out {
+			if msg.Action == proto.ActionMessage {
+				in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+				break
+			}
+		}
+		if err := res.Wait(); err != nil {
+			t.Fatal(err)
+		}
+		tracer.waitSpans(t, "ably.realtime.connect", "ably.channel.attach", "ably.channel.publish")
+	})
+}
@@ -0,0 +1,51 @@
+package ably
+
+import "fmt"
+
+// TransportEventType identifies the kind of a TransportEvent.
+type TransportEventType int
+
+const (
+	// TransportDialing is emitted before dialing the realtime host.
+	TransportDialing TransportEventType = iota + 1
+	// TransportDialFailed is emitted when dialing the realtime host failed.
+	TransportDialFailed
+	// TransportOpened is emitted once the transport was opened.
+	TransportOpened
+	// TransportClosed is emitted once the transport was closed, either by the
+	// client or due to a failure to receive from it.
+	TransportClosed
+)
+
+var transportEventText = map[TransportEventType]string{
+	TransportDialing:    "dialing",
+	TransportDialFailed: "dial failed",
+	TransportOpened:     "opened",
+	TransportClosed:     "closed",
+}
+
+// String implements the fmt.Stringer interface.
+func (t TransportEventType) String() string {
+	if s, ok := transportEventText[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("TransportEventType(%d)", int(t))
+}
+
+// TransportEvent describes an event of the realtime connection's underlying
+// transport, e.g. the websocket connection.
+type TransportEvent struct {
+	Type TransportEventType
+	Host string // realtime host dialed; empty for TransportClosed
+	Err  error  // eventual error causing the event
+
+	// Tags are the tags set on the client with RealtimeClient.SetTag when
+	// the event happened; nil if there are none.
+	Tags map[string]string
+}
+
+func (c *Conn) transportEvent(typ TransportEventType, host string, err error) {
+	if c.opts.OnTransportEvent != nil {
+		c.opts.OnTransportEvent(TransportEvent{Type: typ, Host: host, Err: err, Tags: c.opts.Logger.tags.snapshot()})
+	}
+}
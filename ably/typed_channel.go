@@ -0,0 +1,69 @@
+//go:build go1.18
+// +build go1.18
+
+package ably
+
+import (
+	"context"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// TypedChannel wraps a realtime channel to publish and receive values of
+// type T, sparing the conversion of message data at every call site:
+//
+//	orders := ably.NewTypedChannel[Order](client.Channels.Get("orders"))
+//	err := orders.Publish(ctx, "created", order)
+//
+// Values are sent encoded as JSON, after the channel's encodings if any are
+// set (see proto.RegisterCodec), and received ones are converted with
+// DecodeInto.
+type TypedChannel[T any] struct {
+	channel *RealtimeChannel
+}
+
+// NewTypedChannel gives a TypedChannel for values of type T on channel.
+func NewTypedChannel[T any](channel *RealtimeChannel) *TypedChannel[T] {
+	return &TypedChannel[T]{channel: channel}
+}
+
+// Channel gives the wrapped channel.
+func (c *TypedChannel[T]) Channel() *RealtimeChannel {
+	return c.channel
+}
+
+// Publish publishes value as a message with the given name, and blocks
+// until it's acknowledged, ctx is done or publishing fails, as
+// RealtimeChannel.PublishContext does.
+func (c *TypedChannel[T]) Publish(ctx context.Context, name string, value T) error {
+	return c.channel.PublishContext(ctx, name, value)
+}
+
+// Subscribe calls handler with the name and value of every message received
+// on the channel with one of the given names, or with any name if none is
+// given, until ctx is done or the returned Subscription is closed. Handler
+// calls are made as for RealtimeChannel.SubscribePooled.
+//
+// Messages whose data can't be converted to a T are skipped, and the error
+// logged.
+func (c *TypedChannel[T]) Subscribe(ctx context.Context, handler func(name string, value T), names ...string) (*Subscription, error) {
+	sub, err := c.channel.SubscribePooled(nil, func(m *proto.Message) {
+		value, err := DecodeInto[T](m)
+		if err != nil {
+			c.channel.logger().Printf(LogError, "TypedChannel: skipping message %q on channel %q: %v", m.ID, c.channel.Name, err)
+			return
+		}
+		handler(m.Name, value)
+	}, names...)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			sub.Close()
+		case <-sub.closed:
+		}
+	}()
+	return sub, nil
+}
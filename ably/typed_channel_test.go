@@ -0,0 +1,93 @@
+//go:build go1.18
+// +build go1.18
+
+package ably_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/ablytest"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestTypedChannel(t *testing.T) {
+	t.Parallel()
+
+	type point struct {
+		X, Y int
+	}
+	in := make(chan *proto.ProtocolMessage, 1)
+	out := make(chan *proto.ProtocolMessage, 16)
+	channel := ably.NewTypedChannel[point](newAttachedPipeChannel(t, in, out))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- channel.Publish(context.Background(), "moved", point{X: 1, Y: 2})
+	}()
+	msg := <-out
+	b, err := json.Marshal(msg.Messages[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertDeepEquals(t, `{"connectionId":"conn","data":"{\"X\":1,\"Y\":2}","encoding":"json","name":"moved"}`, string(b))
+	in <- &proto.ProtocolMessage{Action: proto.ActionAck, MsgSerial: msg.MsgSerial, Count: 1}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	type received struct {
+		name  string
+		value point
+	}
+	got := make(chan received, 4)
+	if _, err := channel.Subscribe(ctx, func(name string, value point) {
+		got <- received{name, value}
+	}, "moved"); err != nil {
+		t.Fatal(err)
+	}
+	in <- &proto.ProtocolMessage{
+		Action:  proto.ActionMessage,
+		Channel: "test",
+		Messages: []*proto.Message{
+			{Name: "moved", Data: map[string]interface{}{"X": float64(3)}},
+			{Name: "moved", Data: "not JSON"},
+			{Name: "other", Data: `{"X":5}`},
+			{Name: "moved", Data: `{"Y":4}`},
+		},
+	}
+	for _, want := range []received{{"moved", point{X: 3}}, {"moved", point{Y: 4}}} {
+		select {
+		case r := <-got:
+			assertDeepEquals(t, want, r)
+		case <-time.After(ablytest.Timeout):
+			t.Fatalf("timed out waiting for %v", want)
+		}
+	}
+
+	// The subscription is closed asynchronously once ctx is done; messages
+	// stop being delivered eventually.
+	cancel()
+	deadline := time.Now().Add(ablytest.Timeout)
+	for {
+		in <- &proto.ProtocolMessage{
+			Action:   proto.ActionMessage,
+			Channel:  "test",
+			Messages: []*proto.Message{{Name: "moved", Data: `{"X":6}`}},
+		}
+		select {
+		case <-got:
+			if time.Now().After(deadline) {
+				t.Fatal("want no message after ctx is done")
+			}
+			continue
+		case <-time.After(20 * time.Millisecond):
+		}
+		break
+	}
+}
@@ -0,0 +1,99 @@
+package ably
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ably/ably-go/ably/proto"
+)
+
+// Headers of the webhook requests, naming the key which signed the body and
+// carrying the signature.
+const (
+	WebhookKeyHeader       = "X-Ably-Key"
+	WebhookSignatureHeader = "X-Ably-Signature"
+)
+
+// maxWebhookBody is the maximum size of a webhook request body read by
+// VerifyWebhookSignature, which runs on unauthenticated requests.
+const maxWebhookBody = 4 << 20
+
+// WebhookBatch is the body of a batched webhook request sent by Ably.
+type WebhookBatch struct {
+	Items []*WebhookItem `json:"items"`
+}
+
+// WebhookItem is an event of a WebhookBatch.
+type WebhookItem struct {
+	WebhookID string      `json:"webhookId"`
+	Source    string      `json:"source"` // e.g. "channel.message", "channel.presence" or "channel.lifecycle"
+	Serial    string      `json:"serial"`
+	Timestamp int64       `json:"timestamp"` // in milliseconds since the epoch
+	Name      string      `json:"name"`      // e.g. "channel.message" or "channel.opened"
+	Data      WebhookData `json:"data"`
+}
+
+// WebhookData is the payload of a WebhookItem, which depends on its source.
+type WebhookData struct {
+	ChannelID string `json:"channelId"`
+	Site      string `json:"site"`
+
+	// Messages are the messages of a "channel.message" event, with their
+	// data decoded.
+	Messages []*proto.Message `json:"messages"`
+	// Presence are the presence messages of a "channel.presence" event, with
+	// their data decoded.
+	Presence []*proto.PresenceMessage `json:"presence"`
+	// Status is the channel's status for a "channel.lifecycle" event.
+	Status *ChannelStatus `json:"status"`
+}
+
+// VerifyWebhookSignature checks that req is a webhook request signed by
+// Ably with key, an API key as in AuthOptions.Key. Its body is left for
+// reading, e.g. with DecodeWebhook. Bodies larger than 4 MiB are rejected.
+func VerifyWebhookSignature(req *http.Request, key string) error {
+	opts := AuthOptions{Key: key}
+	if opts.KeyName() == "" || opts.KeySecret() == "" {
+		return newErrorf(ErrInvalidCredentials, "invalid webhook key")
+	}
+	if name := req.Header.Get(WebhookKeyHeader); name != opts.KeyName() {
+		return newErrorf(ErrInvalidCredentials, "webhook signed with key %q, rather than %q", name, opts.KeyName())
+	}
+	signature, err := base64.StdEncoding.DecodeString(req.Header.Get(WebhookSignatureHeader))
+	if err != nil || len(signature) == 0 {
+		return newErrorf(ErrInvalidCredentials, "missing or malformed webhook signature")
+	}
+	var body []byte
+	if req.Body != nil {
+		body, err = ioutil.ReadAll(io.LimitReader(req.Body, maxWebhookBody+1))
+		req.Body.Close()
+		if err != nil {
+			return newError(ErrBadRequest, err)
+		}
+		if len(body) > maxWebhookBody {
+			return newErrorf(ErrBadRequest, "webhook body exceeds %d bytes", maxWebhookBody)
+		}
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	mac := hmac.New(sha256.New, []byte(opts.KeySecret()))
+	mac.Write(body)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return newErrorf(ErrInvalidCredentials, "invalid webhook signature")
+	}
+	return nil
+}
+
+// DecodeWebhook decodes the body of a batched webhook request.
+func DecodeWebhook(data []byte) (*WebhookBatch, error) {
+	var batch WebhookBatch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, newError(ErrInvalidRequestBody, err)
+	}
+	return &batch, nil
+}
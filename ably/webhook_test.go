@@ -0,0 +1,141 @@
+package ably_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	t.Parallel()
+
+	const key = "xxxxxxx.yyyyyyy:zzzzzzz"
+	body := []byte(`{"items":[]}`)
+	large := bytes.Repeat([]byte(" "), 4<<20+1)
+	sign := func(secret string, body []byte) string {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+
+	for _, c := range []struct {
+		name      string
+		keyName   string
+		signature string
+		body      []byte
+		key       string
+		err       int
+	}{
+		{"valid", "xxxxxxx.yyyyyyy", sign("zzzzzzz", body), body, key, 0},
+		{"other key", "xxxxxxx.other", sign("zzzzzzz", body), body, key, ably.ErrInvalidCredentials},
+		{"wrong secret", "xxxxxxx.yyyyyyy", sign("other", body), body, key, ably.ErrInvalidCredentials},
+		{"altered body", "xxxxxxx.yyyyyyy", sign("zzzzzzz", body), []byte(`{"items":[{}]}`), key, ably.ErrInvalidCredentials},
+		{"missing signature", "xxxxxxx.yyyyyyy", "", body, key, ably.ErrInvalidCredentials},
+		{"malformed key", "xxxxxxx.yyyyyyy", sign("zzzzzzz", body), body, "xxxxxxx.yyyyyyy", ably.ErrInvalidCredentials},
+		{"too large", "xxxxxxx.yyyyyyy", sign("zzzzzzz", large), large, key, ably.ErrBadRequest},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("POST", "/webhook", bytes.NewReader(c.body))
+			req.Header.Set(ably.WebhookKeyHeader, c.keyName)
+			req.Header.Set(ably.WebhookSignatureHeader, c.signature)
+			err := ably.VerifyWebhookSignature(req, c.key)
+			if c.err != 0 {
+				if err := checkError(c.err, err); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(req.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assertDeepEquals(t, c.body, b)
+		})
+	}
+}
+
+func TestDecodeWebhook(t *testing.T) {
+	t.Parallel()
+
+	batch, err := ably.DecodeWebhook([]byte(`{"items": [{
+		"webhookId": "ABcDEf",
+		"source": "channel.message",
+		"serial": "a7bcdEFghIjklm123456$789",
+		"timestamp": 1500000000000,
+		"name": "channel.message",
+		"data": {
+			"channelId": "news",
+			"site": "eu-west-1-A",
+			"messages": [{"id": "conn:1:0", "name": "greeting", "data": "{\"n\":1}", "encoding": "json"}]
+		}
+	}, {
+		"source": "channel.presence",
+		"name": "presence.message",
+		"data": {
+			"channelId": "news",
+			"presence": [{"clientId": "alice", "connectionId": "conn", "action": 2}]
+		}
+	}, {
+		"source": "channel.lifecycle",
+		"name": "channel.opened",
+		"data": {
+			"channelId": "news",
+			"status": {"isActive": true, "occupancy": {"metrics": {"connections": 1}}}
+		}
+	}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(batch.Items); n != 3 {
+		t.Fatalf("expected 3 items; got %d", n)
+	}
+
+	status := &ably.ChannelStatus{IsActive: true}
+	status.Occupancy.Metrics.Connections = 1
+	assertDeepEquals(t, []*ably.WebhookItem{{
+		WebhookID: "ABcDEf",
+		Source:    "channel.message",
+		Serial:    "a7bcdEFghIjklm123456$789",
+		Timestamp: 1500000000000,
+		Name:      "channel.message",
+		Data: ably.WebhookData{
+			ChannelID: "news",
+			Site:      "eu-west-1-A",
+			Messages: []*proto.Message{
+				{ID: "conn:1:0", Name: "greeting", Data: map[string]interface{}{"n": float64(1)}, Encoding: "json"},
+			},
+		},
+	}, {
+		Source: "channel.presence",
+		Name:   "presence.message",
+		Data: ably.WebhookData{
+			ChannelID: "news",
+			Presence: []*proto.PresenceMessage{{
+				State:   proto.PresenceEnter,
+				Message: proto.Message{ClientID: "alice", ConnectionID: "conn"},
+			}},
+		},
+	}, {
+		Source: "channel.lifecycle",
+		Name:   "channel.opened",
+		Data:   ably.WebhookData{ChannelID: "news", Status: status},
+	}}, batch.Items)
+
+	_, err = ably.DecodeWebhook([]byte(`{"items": {}}`))
+	if err := checkError(ably.ErrInvalidRequestBody, err); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,16 @@
+module github.com/ably/ably-go/ablyotel
+
+go 1.21
+
+require (
+	github.com/ably/ably-go v0.0.0
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
+
+require (
+	github.com/ugorji/go/codec v1.1.7 // indirect
+	golang.org/x/net v0.0.0-20190110200230-915654e7eabc // indirect
+)
+
+replace github.com/ably/ably-go => ../
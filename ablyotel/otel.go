@@ -0,0 +1,63 @@
+// Package ablyotel provides an OpenTelemetry implementation of
+// ably.TracerProvider.
+package ablyotel
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ably/ably-go/ably"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/ably/ably-go"
+
+// NewTracerProvider gives an ably.TracerProvider which records spans using
+// the given OpenTelemetry tracer provider.
+func NewTracerProvider(tp trace.TracerProvider) ably.TracerProvider {
+	return tracerProvider{tracer: tp.Tracer(instrumentationName)}
+}
+
+type tracerProvider struct {
+	tracer trace.Tracer
+}
+
+func (p tracerProvider) Start(ctx context.Context, name string, attrs ...ably.Field) (context.Context, ably.Span) {
+	kv := make([]attribute.KeyValue, 0, len(attrs))
+	for _, f := range attrs {
+		kv = append(kv, attr(f))
+	}
+	ctx, span := p.tracer.Start(ctx, name, trace.WithAttributes(kv...))
+	return ctx, otelSpan{span: span}
+}
+
+func attr(f ably.Field) attribute.KeyValue {
+	switch v := f.Value.(type) {
+	case string:
+		return attribute.String(f.Key, v)
+	case bool:
+		return attribute.Bool(f.Key, v)
+	case int:
+		return attribute.Int(f.Key, v)
+	case int64:
+		return attribute.Int64(f.Key, v)
+	case float64:
+		return attribute.Float64(f.Key, v)
+	default:
+		return attribute.String(f.Key, fmt.Sprint(v))
+	}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+func (s otelSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
@@ -0,0 +1,105 @@
+// Package ablyprom provides a Prometheus collector implementing
+// ably.MetricsSink.
+package ablyprom
+
+import (
+	"github.com/ably/ably-go/ably"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is an ably.MetricsSink which exposes the reported metrics to
+// Prometheus. It must be registered with a prometheus.Registerer, and can be
+// shared by multiple clients.
+type Collector struct {
+	counters   map[ably.Metric]prometheus.Counter
+	gauges     map[ably.Metric]prometheus.Gauge
+	histograms map[ably.Metric]prometheus.Histogram
+}
+
+var _ ably.MetricsSink = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector gives a new Collector with metric names prefixed with the
+// given namespace, e.g. "ably" gives "ably_messages_sent_total".
+func NewCollector(namespace string) *Collector {
+	c := &Collector{
+		counters:   make(map[ably.Metric]prometheus.Counter),
+		gauges:     make(map[ably.Metric]prometheus.Gauge),
+		histograms: make(map[ably.Metric]prometheus.Histogram),
+	}
+	counter := func(m ably.Metric, help string) {
+		c.counters[m] = prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      string(m) + "_total",
+			Help:      help,
+		})
+	}
+	counter(ably.MetricMessagesSent, "Number of messages published.")
+	counter(ably.MetricMessagesReceived, "Number of messages received on realtime channels.")
+	counter(ably.MetricMessagesDeduplicated, "Number of duplicate messages dropped by realtime channels.")
+	counter(ably.MetricBytesSent, "Bytes of REST request bodies and published realtime message payloads.")
+	counter(ably.MetricBytesReceived, "Bytes of REST response bodies and received realtime message payloads.")
+	counter(ably.MetricReconnects, "Number of attempts to resume a realtime connection.")
+	counter(ably.MetricFallbacks, "Number of REST requests retried against a fallback host.")
+	c.gauges[ably.MetricQueueDepth] = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      string(ably.MetricQueueDepth),
+		Help:      "Number of protocol messages queued or waiting for an ACK.",
+	})
+	c.histograms[ably.MetricACKLatency] = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      string(ably.MetricACKLatency),
+		Help:      "Seconds between sending a protocol message and receiving its ACK.",
+		Buckets:   prometheus.ExponentialBuckets(0.005, 2, 12),
+	})
+	return c
+}
+
+// Add implements ably.MetricsSink.
+func (c *Collector) Add(m ably.Metric, delta float64) {
+	if counter, ok := c.counters[m]; ok {
+		counter.Add(delta)
+	}
+}
+
+// Set implements ably.MetricsSink.
+func (c *Collector) Set(m ably.Metric, value float64) {
+	if gauge, ok := c.gauges[m]; ok {
+		gauge.Set(value)
+	}
+}
+
+// Observe implements ably.MetricsSink.
+func (c *Collector) Observe(m ably.Metric, value float64) {
+	if histogram, ok := c.histograms[m]; ok {
+		histogram.Observe(value)
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.collectors() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, m := range c.collectors() {
+		m.Collect(ch)
+	}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	var all []prometheus.Collector
+	for _, m := range c.counters {
+		all = append(all, m)
+	}
+	for _, m := range c.gauges {
+		all = append(all, m)
+	}
+	for _, m := range c.histograms {
+		all = append(all, m)
+	}
+	return all
+}
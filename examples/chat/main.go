@@ -0,0 +1,65 @@
+// Command chat is an end-to-end encrypted chat over a REST channel. Every
+// line read from stdin is published encrypted with a key shared out of band;
+// the recent conversation is printed decrypted at start.
+//
+// Generate a key with -genkey and give it to all participants with -key.
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func main() {
+	channelName := flag.String("channel", "chat", "channel to chat on")
+	name := flag.String("name", "anonymous", "name shown to the other participants")
+	key := flag.String("key", "", "base64 encoded encryption key shared by the participants")
+	genkey := flag.Bool("genkey", false, "print a new encryption key and exit")
+	flag.Parse()
+
+	if *genkey {
+		k, err := proto.GenerateRandomKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(base64.StdEncoding.EncodeToString(k))
+		return
+	}
+
+	cipher, err := proto.DefaultCipherParams()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cipher.Key, err = base64.StdEncoding.DecodeString(*key); err != nil || len(cipher.Key) == 0 {
+		log.Fatal("a valid -key is required, see -genkey")
+	}
+
+	client, err := ably.NewRestClient(ably.NewClientOptions(os.Getenv("ABLY_KEY")))
+	if err != nil {
+		log.Fatal(err)
+	}
+	channel := client.Channels.Get(*channelName, &proto.ChannelOptions{Cipher: *cipher})
+
+	history, err := channel.History(&ably.PaginateParams{Limit: 20, Direction: "backwards"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	messages := history.Messages()
+	for i := len(messages) - 1; i >= 0; i-- {
+		fmt.Printf("<%s> %v\n", messages[i].Name, messages[i].Data)
+	}
+
+	lines := bufio.NewScanner(os.Stdin)
+	for lines.Scan() {
+		if err := channel.Publish(*name, lines.Text()); err != nil {
+			log.Printf("sending message: %v", err)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package examples_test
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestExamples type checks all the examples, so that changes to the public
+// API breaking them fail the tests.
+func TestExamples(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping building examples in short mode")
+	}
+	out, err := exec.Command(filepath.Join(runtime.GOROOT(), "bin", "go"), "vet", "./...").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go vet ./...: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,57 @@
+// Command historyexport writes a channel's message history to stdout, or to
+// a file, as newline delimited JSON.
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+)
+
+func main() {
+	channelName := flag.String("channel", "", "channel to export")
+	out := flag.String("o", "", "file to write to; stdout by default")
+	interval := flag.Duration("interval", 100*time.Millisecond, "minimum time between history requests")
+	flag.Parse()
+	if *channelName == "" {
+		log.Fatal("-channel is required")
+	}
+
+	client, err := ably.NewRestClient(ably.NewClientOptions(os.Getenv("ABLY_KEY")))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	n, err := client.Channels.Get(*channelName, nil).ExportHistory(ctx, w, &ably.HistoryExportOptions{
+		PageInterval: *interval,
+	})
+	log.Printf("exported %d messages", n)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,74 @@
+// Command presence prints the members present on a channel every time
+// someone enters, updates or leaves it.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/ably/ably-go/ably"
+	"github.com/ably/ably-go/ably/proto"
+)
+
+func main() {
+	channelName := flag.String("channel", "lobby", "channel to watch")
+	clientID := flag.String("client", "", "if set, enter the channel with this client ID")
+	flag.Parse()
+
+	opts := ably.NewClientOptions(os.Getenv("ABLY_KEY"))
+	opts.ClientID = *clientID
+	client, err := ably.NewRealtimeClient(opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer client.Close()
+
+	channel := client.Channels.Get(*channelName)
+	sub, err := channel.Presence.Subscribe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer sub.Close()
+	if *clientID != "" {
+		if err := wait(channel.Presence.Enter("watching")); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	printMembers(channel)
+	for msg := range sub.PresenceChannel() {
+		fmt.Printf("%s %v\n", msg.ClientID, msg.State)
+		printMembers(channel)
+	}
+}
+
+func printMembers(channel *ably.RealtimeChannel) {
+	members, err := channel.Presence.Get(context.Background(), ably.PresenceParams{WaitForSync: true})
+	if err != nil {
+		log.Printf("getting members: %v", err)
+		return
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ClientID < members[j].ClientID })
+	fmt.Printf("%d members present on %q:\n", len(members), channel.Name)
+	for _, m := range members {
+		fmt.Printf("  %-20s %v\n", m.ClientID, data(m))
+	}
+}
+
+func data(m *proto.PresenceMessage) interface{} {
+	if m.Data == nil {
+		return "-"
+	}
+	return m.Data
+}
+
+func wait(res ably.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	return res.Wait()
+}
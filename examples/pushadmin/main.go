@@ -0,0 +1,105 @@
+// Command pushadmin manages push notifications through the push admin REST
+// API, using RestClient.Request.
+//
+// Usage:
+//
+//	pushadmin devices [-client id]
+//	pushadmin publish -client id -title title -body body
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+
+	"github.com/ably/ably-go/ably"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	client, err := ably.NewRestClient(ably.NewClientOptions(os.Getenv("ABLY_KEY")))
+	if err != nil {
+		log.Fatal(err)
+	}
+	switch cmd, args := os.Args[1], os.Args[2:]; cmd {
+	case "devices":
+		err = devices(client, args)
+	case "publish":
+		err = publish(client, args)
+	default:
+		usage()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pushadmin devices [-client id] | publish -client id -title title -body body")
+	os.Exit(2)
+}
+
+// devices lists the registered devices, optionally those of a single client.
+func devices(client *ably.RestClient, args []string) error {
+	flags := flag.NewFlagSet("devices", flag.ExitOnError)
+	clientID := flags.String("client", "", "list only devices of this client ID")
+	flags.Parse(args)
+
+	path := "/push/deviceRegistrations"
+	if *clientID != "" {
+		path += "?clientId=" + url.QueryEscape(*clientID)
+	}
+	page, err := client.Request("GET", path, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	for {
+		if !page.Success {
+			return fmt.Errorf("listing devices: %s (code %d)", page.ErrorMessage, page.ErrorCode)
+		}
+		for _, item := range page.Items() {
+			d, _ := item.(map[string]interface{})
+			fmt.Printf("%v\t%v\t%v\n", d["id"], d["clientId"], d["platform"])
+		}
+		if len(page.Items()) == 0 {
+			return nil
+		}
+		// Next fails once there are no more pages.
+		if page, err = page.Next(); err != nil {
+			return nil
+		}
+	}
+}
+
+// publish sends a push notification to all devices of a client.
+func publish(client *ably.RestClient, args []string) error {
+	flags := flag.NewFlagSet("publish", flag.ExitOnError)
+	clientID := flags.String("client", "", "client ID to notify")
+	title := flags.String("title", "", "notification title")
+	body := flags.String("body", "", "notification body")
+	flags.Parse(args)
+	if *clientID == "" {
+		return fmt.Errorf("-client is required")
+	}
+
+	req := map[string]interface{}{
+		"recipient": map[string]interface{}{"clientId": *clientID},
+		"notification": map[string]interface{}{
+			"title": *title,
+			"body":  *body,
+		},
+	}
+	res, err := client.Request("POST", "/push/publish", nil, req, nil)
+	if err != nil {
+		return err
+	}
+	if !res.Success {
+		return fmt.Errorf("publishing notification: %s (code %d)", res.ErrorMessage, res.ErrorCode)
+	}
+	fmt.Println("notification sent")
+	return nil
+}
@@ -0,0 +1,50 @@
+// Command tokenauth runs an HTTP server which issues Ably token requests, so
+// that clients never see the API key. Clients point
+// ably.AuthOptions.AuthURL at the server's /auth endpoint.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/ably/ably-go/ably"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "address to listen on")
+	ttl := flag.Duration("ttl", time.Hour, "time to live of the issued tokens")
+	flag.Parse()
+
+	client, err := ably.NewRestClient(ably.NewClientOptions(os.Getenv("ABLY_KEY")))
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.Handle("/auth", tokenHandler(client, *ttl))
+	log.Printf("issuing token requests on %s/auth", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
+
+// tokenHandler issues a token request for the client ID given by the
+// clientId query parameter. A real application would authenticate the
+// caller and derive the client ID and capability from its session.
+func tokenHandler(client *ably.RestClient, ttl time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := &ably.TokenParams{
+			ClientID: r.URL.Query().Get("clientId"),
+			TTL:      ably.Duration(ttl),
+		}
+		req, err := client.Auth.CreateTokenRequest(params, nil)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(req); err != nil {
+			log.Printf("writing token request: %v", err)
+		}
+	})
+}